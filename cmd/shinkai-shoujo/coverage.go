@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+)
+
+// --- coverage command ---
+
+func coverageCmd() *cobra.Command {
+	var byOwner bool
+
+	cmd := &cobra.Command{
+		Use:   "coverage",
+		Short: "List roles with no observations at all",
+		Long: `Lists the roles from the latest analysis that no trace has been seen for
+in the observation window: gaps in instrumentation rather than unused
+privileges. Their unused findings say nothing until an exporter reports
+their calls.
+
+With --by-owner, the roles are grouped by owner per
+correlation.owners_file, to route instrumentation work to the teams
+that own them.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationReadOnly: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, db, _, _ := mustFromCtx(cmd)
+			defer db.Close()
+			ctx := cmd.Context()
+
+			var owners *ownership.Map
+			if byOwner {
+				if cfg.Correlation.OwnersFile == "" {
+					return fmt.Errorf("--by-owner requires correlation.owners_file")
+				}
+				var err error
+				if owners, err = ownership.Load(cfg.Correlation.OwnersFile); err != nil {
+					return err
+				}
+			}
+
+			total, err := db.CountAnalysisResults(ctx)
+			if err != nil {
+				return err
+			}
+			if total == 0 {
+				fmt.Println("No analysis results found. Run 'shinkai-shoujo analyze' first.")
+				return nil
+			}
+			since := time.Now().AddDate(0, 0, -cfg.Observation.WindowDays)
+			roles, err := db.GetRolesWithoutObservations(ctx, since)
+			if err != nil {
+				return fmt.Errorf("getting unobserved roles: %w", err)
+			}
+
+			out := stdout(ctx)
+			out.Notef("Roles with no observations in the last %d days: %d of %d\n", cfg.Observation.WindowDays, len(roles), total)
+			printUnobservedRoles(out, roles, owners, byOwner)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&byOwner, "by-owner", false, "group roles by owner per correlation.owners_file")
+	return cmd
+}
+
+// printUnobservedRoles prints roles one per line, or under their owner
+// when grouped, with unowned roles last.
+func printUnobservedRoles(out output, roles []string, owners *ownership.Map, grouped bool) {
+	if !grouped {
+		for _, role := range roles {
+			out.Printf("  %s\n", role)
+		}
+		return
+	}
+	const unowned = "(no owner)"
+	byOwner := make(map[string][]string)
+	for _, role := range roles {
+		name := unowned
+		if o, ok := owners.Lookup(role); ok && !o.IsZero() {
+			name = o.String()
+		}
+		byOwner[name] = append(byOwner[name], role)
+	}
+	names := make([]string, 0, len(byOwner))
+	for name := range byOwner {
+		if name != unowned {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := byOwner[unowned]; ok {
+		names = append(names, unowned)
+	}
+	for _, name := range names {
+		out.Printf("%s (%d)\n", name, len(byOwner[name]))
+		for _, role := range byOwner[name] {
+			out.Printf("  %s\n", role)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// dbStatsInterval is how often the daemon refreshes the DB size gauges.
+const dbStatsInterval = time.Minute
+
+// runDBStats refreshes the DB row-count and size gauges until ctx is done.
+func runDBStats(ctx context.Context, db *storage.DB, path string, m *metrics.Metrics, log *slog.Logger) {
+	ticker := time.NewTicker(dbStatsInterval)
+	defer ticker.Stop()
+	for {
+		updateDBStats(ctx, db, path, m, log)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func updateDBStats(ctx context.Context, db *storage.DB, path string, m *metrics.Metrics, log *slog.Logger) {
+	if n, err := db.CountPrivilegeUsage(ctx); err != nil {
+		log.Warn("could not count privilege_usage rows", "error", err)
+	} else {
+		m.DBPrivilegeRows.Set(float64(n))
+	}
+
+	if n, err := db.CountAnalysisResults(ctx); err != nil {
+		log.Warn("could not count analysis_results rows", "error", err)
+	} else {
+		m.DBAnalysisRows.Set(float64(n))
+	}
+
+	// Prefer the on-disk size; fall back to the page count when the file
+	// cannot be stat'ed (e.g. an in-memory database).
+	if info, err := os.Stat(path); err == nil {
+		m.DBFileBytes.Set(float64(info.Size()))
+	} else if n, err := db.PageBytes(ctx); err != nil {
+		log.Warn("could not determine database size", "error", err)
+	} else {
+		m.DBFileBytes.Set(float64(n))
+	}
+}
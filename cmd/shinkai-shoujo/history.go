@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// --- history command ---
+
+func historyCmd() *cobra.Command {
+	var labelFlags []string
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recorded analysis runs",
+		Long: `Lists the full analysis runs recorded in the analysis history, newest
+first, with the number of roles each analyzed and the labels given to it
+with 'analyze --label'.
+
+With --label key=value (repeatable), only the runs carrying every given
+label are listed.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationReadOnly: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			labels, err := parseLabels(labelFlags)
+			if err != nil {
+				return err
+			}
+			_, db, _, _ := mustFromCtx(cmd)
+			defer db.Close()
+
+			runs, err := db.GetAnalysisRuns(cmd.Context(), labels)
+			if err != nil {
+				return fmt.Errorf("getting analysis runs: %w", err)
+			}
+			out := stdout(cmd.Context())
+			if len(runs) == 0 {
+				out.Notef("No analysis runs recorded.\n")
+				return nil
+			}
+			printAnalysisRuns(out, runs)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&labelFlags, "label", nil, "list only runs with this key=value label (repeatable)")
+	return cmd
+}
+
+// printAnalysisRuns prints one line per run: when it ran, its role count
+// and its labels.
+func printAnalysisRuns(out output, runs []storage.AnalysisRun) {
+	for _, r := range runs {
+		out.Printf("%s  %6d roles  %s\n", r.RunAt.UTC().Format("2006-01-02T15:04:05Z"), r.Roles, formatLabels(r.Labels))
+	}
+}
+
+// formatLabels returns labels as comma-separated key=value pairs sorted by
+// key, or "-" when there are none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseLabels parses --label key=value flags. It returns nil when there are
+// none.
+func parseLabels(flags []string) (map[string]string, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(flags))
+	for _, f := range flags {
+		key, value, ok := strings.Cut(f, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("--label %q: expected key=value", f)
+		}
+		if _, dup := labels[key]; dup {
+			return nil, fmt.Errorf("--label %q: key %q given twice", f, key)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
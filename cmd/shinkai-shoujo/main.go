@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,15 +21,24 @@ import (
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 
+	"github.com/0xKirisame/shinkai-shoujo/internal/catalog"
+	"github.com/0xKirisame/shinkai-shoujo/internal/cloudwatch"
 	"github.com/0xKirisame/shinkai-shoujo/internal/config"
 	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/forwarder"
 	"github.com/0xKirisame/shinkai-shoujo/internal/generator"
+	"github.com/0xKirisame/shinkai-shoujo/internal/health"
 	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
 	"github.com/0xKirisame/shinkai-shoujo/internal/receiver"
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
 	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/sources"
 	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+	"github.com/0xKirisame/shinkai-shoujo/internal/version"
 )
 
 // contextKey is a private type to avoid key collisions in context.
@@ -36,8 +49,35 @@ const (
 	keyDB      contextKey = iota
 	keyMetrics contextKey = iota
 	keyLogger  contextKey = iota
+	// keyRunOptions holds the command's runOptions.
+	keyRunOptions contextKey = iota
 )
 
+// runOptions are the flags that reach the helpers a command calls through
+// its context rather than their parameters.
+type runOptions struct {
+	// quiet is --quiet, for stdout.
+	quiet bool
+	// mfaToken is --mfa-token, for loadAWSConfig.
+	mfaToken string
+	// account is --account, the account whose database is open.
+	account string
+	// resume, compareVersions and summaryJSON are analyze's --resume,
+	// --compare-versions and --summary-json.
+	resume          bool
+	compareVersions bool
+	summaryJSON     bool
+	// labels are analyze's parsed --label flags.
+	labels map[string]string
+	// compare is set by analyze --compare, with baseline the results it
+	// checks against.
+	compare  bool
+	baseline []correlation.Result
+	// dryRun marks an analysis that saves, purges and exports nothing,
+	// for the daemon's --dry-run-first.
+	dryRun bool
+}
+
 func main() {
 	if err := rootCmd().Execute(); err != nil {
 		os.Exit(1)
@@ -66,6 +106,19 @@ func ctxLogger(ctx context.Context) (*slog.Logger, bool) {
 	return v, ok && v != nil
 }
 
+// ctxRunOptions returns the runOptions of ctx, zero when none were set.
+func ctxRunOptions(ctx context.Context) runOptions {
+	v, _ := ctx.Value(keyRunOptions).(runOptions)
+	return v
+}
+
+// withRunOptions returns ctx with its runOptions changed by set.
+func withRunOptions(ctx context.Context, set func(*runOptions)) context.Context {
+	o := ctxRunOptions(ctx)
+	set(&o)
+	return context.WithValue(ctx, keyRunOptions, o)
+}
+
 // mustFromCtx is used in RunE handlers where PersistentPreRunE guarantees values are set.
 // It panics only if there is a programming error (PersistentPreRunE was bypassed).
 func mustFromCtx(cmd *cobra.Command) (*config.Config, *storage.DB, *metrics.Metrics, *slog.Logger) {
@@ -82,20 +135,52 @@ func mustFromCtx(cmd *cobra.Command) (*config.Config, *storage.DB, *metrics.Metr
 
 // --- Root command ---
 
+// annotationNoSetup marks commands that run without loading config or
+// opening the database (e.g. init, schema).
+const annotationNoSetup = "shinkai/no-setup"
+
+// annotationReadOnly marks commands that only read results; they open the
+// database with storage.OpenReadOnly so they never migrate the schema or
+// contend with a running daemon for the write lock.
+const annotationReadOnly = "shinkai/read-only"
+
+// annotationInputFlag names a flag that, when set, replaces the database as
+// the command's input; the database is then not opened at all.
+const annotationInputFlag = "shinkai/input-flag"
+
+// analysisLockLease is how long after its holder stops renewing it (see
+// storage.DB.TryLock) storage.AnalysisLock may be assumed abandoned by a
+// crashed run and taken over.
+const analysisLockLease = 5 * time.Minute
+
+// sourceFlushInterval is how often the daemon writes records buffered by
+// usage sources to the database.
+const sourceFlushInterval = time.Second
+
+// extraCommands holds constructors for commands compiled in behind build tags
+// (e.g. "tui"). Tagged files append to it from init().
+var extraCommands []func() *cobra.Command
+
 func rootCmd() *cobra.Command {
 	var cfgPath string
 	var verbose bool
+	var mfaToken string
+	var quiet bool
+	var account string
 
 	root := &cobra.Command{
 		Use:   "shinkai-shoujo",
 		Short: "Identify unused AWS IAM privileges via OTel traces",
 		Long: `shinkai-shoujo correlates OpenTelemetry traces against IAM-assigned
 permissions to identify unused privileges. Requires read-only IAM access.`,
+		Version:       version.Get().Version,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			// Skip setup for init — it needs no config or DB.
-			if cmd.Name() == "init" {
+			cmd.SetContext(withRunOptions(cmd.Context(), func(o *runOptions) { o.quiet = quiet }))
+
+			// Skip setup for commands that need no config or DB.
+			if cmd.Annotations[annotationNoSetup] != "" {
 				return nil
 			}
 
@@ -105,31 +190,66 @@ permissions to identify unused privileges. Requires read-only IAM access.`,
 			if err != nil {
 				return err
 			}
+			if cfg.Correlation.ActionCatalogFile != "" {
+				if err := catalog.LoadFile(cfg.Correlation.ActionCatalogFile); err != nil {
+					return err
+				}
+			}
 
-			db, err := storage.Open(cfg.Storage.Path)
+			if account != "" && cfg.Storage.PathTemplate == "" {
+				return fmt.Errorf("--account needs storage.path_template to be set")
+			}
+			if err := checkAccountFlag(account, cfg.AWS.AllowedAccountIDs); err != nil {
+				return err
+			}
+			cfg.Storage.Path = cfg.Storage.PathFor(account)
+
+			dbOpts := dbOptions(cfg)
+			var db *storage.DB
+			inputFlag := cmd.Annotations[annotationInputFlag]
+			switch {
+			case inputFlag != "" && cmd.Flags().Changed(inputFlag):
+				// The input replaces the database; leave it unopened.
+			case cmd.Annotations[annotationReadOnly] != "":
+				db, err = storage.OpenReadOnly(cfg.Storage.Path, dbOpts)
+				if errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("no database at %s — run 'shinkai-shoujo analyze' first", cfg.Storage.Path)
+				}
+			default:
+				db, err = storage.OpenWithOptions(cfg.Storage.Path, dbOpts)
+			}
 			if err != nil {
 				return fmt.Errorf("opening database: %w", err)
 			}
 
-			m := metrics.New()
-
-			cmd.SetContext(context.WithValue(
-				context.WithValue(
-					context.WithValue(
-						context.WithValue(cmd.Context(), keyConfig, cfg),
-						keyDB, db,
-					),
-					keyMetrics, m,
-				),
-				keyLogger, log,
-			))
+			m := metrics.New(metrics.Options{
+				AnalysisDurationBuckets: cfg.Metrics.AnalysisDurationBuckets,
+				ScrapeDurationBuckets:   cfg.Metrics.ScrapeDurationBuckets,
+				LabelAccount:            cfg.Metrics.LabelAccount,
+				Region:                  cfg.AWS.Region,
+				Prefix:                  cfg.Metrics.Prefix,
+			})
+
+			ctx := context.WithValue(cmd.Context(), keyConfig, cfg)
+			ctx = context.WithValue(ctx, keyDB, db)
+			ctx = context.WithValue(ctx, keyMetrics, m)
+			ctx = context.WithValue(ctx, keyLogger, log)
+			cmd.SetContext(withRunOptions(ctx, func(o *runOptions) {
+				o.mfaToken = mfaToken
+				o.account = account
+			}))
 			return nil
 		},
 	}
 
 	defaultCfg := config.DefaultConfigPath()
-	root.PersistentFlags().StringVarP(&cfgPath, "config", "c", defaultCfg, "config file path")
+	root.PersistentFlags().StringVarP(&cfgPath, "config", "c", defaultCfg, "config file or directory of *.yaml fragments")
 	root.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose (debug) logging")
+	root.PersistentFlags().StringVar(&mfaToken, "mfa-token", "", "MFA code for assuming an MFA-protected role (prompted for when interactive)")
+	root.PersistentFlags().StringVar(&account, "account", "", "use this AWS account's database from storage.path_template")
+	root.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "print only essential output on stdout, without banners or hints")
+	// --version prints the same details as the version command.
+	root.SetVersionTemplate(version.Get().String())
 
 	root.AddCommand(
 		initCmd(),
@@ -137,7 +257,17 @@ permissions to identify unused privileges. Requires read-only IAM access.`,
 		reportCmd(),
 		generateCmd(),
 		daemonCmd(),
+		schemaCmd(),
+		seedCmd(),
+		simulateCmd(),
+		coverageCmd(),
+		historyCmd(),
+		watchCmd(),
+		versionCmd(),
 	)
+	for _, extra := range extraCommands {
+		root.AddCommand(extra())
+	}
 
 	return root
 }
@@ -146,8 +276,9 @@ permissions to identify unused privileges. Requires read-only IAM access.`,
 
 func initCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "init",
-		Short: "Create a default configuration file",
+		Use:         "init",
+		Short:       "Create a default configuration file",
+		Annotations: map[string]string{annotationNoSetup: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfgPath := config.DefaultConfigPath()
 			if _, err := os.Stat(cfgPath); err == nil {
@@ -169,8 +300,9 @@ func initCmd() *cobra.Command {
 				return fmt.Errorf("writing config file: %w", err)
 			}
 
-			fmt.Printf("Created config at %s\n", cfgPath)
-			fmt.Printf("Edit the file to configure your AWS region, OTel endpoint, and storage path.\n")
+			out := stdout(cmd.Context())
+			out.Notef("Created config at %s\n", cfgPath)
+			out.Notef("Edit the file to configure your AWS region, OTel endpoint, and storage path.\n")
 			return nil
 		},
 	}
@@ -179,33 +311,402 @@ func initCmd() *cobra.Command {
 // --- analyze command ---
 
 func analyzeCmd() *cobra.Command {
-	return &cobra.Command{
+	var role string
+	var rolesFile string
+	var resume bool
+	var compareVersions bool
+	var baseline string
+	var selfObserve bool
+	var summaryJSON bool
+	var labelFlags []string
+	cmd := &cobra.Command{
 		Use:   "analyze",
 		Short: "Run a one-shot correlation analysis",
-		Long:  "Scrapes IAM roles and correlates with stored OTel trace data to find unused privileges.",
+		Long: `Scrapes IAM roles and correlates with stored OTel trace data to find unused privileges.
+
+With --role only that role is scraped, correlated and saved, leaving the
+stored results of every other role untouched. --roles-file does the same for
+a list of roles (one name or ARN per line), looking each up directly instead
+of listing every role in the account.
+
+With --resume a full scrape continues from the roles recorded by an
+interrupted one (see aws.checkpoint) instead of scraping them again.
+
+With --compare-versions the previous version of each managed policy is
+fetched too, and analyze reports which of its actions the default version
+already removed and which are still granted but unused.
+
+With --compare the results are checked against a baseline report written by
+'generate json', and analyze fails if any role gained unused privileges or
+rose in risk, so CI can keep a change from widening access.
+
+With --self-observe (or aws.self_observe) every AWS call the run makes is
+recorded as usage by aws.self_role, the role shinkai-shoujo runs as, so the
+next analysis reports which of its own permissions it does not need.
+
+With --summary-json the output ends with one JSON line for pipelines:
+{"roles":N,"high":X,"medium":Y,"unused_total":Z}, counting the roles
+analyzed, those at HIGH and MEDIUM risk, and their unused privileges.
+
+With --label key=value (repeatable) the run is labeled in the analysis
+history, e.g. --label audit=Q3, so 'history', 'report' and 'generate' can
+find it again with the same flag. Only full and role-list runs are
+recorded in the history, so --label does not apply to --role.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, db, m, log := mustFromCtx(cmd)
 			defer db.Close()
-			return runAnalyze(cmd.Context(), cfg, db, m, log)
+			if role != "" && rolesFile != "" {
+				return fmt.Errorf("--role and --roles-file are mutually exclusive")
+			}
+			labels, err := parseLabels(labelFlags)
+			if err != nil {
+				return err
+			}
+			if role != "" && labels != nil {
+				return fmt.Errorf("--label applies to runs recorded in the analysis history, not --role")
+			}
+			if rolesFile != "" {
+				cfg.AWS.RoleListFile = config.ExpandPath(rolesFile)
+			}
+			if resume && (role != "" || cfg.AWS.RoleListFile != "") {
+				return fmt.Errorf("--resume only applies to a full scrape, not --role or a role list")
+			}
+			if resume {
+				cfg.AWS.Checkpoint = true
+			}
+			if selfObserve {
+				if cfg.AWS.SelfRole == "" {
+					return fmt.Errorf("--self-observe needs aws.self_role, the role shinkai-shoujo runs as")
+				}
+				cfg.AWS.SelfObserve = true
+			}
+			var base []correlation.Result
+			if baseline != "" {
+				if base, err = generator.ReadReportFile(config.ExpandPath(baseline)); err != nil {
+					return fmt.Errorf("reading --compare baseline: %w", err)
+				}
+			}
+			ctx := withRunOptions(cmd.Context(), func(o *runOptions) {
+				o.resume = resume
+				o.compareVersions = compareVersions
+				o.summaryJSON = summaryJSON
+				o.labels = labels
+				o.compare = baseline != ""
+				o.baseline = base
+			})
+			defer pushMetrics(ctx, cfg, m, log)
+			if role != "" {
+				return runAnalyzeRole(ctx, cfg, db, m, log, role)
+			}
+			return runAnalyze(ctx, cfg, db, m, log)
 		},
 	}
+	cmd.Flags().StringVar(&role, "role", "", "analyze only this role (name or ARN)")
+	cmd.Flags().StringVar(&rolesFile, "roles-file", "", "analyze only the roles listed in this file (overrides aws.role_list_file)")
+	cmd.Flags().BoolVar(&resume, "resume", false, "continue an interrupted full scrape from its checkpoint")
+	cmd.Flags().BoolVar(&compareVersions, "compare-versions", false, "compare each managed policy with its previous version")
+	cmd.Flags().StringVar(&baseline, "compare", "", "fail if results regressed against this baseline JSON report")
+	cmd.Flags().BoolVar(&selfObserve, "self-observe", false, "record this run's own AWS calls as usage by aws.self_role")
+	cmd.Flags().BoolVar(&summaryJSON, "summary-json", false, "end the output with a one-line JSON summary for pipelines")
+	cmd.Flags().StringArrayVar(&labelFlags, "label", nil, "label this run in the analysis history with key=value (repeatable)")
+	return cmd
+}
+
+// pushMetrics pushes m to metrics.pushgateway_url, if set, so a one-shot
+// analyze, which has no metrics server, is still observable. A failed push
+// is logged rather than failing the analysis.
+func pushMetrics(ctx context.Context, cfg *config.Config, m *metrics.Metrics, log *slog.Logger) {
+	if cfg.Metrics.PushgatewayURL == "" {
+		return
+	}
+	instance := cfg.Metrics.PushgatewayInstance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := m.Push(ctx, cfg.Metrics.PushgatewayURL, cfg.Metrics.PushgatewayJob, instance); err != nil {
+		log.Warn("failed to push metrics", "error", err)
+		return
+	}
+	log.Debug("pushed metrics", "url", cfg.Metrics.PushgatewayURL, "job", cfg.Metrics.PushgatewayJob, "instance", instance)
+}
+
+// loadAWSConfig loads the default AWS config for cfg's region, connecting
+// through aws.proxy_url when set. When the profile assumes an MFA-protected
+// role, the code comes from --mfa-token or, if stdin is a terminal, a prompt
+// on stderr. Every call made with the config is recorded by obs, if not nil.
+func loadAWSConfig(ctx context.Context, cfg *config.Config, obs *scraper.SelfObserver) (aws.Config, error) {
+	mfa := scraper.MFA{
+		SerialNumber: cfg.AWS.MFASerial,
+		Token:        ctxRunOptions(ctx).mfaToken,
+		Interactive:  stdinIsTerminal(),
+		In:           os.Stdin,
+		Prompt:       os.Stderr,
+	}
+	proxy, err := scraper.Proxy{URL: cfg.AWS.ProxyURL, CAFile: cfg.AWS.ProxyCAFile}.LoadOption()
+	if err != nil {
+		return aws.Config{}, err
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AWS.Region), mfa.LoadOption(), proxy)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading AWS config: %w", err)
+	}
+	obs.Install(&awsCfg)
+	return awsCfg, nil
+}
+
+// selfObserver returns the observer recording the run's own AWS calls in db
+// as usage by aws.self_role, or nil unless aws.self_observe is set outside a
+// dry run.
+func selfObserver(ctx context.Context, cfg *config.Config, db *storage.DB) *scraper.SelfObserver {
+	if !cfg.AWS.SelfObserve || ctxRunOptions(ctx).dryRun {
+		return nil
+	}
+	return scraper.NewSelfObserver(cfg.AWS.SelfRole, db)
+}
+
+// flushSelfObserved writes the AWS calls obs recorded, as one batch. Like an
+// export, a failure is logged without failing the run.
+func flushSelfObserved(ctx context.Context, obs *scraper.SelfObserver, log *slog.Logger) {
+	if err := obs.Flush(context.WithoutCancel(ctx)); err != nil {
+		log.Warn("failed to record own AWS calls", "error", err)
+	}
+}
+
+// stdinIsTerminal reports whether stdin is attached to a terminal.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// scrapeCheckpointSession names the checkpoint of a full account scrape.
+const scrapeCheckpointSession = "all"
+
+// newScraper loads AWS credentials, refuses accounts outside the allowlist
+// and returns a Scraper configured from cfg, with the AWS config it uses so
+// later calls share its credentials (and MFA session). With aws.checkpoint
+// set, full scrapes are checkpointed in db. With aws.self_observe, every
+// AWS call made with the config is buffered in the returned observer, which
+// the caller flushes into db once the run is done; otherwise it is nil.
+func newScraper(ctx context.Context, cfg *config.Config, db *storage.DB, log *slog.Logger) (*scraper.Scraper, aws.Config, *scraper.SelfObserver, error) {
+	obs := selfObserver(ctx, cfg, db)
+	awsCfg, err := loadAWSConfig(ctx, cfg, obs)
+	if err != nil {
+		return nil, aws.Config{}, nil, err
+	}
+
+	// Refuse to touch an account outside the allowlist before any scraping.
+	account, err := scraper.VerifyAccount(ctx, awsCfg, cfg.AWS.AllowedAccountIDs)
+	if err != nil {
+		flushSelfObserved(ctx, obs, log)
+		return nil, aws.Config{}, nil, err
+	}
+	if account != "" {
+		log.Info("AWS account verified against allowlist", "account", account)
+	}
+
+	opts := scraper.Options{
+		StrictDenySplit:      cfg.Correlation.StrictDenySplit,
+		Timeout:              cfg.AWS.ScrapeTimeout,
+		IgnoreSidPrefix:      cfg.Correlation.IgnoreSidPrefix,
+		IncludeServiceLinked: cfg.AWS.IncludeServiceLinked,
+	}
+	run := ctxRunOptions(ctx)
+	opts.CompareVersions = run.compareVersions
+	if cfg.AWS.Checkpoint && !run.dryRun {
+		opts.Checkpoint = scraper.DBCheckpoint{DB: db, Session: scrapeCheckpointSession, MaxAge: cfg.AWS.CheckpointMaxAge}
+		opts.Resume = run.resume
+	}
+	return scraper.New(awsCfg, log, opts), awsCfg, obs, nil
+}
+
+// checkAccountFlag refuses an --account that is not an account ID, or one
+// outside aws.allowed_account_ids when that is set, before it names a
+// database file.
+func checkAccountFlag(account string, allowed []string) error {
+	if account == "" {
+		return nil
+	}
+	if !rolearn.ValidAccount(account) {
+		return fmt.Errorf("--account %q: must be a 12-digit AWS account ID", account)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if strings.TrimSpace(a) == account {
+			return nil
+		}
+	}
+	return fmt.Errorf("--account %s is not in aws.allowed_account_ids (%s)", account, strings.Join(allowed, ", "))
+}
+
+// checkScrapedAccount refuses to correlate roles scraped from one account
+// against the database --account selected for another.
+func checkScrapedAccount(ctx context.Context, assignments []scraper.RoleAssignment) error {
+	account := ctxRunOptions(ctx).account
+	scraped := scrapedAccount(assignments)
+	if account == "" || scraped == "" || scraped == account {
+		return nil
+	}
+	return fmt.Errorf("AWS credentials belong to account %s but --account selects the database of %s — use credentials for %s", scraped, account, account)
+}
+
+// checkScrapedRole refuses a role scraped for --role when the flag was an
+// ARN of another account or partition: the role is looked up by name, so
+// the credentials may reach a same-named role elsewhere.
+func checkScrapedRole(requested string, assignment scraper.RoleAssignment) error {
+	want := rolearn.Parse(requested)
+	if want.Account == "" {
+		return nil
+	}
+	got := rolearn.Parse(assignment.RoleARN)
+	if got.Account != want.Account || got.Partition != want.Partition {
+		return fmt.Errorf("--role %s: the AWS credentials reach %s instead — use credentials for account %s", requested, assignment.RoleARN, want.Account)
+	}
+	return nil
+}
+
+// scrapedAccount returns the account of the scraped roles, which share the
+// scraper's credentials, or "" when no role was scraped.
+func scrapedAccount(assignments []scraper.RoleAssignment) string {
+	for _, a := range assignments {
+		if account := rolearn.Parse(a.RoleARN).Account; account != "" {
+			return account
+		}
+	}
+	return ""
 }
 
-// runAnalyze performs the IAM scrape + correlation pipeline and purges stale DB records.
+// newEngine returns a correlation engine configured from cfg, saving nothing
+// when ctx marks a dry run.
+func newEngine(ctx context.Context, cfg *config.Config, db *storage.DB, m *metrics.Metrics, log *slog.Logger) (*correlation.Engine, error) {
+	windows := make(map[correlation.RiskLevel]int, len(cfg.Observation.Windows))
+	for tier, days := range cfg.Observation.Windows {
+		windows[correlation.RiskLevel(tier)] = days
+	}
+	mappings, err := sdkMappings(cfg.Correlation)
+	if err != nil {
+		return nil, err
+	}
+	run := ctxRunOptions(ctx)
+	var owners *ownership.Map
+	if cfg.Correlation.OwnersFile != "" {
+		if owners, err = ownership.Load(cfg.Correlation.OwnersFile); err != nil {
+			return nil, err
+		}
+	}
+	classifier := correlation.DefaultClassifier{AccessLevelRisks: make(map[string]correlation.RiskLevel, len(cfg.Correlation.AccessLevelRisks))}
+	for level, tier := range cfg.Correlation.AccessLevelRisks {
+		classifier.AccessLevelRisks[level] = correlation.RiskLevel(tier)
+	}
+	return correlation.NewEngineWithOptions(db, cfg.Observation.WindowDays, log, m, correlation.Options{
+		Windows:               windows,
+		SDKMappings:           mappings,
+		Timeout:               cfg.Correlation.Timeout,
+		Scope:                 correlation.Scope(cfg.Correlation.Scope),
+		ExpectedTrustServices: cfg.Correlation.ExpectedTrustServices,
+		ServicesInclude:       cfg.Correlation.ServicesInclude,
+		ServicesExclude:       cfg.Correlation.ServicesExclude,
+		MinCallCount:          cfg.Correlation.MinCallCount,
+		RegressionWindow:      cfg.Correlation.RegressionWindow,
+		NewRoleGraceDays:      cfg.Correlation.NewRoleGraceDays,
+		Owners:                owners,
+		DryRun:                run.dryRun,
+		Labels:                run.labels,
+		Classifier:            classifier,
+	}), nil
+}
+
+// runAnalyzeRole scrapes and correlates a single role, given by name or ARN,
+// and prints its result.
+func runAnalyzeRole(ctx context.Context, cfg *config.Config, db *storage.DB, m *metrics.Metrics, log *slog.Logger, role string) error {
+	unlock, err := lockAnalysis(ctx, db, log)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	engine, err := newEngine(ctx, cfg, db, m, log)
+	if err != nil {
+		return err
+	}
+	sc, _, obs, err := newScraper(ctx, cfg, db, log)
+	if err != nil {
+		return err
+	}
+	defer flushSelfObserved(ctx, obs, log)
+
+	name := rolearn.Parse(role).Name
+	log.Info("scraping IAM role...", "role", name)
+	assignment, err := sc.ScrapeSingleRole(ctx, name)
+	if errors.Is(err, scraper.ErrRoleNotFound) {
+		return fmt.Errorf("%w — check the role name and that the credentials belong to its account", err)
+	}
+	if err != nil {
+		return fmt.Errorf("scraping IAM: %w", err)
+	}
+	if err := checkScrapedRole(role, assignment); err != nil {
+		return err
+	}
+	if err := checkScrapedAccount(ctx, []scraper.RoleAssignment{assignment}); err != nil {
+		return err
+	}
+
+	result, err := engine.RunRole(ctx, assignment)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("running correlation: %w — raise correlation.timeout", err)
+	}
+	if err != nil {
+		return fmt.Errorf("running correlation: %w", err)
+	}
+
+	out := stdout(ctx)
+	out.Notef("\n=== Shinkai Shoujo Analysis Results ===\n")
+	printRegressions(out, []correlation.Result{result})
+	out.Printf("  [%s] %s — %d assigned, %d used, %d unused privilege(s)\n",
+		result.RiskLevel, result.IAMRole, len(result.Assigned), len(result.Used), len(result.Unused))
+	for _, p := range correlation.UnusedByRisk(result) {
+		out.Printf("    %-8s %s\n", p.Risk, p.Privilege)
+	}
+	printVersionDiffs(ctx, out, []scraper.RoleAssignment{assignment}, []correlation.Result{result})
+	out.Notef("\nRun 'shinkai-shoujo generate terraform' to produce Terraform output.\n")
+	return finishAnalysis(ctx, out, []correlation.Result{result})
+}
+
+// runAnalyze performs the IAM scrape + correlation pipeline and purges stale
+// DB records. With aws.role_list_file set only the listed roles are analyzed.
 func runAnalyze(ctx context.Context, cfg *config.Config, db *storage.DB, m *metrics.Metrics, log *slog.Logger) error {
-	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AWS.Region))
+	unlock, err := lockAnalysis(ctx, db, log)
 	if err != nil {
-		return fmt.Errorf("loading AWS config: %w", err)
+		return err
 	}
+	defer unlock()
 
-	sc := scraper.New(awsCfg, log)
+	if cfg.AWS.RoleListFile != "" {
+		return runAnalyzeRoles(ctx, cfg, db, m, log)
+	}
+	sc, awsCfg, obs, err := newScraper(ctx, cfg, db, log)
+	if err != nil {
+		return err
+	}
+	defer flushSelfObserved(ctx, obs, log)
 	log.Info("scraping IAM roles...")
-	assignments, err := sc.ScrapeAll(ctx)
+	scrapeStart := time.Now()
+	assignments, skipped, err := sc.ScrapeAll(ctx)
+	m.ScrapeDuration.Observe(time.Since(scrapeStart).Seconds())
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("scraping IAM: %w — raise aws.scrape_timeout if the account has many roles", err)
+	}
 	if err != nil {
 		return fmt.Errorf("scraping IAM: %w", err)
 	}
-	m.IAMRolesScraped.Set(float64(len(assignments)))
-	log.Info("IAM scrape complete", "roles", len(assignments))
+	m.SetScrape(scrapedAccount(assignments), len(assignments), len(skipped))
+	log.Info("IAM scrape complete", "roles", len(assignments), "skipped", len(skipped))
+	if err := checkScrapedAccount(ctx, assignments); err != nil {
+		return err
+	}
 
 	// Warn if the observation window is shorter than the configured minimum.
 	if oldest, ok, err := db.GetOldestObservation(ctx); err != nil {
@@ -220,44 +721,466 @@ func runAnalyze(ctx context.Context, cfg *config.Config, db *storage.DB, m *metr
 		}
 	}
 
-	engine := correlation.NewEngine(db, cfg.Observation.WindowDays, log, m)
-	results, err := engine.Run(ctx, assignments)
+	engine, err := newEngine(ctx, cfg, db, m, log)
+	if err != nil {
+		return err
+	}
+	// Roles left out of the scrape exist in IAM: usage of them is skipped,
+	// not reported as orphaned.
+	excluded := sc.OutOfScope()
+	for _, se := range skipped {
+		if se.RoleARN != "" {
+			excluded = append(excluded, se.RoleARN)
+		} else {
+			excluded = append(excluded, se.RoleName)
+		}
+	}
+	results, err := engine.RunExcluding(ctx, assignments, excluded)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("running correlation: %w — raise correlation.timeout", err)
+	}
 	if err != nil {
 		return fmt.Errorf("running correlation: %w", err)
 	}
 
-	// Purge privilege_usage records older than the observation window + 1 week buffer.
-	cutoff := time.Now().AddDate(0, 0, -(cfg.Observation.WindowDays + 7))
-	purged, err := db.PurgeOldRecords(ctx, cutoff)
+	publishResults(ctx, cfg, db, awsCfg, log, results)
+
+	out := stdout(ctx)
+
+	printAnalysisSummary(out, results, skipped)
+	printVersionDiffs(ctx, out, assignments, results)
+	reportNameCollisions(out, log, assignments)
+	return finishAnalysis(ctx, out, results)
+}
+
+// publishResults exports results and emits them to CloudWatch, then purges
+// records past retention, unless this is a dry run. Every analysis does this
+// once its results are in.
+func publishResults(ctx context.Context, cfg *config.Config, db *storage.DB, awsCfg aws.Config, log *slog.Logger, results []correlation.Result) {
+	if ctxRunOptions(ctx).dryRun {
+		log.Info("dry run complete: results were not saved, exported or purged")
+		return
+	}
+	exportResults(ctx, cfg, log, results)
+	emitCloudWatch(ctx, cfg, awsCfg, log, results)
+
+	// Purge privilege_usage records older than the longest observation window + 1 week buffer,
+	// and analysis runs past storage.retention_days.
+	cutoff := time.Now().AddDate(0, 0, -(cfg.Observation.MaxWindowDays() + 7))
+	var historyCutoff time.Time
+	if cfg.Storage.RetentionDays > 0 {
+		historyCutoff = time.Now().AddDate(0, 0, -cfg.Storage.RetentionDays)
+	}
+	purged, err := db.PurgeOldRecords(ctx, cutoff, historyCutoff)
 	if err != nil {
 		log.Warn("failed to purge old records", "error", err)
 	} else if purged > 0 {
 		log.Info("purged old privilege records", "count", purged)
 	}
+}
+
+// reportNameCollisions warns of role names this run scraped in more than
+// one account, as those roles are told apart only by ARN.
+func reportNameCollisions(out output, log *slog.Logger, assignments []scraper.RoleAssignment) {
+	byName := make(map[string][]string)
+	accounts := make(map[string]map[string]bool)
+	for _, a := range assignments {
+		role := rolearn.Parse(a.RoleARN)
+		if role.Account == "" {
+			continue
+		}
+		byName[role.Name] = append(byName[role.Name], a.RoleARN)
+		if accounts[role.Name] == nil {
+			accounts[role.Name] = make(map[string]bool)
+		}
+		accounts[role.Name][role.Account] = true
+	}
+	var names []string
+	for name := range byName {
+		if len(accounts[name]) > 1 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	out.Notef("\n")
+	out.Printf("WARNING: %d role name(s) exist in more than one account; tell them apart by ARN:\n", len(names))
+	for _, name := range names {
+		roles := byName[name]
+		sort.Strings(roles)
+		log.Warn("role name exists in more than one account", "name", name, "roles", roles)
+		out.Printf("  %s — %s\n", name, strings.Join(roles, ", "))
+	}
+}
+
+// printAnalysisSummary prints the roles analyze found unused privileges in,
+// and the roles it had to skip.
+func printAnalysisSummary(out output, results []correlation.Result, skipped []scraper.ScrapeError) {
+	out.Notef("\n=== Shinkai Shoujo Analysis Results ===\n")
+	printRegressions(out, results)
+	out.Printf("Roles analyzed: %d\n", len(results))
+	for _, r := range results {
+		switch {
+		case r.RiskLevel == string(correlation.RiskOrphaned):
+			out.Printf("  [%s] %s — observed in traces but not found in IAM\n", r.RiskLevel, r.IAMRole)
+		case r.RiskLevel == string(correlation.RiskNew):
+			out.Printf("  [%s] %s — created within correlation.new_role_grace_days, not analyzed yet\n", r.RiskLevel, r.IAMRole)
+		case len(r.Unused) > 0:
+			out.Printf("  [%s] %s — %d unused privilege(s)\n", r.RiskLevel, r.IAMRole, len(r.Unused))
+			// A role with no observations at all is uncovered throughout;
+			// only a partial gap is worth naming.
+			for _, service := range correlation.UncoveredServices(r) {
+				if len(r.Used) == 0 {
+					break
+				}
+				out.Printf("      %s: no observations — unused findings unreliable\n", service)
+			}
+			if correlation.SessionScoped(r) {
+				out.Printf("      %.0f%% of calls under session policies — unused findings may overstate grants\n", 100*r.SessionPolicyShare)
+			}
+		}
+	}
+	if len(skipped) > 0 {
+		out.Notef("\n")
+		out.Printf("WARNING: %d role(s) could not be scraped and were not analyzed:\n", len(skipped))
+		for _, se := range skipped {
+			out.Printf("  %s — %v\n", se.RoleName, se.Err)
+		}
+	}
+	out.Notef("\nRun 'shinkai-shoujo generate terraform' to produce Terraform output.\n")
+}
+
+// printRegressions leads the output with the roles that recently used
+// privileges they are no longer assigned, since a policy change that just
+// removed them is likely breaking a live workload.
+func printRegressions(out output, results []correlation.Result) {
+	var regressed []correlation.Result
+	for _, r := range results {
+		if len(r.Regressed) > 0 {
+			regressed = append(regressed, r)
+		}
+	}
+	if len(regressed) == 0 {
+		return
+	}
+	out.Printf("ALERT: %d role(s) recently used privileges they are no longer assigned — check for a breaking policy change:\n", len(regressed))
+	for _, r := range regressed {
+		out.Printf("  %s — %s\n", r.IAMRole, strings.Join(r.Regressed, ", "))
+	}
+	out.Notef("\n")
+}
+
+// printPolicyOverlaps lists the roles granted the same action by more than
+// one policy, with each such action and the policies granting it, so teams
+// can consolidate them before remediating.
+func printPolicyOverlaps(out output, results []correlation.Result) {
+	var overlapping []correlation.Result
+	for _, r := range results {
+		if len(r.PolicyOverlaps) > 0 {
+			overlapping = append(overlapping, r)
+		}
+	}
+	if len(overlapping) == 0 {
+		return
+	}
+	out.Printf("\n%d role(s) have actions granted by more than one policy — consider consolidating:\n", len(overlapping))
+	for _, r := range overlapping {
+		out.Printf("  %s\n", r.IAMRole)
+		actions := make([]string, 0, len(r.PolicyOverlaps))
+		for a := range r.PolicyOverlaps {
+			actions = append(actions, a)
+		}
+		sort.Strings(actions)
+		for _, a := range actions {
+			out.Printf("    %s — %s\n", a, strings.Join(r.PolicyOverlaps[a], ", "))
+		}
+	}
+}
+
+// printVersionDiffs prints, with --compare-versions, what each managed
+// policy's default version removed from its previous one and which of the
+// actions it kept are still unused.
+func printVersionDiffs(ctx context.Context, out output, assignments []scraper.RoleAssignment, results []correlation.Result) {
+	if !ctxRunOptions(ctx).compareVersions {
+		return
+	}
+	diffs := correlation.CompareVersions(assignments, results)
+	out.Notef("\n=== Policy Version Comparison ===\n")
+	if len(diffs) == 0 {
+		out.Notef("No managed policy has a previous version.\n")
+		return
+	}
+	for _, d := range diffs {
+		out.Printf("  %s on %s — vs %s: %d removed, %d still unused\n",
+			d.PolicyARN, d.IAMRole, d.PreviousVersion, len(d.Removed), len(d.StillUnused))
+		for _, a := range d.Removed {
+			out.Printf("    removed       %s\n", a)
+		}
+		for _, a := range d.StillUnused {
+			out.Printf("    still unused  %s\n", a)
+		}
+	}
+}
+
+// finishAnalysis checks results against the --compare baseline, then prints
+// the --summary-json line, last so pipelines can take the final line.
+func finishAnalysis(ctx context.Context, out output, results []correlation.Result) error {
+	err := checkBaseline(ctx, out, results)
+	printSummaryJSON(ctx, out, results)
+	return err
+}
+
+// analyzeSummary is the one-line JSON summary analyze --summary-json prints.
+type analyzeSummary struct {
+	Roles       int `json:"roles"`
+	High        int `json:"high"`
+	Medium      int `json:"medium"`
+	UnusedTotal int `json:"unused_total"`
+}
+
+func summarizeAnalysis(results []correlation.Result) analyzeSummary {
+	s := analyzeSummary{Roles: len(results)}
+	for _, r := range results {
+		switch r.RiskLevel {
+		case string(correlation.RiskHigh):
+			s.High++
+		case string(correlation.RiskMedium):
+			s.Medium++
+		}
+		s.UnusedTotal += len(r.Unused)
+	}
+	return s
+}
+
+// printSummaryJSON prints the summary of results as one JSON line when
+// --summary-json is set. It is essential output, kept under --quiet.
+func printSummaryJSON(ctx context.Context, out output, results []correlation.Result) {
+	if !ctxRunOptions(ctx).summaryJSON {
+		return
+	}
+	line, _ := json.Marshal(summarizeAnalysis(results))
+	out.Printf("%s\n", line)
+}
+
+// checkBaseline prints, with --compare, each role that regressed against the
+// baseline report and fails the run if any did. Improvements are not listed:
+// the gate only ratchets one way.
+func checkBaseline(ctx context.Context, out output, results []correlation.Result) error {
+	run := ctxRunOptions(ctx)
+	if !run.compare {
+		return nil
+	}
+	var regressed []correlation.RoleDiff
+	for _, d := range correlation.Diff(run.baseline, results) {
+		if d.Regressed() {
+			regressed = append(regressed, d)
+		}
+	}
+	out.Notef("\n=== Baseline Comparison ===\n")
+	if len(regressed) == 0 {
+		out.Notef("No role regressed against the baseline.\n")
+		return nil
+	}
+	for _, d := range regressed {
+		out.Printf("  %s\n", d.IAMRole)
+		if d.RiskRose() {
+			out.Printf("    risk rose     %s -> %s\n", d.PreviousRisk, d.RiskLevel)
+		}
+		for _, p := range d.NewlyUnused {
+			out.Printf("    newly unused  %s\n", p)
+		}
+	}
+	return fmt.Errorf("%d role(s) regressed against the --compare baseline", len(regressed))
+}
+
+// lockAnalysis takes storage.AnalysisLock, waiting for any analysis already
+// running — in this process or another one on the same database — to finish
+// first, so two runs never interleave result writes and purges.
+func lockAnalysis(ctx context.Context, db *storage.DB, log *slog.Logger) (func(), error) {
+	unlock, ok, err := db.TryLock(ctx, storage.AnalysisLock, analysisLockLease)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return unlock, nil
+	}
+	log.Info("another analysis is running, waiting for it to finish")
+	return db.Lock(ctx, storage.AnalysisLock, analysisLockLease)
+}
+
+// exportResults pushes the full report to the configured export endpoint,
+// if any. A failed export does not fail the run: the results are already
+// saved.
+func exportResults(ctx context.Context, cfg *config.Config, log *slog.Logger, results []correlation.Result) {
+	if cfg.Export.Endpoint == "" {
+		return
+	}
+	fwd := forwarder.New(cfg.Export.Endpoint, log, forwarder.Options{
+		AuthHeader:  cfg.Export.AuthHeader,
+		AuthValue:   cfg.Export.AuthValue,
+		MaxAttempts: cfg.Export.MaxAttempts,
+		Timeout:     cfg.Export.Timeout,
+	})
+	if err := fwd.Send(ctx, results); err != nil {
+		log.Error("failed to export analysis results", "error", err)
+	}
+}
+
+// emitCloudWatch puts results to CloudWatch as metrics when
+// metrics.cloudwatch is enabled. Only full analyses emit, so HighRiskRoles
+// always counts the whole account. Like an export, a failure is logged
+// rather than failing the run.
+func emitCloudWatch(ctx context.Context, cfg *config.Config, awsCfg aws.Config, log *slog.Logger, results []correlation.Result) {
+	if !cfg.Metrics.CloudWatch {
+		return
+	}
+	if err := cloudwatch.Emit(ctx, cloudwatch.NewAPIClient(awsCfg), cfg.Metrics.CloudWatchNamespace, results); err != nil {
+		log.Error("failed to emit CloudWatch metrics", "error", err)
+		return
+	}
+	log.Debug("emitted CloudWatch metrics", "namespace", cfg.Metrics.CloudWatchNamespace, "roles", len(results))
+}
+
+// runAnalyzeRoles scrapes and correlates only the roles listed in
+// aws.role_list_file, leaving every other role's stored result untouched.
+// Listed roles that are missing or fail to scrape are reported and skipped.
+func runAnalyzeRoles(ctx context.Context, cfg *config.Config, db *storage.DB, m *metrics.Metrics, log *slog.Logger) error {
+	names, err := scraper.ReadRoleList(cfg.AWS.RoleListFile)
+	if err != nil {
+		return err
+	}
+	engine, err := newEngine(ctx, cfg, db, m, log)
+	if err != nil {
+		return err
+	}
+	sc, awsCfg, obs, err := newScraper(ctx, cfg, db, log)
+	if err != nil {
+		return err
+	}
+	defer flushSelfObserved(ctx, obs, log)
+
+	log.Info("scraping listed IAM roles...", "file", cfg.AWS.RoleListFile, "roles", len(names))
+	scrapeStart := time.Now()
+	assignments, skipped, err := sc.ScrapeRoles(ctx, names)
+	m.ScrapeDuration.Observe(time.Since(scrapeStart).Seconds())
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("scraping IAM: %w — raise aws.scrape_timeout", err)
+	}
+	if err != nil {
+		return fmt.Errorf("scraping IAM: %w", err)
+	}
+	m.SetScrape(scrapedAccount(assignments), len(assignments), len(skipped))
+	log.Info("IAM scrape complete", "roles", len(assignments), "skipped", len(skipped))
+	if err := checkScrapedAccount(ctx, assignments); err != nil {
+		return err
+	}
+
+	results := make([]correlation.Result, 0, len(assignments))
+	for _, a := range assignments {
+		r, err := engine.RunRole(ctx, a)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("running correlation: %w — raise correlation.timeout", err)
+		}
+		if err != nil {
+			return fmt.Errorf("running correlation: %w", err)
+		}
+		results = append(results, r)
+	}
+	publishResults(ctx, cfg, db, awsCfg, log, results)
 
-	// Print summary.
-	fmt.Printf("\n=== Shinkai Shoujo Analysis Results ===\n")
-	fmt.Printf("Roles analyzed: %d\n", len(results))
+	out := stdout(ctx)
+	out.Notef("\n=== Shinkai Shoujo Analysis Results ===\n")
+	printRegressions(out, results)
+	out.Printf("Roles analyzed: %d of %d listed\n", len(results), len(names))
 	for _, r := range results {
 		if len(r.Unused) > 0 {
-			fmt.Printf("  [%s] %s — %d unused privilege(s)\n", r.RiskLevel, r.IAMRole, len(r.Unused))
+			out.Printf("  [%s] %s — %d unused privilege(s)\n", r.RiskLevel, r.IAMRole, len(r.Unused))
 		}
 	}
-	fmt.Printf("\nRun 'shinkai-shoujo generate terraform' to produce Terraform output.\n")
-	return nil
+	if len(skipped) > 0 {
+		out.Notef("\n")
+		out.Printf("WARNING: %d listed role(s) were not analyzed:\n", len(skipped))
+		for _, se := range skipped {
+			out.Printf("  %s — %v\n", se.RoleName, se.Err)
+		}
+	}
+	printVersionDiffs(ctx, out, assignments, results)
+	reportNameCollisions(out, log, assignments)
+	out.Notef("\nRun 'shinkai-shoujo generate terraform' to produce Terraform output.\n")
+	if len(results) == 0 {
+		printSummaryJSON(ctx, out, results)
+		return fmt.Errorf("none of the %d roles in %s could be analyzed", len(names), cfg.AWS.RoleListFile)
+	}
+	return finishAnalysis(ctx, out, results)
+}
+
+// sdkMappings merges the mappings file (if any) with inline config mappings,
+// inline entries winning.
+func sdkMappings(cc config.CorrelationConfig) (map[string]string, error) {
+	merged := make(map[string]string)
+	if cc.SDKMappingsFile != "" {
+		fromFile, err := correlation.LoadSDKMappingsFile(cc.SDKMappingsFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fromFile {
+			merged[k] = v
+		}
+	}
+	for k, v := range cc.SDKMappings {
+		merged[k] = v
+	}
+	return merged, nil
 }
 
 // --- report command ---
 
 func reportCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "report",
+	var detail bool
+	var topUnused int
+	var redact bool
+	var labelFlags []string
+
+	cmd := &cobra.Command{
+		Use:   "report [role]",
 		Short: "Show the latest analysis results from the database",
+		Long: `Show the latest analysis results from the database.
+
+With --detail, each unused privilege is listed with its own risk level,
+HIGH first. Pass a role ARN or name to show one role; without one, every
+HIGH-risk role is shown.
+
+With --top-unused N, the summary is followed by the N privileges left
+unused by the most roles.
+
+With --redact-accounts (or output.redact_accounts), the account ID in every
+ARN is masked for sharing outside the organization.
+
+With --label key=value (repeatable), the results of the latest analysis run
+carrying every given label (see 'analyze --label') are shown instead.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationReadOnly: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, db, _, _ := mustFromCtx(cmd)
+			cfg, db, _, _ := mustFromCtx(cmd)
 			defer db.Close()
+			redact = redact || cfg.Output.RedactAccounts
+
+			if len(args) > 0 && !detail {
+				return fmt.Errorf("a role argument is only supported with --detail")
+			}
+			labels, err := parseLabels(labelFlags)
+			if err != nil {
+				return err
+			}
 
-			results, err := db.GetLatestAnalysisResults(cmd.Context())
+			var results []storage.AnalysisResult
+			if labels != nil {
+				results, err = db.GetLabeledAnalysisResults(cmd.Context(), time.Now(), labels)
+			} else {
+				results, err = db.GetLatestAnalysisResults(cmd.Context())
+			}
 			if err != nil {
 				return fmt.Errorf("getting analysis results: %w", err)
 			}
@@ -266,64 +1189,243 @@ func reportCmd() *cobra.Command {
 				return nil
 			}
 
-			fmt.Printf("%-60s  %-8s  %-8s  %-8s  %-8s\n",
-				"Role", "Risk", "Assigned", "Used", "Unused")
-			fmt.Println(strings.Repeat("-", 100))
-			for _, r := range results {
-				fmt.Printf("%-60s  %-8s  %-8d  %-8d  %-8d\n",
-					r.IAMRole, r.RiskLevel,
-					len(r.AssignedPrivs), len(r.UsedPrivs), len(r.UnusedPrivs))
+			if detail {
+				return reportDetail(results, args, redact)
+			}
+
+			correlated := toCorrelationResults(results)
+			if redact {
+				correlated = correlation.RedactAccounts(correlated)
+			}
+			printRegressions(stdout(cmd.Context()), correlated)
+			fmt.Printf("%-60s  %-8s  %-8s  %-8s  %-8s  %-8s\n",
+				"Role", "Risk", "Trust", "Assigned", "Used", "Unused")
+			fmt.Println(strings.Repeat("-", 110))
+			for _, r := range correlated {
+				trust := string(r.Trust.RiskLevel)
+				if trust == "" {
+					trust = "-"
+				}
+				fmt.Printf("%-60s  %-8s  %-8s  %-8d  %-8d  %-8d\n",
+					r.IAMRole, r.RiskLevel, trust,
+					len(r.Assigned), len(r.Used), len(r.Unused))
+			}
+			fmt.Println(strings.Repeat("-", 110))
+			if err := generator.WriteSummary(os.Stdout, generator.Summarize(correlated)); err != nil {
+				return err
+			}
+			printPolicyOverlaps(stdout(cmd.Context()), correlated)
+			if topUnused > 0 {
+				fmt.Println()
+				return generator.WriteWidelyUnused(os.Stdout, correlation.AggregateByPrivilege(correlated), topUnused)
 			}
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&detail, "detail", false, "list each unused privilege with its own risk level")
+	cmd.Flags().IntVar(&topUnused, "top-unused", 0, "also list the N privileges left unused by the most roles")
+	cmd.Flags().BoolVar(&redact, "redact-accounts", false, "mask the account ID in every ARN of the output")
+	cmd.Flags().StringArrayVar(&labelFlags, "label", nil, "report the latest run with this key=value label (repeatable)")
+	return cmd
+}
+
+// reportDetail prints the per-privilege risk breakdown for the role named in
+// args (matched by full ARN or role name), or for every HIGH-risk role.
+// With redact, account IDs are masked after the role is matched.
+func reportDetail(dbResults []storage.AnalysisResult, args []string, redact bool) error {
+	var selected []correlation.Result
+	for _, r := range toCorrelationResults(dbResults) {
+		switch {
+		case len(args) == 0:
+			if r.RiskLevel == string(correlation.RiskHigh) {
+				selected = append(selected, r)
+			}
+		case r.IAMRole == args[0] || rolearn.Parse(r.IAMRole).Name == args[0]:
+			selected = append(selected, r)
+		}
+	}
+
+	if len(selected) == 0 {
+		if len(args) == 0 {
+			fmt.Println("No HIGH-risk roles in the latest analysis.")
+			return nil
+		}
+		return fmt.Errorf("role %q not found in the latest analysis results", args[0])
+	}
+	if redact {
+		selected = correlation.RedactAccounts(selected)
+	}
+	return generator.WriteRiskBreakdown(os.Stdout, selected)
 }
 
 // --- generate command ---
 
 func generateCmd() *cobra.Command {
 	var outputFile string
+	var outputDir string
+	var includeClean bool
+	var preventDestroy bool
+	var verboseUsage bool
+	var neverRemove []string
+	var compress string
+	var redact bool
+	var asOf string
+	var inputFile string
+	var force bool
+	var labelFlags []string
+	var removeStyle string
 
 	gen := &cobra.Command{
-		Use:   "generate [terraform|json|yaml]",
+		Use:   "generate [terraform|tf-json|json|yaml|remove-list|all]",
 		Short: "Generate output from the latest analysis results",
-		Args:  cobra.ExactArgs(1),
+		Long: `Generate output from the latest analysis results.
+
+"all" writes report.json, report.yaml and main.tf from one read of the
+results and requires --output-dir.
+
+"remove-list" writes only the delta: for each role, the unused privileges
+to strip, for remediation tooling that edits policies surgically. Roles
+with nothing to remove are left out, and roles with no observed calls are
+skipped with a warning. --remove-style selects a plain list (the default),
+an aws-cli shell script stripping the role's inline policies with jq, or
+json.
+
+With --redact-accounts (or output.redact_accounts), the account ID in every
+ARN is masked for sharing outside the organization.
+
+With --as-of, output is generated from the most recent analysis run at or
+before the given time (RFC 3339, or YYYY-MM-DD for the end of that day in
+UTC) instead of the latest results, for point-in-time snapshots.
+
+With --label key=value (repeatable), output is generated from the most
+recent analysis run carrying every given label (see 'analyze --label'),
+combined with --as-of if given.
+
+With --input, output is generated from a report written by 'generate json'
+instead of the database, which is then not needed at all, e.g. in a CI stage
+separate from the one that ran analyze.
+
+With --never-remove (and generate.never_remove), the listed privileges, or
+patterns such as "kms:*", stay in generated Terraform policies even when
+unused, with a comment noting the override, for permissions with
+dependencies the traces cannot show.
+
+With --verbose-usage, json and yaml reports also list each used privilege
+with its call count and last observation, under "used".
+
+Results older than generate.max_age (default 48h) are refused unless --force
+is given, since usage may have changed since they were analyzed. --as-of and
+--label ask for past results explicitly and are not checked.`,
+		Args: cobra.ExactArgs(1),
+		Annotations: map[string]string{
+			annotationReadOnly:  "true",
+			annotationInputFlag: "input",
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, db, _, _ := mustFromCtx(cmd)
-			defer db.Close()
+			cfg, _ := ctxConfig(cmd.Context())
 
-			format := args[0]
-			g, err := generator.New(format)
+			if inputFile != "" && asOf != "" {
+				return fmt.Errorf("--input and --as-of are mutually exclusive: --as-of selects results from the database")
+			}
+			labels, err := parseLabels(labelFlags)
 			if err != nil {
 				return err
 			}
+			if inputFile != "" && labels != nil {
+				return fmt.Errorf("--input and --label are mutually exclusive: --label selects results from the database")
+			}
+			if outputDir != "" && outputFile != "" {
+				return fmt.Errorf("--output and --output-dir are mutually exclusive")
+			}
+			if args[0] == "remove-list" && outputDir != "" {
+				return fmt.Errorf("remove-list writes one list for every role — pass --output, not --output-dir")
+			}
+			if outputDir != "" && compress != "" && compress != generator.CompressNone {
+				return fmt.Errorf("--compress applies to --output or stdout, not --output-dir")
+			}
 
-			dbResults, err := db.GetLatestAnalysisResults(cmd.Context())
-			if err != nil {
-				return fmt.Errorf("getting analysis results: %w", err)
+			format := args[0]
+			opts := generator.Options{
+				PreventDestroy: preventDestroy,
+				VerboseUsage:   verboseUsage,
+				NeverRemove:    append(append([]string(nil), cfg.Generate.NeverRemove...), neverRemove...),
+				RemoveStyle:    removeStyle,
 			}
-			if len(dbResults) == 0 {
-				fmt.Println("No analysis results found. Run 'shinkai-shoujo analyze' first.")
+			var g generator.Generator
+			if format == "all" {
+				if outputDir == "" {
+					return fmt.Errorf("generate all writes several files — pass --output-dir")
+				}
+			} else if g, err = generator.NewWithOptions(format, opts); err != nil {
+				return err
+			}
+
+			var corrResults []correlation.Result
+			var lastRun time.Time
+			if inputFile != "" {
+				if corrResults, err = generator.ReadReportFile(inputFile); err != nil {
+					return err
+				}
+			} else if corrResults, lastRun, err = latestResults(cmd, asOf, labels); err != nil {
+				return err
+			}
+			if len(corrResults) == 0 {
+				// Stdout may be the generated output itself; keep it empty.
+				if inputFile != "" {
+					fmt.Fprintf(os.Stderr, "No roles in %s.\n", inputFile)
+				} else {
+					fmt.Fprintln(os.Stderr, "No analysis results found. Run 'shinkai-shoujo analyze' first.")
+				}
+				return nil
+			}
+			if asOf == "" && labels == nil {
+				if err := checkFreshness(corrResults, lastRun, cfg.Generate.MaxAge, time.Now()); err != nil {
+					if !force {
+						return fmt.Errorf("%w — run analyze again, or pass --force to generate anyway", err)
+					}
+					fmt.Fprintf(os.Stderr, "WARNING: %v; generating anyway (--force)\n", err)
+				}
+			}
+
+			out := stdout(cmd.Context())
+			if redact || cfg.Output.RedactAccounts {
+				corrResults = correlation.RedactAccounts(corrResults)
+			}
+
+			if format == "all" {
+				written, err := generator.WriteAll(corrResults, outputDir, opts)
+				if err != nil {
+					return err
+				}
+				for _, path := range written {
+					out.Notef("Wrote %s\n", path)
+				}
 				return nil
 			}
 
-			corrResults := make([]correlation.Result, 0, len(dbResults))
-			for _, r := range dbResults {
-				corrResults = append(corrResults, correlation.Result{
-					IAMRole:    r.IAMRole,
-					Assigned:   r.AssignedPrivs,
-					Used:       r.UsedPrivs,
-					Unused:     r.UnusedPrivs,
-					RiskLevel:  r.RiskLevel,
-					AnalyzedAt: r.AnalysisDate,
-				})
+			if outputDir != "" {
+				written, err := generator.WritePerRole(g, format, corrResults, outputDir, includeClean)
+				if err != nil {
+					return err
+				}
+				out.Notef("Wrote %d file(s) to %s\n", len(written), outputDir)
+				return nil
 			}
 
 			if outputFile == "" || outputFile == "-" {
+				// Stdout stays uncompressed unless explicitly requested.
+				if g, err = generator.Compressed(g, compress); err != nil {
+					return err
+				}
 				return g.Generate(corrResults, os.Stdout)
 			}
 
-			f, err := os.Create(outputFile)
+			compression, path := generator.CompressionForPath(outputFile, compress)
+			if g, err = generator.Compressed(g, compression); err != nil {
+				return err
+			}
+			f, err := os.Create(path)
 			if err != nil {
 				return fmt.Errorf("creating output file: %w", err)
 			}
@@ -332,20 +1434,122 @@ func generateCmd() *cobra.Command {
 			if err := g.Generate(corrResults, f); err != nil {
 				return err
 			}
-			fmt.Printf("Output written to %s\n", outputFile)
+			out.Notef("Output written to %s\n", path)
 			return nil
 		},
 	}
 
 	gen.Flags().StringVarP(&outputFile, "output", "o", "", "output file (default: stdout)")
+	gen.Flags().StringVar(&outputDir, "output-dir", "", "write one file per role into this directory (with 'all', one file per format)")
+	gen.Flags().BoolVar(&preventDestroy, "prevent-destroy", false, "add a lifecycle prevent_destroy guard to generated Terraform resources")
+	gen.Flags().StringVar(&compress, "compress", "", "compress the output: none or gzip (default: gzip when --output ends in .gz)")
+	gen.Flags().BoolVar(&includeClean, "include-clean", false, "with --output-dir, also write stub files for roles with no unused privileges")
+	gen.Flags().BoolVar(&redact, "redact-accounts", false, "mask the account ID in every ARN of the output")
+	gen.Flags().StringVar(&asOf, "as-of", "", "generate from the latest analysis run at or before this time (RFC 3339 or YYYY-MM-DD)")
+	gen.Flags().StringVar(&inputFile, "input", "", "generate from this JSON report (from 'generate json') instead of the database")
+	gen.Flags().BoolVar(&force, "force", false, "generate even from results older than generate.max_age")
+	gen.Flags().StringVar(&removeStyle, "remove-style", generator.RemoveStyleList, "remove-list output: list, aws-cli or json")
+	gen.Flags().StringArrayVar(&labelFlags, "label", nil, "generate from the latest run with this key=value label (repeatable)")
+	gen.Flags().StringSliceVar(&neverRemove, "never-remove", nil, "privileges or patterns (e.g. kms:*) generated Terraform keeps even when unused; adds to generate.never_remove")
+	gen.Flags().BoolVar(&verboseUsage, "verbose-usage", false, "add each used privilege's call count and last observation to json and yaml reports")
 	return gen
 }
 
+// checkFreshness returns an error when neither the last analysis run nor
+// the newest of results is within maxAge of now. lastRun is zero when
+// unknown; results reused by a run keep their older analysis date. Zero
+// maxAge disables the check.
+func checkFreshness(results []correlation.Result, lastRun time.Time, maxAge time.Duration, now time.Time) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	newest := lastRun
+	for _, r := range results {
+		if r.AnalyzedAt.After(newest) {
+			newest = r.AnalyzedAt
+		}
+	}
+	if newest.IsZero() {
+		return nil
+	}
+	if age := now.Sub(newest); age > maxAge {
+		return fmt.Errorf("the latest analysis is %s old, more than generate.max_age (%s)", age.Round(time.Minute), maxAge)
+	}
+	return nil
+}
+
+// latestResults reads the latest analysis results from the database, or
+// those in effect at asOf when set, limited to the runs carrying labels when
+// it is non-nil. It also returns when the last analysis run ran, zero when
+// none is recorded.
+func latestResults(cmd *cobra.Command, asOf string, labels map[string]string) ([]correlation.Result, time.Time, error) {
+	_, db, _, _ := mustFromCtx(cmd)
+	defer db.Close()
+
+	var dbResults []storage.AnalysisResult
+	if asOf != "" || labels != nil {
+		t := time.Now()
+		var err error
+		if asOf != "" {
+			if t, err = parseAsOf(asOf); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+		if dbResults, err = db.GetLabeledAnalysisResults(cmd.Context(), t, labels); err != nil {
+			return nil, time.Time{}, fmt.Errorf("getting analysis results as of %s: %w", t.Format(time.RFC3339), err)
+		}
+	} else {
+		var err error
+		if dbResults, err = db.GetLatestAnalysisResults(cmd.Context()); err != nil {
+			return nil, time.Time{}, fmt.Errorf("getting analysis results: %w", err)
+		}
+	}
+	lastRun, err := db.LastAnalysisRun(cmd.Context())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return toCorrelationResults(dbResults), lastRun, nil
+}
+
+// toCorrelationResults converts stored analysis rows into the shape the
+// generators consume.
+func toCorrelationResults(dbResults []storage.AnalysisResult) []correlation.Result {
+	corrResults := make([]correlation.Result, 0, len(dbResults))
+	for _, r := range dbResults {
+		corrResults = append(corrResults, correlation.Result{
+			IAMRole:             r.IAMRole,
+			Assigned:            r.AssignedPrivs,
+			Used:                r.UsedPrivs,
+			Unused:              r.UnusedPrivs,
+			UnusedRisks:         correlation.RisksFromStrings(r.UnusedRisks),
+			RiskLevel:           r.RiskLevel,
+			AnalyzedAt:          r.AnalysisDate,
+			PolicyARNs:          r.PolicyARNs,
+			Resources:           r.Resources,
+			Usage:               r.Usage,
+			SessionPolicyShare:  correlation.SessionPolicyShare(r.Usage),
+			Sources:             correlation.SourcesFromStrings(r.Sources),
+			Suppressed:          r.SuppressedPrivs,
+			ResourceConstrained: r.ConstrainedPrivs,
+			PolicyOverlaps:      r.PolicyOverlaps,
+			ReadOnly:            r.ReadOnly,
+			ExcessObserved:      r.ExcessObservedPrivs,
+			Regressed:           r.RegressedPrivs,
+			ServiceCoverage:     r.ServiceCoverage,
+			Trust:               correlation.TrustFromRecord(r.Trust),
+			Owner:               r.Owner,
+		})
+	}
+	return corrResults
+}
+
 // --- daemon command ---
 
 func daemonCmd() *cobra.Command {
 	var intervalStr string
+	var jitterStr string
 	var skipIfRunning bool
+	var dryRunFirst bool
 
 	var analyzeMu  sync.Mutex
 	var analyzeRunning bool
@@ -353,56 +1557,116 @@ func daemonCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "daemon",
 		Short: "Run continuously, re-analyzing on an interval",
+		Long: `Receives OTel traces and re-analyzes on an interval, starting with one
+analysis at startup.
+
+With --dry-run-first that first analysis saves, exports and purges nothing:
+it only prints its summary, to confirm scraping and correlation work against
+a new deployment before the daemon starts writing. Later runs are normal.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, db, m, log := mustFromCtx(cmd)
 			defer db.Close()
 
+			// The daemon analyzes and purges only the database it opened, so
+			// with per-account databases it must open its own account's.
+			account := ctxRunOptions(cmd.Context()).account
+			if cfg.Storage.PathTemplate != "" && account == "" {
+				return fmt.Errorf("daemon needs --account with storage.path_template: the account its AWS credentials belong to, whose database it analyzes")
+			}
+
 			interval, err := parseDuration(intervalStr)
 			if err != nil {
 				return fmt.Errorf("invalid interval %q: %w", intervalStr, err)
 			}
+			jitter, err := parseDuration(jitterStr)
+			if err != nil {
+				return fmt.Errorf("invalid jitter %q: %w", jitterStr, err)
+			}
+			if jitter < 0 || jitter >= interval {
+				return fmt.Errorf("invalid jitter %s: must be at least 0 and less than the interval (%s)", jitter, interval)
+			}
 
 			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
 			defer stop()
 
-			// Start metrics HTTP server with graceful shutdown.
-			metricsSrv := &http.Server{
-				Addr:    cfg.Metrics.Endpoint,
-				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					if r.URL.Path == "/metrics" {
-						m.Handler().ServeHTTP(w, r)
-						return
-					}
-					http.NotFound(w, r)
-				}),
+			mappings, err := sdkMappings(cfg.Correlation)
+			if err != nil {
+				return err
 			}
-			go func() {
-				log.Info("metrics server listening", "addr", cfg.Metrics.Endpoint)
-				if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-					log.Error("metrics server error", "error", err)
-				}
-			}()
 
-			// Start OTel receiver.
-			recv, err := receiver.New(cfg.OTel.Endpoint, db, log, m)
+			// Create the OTel receiver first so readiness can report on it.
+			recv, err := receiver.New(cfg.OTel.Endpoint, log, m, receiver.Options{
+				RateLimit: receiver.RateLimit{
+					RequestsPerSecond: cfg.OTel.RateLimit.RequestsPerSecond,
+					Burst:             cfg.OTel.RateLimit.Burst,
+					PerRemoteAddr:     cfg.OTel.RateLimit.PerRemoteAddr,
+				},
+				EnableJSONL:     cfg.OTel.EnableJSONL,
+				SDKMappings:     mappings,
+				RoleAttribute:   cfg.OTel.RoleAttribute,
+				ShutdownTimeout: cfg.OTel.ShutdownTimeout,
+			})
 			if err != nil {
 				return fmt.Errorf("creating receiver: %w", err)
 			}
 
+			// Start metrics HTTP server (with health probes) and graceful shutdown.
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", m.Handler())
+			health.Register(mux,
+				health.Check{Name: "receiver", Fn: func(context.Context) error {
+					if !recv.Listening() {
+						return errors.New("OTLP receiver is not listening")
+					}
+					return nil
+				}},
+				health.Check{Name: "database", Fn: db.Ping},
+			)
+			metricsSrv, err := startMetricsServer(cfg.Metrics.Endpoint, mux, log)
+			if err != nil {
+				return err
+			}
+
 			// Track both the receiver and all analysis goroutines.
 			var wg sync.WaitGroup
 
+			// Flushing outlives ctx until the receiver has drained in-flight
+			// requests, so nothing it accepted during shutdown is lost.
+			flushCtx, stopFlush := context.WithCancel(context.Background())
+			defer stopFlush()
+
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
+				defer stopFlush()
 				if err := recv.Start(ctx); err != nil {
 					log.Error("receiver stopped", "error", err)
 				}
 			}()
 
-			log.Info("daemon started", "interval", interval)
-			ticker := time.NewTicker(interval)
-			defer ticker.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runDBStats(ctx, db, cfg.Storage.Path, m, log)
+			}()
+
+			// Flush buffered usage from every source into the database, or
+			// into each account's own with storage.path_template.
+			var sink sources.Sink = db
+			if cfg.Storage.PathTemplate != "" {
+				router := storage.NewAccountRouter(cfg.Storage.PathFor, dbOptions(cfg), db, cfg.AWS.AllowedAccountIDs)
+				router.Use(account, db)
+				defer router.Close()
+				sink = router
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sources.Run(flushCtx, sink, sourceFlushInterval, log, recv)
+			}()
+
+			sched := schedule{interval: interval, jitter: jitter}
+			log.Info("daemon started", "interval", interval, "jitter", jitter)
 
 			launchAnalysis := func() {
 				if skipIfRunning {
@@ -416,6 +1680,12 @@ func daemonCmd() *cobra.Command {
 					analyzeMu.Unlock()
 				}
 
+				runCtx := ctx
+				if dryRunFirst {
+					runCtx = withRunOptions(ctx, func(o *runOptions) { o.dryRun = true })
+					dryRunFirst = false
+				}
+
 				wg.Add(1)
 				go func() {
 					defer wg.Done()
@@ -426,19 +1696,21 @@ func daemonCmd() *cobra.Command {
 							analyzeMu.Unlock()
 						}()
 					}
-					if err := runAnalyze(ctx, cfg, db, m, log); err != nil {
+					if err := runAnalyze(runCtx, cfg, db, m, log); err != nil {
 						log.Error("analysis failed", "error", err)
 					}
 				}()
 			}
 
-			// Run immediately on start.
-			launchAnalysis()
+			// Run on start, after a random offset when jittered.
+			timer := time.NewTimer(sched.first())
+			defer timer.Stop()
 
 			for {
 				select {
-				case <-ticker.C:
+				case <-timer.C:
 					launchAnalysis()
+					timer.Reset(sched.next())
 				case <-ctx.Done():
 					log.Info("daemon shutting down, waiting for in-flight work...")
 					wg.Wait()
@@ -451,12 +1723,41 @@ func daemonCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVar(&intervalStr, "interval", "24h", "analysis interval (e.g. 1h, 7d, 30m)")
-	cmd.Flags().BoolVar(&skipIfRunning, "skip-if-running", true, "skip analysis if previous run is still active")
+	cmd.Flags().StringVar(&jitterStr, "jitter", "0", "randomly delay the first run by up to this much and vary each interval by as much, to spread load across daemons (e.g. 10m)")
+	cmd.Flags().BoolVar(&skipIfRunning, "skip-if-running", true, "skip analysis if previous run is still active (otherwise wait for it to finish)")
+	cmd.Flags().BoolVar(&dryRunFirst, "dry-run-first", false, "make the first analysis print its summary without saving, exporting or purging anything")
 	return cmd
 }
 
 // --- helpers ---
 
+// dbOptions returns the SQLite options configured in cfg.
+func dbOptions(cfg *config.Config) storage.Options {
+	return storage.Options{
+		BusyTimeoutMS: cfg.Storage.BusyTimeoutMS,
+		CacheSize:     cfg.Storage.CacheSize,
+		SkipMigrate:   cfg.Storage.SkipMigrate,
+	}
+}
+
+// startMetricsServer serves handler on addr. It binds before returning, so a
+// port already in use fails daemon startup instead of leaving the daemon
+// running without metrics or health probes.
+func startMetricsServer(addr string, handler http.Handler, log *slog.Logger) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics server: %w", err)
+	}
+	srv := &http.Server{Addr: addr, Handler: handler}
+	log.Info("metrics server listening", "addr", ln.Addr().String())
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server error", "error", err)
+		}
+	}()
+	return srv, nil
+}
+
 func newLogger(verbose bool) *slog.Logger {
 	level := slog.LevelInfo
 	if verbose {
@@ -467,6 +1768,18 @@ func newLogger(verbose bool) *slog.Logger {
 	}))
 }
 
+// parseAsOf parses a --as-of timestamp: RFC 3339, or a bare date meaning
+// the end of that day in UTC.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		return d.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("--as-of %q: expected an RFC 3339 timestamp or YYYY-MM-DD", s)
+}
+
 // parseDuration parses a duration string, extending time.ParseDuration to support
 // day suffixes ("d"). Examples: "7d", "24h", "30m".
 func parseDuration(s string) (time.Duration, error) {
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/config"
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+func TestPrintAnalysisSummary_Quiet(t *testing.T) {
+	results := []correlation.Result{
+		{IAMRole: "arn:aws:iam::123456789012:role/app", RiskLevel: "HIGH", Unused: []string{"s3:DeleteObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/clean", RiskLevel: "LOW"},
+	}
+	skipped := []scraper.ScrapeError{{RoleName: "broken", Err: errors.New("access denied")}}
+
+	var loud, quiet bytes.Buffer
+	printAnalysisSummary(output{w: &loud}, results, skipped)
+	printAnalysisSummary(output{w: &quiet, quiet: true}, results, skipped)
+
+	if !strings.Contains(loud.String(), "=== Shinkai Shoujo Analysis Results ===") {
+		t.Errorf("expected banner without --quiet, got:\n%s", loud.String())
+	}
+	want := "Roles analyzed: 2\n" +
+		"  [HIGH] arn:aws:iam::123456789012:role/app — 1 unused privilege(s)\n" +
+		"WARNING: 1 role(s) could not be scraped and were not analyzed:\n" +
+		"  broken — access denied\n"
+	if quiet.String() != want {
+		t.Errorf("quiet output:\n%q\nwant:\n%q", quiet.String(), want)
+	}
+}
+
+func TestReportNameCollisions(t *testing.T) {
+	assignments := []scraper.RoleAssignment{
+		{RoleName: "App", RoleARN: "arn:aws:iam::222222222222:role/App"},
+		{RoleName: "App", RoleARN: "arn:aws:iam::111111111111:role/App"},
+		{RoleName: "Other", RoleARN: "arn:aws:iam::111111111111:role/Other"},
+	}
+
+	var out, logs bytes.Buffer
+	reportNameCollisions(output{w: &out, quiet: true}, slog.New(slog.NewTextHandler(&logs, nil)), assignments)
+	want := "WARNING: 1 role name(s) exist in more than one account; tell them apart by ARN:\n" +
+		"  App — arn:aws:iam::111111111111:role/App, arn:aws:iam::222222222222:role/App\n"
+	if out.String() != want {
+		t.Errorf("output:\n%q\nwant:\n%q", out.String(), want)
+	}
+	if !strings.Contains(logs.String(), "role name exists in more than one account") {
+		t.Errorf("expected a collision warning in the log, got:\n%s", logs.String())
+	}
+}
+
+func TestReportNameCollisionsIgnoresRolesNotScraped(t *testing.T) {
+	// Only this run's roles count: a same-named role analyzed in another
+	// account earlier is not in assignments.
+	assignments := []scraper.RoleAssignment{{RoleName: "App", RoleARN: "arn:aws:iam::111111111111:role/App"}}
+	var out, logs bytes.Buffer
+	reportNameCollisions(output{w: &out, quiet: true}, slog.New(slog.NewTextHandler(&logs, nil)), assignments)
+	if out.Len() != 0 {
+		t.Errorf("expected no warning, got:\n%s", out.String())
+	}
+}
+
+func TestPrintUnobservedRolesByOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.yaml")
+	if err := os.WriteFile(path, []byte("owners:\n  - role: \"app-.*\"\n    team: platform\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := ownership.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := []string{
+		"arn:aws:iam::123456789012:role/app-api",
+		"arn:aws:iam::123456789012:role/batch",
+		"arn:aws:iam::123456789012:role/app-web",
+	}
+
+	var out bytes.Buffer
+	printUnobservedRoles(output{w: &out}, roles, owners, true)
+	want := "platform (2)\n" +
+		"  arn:aws:iam::123456789012:role/app-api\n" +
+		"  arn:aws:iam::123456789012:role/app-web\n" +
+		"(no owner) (1)\n" +
+		"  arn:aws:iam::123456789012:role/batch\n"
+	if out.String() != want {
+		t.Errorf("output:\n%q\nwant:\n%q", out.String(), want)
+	}
+}
+
+func TestParseAndPrintLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"audit=Q3", " team = payments "})
+	if err != nil {
+		t.Fatalf("parseLabels() error: %v", err)
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"audit": "Q3", "team": "payments"}) {
+		t.Errorf("labels = %v", labels)
+	}
+	for _, bad := range [][]string{{"audit"}, {"=Q3"}, {"audit=Q3", "audit=Q4"}} {
+		if _, err := parseLabels(bad); err == nil {
+			t.Errorf("parseLabels(%q): expected an error", bad)
+		}
+	}
+
+	var out bytes.Buffer
+	at := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	printAnalysisRuns(output{w: &out}, []storage.AnalysisRun{
+		{RunAt: at, Roles: 42, Labels: labels},
+		{RunAt: at.Add(-time.Hour), Roles: 7},
+	})
+	want := "2026-07-01T12:00:00Z      42 roles  audit=Q3,team=payments\n" +
+		"2026-07-01T11:00:00Z       7 roles  -\n"
+	if out.String() != want {
+		t.Errorf("output:\n%q\nwant:\n%q", out.String(), want)
+	}
+}
+
+func TestPrintSummaryJSON(t *testing.T) {
+	results := []correlation.Result{
+		{IAMRole: "arn:aws:iam::123456789012:role/a", RiskLevel: "HIGH", Unused: []string{"iam:PassRole", "s3:DeleteObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/b", RiskLevel: "HIGH", Unused: []string{"ec2:TerminateInstances"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/c", RiskLevel: "MEDIUM", Unused: []string{"s3:PutObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/d", RiskLevel: "LOW", Unused: []string{"s3:ListBucket", "s3:GetObject", "sqs:ReceiveMessage"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/e", RiskLevel: "ORPHANED", Used: []string{"s3:GetObject"}},
+	}
+
+	var out bytes.Buffer
+	printSummaryJSON(context.Background(), output{w: &out}, results)
+	if out.Len() != 0 {
+		t.Errorf("expected nothing without --summary-json, got %q", out.String())
+	}
+
+	ctx := withRunOptions(context.Background(), func(o *runOptions) { o.summaryJSON = true })
+	printSummaryJSON(ctx, output{w: &out, quiet: true}, results)
+	if want := `{"roles":5,"high":2,"medium":1,"unused_total":7}` + "\n"; out.String() != want {
+		t.Errorf("summary = %q, want %q", out.String(), want)
+	}
+}
+
+func TestCheckBaselineFailsOnNewUnusedPrivilege(t *testing.T) {
+	const role = "arn:aws:iam::123456789012:role/app"
+	baseline := []correlation.Result{{IAMRole: role, RiskLevel: "LOW", Unused: []string{"s3:GetObject"}}}
+	ctx := withRunOptions(context.Background(), func(o *runOptions) {
+		o.compare = true
+		o.baseline = baseline
+	})
+
+	var out bytes.Buffer
+	same := []correlation.Result{{IAMRole: role, RiskLevel: "LOW", Unused: []string{"s3:GetObject"}}}
+	if err := checkBaseline(ctx, output{w: &out}, same); err != nil {
+		t.Fatalf("unchanged results: %v", err)
+	}
+
+	out.Reset()
+	widened := []correlation.Result{{IAMRole: role, RiskLevel: "LOW", Unused: []string{"s3:GetObject", "s3:ListBucket"}}}
+	if err := checkBaseline(ctx, output{w: &out, quiet: true}, widened); err == nil {
+		t.Fatal("expected an error for a new unused privilege")
+	}
+	want := "  " + role + "\n    newly unused  s3:ListBucket\n"
+	if out.String() != want {
+		t.Errorf("output:\n%q\nwant:\n%q", out.String(), want)
+	}
+}
+
+func TestDaemonFailsWhenMetricsPortTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	cfg := fmt.Sprintf("storage:\n  path: %s\notel:\n  endpoint: 127.0.0.1:0\nmetrics:\n  endpoint: %s\n",
+		filepath.Join(dir, "shinkai.db"), taken.Addr())
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	root := rootCmd()
+	root.SetArgs([]string{"--config", cfgPath, "daemon"})
+	done := make(chan error, 1)
+	go func() { done <- root.Execute() }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "metrics server") {
+			t.Errorf("expected a metrics server error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon kept running with its metrics port taken")
+	}
+}
+
+func TestGenerateRefusesStaleResults(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "shinkai.db")
+	db, err := storage.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveAnalysisResult(context.Background(), storage.AnalysisResult{
+		IAMRole:       "arn:aws:iam::123456789012:role/App",
+		AnalysisDate:  time.Now().Add(-72 * time.Hour),
+		AssignedPrivs: []string{"s3:GetObject", "s3:PutObject"},
+		UsedPrivs:     []string{"s3:GetObject"},
+		UnusedPrivs:   []string{"s3:PutObject"},
+		RiskLevel:     "MEDIUM",
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Generate.MaxAge = 24 * time.Hour
+
+	out := filepath.Join(dir, "main.tf")
+	generate := func(extra ...string) error {
+		t.Helper()
+		db, err := storage.Open(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.WithValue(context.Background(), keyConfig, cfg)
+		ctx = context.WithValue(ctx, keyDB, db)
+		ctx = context.WithValue(ctx, keyMetrics, metrics.NewWithRegistry(prometheus.NewRegistry()))
+		ctx = context.WithValue(ctx, keyLogger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		ctx = withRunOptions(ctx, func(o *runOptions) { o.quiet = true })
+		gen := generateCmd()
+		gen.SetArgs(append([]string{"terraform", "--output", out}, extra...))
+		gen.SilenceUsage, gen.SilenceErrors = true, true
+		return gen.ExecuteContext(ctx)
+	}
+	if err := generate(); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected stale results to be refused, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no output from a refused run, stat error %v", err)
+	}
+	if err := generate("--force"); err != nil {
+		t.Fatalf("generate --force: %v", err)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("expected output with --force: %v", err)
+	}
+
+	// A run that just reused the result unchanged makes it current again.
+	db, err = storage.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetLatestAnalysisResults(context.Background())
+	if err == nil {
+		err = db.SaveAnalysisRun(context.Background(), time.Now(), stored, nil)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(); err != nil {
+		t.Errorf("expected results reused by a recent run to be fresh, got %v", err)
+	}
+}
+
+func TestPushMetricsAfterAnalyze(t *testing.T) {
+	pushed := make(chan string, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed <- r.URL.Path
+	}))
+	defer gateway.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Metrics.PushgatewayURL = gateway.URL
+	cfg.Metrics.PushgatewayInstance = "nightly"
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	pushMetrics(context.Background(), cfg, m, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	select {
+	case path := <-pushed:
+		if path != "/metrics/job/shinkai-shoujo/instance/nightly" {
+			t.Errorf("pushed to %s, want the default job and configured instance", path)
+		}
+	default:
+		t.Fatal("expected a push to the pushgateway")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	const interval, jitter = time.Hour, 10 * time.Minute
+	r := rand.New(rand.NewSource(1))
+	s := schedule{interval: interval, jitter: jitter, randN: r.Int63n}
+	for i := 0; i < 1000; i++ {
+		if d := s.first(); d < 0 || d >= jitter {
+			t.Fatalf("first() = %s, want within [0, %s)", d, jitter)
+		}
+		if d := s.next(); d < interval-jitter || d > interval+jitter {
+			t.Fatalf("next() = %s, want within %s ± %s", d, interval, jitter)
+		}
+	}
+
+	unjittered := schedule{interval: interval}
+	if d := unjittered.first(); d != 0 {
+		t.Errorf("first() without jitter = %s, want 0", d)
+	}
+	if d := unjittered.next(); d != interval {
+		t.Errorf("next() without jitter = %s, want %s", d, interval)
+	}
+
+	// A jitter as large as the interval must still never yield a
+	// non-positive delay.
+	wide := schedule{interval: interval, jitter: interval, randN: func(int64) int64 { return 0 }}
+	if d := wide.next(); d != interval {
+		t.Errorf("next() = %s, want fallback to %s", d, interval)
+	}
+}
+
+func TestCheckAccountFlag(t *testing.T) {
+	allowed := []string{"123456789012"}
+	for _, tc := range []struct {
+		account string
+		allowed []string
+		wantErr bool
+	}{
+		{"", allowed, false},
+		{"123456789012", allowed, false},
+		{"210987654321", nil, false},
+		{"210987654321", allowed, true},
+		{"../123456789012", nil, true},
+	} {
+		if err := checkAccountFlag(tc.account, tc.allowed); (err != nil) != tc.wantErr {
+			t.Errorf("checkAccountFlag(%q, %v) error = %v, want error %v", tc.account, tc.allowed, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCheckScrapedAccount(t *testing.T) {
+	// Credentials of account B while --account selects A's database, as
+	// analyze --role scrapes them.
+	ctx := withRunOptions(context.Background(), func(o *runOptions) { o.account = "111111111111" })
+	other := []scraper.RoleAssignment{{RoleName: "App", RoleARN: "arn:aws:iam::222222222222:role/App"}}
+	if err := checkScrapedAccount(ctx, other); err == nil || !strings.Contains(err.Error(), "222222222222") {
+		t.Errorf("expected the credentials' account to be refused, got %v", err)
+	}
+
+	same := []scraper.RoleAssignment{{RoleName: "App", RoleARN: "arn:aws:iam::111111111111:role/App"}}
+	if err := checkScrapedAccount(ctx, same); err != nil {
+		t.Errorf("checkScrapedAccount() error: %v", err)
+	}
+	if err := checkScrapedAccount(context.Background(), other); err != nil {
+		t.Errorf("without --account any account is accepted, got %v", err)
+	}
+}
+
+func TestCheckScrapedRole(t *testing.T) {
+	scraped := scraper.RoleAssignment{RoleName: "App", RoleARN: "arn:aws:iam::222222222222:role/App"}
+	for _, tc := range []struct {
+		role    string
+		wantErr bool
+	}{
+		{"App", false},
+		{"arn:aws:iam::222222222222:role/App", false},
+		{"arn:aws:iam::111111111111:role/App", true},
+		{"arn:aws-cn:iam::222222222222:role/App", true},
+	} {
+		if err := checkScrapedRole(tc.role, scraped); (err != nil) != tc.wantErr {
+			t.Errorf("checkScrapedRole(%q) error = %v, want error %v", tc.role, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// output is where a command prints for the user. Notef is for decorative
+// text — banners, hints and "wrote file" notices — which --quiet drops, so
+// scripts parsing stdout see only a command's essential output.
+type output struct {
+	w     io.Writer
+	quiet bool
+}
+
+// Printf writes essential output.
+func (o output) Printf(format string, args ...any) {
+	fmt.Fprintf(o.w, format, args...)
+}
+
+// Notef writes decorative output unless quiet.
+func (o output) Notef(format string, args ...any) {
+	if !o.quiet {
+		fmt.Fprintf(o.w, format, args...)
+	}
+}
+
+// stdout returns the output on stdout for the command running under ctx.
+func stdout(ctx context.Context) output {
+	return output{w: os.Stdout, quiet: ctxRunOptions(ctx).quiet}
+}
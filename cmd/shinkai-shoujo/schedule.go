@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// schedule spaces the daemon's analysis runs. With a jitter, the first run
+// is delayed by up to jitter and each later one lands within jitter either
+// side of interval, so a fleet of daemons started together does not scrape
+// IAM at the same instant.
+type schedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	// randN returns a random value in [0, n); rand.Int63n when nil.
+	randN func(n int64) int64
+}
+
+func (s schedule) rand(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	randN := s.randN
+	if randN == nil {
+		randN = rand.Int63n
+	}
+	return time.Duration(randN(int64(n)))
+}
+
+// first returns the delay before the first run: none without a jitter.
+func (s schedule) first() time.Duration {
+	return s.rand(s.jitter)
+}
+
+// next returns the delay between two runs, interval offset by up to jitter
+// either way. It is always positive: an offset that would bring it to zero
+// or below falls back to interval.
+func (s schedule) next() time.Duration {
+	d := s.interval - s.jitter + s.rand(2*s.jitter+1)
+	if d <= 0 {
+		return s.interval
+	}
+	return d
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/generator"
+)
+
+// --- schema command ---
+
+func schemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "schema",
+		Short:       "Print schemas for machine-readable output",
+		Annotations: map[string]string{annotationNoSetup: "true"},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:         "json",
+		Short:       "Print the JSON Schema (draft 2020-12) for 'generate json' output",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationNoSetup: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := generator.JSONSchema()
+			if err != nil {
+				return fmt.Errorf("building JSON schema: %w", err)
+			}
+			_, err = fmt.Fprintln(os.Stdout, string(data))
+			return err
+		},
+	})
+	return cmd
+}
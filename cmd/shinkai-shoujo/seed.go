@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/seed"
+)
+
+// --- seed command ---
+
+func seedCmd() *cobra.Command {
+	var file string
+	var timestamp string
+
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Import known-used privileges to bootstrap analysis",
+		Long: `Records (role, privilege) pairs from a JSON file as observed usage, so a
+fresh deployment has a baseline before trace history accumulates.
+
+The file is a JSON array:
+  [{"role": "arn:aws:iam::123456789012:role/App", "privilege": "s3:GetObject",
+    "timestamp": "2024-05-01T00:00:00Z"}]
+"timestamp" is optional and defaults to --timestamp.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, db, _, log := mustFromCtx(cmd)
+			defer db.Close()
+
+			at := time.Now()
+			if timestamp != "" {
+				t, err := time.Parse(time.RFC3339, timestamp)
+				if err != nil {
+					return fmt.Errorf("invalid --timestamp %q (expected RFC 3339, e.g. 2024-05-01T00:00:00Z): %w", timestamp, err)
+				}
+				at = t
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return fmt.Errorf("opening seed file: %w", err)
+			}
+			defer f.Close()
+
+			records, err := seed.Parse(f, at)
+			if err != nil {
+				return err
+			}
+			if err := db.BatchRecordPrivilegeUsage(cmd.Context(), records); err != nil {
+				return fmt.Errorf("recording seed privileges: %w", err)
+			}
+			log.Info("seeded privilege usage", "records", len(records), "file", file)
+			stdout(cmd.Context()).Notef("Seeded %d privilege observation(s) from %s\n", len(records), file)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "JSON file of {role, privilege[, timestamp]} entries")
+	cmd.Flags().StringVar(&timestamp, "timestamp", "", "RFC 3339 time recorded for entries without one (default: now)")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
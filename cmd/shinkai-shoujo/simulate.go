@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// --- simulate command ---
+
+func simulateCmd() *cobra.Command {
+	var rps float64
+
+	cmd := &cobra.Command{
+		Use:   "simulate [role]",
+		Short: "Confirm unused privileges with the IAM policy simulator",
+		Long: `Checks the unused privileges from the latest analysis against IAM's
+policy simulator (SimulatePrincipalPolicy), which accounts for permissions
+boundaries, conditions and SCPs. Privileges the simulator does not allow are
+flagged: our policy parsing counted them as granted, but the role cannot
+actually use them, so removing them changes nothing.
+
+Requires iam:SimulatePrincipalPolicy in addition to the analyze permissions.
+The API is rate-limited; --rps paces the requests. Pass a role ARN or name
+to check one role.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationReadOnly: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, db, _, log := mustFromCtx(cmd)
+			defer db.Close()
+			ctx := cmd.Context()
+
+			dbResults, err := db.GetLatestAnalysisResults(ctx)
+			if err != nil {
+				return fmt.Errorf("getting analysis results: %w", err)
+			}
+			var selected []correlation.Result
+			for _, r := range toCorrelationResults(dbResults) {
+				if len(r.Unused) == 0 || r.RiskLevel == string(correlation.RiskOrphaned) {
+					continue
+				}
+				if len(args) == 0 || r.IAMRole == args[0] || rolearn.Parse(r.IAMRole).Name == args[0] {
+					selected = append(selected, r)
+				}
+			}
+			if len(selected) == 0 {
+				if len(args) > 0 {
+					return fmt.Errorf("role %q has no unused privileges in the latest analysis results", args[0])
+				}
+				fmt.Println("No unused privileges to simulate. Run 'shinkai-shoujo analyze' first.")
+				return nil
+			}
+
+			// The database is open read-only; recording the simulator's
+			// calls needs a writable handle of its own.
+			var obs *scraper.SelfObserver
+			if cfg.AWS.SelfObserve {
+				rw, err := storage.OpenWithOptions(cfg.Storage.Path, dbOptions(cfg))
+				if err != nil {
+					return fmt.Errorf("opening database to record own AWS calls: %w", err)
+				}
+				defer rw.Close()
+				obs = scraper.NewSelfObserver(cfg.AWS.SelfRole, rw)
+				defer flushSelfObserved(ctx, obs, log)
+			}
+			awsCfg, err := loadAWSConfig(ctx, cfg, obs)
+			if err != nil {
+				return err
+			}
+			if _, err := scraper.VerifyAccount(ctx, awsCfg, cfg.AWS.AllowedAccountIDs); err != nil {
+				return err
+			}
+			sim := scraper.NewSimulator(awsCfg, rps)
+
+			disagreements := 0
+			for _, r := range selected {
+				allowed, err := sim.Allowed(ctx, r.IAMRole, r.Unused)
+				if err != nil {
+					return fmt.Errorf("%w — check that the credentials allow iam:SimulatePrincipalPolicy", err)
+				}
+				var denied, skipped []string
+				for _, p := range r.Unused {
+					ok, simulated := allowed[p]
+					switch {
+					case !simulated:
+						skipped = append(skipped, p)
+					case !ok:
+						denied = append(denied, p)
+					}
+				}
+				sort.Strings(denied)
+				disagreements += len(denied)
+				log.Debug("simulated role", "role", r.IAMRole, "unused", len(r.Unused), "not_allowed", len(denied))
+
+				fmt.Printf("%s\n", r.IAMRole)
+				fmt.Printf("  unused: %d  confirmed allowed: %d  not allowed by simulator: %d  not simulated: %d\n",
+					len(r.Unused), len(r.Unused)-len(denied)-len(skipped), len(denied), len(skipped))
+				for _, p := range denied {
+					fmt.Printf("    %s  (denied by a boundary, SCP or condition — not actually granted)\n", p)
+				}
+			}
+			fmt.Printf("\n%d role(s) simulated, %d privilege(s) where the simulator disagrees with the analysis.\n",
+				len(selected), disagreements)
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64Var(&rps, "rps", 1, "maximum SimulatePrincipalPolicy requests per second")
+	return cmd
+}
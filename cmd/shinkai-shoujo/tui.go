@@ -0,0 +1,39 @@
+//go:build tui
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/tui"
+)
+
+func init() {
+	extraCommands = append(extraCommands, tuiCmd)
+}
+
+// --- tui command ---
+
+func tuiCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Browse the latest analysis results interactively",
+		Long:  "Opens a read-only terminal UI over the stored analysis results. Does not call AWS.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, db, _, _ := mustFromCtx(cmd)
+			defer db.Close()
+
+			dbResults, err := db.GetLatestAnalysisResults(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("getting analysis results: %w", err)
+			}
+			if len(dbResults) == 0 {
+				fmt.Println("No analysis results found. Run 'shinkai-shoujo analyze' first.")
+				return nil
+			}
+			return tui.Run(toCorrelationResults(dbResults))
+		},
+	}
+}
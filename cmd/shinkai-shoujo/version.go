@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/version"
+)
+
+// --- version command ---
+
+func versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "version",
+		Short:       "Print version, commit, build date and Go version",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationNoSetup: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := fmt.Fprint(cmd.OutOrStdout(), version.Get())
+			return err
+		},
+	}
+}
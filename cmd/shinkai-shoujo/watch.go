@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// --- watch command ---
+
+func watchCmd() *cobra.Command {
+	var role string
+	var interval time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print privilege observations as they are recorded",
+		Long: `Tails the database shared with a running daemon, printing each
+(role, privilege) observation as it is recorded, to confirm an exporter is
+wired up. Observations reach the database when the daemon flushes its
+receiver, so they appear in batches, in the order they were written rather
+than by timestamp.
+
+A privilege seen again is printed again with its new timestamp.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationReadOnly: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, db, _, _ := mustFromCtx(cmd)
+			defer db.Close()
+
+			if interval <= 0 {
+				return fmt.Errorf("invalid --interval %s: must be positive", interval)
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer stop()
+
+			tail, err := db.TailPrivilegeUsage(ctx, role)
+			if err != nil {
+				return err
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				records, err := tail.Poll(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				for _, r := range records {
+					fmt.Printf("%s  %s  %s\n", r.Timestamp.UTC().Format(time.RFC3339), r.IAMRole, r.Privilege)
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&role, "role", "", "only show observations for this role ARN or name")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "how often to poll the database")
+	return cmd
+}
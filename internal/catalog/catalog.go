@@ -0,0 +1,164 @@
+// Package catalog embeds a catalog of IAM actions per service, and of the
+// resource type each S3 action acts on, taken from the AWS Service
+// Authorization Reference. It is deliberately partial: only
+// services listed in actions.json are known, and callers must fall back to
+// their wildcard/heuristic behavior for anything else. LoadFile extends or
+// corrects it from a file in the same format.
+package catalog
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//go:embed actions.json
+var actionsJSON []byte
+
+// Documented access levels of an action.
+const (
+	LevelList                  = "List"
+	LevelRead                  = "Read"
+	LevelWrite                 = "Write"
+	LevelPermissionsManagement = "Permissions management"
+	LevelTagging               = "Tagging"
+)
+
+// Levels returns every documented access level.
+func Levels() []string {
+	return []string{LevelList, LevelRead, LevelWrite, LevelPermissionsManagement, LevelTagging}
+}
+
+var (
+	mu sync.RWMutex
+	// services maps a lowercase service prefix to its actions and their
+	// documented access level.
+	services = mustLoad(actionsJSON)
+)
+
+func mustLoad(data []byte) map[string]map[string]string {
+	m, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: embedded action catalog is invalid: %v", err))
+	}
+	return m
+}
+
+// parse decodes a catalog, lowercasing service prefixes and rejecting
+// unknown access levels.
+func parse(data []byte) (map[string]map[string]string, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	m := make(map[string]map[string]string, len(raw))
+	for service, actions := range raw {
+		for action, level := range actions {
+			switch level {
+			case LevelList, LevelRead, LevelWrite, LevelPermissionsManagement, LevelTagging:
+			default:
+				return nil, fmt.Errorf("%s:%s: unknown access level %q", service, action, level)
+			}
+		}
+		m[strings.ToLower(service)] = actions
+	}
+	return m, nil
+}
+
+// LoadFile merges a JSON object of {"service": {"Action": "access level"}}
+// from path over the catalog, adding services and actions and replacing the
+// access level of those already known. It is meant to be called once at
+// startup.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading action catalog: %w", err)
+	}
+	extra, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("parsing action catalog %s: %w", path, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for service, actions := range extra {
+		merged := make(map[string]string, len(services[service])+len(actions))
+		for name, level := range services[service] {
+			merged[name] = level
+		}
+		for name, level := range actions {
+			for known := range merged {
+				if strings.EqualFold(known, name) {
+					delete(merged, known)
+				}
+			}
+			merged[name] = level
+		}
+		services[service] = merged
+	}
+	return nil
+}
+
+// AccessLevel returns the documented access level of a "service:Action"
+// privilege, matching case-insensitively. ok is false for wildcards and for
+// actions not in the catalog.
+func AccessLevel(privilege string) (level string, ok bool) {
+	service, action, found := strings.Cut(privilege, ":")
+	if !found || strings.Contains(action, "*") {
+		return "", false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	known := services[strings.ToLower(service)]
+	if level, ok := known[action]; ok {
+		return level, true
+	}
+	for name, level := range known {
+		if strings.EqualFold(name, action) {
+			return level, true
+		}
+	}
+	return "", false
+}
+
+// Actions returns every catalogued action of service as "service:Action",
+// sorted. ok is false when the service is not in the catalog.
+func Actions(service string) (actions []string, ok bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	known, ok := services[strings.ToLower(service)]
+	if !ok {
+		return nil, false
+	}
+	prefix := strings.ToLower(service) + ":"
+	actions = make([]string, 0, len(known))
+	for name := range known {
+		actions = append(actions, prefix+name)
+	}
+	sort.Strings(actions)
+	return actions, true
+}
+
+// Expand returns the catalogued actions matched by an IAM action pattern with
+// a trailing wildcard, e.g. "s3:*" or "s3:Get*". ok is false when the pattern
+// has no service prefix or the service is not in the catalog.
+func Expand(pattern string) (actions []string, ok bool) {
+	service, action, found := strings.Cut(pattern, ":")
+	if !found || !strings.HasSuffix(action, "*") {
+		return nil, false
+	}
+	all, ok := Actions(service)
+	if !ok {
+		return nil, false
+	}
+	prefix := strings.ToLower(service) + ":" + strings.TrimSuffix(action, "*")
+	for _, a := range all {
+		if strings.HasPrefix(a, prefix) {
+			actions = append(actions, a)
+		}
+	}
+	return actions, true
+}
@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestActionsKnownService(t *testing.T) {
+	actions, ok := Actions("S3")
+	if !ok {
+		t.Fatal("expected s3 to be catalogued")
+	}
+	found := map[string]bool{}
+	for _, a := range actions {
+		found[a] = true
+	}
+	if !found["s3:GetObject"] || !found["s3:DeleteObject"] {
+		t.Errorf("expected core s3 actions in catalog, got %d actions", len(actions))
+	}
+}
+
+func TestActionsUnknownService(t *testing.T) {
+	if _, ok := Actions("notaservice"); ok {
+		t.Error("expected unknown service to report ok=false")
+	}
+}
+
+func TestExpandPrefixWildcard(t *testing.T) {
+	actions, ok := Expand("sqs:Send*")
+	if !ok {
+		t.Fatal("expected sqs:Send* to expand")
+	}
+	if len(actions) != 1 || actions[0] != "sqs:SendMessage" {
+		t.Errorf("expected [sqs:SendMessage], got %v", actions)
+	}
+	if _, ok := Expand("*"); ok {
+		t.Error("global wildcard must not expand")
+	}
+}
+
+func TestAccessLevel(t *testing.T) {
+	tests := []struct {
+		privilege string
+		level     string
+		ok        bool
+	}{
+		{"s3:PutObjectAcl", LevelPermissionsManagement, true},
+		{"S3:putobjectacl", LevelPermissionsManagement, true},
+		{"dynamodb:Query", LevelRead, true},
+		{"sqs:ListQueues", LevelList, true},
+		{"s3:Get*", "", false},
+		{"ec2:DescribeInstances", "", false},
+		{"*", "", false},
+	}
+	for _, tt := range tests {
+		level, ok := AccessLevel(tt.privilege)
+		if level != tt.level || ok != tt.ok {
+			t.Errorf("AccessLevel(%q) = %q, %t; want %q, %t", tt.privilege, level, ok, tt.level, tt.ok)
+		}
+	}
+}
+
+func TestResourceType(t *testing.T) {
+	tests := []struct {
+		privilege    string
+		resourceType string
+		ok           bool
+	}{
+		{"s3:GetObject", ResourceObject, true},
+		{"s3:replicatedelete", ResourceObject, true},
+		{"s3:ListBucketMultipartUploads", ResourceBucket, true},
+		{"s3:GetBucketObjectLockConfiguration", ResourceBucket, true},
+		{"s3:CreateAccessPoint", ResourceAccessPoint, true},
+		{"s3:ListAllMyBuckets", "", false},
+		{"s3:Get*", "", false},
+		{"dynamodb:GetItem", "", false},
+	}
+	for _, tt := range tests {
+		resourceType, ok := ResourceType(tt.privilege)
+		if resourceType != tt.resourceType || ok != tt.ok {
+			t.Errorf("ResourceType(%q) = %q, %t; want %q, %t", tt.privilege, resourceType, ok, tt.resourceType, tt.ok)
+		}
+	}
+}
+
+func TestLoadFileMergesOverCatalog(t *testing.T) {
+	saved := services
+	t.Cleanup(func() { services = saved })
+	services = mustLoad(actionsJSON)
+
+	path := filepath.Join(t.TempDir(), "actions.json")
+	data := `{"EC2": {"DescribeInstances": "List"}, "s3": {"getobject": "Write"}}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if level, _ := AccessLevel("ec2:DescribeInstances"); level != LevelList {
+		t.Errorf("ec2:DescribeInstances = %q, want added service", level)
+	}
+	if level, _ := AccessLevel("s3:GetObject"); level != LevelWrite {
+		t.Errorf("s3:GetObject = %q, want overridden level", level)
+	}
+	if level, _ := AccessLevel("s3:PutObject"); level != LevelWrite {
+		t.Errorf("s3:PutObject = %q, want embedded level kept", level)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"s3": {"GetObject": "Admin"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path); err == nil {
+		t.Error("expected an unknown access level to be rejected")
+	}
+}
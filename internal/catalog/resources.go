@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed resources.json
+var resourcesJSON []byte
+
+// Resource types an action can act on, as named in the Service
+// Authorization Reference.
+const (
+	ResourceBucket      = "bucket"
+	ResourceObject      = "object"
+	ResourceAccessPoint = "accesspoint"
+)
+
+// resourceTypes maps a lowercase service prefix to its actions and the one
+// resource type each acts on. Actions acting on no resource type ("*"), or
+// on several, are left out.
+var resourceTypes = mustLoadResources(resourcesJSON)
+
+func mustLoadResources(data []byte) map[string]map[string]string {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		panic(fmt.Sprintf("BUG: embedded resource type catalog is invalid: %v", err))
+	}
+	m := make(map[string]map[string]string, len(raw))
+	for service, actions := range raw {
+		m[strings.ToLower(service)] = actions
+	}
+	return m
+}
+
+// ResourceType returns the resource type a "service:Action" privilege acts
+// on, e.g. ResourceObject for "s3:GetObject", matching case-insensitively.
+// ok is false for wildcards, for actions not in the catalog and for actions
+// without a single resource type.
+func ResourceType(privilege string) (resourceType string, ok bool) {
+	service, action, found := strings.Cut(privilege, ":")
+	if !found || strings.Contains(action, "*") {
+		return "", false
+	}
+	known := resourceTypes[strings.ToLower(service)]
+	if t, ok := known[action]; ok {
+		return t, true
+	}
+	for name, t := range known {
+		if strings.EqualFold(name, action) {
+			return t, true
+		}
+	}
+	return "", false
+}
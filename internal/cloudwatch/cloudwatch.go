@@ -0,0 +1,127 @@
+// Package cloudwatch publishes analysis results as CloudWatch metrics, for
+// teams that watch AWS rather than Prometheus. It calls PutMetricData
+// through the SDK's CloudWatch client.
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cw "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// DefaultNamespace is the metric namespace used when none is configured.
+const DefaultNamespace = "ShinkaiShoujo"
+
+// MaxDatapoints is the most data points one PutMetricData call accepts.
+const MaxDatapoints = 1000
+
+// Datum is one CloudWatch data point.
+type Datum struct {
+	MetricName string
+	Dimensions map[string]string
+	Value      float64
+	Unit       string
+	Timestamp  time.Time
+}
+
+// Client sends data points to CloudWatch. APIClient is the real one.
+type Client interface {
+	PutMetricData(ctx context.Context, namespace string, data []Datum) error
+}
+
+// Data turns results into data points at now:
+//
+//   - UnusedPrivileges, the number of unused privileges, per Role and
+//     RiskLevel, like the shinkai_unused_privileges gauge;
+//   - HighRiskRoles, the number of HIGH risk roles.
+func Data(results []correlation.Result, now time.Time) []Datum {
+	data := make([]Datum, 0, len(results)+1)
+	high := 0
+	for _, r := range results {
+		if r.RiskLevel == string(correlation.RiskHigh) {
+			high++
+		}
+		data = append(data, Datum{
+			MetricName: "UnusedPrivileges",
+			Dimensions: map[string]string{"Role": r.IAMRole, "RiskLevel": r.RiskLevel},
+			Value:      float64(len(r.Unused)),
+			Unit:       "Count",
+			Timestamp:  now,
+		})
+	}
+	return append(data, Datum{MetricName: "HighRiskRoles", Value: float64(high), Unit: "Count", Timestamp: now})
+}
+
+// Emit sends the data points for results to client under namespace, in
+// batches of at most MaxDatapoints. It stops at the first failed batch.
+func Emit(ctx context.Context, client Client, namespace string, results []correlation.Result) error {
+	data := Data(results, time.Now())
+	for start := 0; start < len(data); start += MaxDatapoints {
+		end := min(start+MaxDatapoints, len(data))
+		if err := client.PutMetricData(ctx, namespace, data[start:end]); err != nil {
+			return fmt.Errorf("putting metric data %d-%d of %d: %w", start+1, end, len(data), err)
+		}
+	}
+	return nil
+}
+
+// APIClient calls PutMetricData through the SDK's CloudWatch client, with
+// the credentials, region and retries of an AWS config. It needs
+// cloudwatch:PutMetricData.
+type APIClient struct {
+	api putMetricDataAPI
+}
+
+// putMetricDataAPI is the part of the SDK client APIClient uses.
+type putMetricDataAPI interface {
+	PutMetricData(ctx context.Context, params *cw.PutMetricDataInput, optFns ...func(*cw.Options)) (*cw.PutMetricDataOutput, error)
+}
+
+// NewAPIClient returns an APIClient for cfg.
+func NewAPIClient(cfg aws.Config) *APIClient {
+	return &APIClient{api: cw.NewFromConfig(cfg)}
+}
+
+func (c *APIClient) PutMetricData(ctx context.Context, namespace string, data []Datum) error {
+	_, err := c.api.PutMetricData(ctx, &cw.PutMetricDataInput{
+		Namespace:  aws.String(namespace),
+		MetricData: metricData(data),
+	})
+	return err
+}
+
+// metricData converts data points to the SDK's form, with each point's
+// dimensions sorted by name.
+func metricData(data []Datum) []types.MetricDatum {
+	out := make([]types.MetricDatum, 0, len(data))
+	for _, d := range data {
+		datum := types.MetricDatum{
+			MetricName: aws.String(d.MetricName),
+			Value:      aws.Float64(d.Value),
+			Unit:       types.StandardUnit(d.Unit),
+		}
+		if !d.Timestamp.IsZero() {
+			datum.Timestamp = aws.Time(d.Timestamp)
+		}
+		names := make([]string, 0, len(d.Dimensions))
+		for name := range d.Dimensions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			datum.Dimensions = append(datum.Dimensions, types.Dimension{
+				Name:  aws.String(name),
+				Value: aws.String(d.Dimensions[name]),
+			})
+		}
+		out = append(out, datum)
+	}
+	return out
+}
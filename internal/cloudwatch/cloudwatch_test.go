@@ -0,0 +1,131 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cw "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// fakeClient records every PutMetricData call.
+type fakeClient struct {
+	namespaces []string
+	batches    [][]Datum
+	err        error
+}
+
+func (f *fakeClient) PutMetricData(_ context.Context, namespace string, data []Datum) error {
+	f.namespaces = append(f.namespaces, namespace)
+	f.batches = append(f.batches, data)
+	return f.err
+}
+
+func TestEmitPutsUnusedPrivilegesAndHighRiskRoles(t *testing.T) {
+	results := []correlation.Result{
+		{IAMRole: "arn:aws:iam::123456789012:role/App", RiskLevel: "HIGH", Unused: []string{"iam:PassRole", "s3:DeleteObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/Reader", RiskLevel: "LOW", Unused: []string{"s3:ListBucket"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/Clean", RiskLevel: "LOW"},
+	}
+	client := &fakeClient{}
+	if err := Emit(context.Background(), client, "Custom", results); err != nil {
+		t.Fatalf("Emit() error: %v", err)
+	}
+	if len(client.batches) != 1 || client.namespaces[0] != "Custom" {
+		t.Fatalf("expected one call under Custom, got %d under %v", len(client.batches), client.namespaces)
+	}
+
+	var got []string
+	for _, d := range client.batches[0] {
+		if d.Unit != "Count" || d.Timestamp.IsZero() {
+			t.Errorf("%s: unit %q, timestamp %v", d.MetricName, d.Unit, d.Timestamp)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %g", d.MetricName, d.Dimensions["Role"], d.Dimensions["RiskLevel"], d.Value))
+	}
+	want := []string{
+		"UnusedPrivileges arn:aws:iam::123456789012:role/App HIGH 2",
+		"UnusedPrivileges arn:aws:iam::123456789012:role/Reader LOW 1",
+		"UnusedPrivileges arn:aws:iam::123456789012:role/Clean LOW 0",
+		"HighRiskRoles   1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("data:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestEmitBatchesToTheAPILimit(t *testing.T) {
+	results := make([]correlation.Result, MaxDatapoints+500)
+	for i := range results {
+		results[i] = correlation.Result{IAMRole: fmt.Sprintf("role-%d", i), RiskLevel: "LOW"}
+	}
+	client := &fakeClient{}
+	if err := Emit(context.Background(), client, DefaultNamespace, results); err != nil {
+		t.Fatalf("Emit() error: %v", err)
+	}
+	var sizes []int
+	for _, b := range client.batches {
+		sizes = append(sizes, len(b))
+	}
+	if !reflect.DeepEqual(sizes, []int{MaxDatapoints, 501}) {
+		t.Errorf("batch sizes = %v, want [%d 501]", sizes, MaxDatapoints)
+	}
+
+	failing := &fakeClient{err: errors.New("throttled")}
+	if err := Emit(context.Background(), failing, DefaultNamespace, results); err == nil || len(failing.batches) != 1 {
+		t.Errorf("expected Emit to stop at the first failed batch, got %v after %d calls", err, len(failing.batches))
+	}
+}
+
+// fakeAPI records the input of every PutMetricData call to the SDK client.
+type fakeAPI struct {
+	inputs []*cw.PutMetricDataInput
+	err    error
+}
+
+func (f *fakeAPI) PutMetricData(_ context.Context, params *cw.PutMetricDataInput, _ ...func(*cw.Options)) (*cw.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, params)
+	return &cw.PutMetricDataOutput{}, f.err
+}
+
+func TestAPIClientPutMetricData(t *testing.T) {
+	api := &fakeAPI{}
+	c := &APIClient{api: api}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := []Datum{{MetricName: "UnusedPrivileges", Dimensions: map[string]string{"Role": "App", "RiskLevel": "HIGH"}, Value: 2, Unit: "Count", Timestamp: at}}
+	if err := c.PutMetricData(context.Background(), "ShinkaiShoujo", data); err != nil {
+		t.Fatalf("PutMetricData() error: %v", err)
+	}
+
+	if len(api.inputs) != 1 {
+		t.Fatalf("expected 1 call, got %d", len(api.inputs))
+	}
+	in := api.inputs[0]
+	if aws.ToString(in.Namespace) != "ShinkaiShoujo" || len(in.MetricData) != 1 {
+		t.Fatalf("unexpected input: %+v", in)
+	}
+	d := in.MetricData[0]
+	if aws.ToString(d.MetricName) != "UnusedPrivileges" || aws.ToFloat64(d.Value) != 2 ||
+		d.Unit != types.StandardUnitCount || !aws.ToTime(d.Timestamp).Equal(at) {
+		t.Errorf("unexpected datum: %+v", d)
+	}
+	var dims []string
+	for _, dim := range d.Dimensions {
+		dims = append(dims, aws.ToString(dim.Name)+"="+aws.ToString(dim.Value))
+	}
+	if want := []string{"RiskLevel=HIGH", "Role=App"}; !reflect.DeepEqual(dims, want) {
+		t.Errorf("dimensions = %v, want %v", dims, want)
+	}
+
+	api.err = errors.New("AccessDenied: not authorized")
+	if err := c.PutMetricData(context.Background(), "ShinkaiShoujo", data); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package correlation
+
+import "sort"
+
+// PrivilegeSpread counts how many roles leave one privilege unused. A HIGH
+// privilege unused by 200 roles is a wider exposure than the same privilege
+// unused by one.
+type PrivilegeSpread struct {
+	Privilege string
+	Risk      RiskLevel
+	Roles     int
+}
+
+// AggregateByPrivilege counts, for each distinct unused privilege across
+// results, the roles that have it unused. The most widely-unused come first,
+// ties broken HIGH → LOW and then alphabetically.
+func AggregateByPrivilege(results []Result) []PrivilegeSpread {
+	counts := make(map[string]int)
+	risks := make(map[string]RiskLevel)
+	for _, r := range results {
+		seen := make(map[string]bool, len(r.Unused))
+		for _, p := range r.Unused {
+			if !seen[p] {
+				seen[p] = true
+				counts[p]++
+			}
+			if _, ok := risks[p]; !ok {
+				risks[p] = r.unusedRisk(p)
+			}
+		}
+	}
+
+	out := make([]PrivilegeSpread, 0, len(counts))
+	for p, n := range counts {
+		out = append(out, PrivilegeSpread{Privilege: p, Risk: risks[p], Roles: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Roles != out[j].Roles {
+			return out[i].Roles > out[j].Roles
+		}
+		if riskRank[out[i].Risk] != riskRank[out[j].Risk] {
+			return riskRank[out[i].Risk] < riskRank[out[j].Risk]
+		}
+		return out[i].Privilege < out[j].Privilege
+	})
+	return out
+}
@@ -1,9 +1,49 @@
 package correlation
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/catalog"
+	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
 
+// newTestEngine returns an Engine over a fresh in-memory DB.
+func newTestEngine(t *testing.T) (*Engine, *storage.DB) {
+	t.Helper()
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	return NewEngine(db, 30, log, m), db
+}
+
+func resultFor(results []Result, role string) (Result, bool) {
+	for _, r := range results {
+		if r.IAMRole == role {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
 // --- Risk classification tests ---
 
 func TestClassifyPrivilege(t *testing.T) {
@@ -32,6 +72,70 @@ func TestClassifyPrivilege(t *testing.T) {
 	}
 }
 
+func TestClassifyPrivilegeUsesLeadingVerb(t *testing.T) {
+	tests := []struct {
+		privilege string
+		expected  RiskLevel
+	}{
+		{"s3:PutBucketDeletePolicy", RiskMedium}, // "Delete" is not the verb
+		{"ec2:DescribeTerminatedInstances", RiskLow},
+		{"rds:DeleteDBInstance", RiskHigh}, // acronym after the verb
+		{"iam:GetAccountAuthorizationDetails", RiskLow},
+		{"svc:Getaway", RiskMedium}, // "Get" is only a raw prefix
+		{"svc:Listen", RiskMedium},
+		{"svc:Deleted", RiskMedium},
+		{"svc:Delete", RiskHigh},
+	}
+	for _, tt := range tests {
+		if got := ClassifyPrivilege(tt.privilege); got != tt.expected {
+			t.Errorf("ClassifyPrivilege(%q) = %s, want %s", tt.privilege, got, tt.expected)
+		}
+	}
+}
+
+func TestClassifyPrivilegeUsesAccessLevels(t *testing.T) {
+	tests := []struct {
+		privilege string
+		expected  RiskLevel
+	}{
+		{"s3:PutObjectAcl", RiskHigh},          // permissions management; verb says MEDIUM
+		{"kms:CreateGrant", RiskHigh},          // permissions management
+		{"s3:DeleteBucketPolicy", RiskHigh},    // permissions management
+		{"dynamodb:Query", RiskLow},            // read; verb says MEDIUM
+		{"sqs:ReceiveMessage", RiskLow},        // read
+		{"s3:DeleteObjectTagging", RiskMedium}, // tagging; verb says HIGH
+		{"dynamodb:DeleteTable", RiskHigh},     // write keeps destructive verbs HIGH
+		{"sqs:SendMessage", RiskMedium},        // write
+		{"ec2:RunInstances", RiskMedium},       // not catalogued: verb heuristic
+	}
+	for _, tt := range tests {
+		if got := ClassifyPrivilege(tt.privilege); got != tt.expected {
+			t.Errorf("ClassifyPrivilege(%q) = %s, want %s", tt.privilege, got, tt.expected)
+		}
+	}
+}
+
+func TestDefaultClassifierAccessLevelRisks(t *testing.T) {
+	c := DefaultClassifier{AccessLevelRisks: map[string]RiskLevel{
+		catalog.LevelTagging: RiskLow,
+		catalog.LevelWrite:   RiskHigh,
+	}}
+	tests := []struct {
+		privilege string
+		expected  RiskLevel
+	}{
+		{"s3:DeleteObjectTagging", RiskLow}, // tagging, overridden
+		{"sqs:SendMessage", RiskHigh},       // write, overridden
+		{"dynamodb:Query", RiskLow},         // read keeps its built-in risk
+		{"ec2:RunInstances", RiskMedium},    // not catalogued: verb heuristic
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.privilege); got != tt.expected {
+			t.Errorf("Classify(%q) = %s, want %s", tt.privilege, got, tt.expected)
+		}
+	}
+}
+
 func TestClassifySet(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -57,6 +161,404 @@ func TestClassifySet(t *testing.T) {
 
 // --- Set difference tests ---
 
+func TestAggregateByPrivilege(t *testing.T) {
+	results := []Result{
+		{IAMRole: "a", Unused: []string{"s3:DeleteObject", "s3:GetObject", "iam:PassRole"}},
+		{IAMRole: "b", Unused: []string{"s3:GetObject", "iam:PassRole", "iam:PassRole"}},
+		{IAMRole: "c", Unused: []string{"s3:GetObject"}},
+		{IAMRole: "d", Unused: nil},
+	}
+	got := AggregateByPrivilege(results)
+	want := []PrivilegeSpread{
+		{Privilege: "s3:GetObject", Risk: RiskLow, Roles: 3},
+		{Privilege: "iam:PassRole", Risk: RiskMedium, Roles: 2},
+		{Privilege: "s3:DeleteObject", Risk: RiskHigh, Roles: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("AggregateByPrivilege() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Equal counts order HIGH first, then by name.
+	tied := AggregateByPrivilege([]Result{{Unused: []string{"s3:ListBucket", "ec2:TerminateInstances", "ec2:DescribeInstances"}}})
+	if tied[0].Privilege != "ec2:TerminateInstances" || tied[1].Privilege != "ec2:DescribeInstances" || tied[2].Privilege != "s3:ListBucket" {
+		t.Errorf("unexpected tie order: %v", tied)
+	}
+
+	if got := AggregateByPrivilege(nil); len(got) != 0 {
+		t.Errorf("expected no spreads for no results, got %v", got)
+	}
+}
+
+func TestRedactAccounts(t *testing.T) {
+	results := []Result{{
+		IAMRole:    "arn:aws:iam::123456789012:role/path/App",
+		PolicyARNs: []string{"arn:aws-us-gov:iam::123456789012:policy/Custom", "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		PolicyOverlaps: map[string][]string{
+			"s3:GetObject": {"arn:aws:iam::123456789012:policy/Custom", "arn:aws:iam::aws:policy/ReadOnlyAccess", "inline"},
+		},
+		Resources: map[string][]string{
+			"sqs:SendMessage": {"arn:aws:sqs:us-east-1:210987654321:queue"},
+			"s3:GetObject":    {"arn:aws:s3:::bucket-123456789012/key"},
+		},
+		Trust: TrustAnalysis{
+			Principals:   []string{"AWS:111122223333", "AWS:arn:aws:iam::210987654321:root", "Service:ec2.amazonaws.com"},
+			CrossAccount: []string{"AWS:111122223333", "AWS:arn:aws:iam::210987654321:root"},
+		},
+	}}
+	got := RedactAccounts(results)[0]
+
+	if got.IAMRole != "arn:aws:iam::XXXXXXXXXXXX:role/path/App" {
+		t.Errorf("IAMRole = %q", got.IAMRole)
+	}
+	if got.PolicyARNs[0] != "arn:aws-us-gov:iam::XXXXXXXXXXXX:policy/Custom" || got.PolicyARNs[1] != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyARNs = %v", got.PolicyARNs)
+	}
+	if r := got.Resources["sqs:SendMessage"][0]; r != "arn:aws:sqs:us-east-1:XXXXXXXXXXXX:queue" {
+		t.Errorf("sqs resource = %q", r)
+	}
+	if r := got.Resources["s3:GetObject"][0]; r != "arn:aws:s3:::bucket-123456789012/key" {
+		t.Errorf("digits outside the account field must be kept, got %q", r)
+	}
+	if p := got.Trust.Principals; p[0] != "AWS:XXXXXXXXXXXX" || p[1] != "AWS:arn:aws:iam::XXXXXXXXXXXX:root" || p[2] != "Service:ec2.amazonaws.com" {
+		t.Errorf("Trust.Principals = %v", p)
+	}
+	if c := got.Trust.CrossAccount; c[0] != "AWS:XXXXXXXXXXXX" || c[1] != "AWS:arn:aws:iam::XXXXXXXXXXXX:root" {
+		t.Errorf("Trust.CrossAccount = %v", c)
+	}
+	if o := got.PolicyOverlaps["s3:GetObject"]; !reflect.DeepEqual(o, []string{"arn:aws:iam::XXXXXXXXXXXX:policy/Custom", "arn:aws:iam::aws:policy/ReadOnlyAccess", "inline"}) {
+		t.Errorf("PolicyOverlaps = %v", o)
+	}
+	if results[0].PolicyOverlaps["s3:GetObject"][0] != "arn:aws:iam::123456789012:policy/Custom" {
+		t.Error("RedactAccounts must not modify the input's policy overlaps")
+	}
+	if results[0].IAMRole != "arn:aws:iam::123456789012:role/path/App" || results[0].Resources["sqs:SendMessage"][0] != "arn:aws:sqs:us-east-1:210987654321:queue" {
+		t.Error("RedactAccounts must not modify its input")
+	}
+}
+
+func TestAnalyzeTrust(t *testing.T) {
+	const role = "arn:aws:iam::123456789012:role/App"
+	expected := []string{"ec2.amazonaws.com"}
+
+	wildcard := AnalyzeTrust(role, []scraper.Principal{{Type: "*", ID: "*"}}, expected)
+	if !wildcard.Wildcard || wildcard.RiskLevel != RiskHigh {
+		t.Errorf("wildcard trust: got %+v, want HIGH wildcard", wildcard)
+	}
+	if awsWildcard := AnalyzeTrust(role, []scraper.Principal{{Type: "AWS", ID: "*"}}, nil); awsWildcard.RiskLevel != RiskHigh {
+		t.Errorf("AWS \"*\" trust: got %+v, want HIGH", awsWildcard)
+	}
+
+	cross := AnalyzeTrust(role, []scraper.Principal{
+		{Type: "AWS", ID: "arn:aws:iam::123456789012:role/Deployer"},
+		{Type: "AWS", ID: "arn:aws:iam::210987654321:root"},
+		{Type: "AWS", ID: "111122223333"},
+		{Type: "Service", ID: "ec2.amazonaws.com"},
+	}, expected)
+	if cross.RiskLevel != RiskMedium || cross.Wildcard {
+		t.Errorf("cross-account trust: got %+v, want MEDIUM", cross)
+	}
+	if len(cross.CrossAccount) != 2 || cross.CrossAccount[0] != "AWS:arn:aws:iam::210987654321:root" || cross.CrossAccount[1] != "AWS:111122223333" {
+		t.Errorf("CrossAccount = %v", cross.CrossAccount)
+	}
+	if len(cross.Principals) != 4 {
+		t.Errorf("expected all 4 principals listed, got %v", cross.Principals)
+	}
+
+	service := AnalyzeTrust(role, []scraper.Principal{{Type: "Service", ID: "lambda.amazonaws.com"}}, expected)
+	if service.RiskLevel != RiskMedium || len(service.UnexpectedServices) != 1 {
+		t.Errorf("unexpected service: got %+v, want MEDIUM", service)
+	}
+	if same := AnalyzeTrust(role, []scraper.Principal{{Type: "Service", ID: "lambda.amazonaws.com"}}, nil); same.RiskLevel != RiskLow {
+		t.Errorf("services are not flagged without an expected list, got %+v", same)
+	}
+
+	if unread := AnalyzeTrust(role, nil, expected); unread.RiskLevel != "" {
+		t.Errorf("unread trust policy should not be analyzed, got %+v", unread)
+	}
+}
+
+func TestEngineRun_RecordsHistory(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject"},
+	}
+	if _, err := engine.Run(ctx, []scraper.RoleAssignment{role}); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	history, err := db.GetAnalysisResultsAsOf(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetAnalysisResultsAsOf() error: %v", err)
+	}
+	if len(history) != 1 || history[0].IAMRole != role.RoleARN || len(history[0].UnusedPrivs) != 1 {
+		t.Errorf("run not recorded in history: %+v", history)
+	}
+}
+
+func TestEngineRun_ReportsExcessObservedPrivileges(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	noS3 := scraper.RoleAssignment{
+		RoleName:   "NoS3",
+		RoleARN:    "arn:aws:iam::123456789012:role/NoS3",
+		Privileges: []string{"dynamodb:GetItem"},
+	}
+	pattern := scraper.RoleAssignment{
+		RoleName:   "S3Reader",
+		RoleARN:    "arn:aws:iam::123456789012:role/S3Reader",
+		Privileges: []string{"s3:Get*"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: noS3.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: pattern.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{noS3, pattern})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if r, _ := resultFor(results, noS3.RoleARN); len(r.ExcessObserved) != 1 || r.ExcessObserved[0] != "s3:GetObject" {
+		t.Errorf("expected s3:GetObject as excess for %s, got %v", noS3.RoleName, r.ExcessObserved)
+	}
+	if r, _ := resultFor(results, pattern.RoleARN); len(r.ExcessObserved) != 0 {
+		t.Errorf("s3:Get* grants s3:GetObject, got excess %v", r.ExcessObserved)
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range stored {
+		if r.IAMRole == noS3.RoleARN && len(r.ExcessObservedPrivs) != 1 {
+			t.Errorf("excess observed privileges not stored: %+v", r)
+		}
+	}
+}
+
+func TestRegressedPrivileges(t *testing.T) {
+	now := time.Now()
+	lastSeen := map[string]time.Time{
+		"s3:GetObject":     now.Add(-time.Hour),      // still assigned
+		"s3:PutObject":     now.Add(-2 * time.Hour),  // removed, used recently
+		"sqs:SendMessage":  now.Add(-72 * time.Hour), // removed long ago
+		"dynamodb:GetItem": now.Add(-time.Minute),    // removed, used recently
+	}
+	got := RegressedPrivileges([]string{"s3:GetObject"}, lastSeen, now.Add(-24*time.Hour))
+	if strings.Join(got, ",") != "dynamodb:GetItem,s3:PutObject" {
+		t.Errorf("RegressedPrivileges() = %v, want [dynamodb:GetItem s3:PutObject]", got)
+	}
+}
+
+func TestEngineRun_RecordsPrivilegeUsage(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "Invoker",
+		RoleARN:    "arn:aws:iam::123456789012:role/Invoker",
+		Privileges: []string{"lambda:InvokeFunction", "s3:GetObject"},
+	}
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		// Both SDK operations map to lambda:InvokeFunction.
+		{Timestamp: recent.Add(-time.Hour), IAMRole: role.RoleARN, Privilege: "lambda:Invoke", CallCount: 2},
+		{Timestamp: recent, IAMRole: role.RoleARN, Privilege: "lambda:InvokeAsync", CallCount: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	want := map[string]storage.PrivilegeUsage{"lambda:InvokeFunction": {CallCount: 5, LastSeen: recent}}
+	r, _ := resultFor(results, role.RoleARN)
+	if !reflect.DeepEqual(r.Usage, want) {
+		t.Errorf("Usage = %v, want %v", r.Usage, want)
+	}
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := stored[0].Usage["lambda:InvokeFunction"]; u.CallCount != 5 || !u.LastSeen.Equal(recent) {
+		t.Errorf("usage not stored: %+v", stored[0].Usage)
+	}
+}
+
+func TestEngineRun_FlagsSessionScopedRoles(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	scoped := scraper.RoleAssignment{
+		RoleName:   "Broker",
+		RoleARN:    "arn:aws:iam::123456789012:role/Broker",
+		Privileges: []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject"},
+	}
+	plain := scraper.RoleAssignment{
+		RoleName:   "Worker",
+		RoleARN:    "arn:aws:iam::123456789012:role/Worker",
+		Privileges: []string{"s3:GetObject", "s3:DeleteObject"},
+	}
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: scoped.RoleARN, Privilege: "s3:GetObject", CallCount: 3, SessionPolicy: true},
+		{Timestamp: now, IAMRole: scoped.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: scoped.RoleARN, Privilege: "s3:PutObject", CallCount: 2, SessionPolicy: true},
+		{Timestamp: now, IAMRole: plain.RoleARN, Privilege: "s3:GetObject", CallCount: 4},
+		{Timestamp: now, IAMRole: plain.RoleARN, Privilege: "s3:GetObject", CallCount: 1, SessionPolicy: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{scoped, plain})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, _ := resultFor(results, scoped.RoleARN)
+	if r.SessionPolicyShare != 5.0/6 || !SessionScoped(r) {
+		t.Errorf("Broker: share %v, want 5/6 and flagged", r.SessionPolicyShare)
+	}
+	if r.Usage["s3:GetObject"].SessionScopedCalls != 3 {
+		t.Errorf("Broker usage: %+v", r.Usage)
+	}
+	r, _ = resultFor(results, plain.RoleARN)
+	if r.SessionPolicyShare != 0.2 || SessionScoped(r) {
+		t.Errorf("Worker: share %v, want 0.2 and not flagged", r.SessionPolicyShare)
+	}
+}
+
+func TestServiceCoverage(t *testing.T) {
+	lastSeen := map[string]time.Time{"s3:GetObject": time.Now()}
+	got := ServiceCoverage([]string{"s3:GetObject", "s3:PutObject", "EC2:StartInstances", "*"}, lastSeen)
+	want := map[string]bool{"s3": true, "ec2": false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceCoverage() = %v, want %v", got, want)
+	}
+	if u := UncoveredServices(Result{ServiceCoverage: got}); !reflect.DeepEqual(u, []string{"ec2"}) {
+		t.Errorf("UncoveredServices() = %v, want [ec2]", u)
+	}
+}
+
+func TestEngineRun_FlagsUncoveredServices(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject", "s3:PutObject", "ec2:DescribeInstances"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"s3": true, "ec2": false}
+	// The second run reuses the stored result, which must keep its coverage.
+	for run := 1; run <= 2; run++ {
+		results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+		if err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		if r, _ := resultFor(results, role.RoleARN); !reflect.DeepEqual(r.ServiceCoverage, want) {
+			t.Errorf("run %d: ServiceCoverage = %v, want %v", run, r.ServiceCoverage, want)
+		}
+	}
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || !reflect.DeepEqual(stored[0].ServiceCoverage, want) {
+		t.Errorf("service coverage not stored: %+v", stored)
+	}
+}
+
+func TestEngineRun_FlagsRegressedPrivileges(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "Worker",
+		RoleARN:    "arn:aws:iam::123456789012:role/Worker",
+		Privileges: []string{"s3:GetObject"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now().Add(-time.Hour), IAMRole: role.RoleARN, Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: time.Now().AddDate(0, 0, -5), IAMRole: role.RoleARN, Privilege: "sqs:SendMessage", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, _ := resultFor(results, role.RoleARN)
+	if strings.Join(r.ExcessObserved, ",") != "s3:PutObject,sqs:SendMessage" {
+		t.Errorf("ExcessObserved = %v, want both unassigned privileges", r.ExcessObserved)
+	}
+	if strings.Join(r.Regressed, ",") != "s3:PutObject" {
+		t.Errorf("Regressed = %v, want only the one used within the regression window", r.Regressed)
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || strings.Join(stored[0].RegressedPrivs, ",") != "s3:PutObject" {
+		t.Errorf("regressed privileges not stored: %+v", stored)
+	}
+}
+
+func TestEngineRun_StoresTrustAnalysis(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:          "Open",
+		RoleARN:           "arn:aws:iam::123456789012:role/Open",
+		Privileges:        []string{"s3:GetObject"},
+		TrustedPrincipals: []scraper.Principal{{Type: "*", ID: "*"}},
+	}
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if r, _ := resultFor(results, role.RoleARN); r.Trust.RiskLevel != RiskHigh {
+		t.Errorf("expected HIGH trust risk, got %+v", r.Trust)
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || !stored[0].Trust.Wildcard || stored[0].Trust.RiskLevel != "HIGH" {
+		t.Fatalf("trust analysis not stored: %+v", stored)
+	}
+
+	// An unchanged role reuses its stored result, trust included.
+	results, err = engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if r, _ := resultFor(results, role.RoleARN); r.Trust.RiskLevel != RiskHigh {
+		t.Errorf("reused result lost its trust analysis: %+v", r.Trust)
+	}
+}
+
 func TestSetDifference_ExactMatch(t *testing.T) {
 	assigned := []string{"s3:GetObject", "s3:PutObject", "ec2:DescribeInstances"}
 	used := []string{"s3:GetObject"}
@@ -129,7 +631,7 @@ func TestSetDifference_EmptyAssigned(t *testing.T) {
 
 // --- SDK mapping tests ---
 
-func TestMapSDKToIAM(t *testing.T) {
+func TestNormalize(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -140,12 +642,1134 @@ func TestMapSDKToIAM(t *testing.T) {
 		{"ec2:StopInstance", "ec2:StopInstances"},
 		{"s3:GetObject", "s3:GetObject"}, // no mapping, passthrough
 		{"unknown:SomeOp", "unknown:SomeOp"},
+		{"S3:HeadObject", "s3:GetObject"},              // service case
+		{" Lambda : invoke ", "lambda:InvokeFunction"}, // whitespace, operation case
 	}
 
 	for _, tt := range tests {
-		got := MapSDKToIAM(tt.input)
+		got := Normalize(tt.input)
 		if got != tt.expected {
-			t.Errorf("MapSDKToIAM(%q) = %q, want %q", tt.input, got, tt.expected)
+			t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.expected)
 		}
 	}
 }
+
+func TestSDKMappings_UserOverrides(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// Keys arrive lower-cased from viper; matching must still work.
+	m := NewSDKMappings(map[string]string{
+		"s3:headobject":     "s3:GetObjectAttributes",
+		"bedrock:InvokeAll": "bedrock:InvokeModel",
+	}, log)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"s3:HeadObject", "s3:GetObjectAttributes"},  // overrides built-in
+		{"bedrock:InvokeAll", "bedrock:InvokeModel"}, // new mapping
+		{"lambda:Invoke", "lambda:InvokeFunction"},   // built-in still applies
+		{"s3:GetObject", "s3:GetObject"},             // passthrough
+	}
+	for _, tt := range tests {
+		if got := m.Map(tt.input); got != tt.expected {
+			t.Errorf("Map(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestEngineRun_AppliesUserSDKMappings(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{
+		SDKMappings: map[string]string{"bedrock:InvokeAll": "bedrock:InvokeModel"},
+	})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"bedrock:InvokeModel"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "bedrock:InvokeAll", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Unused) != 0 {
+		t.Errorf("expected mapped privilege to count as used, got %+v", results)
+	}
+}
+
+func TestEngineRun_FiltersServices(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{
+		ServicesInclude: []string{"s3", "dynamodb", "EC2"},
+		ServicesExclude: []string{"ec2"},
+	})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:DeleteBucket", "ec2:RunInstances", "lambda:InvokeFunction", "dynamodb:*"},
+		Policies: []scraper.PolicySource{{
+			ARN:     "arn:aws:iam::123456789012:policy/App",
+			Actions: []string{"s3:GetObject", "s3:DeleteBucket", "ec2:RunInstances", "lambda:InvokeFunction", "dynamodb:*"},
+		}},
+	}
+	orphan := "arn:aws:iam::123456789012:role/Gone"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "ec2:DescribeInstances", CallCount: 1, Resource: "i-123"},
+		{Timestamp: time.Now(), IAMRole: orphan, Privilege: "lambda:InvokeFunction", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	for _, r := range results {
+		for _, set := range [][]string{r.Assigned, r.Used, r.Unused} {
+			for _, p := range set {
+				if strings.HasPrefix(p, "ec2:") || strings.HasPrefix(p, "lambda:") {
+					t.Errorf("%s: out-of-scope privilege %s in results", r.IAMRole, p)
+				}
+			}
+		}
+		for p := range r.Sources {
+			if strings.HasPrefix(p, "ec2:") || strings.HasPrefix(p, "lambda:") {
+				t.Errorf("%s: out-of-scope privilege %s in sources", r.IAMRole, p)
+			}
+		}
+		if len(r.Resources) != 0 {
+			t.Errorf("%s: expected no resources for out-of-scope usage, got %v", r.IAMRole, r.Resources)
+		}
+	}
+	r, _ := resultFor(results, role.RoleARN)
+	if len(r.Assigned) != 3 || len(r.Used) != 1 || len(r.Unused) != 2 {
+		t.Errorf("expected counts over s3 and dynamodb only, got %+v", r)
+	}
+}
+
+// fixedClassifier rates the privileges it lists, and every other one LOW.
+type fixedClassifier map[string]RiskLevel
+
+func (c fixedClassifier) Classify(privilege string) RiskLevel {
+	if level, ok := c[privilege]; ok {
+		return level
+	}
+	return RiskLow
+}
+
+func TestEngineRun_CustomClassifier(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{
+		Windows:    map[RiskLevel]int{RiskHigh: 7},
+		Classifier: fixedClassifier{"s3:GetObject": RiskHigh},
+	})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:DeleteObject"},
+	}
+	// Used 10 days ago: outside the 7-day window of what the classifier
+	// rates HIGH, although the built-in model rates it LOW.
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now().AddDate(0, 0, -10), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:DeleteObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("expected a result for the role")
+	}
+	if strings.Join(r.Unused, ",") != "s3:GetObject" || r.RiskLevel != string(RiskHigh) {
+		t.Errorf("got Unused %v at %s, want s3:GetObject at HIGH per the custom classifier", r.Unused, r.RiskLevel)
+	}
+	// Reports rank each privilege as the engine did, also once stored.
+	if got := UnusedByRisk(r); len(got) != 1 || got[0].Risk != RiskHigh {
+		t.Errorf("UnusedByRisk() = %v, want s3:GetObject at HIGH", got)
+	}
+	if got := AggregateByPrivilege(results); len(got) != 1 || got[0].Risk != RiskHigh {
+		t.Errorf("AggregateByPrivilege() = %v, want s3:GetObject at HIGH", got)
+	}
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].UnusedRisks["s3:GetObject"] != string(RiskHigh) {
+		t.Errorf("stored unused risks = %+v, want s3:GetObject at HIGH", stored)
+	}
+	if ClassifyPrivilege("s3:GetObject") != RiskLow {
+		t.Error("expected the package-level classification to stay on the default model")
+	}
+}
+
+func TestEngineRun_NewRoleGrace(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{NewRoleGraceDays: 7})
+
+	fresh := scraper.RoleAssignment{
+		RoleName:   "FreshRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/FreshRole",
+		Privileges: []string{"s3:GetObject", "s3:DeleteObject"},
+		CreatedAt:  time.Now().AddDate(0, 0, -2),
+	}
+	old := scraper.RoleAssignment{
+		RoleName:   "OldRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/OldRole",
+		Privileges: []string{"s3:DeleteObject"},
+		CreatedAt:  time.Now().AddDate(-1, 0, 0),
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: fresh.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{fresh, old})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, fresh.RoleARN)
+	if !ok || r.RiskLevel != string(RiskNew) || len(r.Unused) != 0 {
+		t.Errorf("fresh role: got %+v, want NEW with no unused privileges", r)
+	}
+	if strings.Join(r.Used, ",") != "s3:GetObject" {
+		t.Errorf("fresh role: Used = %v, want its observed privilege", r.Used)
+	}
+	r, ok = resultFor(results, old.RoleARN)
+	if !ok || r.RiskLevel != string(RiskHigh) || len(r.Unused) != 1 {
+		t.Errorf("old role: got %+v, want it analyzed as usual", r)
+	}
+
+	// Past the grace period the same role is analyzed, not reused as NEW.
+	fresh.CreatedAt = time.Now().AddDate(0, 0, -8)
+	r, err = engine.RunRole(ctx, fresh)
+	if err != nil {
+		t.Fatalf("RunRole() error: %v", err)
+	}
+	if r.RiskLevel != string(RiskHigh) || strings.Join(r.Unused, ",") != "s3:DeleteObject" {
+		t.Errorf("after the grace period: got %+v, want s3:DeleteObject unused at HIGH", r)
+	}
+}
+
+func TestEngineRun_MinCallCount(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{MinCallCount: 3})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:ListBucket", "s3:PutObject", "sqs:SendMessage"},
+	}
+	session := "arn:aws:sts::123456789012:assumed-role/AppRole/session"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		// Calls from before the window do not count towards it.
+		{Timestamp: time.Now().AddDate(0, 0, -60), IAMRole: role.RoleARN, Privilege: "s3:ListBucket", CallCount: 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:ListBucket", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 5},
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:PutObject", CallCount: 1}, // a stray call
+		// Calls recorded under different forms of the role add up.
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "sqs:SendMessage", CallCount: 2},
+		{Timestamp: time.Now(), IAMRole: session, Privilege: "sqs:SendMessage", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("expected a result for the role")
+	}
+	if strings.Join(r.Used, ",") != "s3:GetObject,sqs:SendMessage" {
+		t.Errorf("Used = %v, want privileges with at least 3 calls", r.Used)
+	}
+	if strings.Join(r.Unused, ",") != "s3:ListBucket,s3:PutObject" {
+		t.Errorf("Unused = %v, want the privileges called once in the window", r.Unused)
+	}
+}
+
+func TestEngineRun_AttachesOwners(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	owners, err := ownership.Parse([]byte("owners:\n  - role: \"app-.*\"\n    team: platform\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := scraper.RoleAssignment{RoleName: "app-web", RoleARN: "arn:aws:iam::123456789012:role/app-web", Privileges: []string{"s3:GetObject"}}
+	other := scraper.RoleAssignment{RoleName: "batch", RoleARN: "arn:aws:iam::123456789012:role/batch", Privileges: []string{"s3:GetObject"}}
+	run := func(owners *ownership.Map) []Result {
+		t.Helper()
+		results, err := NewEngineWithOptions(db, 30, log, m, Options{Owners: owners}).Run(ctx, []scraper.RoleAssignment{app, other})
+		if err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return results
+	}
+
+	run(owners)
+	// The second run reuses the stored results, owner included.
+	results := run(owners)
+	if r, _ := resultFor(results, app.RoleARN); r.Owner.Team != "platform" {
+		t.Errorf("app-web owner = %+v, want platform", r.Owner)
+	}
+	if r, _ := resultFor(results, other.RoleARN); !r.Owner.IsZero() {
+		t.Errorf("batch owner = %+v, want none", r.Owner)
+	}
+
+	// Changing the ownership file must not leave the stored owner in place.
+	results = run(nil)
+	if r, _ := resultFor(results, app.RoleARN); !r.Owner.IsZero() {
+		t.Errorf("app-web owner after removing the mapping = %+v, want none", r.Owner)
+	}
+}
+
+func TestLoadSDKMappingsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mappings.json")
+	if err := os.WriteFile(path, []byte(`{"svc:Op": "svc:Action"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadSDKMappingsFile(path)
+	if err != nil {
+		t.Fatalf("LoadSDKMappingsFile() error: %v", err)
+	}
+	if got["svc:Op"] != "svc:Action" {
+		t.Errorf("unexpected mappings: %v", got)
+	}
+}
+
+// --- Role matching tests ---
+
+func TestRoleIndex_SameNameDifferentAccounts(t *testing.T) {
+	a := scraper.RoleAssignment{RoleName: "AppRole", RoleARN: "arn:aws:iam::111111111111:role/AppRole"}
+	b := scraper.RoleAssignment{RoleName: "AppRole", RoleARN: "arn:aws:iam::222222222222:role/AppRole"}
+	idx := newRoleIndex([]scraper.RoleAssignment{a, b})
+
+	got, err := idx.lookup(b.RoleARN)
+	if err != nil || got.RoleARN != b.RoleARN {
+		t.Errorf("lookup(%s) = %s, %v; want account 222 role", b.RoleARN, got.RoleARN, err)
+	}
+	if _, err := idx.lookup("AppRole"); err != errAmbiguousRole {
+		t.Errorf("expected bare name to be ambiguous, got %v", err)
+	}
+	if _, err := idx.lookup("arn:aws:iam::333333333333:role/AppRole"); err != errRoleNotFound {
+		t.Errorf("expected role in unknown account not to match by name, got %v", err)
+	}
+}
+
+func TestEngineRun_SameNameDifferentAccountsDoNotCrossContaminate(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	prod := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::111111111111:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	dev := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::222222222222:role/AppRole",
+		Privileges: []string{"dynamodb:GetItem"},
+	}
+
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: prod.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{prod, dev})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	p, ok := resultFor(results, prod.RoleARN)
+	if !ok {
+		t.Fatal("missing result for account 111 role")
+	}
+	if len(p.Unused) != 1 || p.Unused[0] != "s3:PutObject" {
+		t.Errorf("account 111: expected [s3:PutObject] unused, got %v", p.Unused)
+	}
+
+	d, ok := resultFor(results, dev.RoleARN)
+	if !ok {
+		t.Fatal("missing result for account 222 role")
+	}
+	if len(d.Used) != 0 || len(d.Unused) != 1 || d.Unused[0] != "dynamodb:GetItem" {
+		t.Errorf("account 222: expected untouched assignment, got used=%v unused=%v", d.Used, d.Unused)
+	}
+}
+
+func TestEngineRun_AssumedRoleARNInOtherPartitions(t *testing.T) {
+	ctx := context.Background()
+
+	for _, partition := range []string{"aws-us-gov", "aws-cn"} {
+		t.Run(partition, func(t *testing.T) {
+			engine, db := newTestEngine(t)
+			role := scraper.RoleAssignment{
+				RoleName:   "AppRole",
+				RoleARN:    "arn:" + partition + ":iam::123456789012:role/AppRole",
+				Privileges: []string{"s3:GetObject", "s3:PutObject"},
+			}
+			stsARN := "arn:" + partition + ":sts::123456789012:assumed-role/AppRole/session"
+			if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+				{Timestamp: time.Now(), IAMRole: stsARN, Privilege: "s3:GetObject", CallCount: 1},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+			if err != nil {
+				t.Fatalf("Run() error: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d: %+v", len(results), results)
+			}
+			if results[0].IAMRole != role.RoleARN {
+				t.Errorf("expected result keyed by %s, got %s", role.RoleARN, results[0].IAMRole)
+			}
+			if len(results[0].Unused) != 1 || results[0].Unused[0] != "s3:PutObject" {
+				t.Errorf("expected [s3:PutObject] unused, got %v", results[0].Unused)
+			}
+		})
+	}
+}
+
+func TestEngineRun_PerRiskWindows(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{
+		Windows: map[RiskLevel]int{RiskHigh: 7, RiskLow: 90},
+	})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:DeleteObject", "s3:GetObject"},
+	}
+	fortyDaysAgo := time.Now().AddDate(0, 0, -40)
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: fortyDaysAgo, IAMRole: role.RoleARN, Privilege: "s3:DeleteObject", CallCount: 1},
+		{Timestamp: fortyDaysAgo, IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("missing result for role")
+	}
+	// HIGH-risk DeleteObject falls outside its 7-day window; LOW-risk
+	// GetObject is still within its 90-day window.
+	if len(r.Unused) != 1 || r.Unused[0] != "s3:DeleteObject" {
+		t.Errorf("expected [s3:DeleteObject] unused, got %v", r.Unused)
+	}
+	if len(r.Used) != 1 || r.Used[0] != "s3:GetObject" {
+		t.Errorf("expected [s3:GetObject] used, got %v", r.Used)
+	}
+	if r.RiskLevel != string(RiskHigh) {
+		t.Errorf("expected HIGH risk, got %s", r.RiskLevel)
+	}
+}
+
+func TestEngineRun_ReportsUnusedPrivilegeSpread(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngine(db, 30, log, m)
+
+	roles := []scraper.RoleAssignment{
+		{RoleName: "A", RoleARN: "arn:aws:iam::123456789012:role/A", Privileges: []string{"s3:DeleteObject", "s3:GetObject"}},
+		{RoleName: "B", RoleARN: "arn:aws:iam::123456789012:role/B", Privileges: []string{"s3:DeleteObject"}},
+	}
+	if _, err := engine.Run(ctx, roles); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := testutil.ToFloat64(m.UnusedPrivilegeRoles.WithLabelValues("s3:DeleteObject", "HIGH")); got != 2 {
+		t.Errorf("s3:DeleteObject roles = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.UnusedPrivilegeRoles.WithLabelValues("s3:GetObject", "LOW")); got != 1 {
+		t.Errorf("s3:GetObject roles = %v, want 1", got)
+	}
+}
+
+func TestEngineRun_ObservedRoleMissingFromIAMIsOrphaned(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	live := scraper.RoleAssignment{
+		RoleName:   "LiveRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/LiveRole",
+		Privileges: []string{"s3:GetObject"},
+	}
+	deleted := "arn:aws:sts::123456789012:assumed-role/DeletedRole/session"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: deleted, Privilege: "s3:PutObject", CallCount: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{live})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, "arn:aws:iam::123456789012:role/DeletedRole")
+	if !ok {
+		t.Fatalf("expected orphaned role in results, got %+v", results)
+	}
+	if r.RiskLevel != string(RiskOrphaned) {
+		t.Errorf("expected ORPHANED, got %s", r.RiskLevel)
+	}
+	if len(r.Used) != 1 || r.Used[0] != "s3:PutObject" {
+		t.Errorf("expected observed privileges on orphaned role, got %v", r.Used)
+	}
+
+	saved, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range saved {
+		if s.RiskLevel == string(RiskOrphaned) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected orphaned role to be saved for reports")
+	}
+}
+
+func TestEngineRunExcluding_LeftOutRolesAreNotOrphaned(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	live := scraper.RoleAssignment{
+		RoleName:   "LiveRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/LiveRole",
+		Privileges: []string{"s3:GetObject"},
+	}
+	linked := "arn:aws:iam::123456789012:role/aws-service-role/ecs.amazonaws.com/AWSServiceRoleForECS"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: "arn:aws:sts::123456789012:assumed-role/AWSServiceRoleForECS/ecs", Privilege: "ecs:ListClusters", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: "arn:aws:sts::123456789012:assumed-role/Broken/session", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: "arn:aws:iam::210987654321:role/OtherAccount", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: "arn:aws:sts::123456789012:assumed-role/DeletedRole/session", Privilege: "s3:PutObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.RunExcluding(ctx, []scraper.RoleAssignment{live}, []string{linked, "arn:aws:iam::123456789012:role/Broken"})
+	if err != nil {
+		t.Fatalf("RunExcluding() error: %v", err)
+	}
+	var orphaned []string
+	for _, r := range results {
+		if r.RiskLevel == string(RiskOrphaned) {
+			orphaned = append(orphaned, r.IAMRole)
+		}
+	}
+	if want := []string{"arn:aws:iam::123456789012:role/DeletedRole"}; !reflect.DeepEqual(orphaned, want) {
+		t.Errorf("orphaned roles = %v, want %v", orphaned, want)
+	}
+}
+
+func TestEngineRun_PolicyScopeCreditsSharedPolicyUsage(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	policy := scraper.PolicySource{
+		ARN:     "arn:aws:iam::123456789012:policy/Shared",
+		Name:    "Shared",
+		Actions: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	roleA := scraper.RoleAssignment{
+		RoleName:   "RoleA",
+		RoleARN:    "arn:aws:iam::123456789012:role/RoleA",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+		Policies:   []scraper.PolicySource{policy},
+	}
+	roleB := roleA
+	roleB.RoleName = "RoleB"
+	roleB.RoleARN = "arn:aws:iam::123456789012:role/RoleB"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: roleA.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: roleB.RoleARN, Privilege: "s3:PutObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(scope Scope) Result {
+		t.Helper()
+		m := metrics.NewWithRegistry(prometheus.NewRegistry())
+		engine := NewEngineWithOptions(db, 30, log, m, Options{Scope: scope})
+		results, err := engine.Run(ctx, []scraper.RoleAssignment{roleA, roleB})
+		if err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		r, ok := resultFor(results, roleA.RoleARN)
+		if !ok {
+			t.Fatal("missing result for RoleA")
+		}
+		return r
+	}
+
+	r := run(ScopeRole)
+	if len(r.Unused) != 1 || r.Unused[0] != "s3:PutObject" {
+		t.Errorf("role scope: expected [s3:PutObject] unused, got %v", r.Unused)
+	}
+
+	r = run(ScopePolicy)
+	if len(r.Unused) != 0 {
+		t.Errorf("policy scope: expected no unused privileges, got %v", r.Unused)
+	}
+	if len(r.Used) != 2 {
+		t.Errorf("policy scope: expected shared privilege to be kept as used, got %v", r.Used)
+	}
+}
+
+func TestEngineRun_PolicyScopeIgnoresInlinePolicies(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{Scope: ScopePolicy})
+
+	inline := scraper.PolicySource{Name: "inline", Inline: true, Actions: []string{"s3:PutObject"}}
+	roleA := scraper.RoleAssignment{
+		RoleName:   "RoleA",
+		RoleARN:    "arn:aws:iam::123456789012:role/RoleA",
+		Privileges: []string{"s3:PutObject"},
+		Policies:   []scraper.PolicySource{inline},
+	}
+	roleB := roleA
+	roleB.RoleName = "RoleB"
+	roleB.RoleARN = "arn:aws:iam::123456789012:role/RoleB"
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: roleB.RoleARN, Privilege: "s3:PutObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{roleA, roleB})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, roleA.RoleARN)
+	if !ok {
+		t.Fatal("missing result for RoleA")
+	}
+	if len(r.Unused) != 1 || r.Unused[0] != "s3:PutObject" {
+		t.Errorf("expected inline privilege to stay unused, got %v", r.Unused)
+	}
+}
+
+func TestEngineRun_ReusesUnchangedRoles(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	unchanged := scraper.RoleAssignment{
+		RoleName:   "Unchanged",
+		RoleARN:    "arn:aws:iam::123456789012:role/Unchanged",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	changed := scraper.RoleAssignment{
+		RoleName:   "Changed",
+		RoleARN:    "arn:aws:iam::123456789012:role/Changed",
+		Privileges: []string{"s3:GetObject"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: unchanged.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Run(ctx, []scraper.RoleAssignment{unchanged, changed}); err != nil {
+		t.Fatalf("first Run() error: %v", err)
+	}
+
+	// Mark every stored result as ancient so a re-save is visible.
+	if _, err := db.Conn().ExecContext(ctx, `UPDATE analysis_results SET analysis_date = 0`); err != nil {
+		t.Fatal(err)
+	}
+
+	changed.Privileges = []string{"s3:GetObject", "s3:DeleteObject"}
+	if _, err := engine.Run(ctx, []scraper.RoleAssignment{unchanged, changed}); err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+
+	saved, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dates := make(map[string]int64)
+	for _, r := range saved {
+		dates[r.IAMRole] = r.AnalysisDate.Unix()
+	}
+	if dates[unchanged.RoleARN] != 0 {
+		t.Errorf("unchanged role was re-saved (analysis_date = %d)", dates[unchanged.RoleARN])
+	}
+	if dates[changed.RoleARN] == 0 {
+		t.Error("changed role was not re-saved")
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	tests := []struct {
+		priv   string
+		risk   RiskLevel
+		source PrivilegeSource
+		want   string
+	}{
+		{"s3:DeleteBucket", RiskHigh, SourceInline, RecommendRemove},
+		{"s3:PutObject", RiskMedium, SourceInline, RecommendRemoveInline},
+		{"s3:PutObject", RiskMedium, SourceManaged, RecommendDetach},
+		{"s3:DeleteBucket", RiskHigh, SourceManaged, RecommendDetach},
+		{"s3:DeleteBucket", RiskHigh, SourceUnobserved, RecommendVerify},
+		{"s3:GetObject", RiskLow, "", RecommendReview},
+	}
+	for _, tt := range tests {
+		if got := Recommend(tt.priv, tt.risk, tt.source); got != tt.want {
+			t.Errorf("Recommend(%q, %q, %q) = %q, want %q", tt.priv, tt.risk, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestEngineRun_RecordsPrivilegeSources(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	role := scraper.RoleAssignment{
+		RoleName:   "Mixed",
+		RoleARN:    "arn:aws:iam::123456789012:role/Mixed",
+		Privileges: []string{"s3:DeleteBucket", "s3:PutObject"},
+		Policies: []scraper.PolicySource{
+			{Name: "inline", Inline: true, Actions: []string{"s3:DeleteBucket", "s3:PutObject"}},
+			{ARN: "arn:aws:iam::123456789012:policy/Writers", Name: "Writers", Actions: []string{"s3:PutObject"}},
+		},
+	}
+
+	results, err := engine.Run(context.Background(), []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("missing result for role")
+	}
+	if r.Sources["s3:DeleteBucket"] != SourceInline {
+		t.Errorf("s3:DeleteBucket source = %q, want inline", r.Sources["s3:DeleteBucket"])
+	}
+	if r.Sources["s3:PutObject"] != SourceManaged {
+		t.Errorf("s3:PutObject granted by both should be managed, got %q", r.Sources["s3:PutObject"])
+	}
+}
+
+func TestEngineRun_SuppressesIntentionalGrants(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+	// iam:PassRole comes only from a statement annotated as intentional.
+	role := scraper.RoleAssignment{
+		RoleName:   "Deployer",
+		RoleARN:    "arn:aws:iam::123456789012:role/Deployer",
+		Privileges: []string{"iam:PassRole", "s3:DeleteBucket", "s3:GetObject"},
+		Policies: []scraper.PolicySource{{
+			Name:       "deploy",
+			Inline:     true,
+			Actions:    []string{"iam:PassRole", "s3:DeleteBucket", "s3:GetObject"},
+			Suppressed: []string{"iam:PassRole"},
+		}},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("missing result for role")
+	}
+	if len(r.Unused) != 1 || r.Unused[0] != "s3:DeleteBucket" {
+		t.Errorf("Unused = %v, want [s3:DeleteBucket]", r.Unused)
+	}
+	if len(r.Suppressed) != 1 || r.Suppressed[0] != "iam:PassRole" {
+		t.Errorf("Suppressed = %v, want [iam:PassRole]", r.Suppressed)
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || len(stored[0].SuppressedPrivs) != 1 {
+		t.Errorf("suppressed privileges not stored: %+v", stored)
+	}
+}
+
+func TestEngineRunRole_OnlyTouchesThatRole(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+	target := scraper.RoleAssignment{
+		RoleName:   "Target",
+		RoleARN:    "arn:aws:iam::123456789012:role/Target",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	other := storage.AnalysisResult{
+		AnalysisDate:  time.Now().Add(-time.Hour),
+		IAMRole:       "arn:aws:iam::123456789012:role/Other",
+		AssignedPrivs: []string{"ec2:DescribeInstances"},
+		UnusedPrivs:   []string{"ec2:DescribeInstances"},
+		RiskLevel:     "LOW",
+	}
+	if err := db.SaveAnalysisResult(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	// Observed under the STS form; RunRole must still match it.
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: "arn:aws:sts::123456789012:assumed-role/Target/session", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: "arn:aws:iam::123456789012:role/Unscraped", Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.RunRole(ctx, target)
+	if err != nil {
+		t.Fatalf("RunRole() error: %v", err)
+	}
+	if len(result.Unused) != 1 || result.Unused[0] != "s3:PutObject" {
+		t.Errorf("expected only s3:PutObject unused, got %v", result.Unused)
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("expected Other and Target only (no orphaned roles), got %d results", len(stored))
+	}
+	for _, r := range stored {
+		if r.IAMRole == other.IAMRole && r.AnalysisDate.Unix() != other.AnalysisDate.Unix() {
+			t.Error("RunRole must not rewrite other roles' results")
+		}
+	}
+}
+
+func TestEngineRunRole_RejectsPolicyScope(t *testing.T) {
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{Scope: ScopePolicy})
+	if _, err := engine.RunRole(context.Background(), scraper.RoleAssignment{RoleARN: "arn:aws:iam::123456789012:role/R"}); err == nil {
+		t.Error("expected RunRole to refuse policy scope")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/App"
+	assignments := []scraper.RoleAssignment{{
+		RoleARN: roleARN,
+		Policies: []scraper.PolicySource{
+			{
+				ARN:             "arn:aws:iam::123456789012:policy/App",
+				Actions:         []string{"s3:GetObject", "s3:PutObject", "s3:ListBucket"},
+				PreviousVersion: "v1",
+				PreviousActions: []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject"},
+			},
+			{ARN: "arn:aws:iam::123456789012:policy/Single", Actions: []string{"sqs:SendMessage"}},
+		},
+	}}
+	results := []Result{{IAMRole: roleARN, Unused: []string{"s3:PutObject", "s3:ListBucket", "sqs:SendMessage"}}}
+
+	diffs := CompareVersions(assignments, results)
+	if len(diffs) != 1 {
+		t.Fatalf("expected one diff (the policy with a previous version), got %+v", diffs)
+	}
+	d := diffs[0]
+	if d.PreviousVersion != "v1" {
+		t.Errorf("PreviousVersion = %q, want v1", d.PreviousVersion)
+	}
+	if got := strings.Join(d.Removed, ","); got != "s3:DeleteObject" {
+		t.Errorf("Removed = %s, want s3:DeleteObject", got)
+	}
+	if got := strings.Join(d.StillUnused, ","); got != "s3:PutObject" {
+		t.Errorf("StillUnused = %s, want s3:PutObject", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	baseline := []Result{
+		{IAMRole: "arn:aws:iam::123456789012:role/App", RiskLevel: "LOW", Unused: []string{"s3:GetObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/Worker", RiskLevel: "MEDIUM", Unused: []string{"sqs:SendMessage"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/Gone", RiskLevel: "HIGH", Unused: []string{"iam:PassRole"}},
+	}
+	current := []Result{
+		{IAMRole: "arn:aws:iam::123456789012:role/App", RiskLevel: "HIGH", Unused: []string{"S3:GetObject", "s3:DeleteObject"}},
+		{IAMRole: "arn:aws:iam::123456789012:role/service/Worker", RiskLevel: "LOW"},
+		{IAMRole: "arn:aws:iam::123456789012:role/Unchanged", RiskLevel: "LOW"},
+	}
+
+	diffs := Diff(baseline, current)
+	if len(diffs) != 2 {
+		t.Fatalf("expected diffs for App and Worker, got %+v", diffs)
+	}
+	app, worker := diffs[0], diffs[1]
+	if got := strings.Join(app.NewlyUnused, ","); got != "s3:DeleteObject" {
+		t.Errorf("App NewlyUnused = %s, want s3:DeleteObject", got)
+	}
+	if !app.RiskRose() || !app.Regressed() {
+		t.Errorf("App went LOW -> HIGH with a new unused privilege, want a regression: %+v", app)
+	}
+	if got := strings.Join(worker.NoLongerUnused, ","); got != "sqs:SendMessage" {
+		t.Errorf("Worker NoLongerUnused = %s, want sqs:SendMessage", got)
+	}
+	if worker.Regressed() {
+		t.Errorf("Worker only improved, want no regression: %+v", worker)
+	}
+}
+
+func TestEngineRun_DryRunWritesNothing(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	engine := NewEngineWithOptions(db, 30, log, m, Options{DryRun: true})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	idle := scraper.RoleAssignment{
+		RoleName:   "Idle",
+		RoleARN:    "arn:aws:iam::123456789012:role/Idle",
+		Privileges: []string{"sqs:SendMessage"},
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: "arn:aws:iam::123456789012:role/Gone", Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role, idle})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected results for the observed, idle and orphaned roles, got %d", len(results))
+	}
+
+	stored, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("dry run saved %d results", len(stored))
+	}
+	if _, err := db.GetAnalysisResultsAsOf(ctx, time.Now().Add(time.Minute)); !errors.Is(err, storage.ErrNoAnalysisRun) {
+		t.Errorf("dry run should not record a run in history, got err %v", err)
+	}
+}
+
+func TestEngineRun_LabelsUnusedPrivilegesByAccount(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := metrics.NewWithOptions(prometheus.NewRegistry(), metrics.Options{LabelAccount: true, Region: "eu-west-1"})
+	engine := NewEngine(db, 30, log, m)
+
+	roles := []scraper.RoleAssignment{
+		{RoleName: "A", RoleARN: "arn:aws:iam::111111111111:role/A", Privileges: []string{"s3:DeleteObject"}},
+		{RoleName: "B", RoleARN: "arn:aws:iam::222222222222:role/B", Privileges: []string{"s3:GetObject", "s3:ListBucket"}},
+	}
+	if _, err := engine.Run(ctx, roles); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := testutil.ToFloat64(m.UnusedPrivileges.WithLabelValues(roles[0].RoleARN, "HIGH", "111111111111")); got != 1 {
+		t.Errorf("A unused = %v, want 1 under account 111111111111", got)
+	}
+	if got := testutil.ToFloat64(m.UnusedPrivileges.WithLabelValues(roles[1].RoleARN, "LOW", "222222222222")); got != 2 {
+		t.Errorf("B unused = %v, want 2 under account 222222222222", got)
+	}
+}
+
+func TestEngineRun_MergesObservedFormsOfOneRole(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:PutObject", "sqs:SendMessage", "s3:DeleteObject"},
+	}
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:sts::123456789012:assumed-role/AppRole/session", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "AppRole", Privilege: "sqs:SendMessage", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one result for the role's three observed forms, got %d", len(results))
+	}
+	r := results[0]
+	if r.IAMRole != role.RoleARN {
+		t.Errorf("IAMRole = %s, want %s", r.IAMRole, role.RoleARN)
+	}
+	if strings.Join(r.Unused, ",") != "s3:DeleteObject" {
+		t.Errorf("Unused = %v, want [s3:DeleteObject] with every form's usage credited", r.Unused)
+	}
+}
+
+func TestEngineRun_MatchesUsageIgnoringCase(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "AppRole",
+		RoleARN:    "arn:aws:iam::123456789012:role/AppRole",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	// Stored in the casing the exporter first sent.
+	if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+		{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:getobject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("expected a result for the role")
+	}
+	if strings.Join(r.Unused, ",") != "s3:PutObject" {
+		t.Errorf("Unused = %v, want [s3:PutObject]: s3:getobject is a call to s3:GetObject", r.Unused)
+	}
+}
+
+func TestEngineRun_ScopeChangeRecomputes(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policyEngine := NewEngineWithOptions(db, 30, log, metrics.NewWithRegistry(prometheus.NewRegistry()), Options{Scope: ScopePolicy})
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject"},
+	}
+	if _, err := policyEngine.Run(ctx, []scraper.RoleAssignment{role}); err != nil {
+		t.Fatalf("policy-scope Run() error: %v", err)
+	}
+	if _, err := db.Conn().ExecContext(ctx, `UPDATE analysis_results SET analysis_date = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Run(ctx, []scraper.RoleAssignment{role}); err != nil {
+		t.Fatalf("role-scope Run() error: %v", err)
+	}
+	saved, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].AnalysisDate.Unix() == 0 {
+		t.Errorf("a result computed under policy scope was reused under role scope: %+v", saved)
+	}
+}
+
+func TestEngineRun_ReuseRefreshesUsage(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTestEngine(t)
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	record := func() {
+		t.Helper()
+		if err := db.BatchRecordPrivilegeUsage(ctx, []storage.PrivilegeUsageRecord{
+			{Timestamp: time.Now(), IAMRole: role.RoleARN, Privilege: "s3:GetObject", CallCount: 1},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record()
+	if _, err := engine.Run(ctx, []scraper.RoleAssignment{role}); err != nil {
+		t.Fatalf("first Run() error: %v", err)
+	}
+	if _, err := db.Conn().ExecContext(ctx, `UPDATE analysis_results SET analysis_date = 0`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another call to a privilege already used leaves the result unchanged.
+	record()
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+	r, ok := resultFor(results, role.RoleARN)
+	if !ok {
+		t.Fatal("expected a result for the role")
+	}
+	if r.AnalyzedAt.Unix() != 0 {
+		t.Error("expected the result to be reused, not recomputed")
+	}
+	if got := r.Usage["s3:GetObject"].CallCount; got != 2 {
+		t.Errorf("reused result has %d calls to s3:GetObject, want the current 2", got)
+	}
+}
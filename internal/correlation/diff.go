@@ -0,0 +1,71 @@
+package correlation
+
+import (
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+)
+
+// RoleDiff is how one role's result changed from a baseline result.
+type RoleDiff struct {
+	IAMRole string
+	// NewlyUnused are unused privileges the baseline did not list as unused.
+	// For a role missing from the baseline that is every unused privilege.
+	NewlyUnused []string
+	// NoLongerUnused are privileges the baseline listed as unused that are
+	// now used or no longer assigned.
+	NoLongerUnused []string
+	// PreviousRisk is the baseline risk level, empty for a new role.
+	PreviousRisk string
+	RiskLevel    string
+}
+
+// RiskRose reports whether the role's risk level is higher than in the
+// baseline. Orphaned roles and roles new to the baseline never count.
+func (d RoleDiff) RiskRose() bool {
+	prev, okPrev := riskRank[RiskLevel(d.PreviousRisk)]
+	cur, okCur := riskRank[RiskLevel(d.RiskLevel)]
+	return okPrev && okCur && cur < prev
+}
+
+// Regressed reports whether the role moved away from least privilege: it
+// gained unused privileges or rose in risk.
+func (d RoleDiff) Regressed() bool {
+	return len(d.NewlyUnused) > 0 || d.RiskRose()
+}
+
+// Diff compares current against baseline, role by role, and returns the
+// roles whose unused privileges or risk level changed, in current's order.
+// Roles are matched by account and name, so a role whose IAM path changed is
+// still the same role; roles only in the baseline are left out. Privileges
+// compare case-insensitively, as IAM does.
+func Diff(baseline, current []Result) []RoleDiff {
+	byKey := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		byKey[rolearn.Parse(r.IAMRole).Key()] = r
+	}
+
+	var diffs []RoleDiff
+	for _, r := range current {
+		base, ok := byKey[rolearn.Parse(r.IAMRole).Key()]
+		d := RoleDiff{IAMRole: r.IAMRole, RiskLevel: r.RiskLevel}
+		if ok {
+			d.PreviousRisk = base.RiskLevel
+		}
+		before, after := lowerSet(base.Unused), lowerSet(r.Unused)
+		for _, p := range r.Unused {
+			if !before[strings.ToLower(p)] {
+				d.NewlyUnused = append(d.NewlyUnused, p)
+			}
+		}
+		for _, p := range base.Unused {
+			if !after[strings.ToLower(p)] {
+				d.NoLongerUnused = append(d.NoLongerUnused, p)
+			}
+		}
+		if len(d.NewlyUnused) > 0 || len(d.NoLongerUnused) > 0 || (ok && d.PreviousRisk != d.RiskLevel) {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
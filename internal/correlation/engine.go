@@ -2,12 +2,17 @@ package correlation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
 	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
 	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
@@ -20,92 +25,390 @@ type Result struct {
 	Unused     []string
 	RiskLevel  string
 	AnalyzedAt time.Time
+	// UnusedRisks maps each unused privilege to its risk level under the
+	// engine's classifier.
+	UnusedRisks map[string]RiskLevel
+	// PolicyARNs are the managed policies attached to the role (provenance).
+	PolicyARNs []string
+	// Resources maps a used privilege to the resources it was observed on.
+	// Privileges with no captured resource are absent.
+	Resources map[string][]string
+	// Usage maps each privilege the role was observed using in the
+	// observation window to its calls in the window, counted by UTC day,
+	// and its latest observation. Privileges only credited through a shared policy are
+	// absent.
+	Usage map[string]storage.PrivilegeUsage
+	// SessionPolicyShare is the share of the calls in Usage made in sessions
+	// scoped by a session policy (see SessionScoped).
+	SessionPolicyShare float64
+	// Sources maps an assigned privilege to the kind of policy granting it.
+	// Privileges of unknown provenance are absent.
+	Sources map[string]PrivilegeSource
+	// Suppressed are unused privileges left out of Unused because they are
+	// granted only by intentionally annotated policy statements.
+	Suppressed []string
+	// ResourceConstrained are the assigned privileges every granting policy
+	// scopes to specific resources rather than "*", so they are narrower
+	// than their action alone suggests.
+	ResourceConstrained []string
+	// PolicyOverlaps maps each action more than one of the role's policies
+	// grants to those policies (see scraper.RoleAssignment.PolicyOverlaps).
+	// It is independent of usage: overlapping grants only complicate
+	// remediation.
+	PolicyOverlaps map[string][]string
+	// ReadOnly marks a service-linked role: its findings are informational,
+	// since AWS manages its policies.
+	ReadOnly bool
+	// ExcessObserved are privileges the role was observed using that none of
+	// its assigned privileges grant: a sign of mis-attributed spans or a
+	// recently removed policy.
+	ExcessObserved []string
+	// Regressed are the ExcessObserved privileges used within the regression
+	// window: the role was using them until very recently, so a policy
+	// change that just removed them is likely to break its workload.
+	Regressed []string
+	// ServiceCoverage maps each service of the assigned privileges to
+	// whether any of its actions was observed. Unused findings in a service
+	// with no observations may only mean it is not instrumented.
+	ServiceCoverage map[string]bool
+	// Trust analyzes who can assume the role, a risk axis separate from
+	// RiskLevel.
+	Trust TrustAnalysis
+	// Owner is who owns the role, for routing its findings. The zero value
+	// means no owner is known.
+	Owner ownership.Owner
 }
 
 // Engine performs correlation between observed OTel privileges and IAM assignments.
 type Engine struct {
 	db         *storage.DB
 	windowDays int
+	windows    map[RiskLevel]int
+	mappings   SDKMappings
+	timeout    time.Duration
+	scope      Scope
+	services   []string
+	filter     serviceFilter
+	minCalls   int
+	regression time.Duration
+	owners     *ownership.Map
+	dryRun     bool
+	labels     map[string]string
+	classifier Classifier
+	graceDays  int
 	log        *slog.Logger
 	metrics    *metrics.Metrics
 }
 
+// Options tune the correlation engine. The zero value reproduces the
+// default single-window behavior.
+type Options struct {
+	// Windows overrides the observation window (in days) for privileges of a
+	// given risk tier. Tiers not present use the engine's default window.
+	Windows map[RiskLevel]int
+	// SDKMappings are extra "service:Op" → "service:IamAction" translations
+	// merged over the built-in table; they win on conflict.
+	SDKMappings map[string]string
+	// Timeout bounds a whole Run call. Zero means no limit beyond the
+	// caller's context.
+	Timeout time.Duration
+	// Scope selects whose usage counts when deciding whether a privilege is
+	// used. Empty means ScopeRole.
+	Scope Scope
+	// ExpectedTrustServices are the service principals roles may trust;
+	// others are flagged in TrustAnalysis. Empty flags none.
+	ExpectedTrustServices []string
+	// ServicesInclude, when non-empty, limits analysis to privileges of
+	// these service prefixes (e.g. "s3"); ServicesExclude drops privileges
+	// of its services. Both assigned and used privileges are filtered.
+	ServicesInclude []string
+	ServicesExclude []string
+	// MinCallCount is the number of calls in the observation window a
+	// privilege needs before it counts as used, so a stray call does not keep
+	// it. Zero or one counts any call.
+	MinCallCount int
+	// Owners attributes each result to the team owning its role. Nil
+	// leaves results without an owner.
+	Owners *ownership.Map
+	// RegressionWindow is how recently an unassigned privilege must have
+	// been used to be reported in Result.Regressed. Zero means
+	// DefaultRegressionWindow.
+	RegressionWindow time.Duration
+	// DryRun computes and returns results without saving them or recording
+	// the run in the analysis history.
+	DryRun bool
+	// Labels annotate the run Run records in the analysis history, e.g.
+	// audit=Q3, so it can be found later.
+	Labels map[string]string
+	// Classifier assigns the risk levels that set each result's RiskLevel
+	// and each privilege's observation window. Nil means
+	// DefaultClassifier.
+	Classifier Classifier
+	// NewRoleGraceDays is how many days after its creation a role is
+	// reported as RiskNew, with no unused privileges, instead of being
+	// analyzed: it has had no time to exercise them. Zero disables it.
+	NewRoleGraceDays int
+}
+
+// DefaultRegressionWindow is the regression window used when
+// Options.RegressionWindow is zero.
+const DefaultRegressionWindow = 24 * time.Hour
+
 // NewEngine creates a new correlation Engine.
 func NewEngine(db *storage.DB, windowDays int, log *slog.Logger, m *metrics.Metrics) *Engine {
+	return NewEngineWithOptions(db, windowDays, log, m, Options{})
+}
+
+// NewEngineWithOptions is like NewEngine but applies opts.
+func NewEngineWithOptions(db *storage.DB, windowDays int, log *slog.Logger, m *metrics.Metrics, opts Options) *Engine {
+	if opts.Classifier == nil {
+		opts.Classifier = DefaultClassifier{}
+	}
 	return &Engine{
 		db:         db,
 		windowDays: windowDays,
+		windows:    opts.Windows,
+		mappings:   NewSDKMappings(opts.SDKMappings, log),
+		timeout:    opts.Timeout,
+		scope:      opts.Scope,
+		services:   opts.ExpectedTrustServices,
+		filter:     newServiceFilter(opts.ServicesInclude, opts.ServicesExclude),
+		minCalls:   opts.MinCallCount,
+		regression: opts.RegressionWindow,
+		owners:     opts.Owners,
+		dryRun:     opts.DryRun,
+		labels:     opts.Labels,
+		classifier: opts.Classifier,
+		graceDays:  opts.NewRoleGraceDays,
 		log:        log,
 		metrics:    m,
 	}
 }
 
+// interrupted wraps a context error from Run with the configured timeout.
+func (e *Engine) interrupted(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && e.timeout > 0 {
+		return fmt.Errorf("correlation did not finish within %s: %w", e.timeout, err)
+	}
+	return fmt.Errorf("correlation interrupted: %w", err)
+}
+
+// owner returns the owner of role, or the zero Owner when none is known.
+func (e *Engine) owner(role string) ownership.Owner {
+	o, _ := e.owners.Lookup(role)
+	return o
+}
+
+// regressionSince returns the start of the regression window ending at now.
+func (e *Engine) regressionSince(now time.Time) time.Time {
+	if e.regression > 0 {
+		return now.Add(-e.regression)
+	}
+	return now.Add(-DefaultRegressionWindow)
+}
+
+// windowFor returns the observation window in days for a privilege of the
+// given risk level.
+func (e *Engine) windowFor(level RiskLevel) int {
+	if d, ok := e.windows[level]; ok && d > 0 {
+		return d
+	}
+	return e.windowDays
+}
+
+// maxWindow returns the longest window across all risk tiers.
+func (e *Engine) maxWindow() int {
+	max := e.windowDays
+	for _, d := range e.windows {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
 // Run performs a full correlation analysis for the given role assignments.
-// Results are saved to the database and returned.
+// Results are saved to the database, recorded as one run in the analysis
+// history, and returned; with Options.DryRun they are only returned.
 func (e *Engine) Run(ctx context.Context, assignments []scraper.RoleAssignment) ([]Result, error) {
+	return e.RunExcluding(ctx, assignments, nil)
+}
+
+// RunExcluding is Run for assignments that leave out some roles IAM has,
+// such as service-linked roles the scraper skipped or roles that failed to
+// scrape; excluded names them by ARN or name. Observations of those roles,
+// or of roles in an account none of the assignments come from, are skipped:
+// the roles are out of scope, not missing from IAM, so they are not
+// reported ORPHANED.
+func (e *Engine) RunExcluding(ctx context.Context, assignments []scraper.RoleAssignment, excluded []string) ([]Result, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
 	timer := time.Now()
-	since := time.Now().AddDate(0, 0, -e.windowDays)
 	now := time.Now()
+	// Query over the longest window; shorter per-tier windows are applied
+	// per privilege in correlateRole.
+	since := now.AddDate(0, 0, -e.maxWindow())
 
 	e.metrics.AnalysisRuns.Inc()
 
-	// Build a map from role ARN/name → assignment for quick lookup.
-	roleMap := make(map[string]scraper.RoleAssignment, len(assignments))
+	assignments = e.filter.assignments(assignments)
+	roles := newRoleIndex(assignments)
+	// iam indexes every role IAM is known to have, so an observed role is
+	// orphaned only when it is missing from IAM, not when it was left out.
+	iam := newRoleIndex(append(assignments[:len(assignments):len(assignments)], excludedRoles(roles, excluded)...))
+	accounts := make(map[string]bool)
 	for _, a := range assignments {
-		roleMap[a.RoleARN] = a
-		roleMap[a.RoleName] = a
+		accounts[rolearn.Parse(a.RoleARN).Account] = true
 	}
 
 	// Get all roles observed in the OTel window.
 	observedRoles, err := e.db.GetObservedRoles(ctx, since)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, e.interrupted(ctx.Err())
+		}
 		return nil, fmt.Errorf("getting observed roles: %w", err)
 	}
 
+	var shared policyUsage
+	if e.scope == ScopePolicy {
+		shared, err = e.sharedPolicyUsage(ctx, roles, observedRoles, since)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, e.interrupted(ctx.Err())
+			}
+			return nil, err
+		}
+	}
+
+	// Roles whose inputs are unchanged since the last run reuse that result
+	// instead of being recomputed and re-saved.
+	prior := e.priorResults(ctx)
+
 	results := make([]Result, 0, len(assignments))
 	processedRoles := make(map[string]bool)
+	orphaned := 0
 
-	// Process roles that appear in OTel traces.
+	// Group the observed forms of each role (its IAM ARN, STS sessions, bare
+	// name) so it is correlated once against all of them.
+	var observed []scraper.RoleAssignment
+	forms := make(map[string][]string)
 	for _, role := range observedRoles {
-		assignment, ok := roleMap[role]
-		if !ok {
-			e.log.Warn("role observed in OTel but not found in IAM, skipping", "role", role)
+		if ctx.Err() != nil {
+			return nil, e.interrupted(ctx.Err())
+		}
+		assignment, err := iam.lookup(role)
+		if errors.Is(err, errAmbiguousRole) {
+			e.log.Warn("observed role name matches roles in multiple accounts, skipping; export the full role ARN to disambiguate", "role", role)
+			continue
+		}
+		if err == nil {
+			analyzed, ok := roles.byARN[assignment.RoleARN]
+			if !ok {
+				e.log.Debug("observed role is not analyzed in this run, skipping", "role", role)
+				continue
+			}
+			assignment = analyzed
+		}
+		if account := rolearn.Parse(role).Account; err != nil && account != "" && len(accounts) > 0 && !accounts[account] {
+			e.log.Debug("observed role is outside the analyzed account, skipping", "role", role, "account", account)
+			continue
+		}
+		if err != nil {
+			e.log.Warn("role observed in OTel but not found in IAM, reporting as orphaned", "role", role)
+			result, err := e.orphanedRole(ctx, role, since, now)
+			if err != nil {
+				e.log.Warn("failed to record orphaned role", "role", role, "error", err)
+				continue
+			}
+			results = append(results, result)
+			orphaned++
 			continue
 		}
+		if _, ok := forms[assignment.RoleARN]; !ok {
+			observed = append(observed, assignment)
+		}
+		forms[assignment.RoleARN] = append(forms[assignment.RoleARN], role)
+	}
 
-		result, err := e.correlateRole(ctx, assignment, role, since, now)
+	// Process roles that appear in OTel traces.
+	for _, assignment := range observed {
+		if ctx.Err() != nil {
+			return nil, e.interrupted(ctx.Err())
+		}
+		result, err := e.correlateRole(ctx, assignment, forms[assignment.RoleARN], shared, prior, since, now)
 		if err != nil {
-			e.log.Warn("failed to correlate role", "role", role, "error", err)
+			e.log.Warn("failed to correlate role", "role", assignment.RoleARN, "error", err)
 			continue
 		}
 
 		results = append(results, result)
 		processedRoles[assignment.RoleARN] = true
-		processedRoles[assignment.RoleName] = true
 	}
 
 	// Process IAM roles with no OTel observations → all privileges are "unused".
 	for _, assignment := range assignments {
-		if processedRoles[assignment.RoleARN] || processedRoles[assignment.RoleName] {
+		if ctx.Err() != nil {
+			return nil, e.interrupted(ctx.Err())
+		}
+		if processedRoles[assignment.RoleARN] {
+			continue
+		}
+		hash := e.fingerprint(assignment, nil, nil, now)
+		if result, ok := reuse(prior, assignment.RoleARN, hash); ok {
+			results = append(results, result)
 			continue
 		}
+		unused, credited := shared.credit(e, assignment, assignment.Privileges, now)
+		unused, suppressed := suppress(assignment, unused)
 		result := Result{
-			IAMRole:    assignment.RoleARN,
-			Assigned:   assignment.Privileges,
-			Used:       []string{},
-			Unused:     assignment.Privileges,
-			RiskLevel:  string(ClassifySet(assignment.Privileges)),
-			AnalyzedAt: now,
+			IAMRole:             assignment.RoleARN,
+			Assigned:            assignment.Privileges,
+			Used:                append([]string{}, credited...),
+			Unused:              unused,
+			UnusedRisks:         e.unusedRisks(unused),
+			RiskLevel:           string(classifySet(e.classifier, unused)),
+			AnalyzedAt:          now,
+			PolicyARNs:          assignment.ManagedPolicyARNs(),
+			Sources:             privilegeSources(assignment),
+			Suppressed:          suppressed,
+			ResourceConstrained: assignment.ConstrainedPrivileges(),
+			PolicyOverlaps:      assignment.PolicyOverlaps,
+			ReadOnly:            assignment.ReadOnly,
+			ServiceCoverage:     ServiceCoverage(assignment.Privileges, nil),
+			Trust:               AnalyzeTrust(assignment.RoleARN, assignment.TrustedPrincipals, e.services),
+			Owner:               e.owner(assignment.RoleARN),
 		}
+		result = e.markNew(result, assignment, now)
 		results = append(results, result)
-		if err := e.saveResult(ctx, result); err != nil {
+		if err := e.saveResult(ctx, result, hash); err != nil {
 			e.log.Warn("failed to save analysis result", "role", assignment.RoleARN, "error", err)
 		}
 	}
 
+	if !e.dryRun {
+		records := make([]storage.AnalysisResult, 0, len(results))
+		for _, r := range results {
+			records = append(records, toRecord(r, ""))
+		}
+		if err := e.db.SaveAnalysisRun(ctx, now, records, e.labels); err != nil {
+			e.log.Warn("failed to record analysis run in history", "error", err)
+		}
+	}
+
 	// Update metrics.
+	e.metrics.OrphanedRoles.Set(float64(orphaned))
 	for _, r := range results {
-		e.metrics.UnusedPrivileges.WithLabelValues(r.IAMRole, r.RiskLevel).Set(float64(len(r.Unused)))
+		e.metrics.SetUnusedPrivileges(r.IAMRole, r.RiskLevel, len(r.Unused))
+	}
+	// Privileges no role leaves unused any more must not linger.
+	e.metrics.UnusedPrivilegeRoles.Reset()
+	for _, s := range AggregateByPrivilege(results) {
+		e.metrics.UnusedPrivilegeRoles.WithLabelValues(s.Privilege, string(s.Risk)).Set(float64(s.Roles))
 	}
 
 	elapsed := time.Since(timer).Seconds()
@@ -118,56 +421,431 @@ func (e *Engine) Run(ctx context.Context, assignments []scraper.RoleAssignment)
 	return results, nil
 }
 
+// RunRole correlates a single role against its observations, saving and
+// returning only its result; other roles' stored results are untouched and
+// no run is recorded in the analysis history. It
+// is not available under ScopePolicy, where a role's result depends on the
+// usage of every role sharing its policies.
+func (e *Engine) RunRole(ctx context.Context, assignment scraper.RoleAssignment) (Result, error) {
+	if e.scope == ScopePolicy {
+		return Result{}, errors.New("single-role analysis needs every role's usage under policy scope; set correlation.scope to role or analyze the whole account")
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	now := time.Now()
+	since := now.AddDate(0, 0, -e.maxWindow())
+	e.metrics.AnalysisRuns.Inc()
+	assignment = e.filter.assignment(assignment)
+
+	// The stored observations match the role under any of its ARN forms.
+	result, err := e.correlateRole(ctx, assignment, []string{assignment.RoleARN}, nil, e.priorResults(ctx), since, now)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Result{}, e.interrupted(ctx.Err())
+		}
+		return Result{}, err
+	}
+	e.metrics.SetUnusedPrivileges(result.IAMRole, result.RiskLevel, len(result.Unused))
+	return result, nil
+}
+
 func (e *Engine) correlateRole(
 	ctx context.Context,
 	assignment scraper.RoleAssignment,
-	observedRole string,
+	observedRoles []string,
+	shared policyUsage,
+	prior map[string]storage.AnalysisResult,
 	since, now time.Time,
 ) (Result, error) {
-	usedRaw, err := e.db.GetUsedPrivilegesForRole(ctx, observedRole, since)
+	usage, err := e.usage(ctx, observedRoles, since)
 	if err != nil {
-		return Result{}, fmt.Errorf("getting used privileges: %w", err)
+		return Result{}, err
+	}
+	lastSeen := lastSeenOf(usage)
+	resources, err := e.resources(ctx, observedRoles, since)
+	if err != nil {
+		return Result{}, err
+	}
+	hash := e.fingerprint(assignment, usage, resources, now)
+	if result, ok := reuse(prior, assignment.RoleARN, hash); ok {
+		e.log.Debug("role unchanged since last analysis, reusing result", "role", assignment.RoleARN)
+		// Call counts grow with every call, so they are not fingerprinted;
+		// the reused result takes the current ones.
+		result.Usage = usage
+		result.SessionPolicyShare = SessionPolicyShare(usage)
+		if err := e.saveResult(ctx, result, hash); err != nil {
+			e.log.Warn("failed to save analysis result", "role", assignment.RoleARN, "error", err)
+		}
+		return result, nil
 	}
+	used := e.usedByWindow(lastSeen, now)
+	unused := e.unusedByWindow(assignment.Privileges, lastSeen, now)
+	unused, credited := shared.credit(e, assignment, unused, now)
+	// Credited privileges were unused within their window, so none is
+	// already in used.
+	used = append(used, credited...)
+	sort.Strings(used)
+	unused, suppressed := suppress(assignment, unused)
+	excess := excessObserved(assignment.Privileges, lastSeen)
+	regressed := RegressedPrivileges(assignment.Privileges, lastSeen, e.regressionSince(now))
+
+	riskLevel := classifySet(e.classifier, unused)
 
-	// Map SDK operation names to IAM action names.
-	used := make([]string, 0, len(usedRaw))
-	for _, p := range usedRaw {
-		used = append(used, MapSDKToIAM(p))
+	result := Result{
+		IAMRole:             assignment.RoleARN,
+		Assigned:            assignment.Privileges,
+		Used:                used,
+		Unused:              unused,
+		UnusedRisks:         e.unusedRisks(unused),
+		RiskLevel:           string(riskLevel),
+		AnalyzedAt:          now,
+		PolicyARNs:          assignment.ManagedPolicyARNs(),
+		Resources:           resources,
+		Usage:               usage,
+		SessionPolicyShare:  SessionPolicyShare(usage),
+		Sources:             privilegeSources(assignment),
+		Suppressed:          suppressed,
+		ResourceConstrained: assignment.ConstrainedPrivileges(),
+		PolicyOverlaps:      assignment.PolicyOverlaps,
+		ReadOnly:            assignment.ReadOnly,
+		ExcessObserved:      excess,
+		Regressed:           regressed,
+		ServiceCoverage:     ServiceCoverage(assignment.Privileges, lastSeen),
+		Trust:               AnalyzeTrust(assignment.RoleARN, assignment.TrustedPrincipals, e.services),
+		Owner:               e.owner(assignment.RoleARN),
+	}
+	result = e.markNew(result, assignment, now)
+	if len(regressed) > 0 {
+		e.log.Error("role was using privileges it is no longer assigned until recently; its workload may break",
+			"role", assignment.RoleARN, "privileges", regressed)
+	} else if len(excess) > 0 {
+		e.log.Warn("role observed using privileges it is not assigned", "role", assignment.RoleARN, "privileges", excess)
 	}
 
-	unused := setDifference(assignment.Privileges, used)
-	riskLevel := ClassifySet(unused)
+	if err := e.saveResult(ctx, result, hash); err != nil {
+		e.log.Warn("failed to save analysis result", "role", assignment.RoleARN, "error", err)
+	}
 
-	result := Result{
-		IAMRole:    observedRole,
-		Assigned:   assignment.Privileges,
-		Used:       used,
-		Unused:     unused,
-		RiskLevel:  string(riskLevel),
-		AnalyzedAt: now,
+	return result, nil
+}
+
+// usage returns the calls to and last use of each in-scope privilege by the
+// role, observed under any of the forms in roles, since the given time, with
+// SDK operation names mapped to IAM action names. Privileges with fewer
+// calls than minCalls are left out, as if never used.
+func (e *Engine) usage(ctx context.Context, roles []string, since time.Time) (map[string]storage.PrivilegeUsage, error) {
+	raw := make(map[string]storage.PrivilegeUsage)
+	for _, role := range distinctKeys(roles) {
+		r, err := e.db.GetPrivilegeUsageForRole(ctx, role, since)
+		if err != nil {
+			return nil, fmt.Errorf("getting used privileges: %w", err)
+		}
+		for p, u := range r {
+			raw[p] = mergeUsage(raw[p], u)
+		}
+	}
+	usage := e.mapUsage(raw)
+	if e.minCalls > 1 {
+		for p, u := range usage {
+			if u.CallCount < e.minCalls {
+				delete(usage, p)
+			}
+		}
 	}
+	return usage, nil
+}
 
-	if err := e.saveResult(ctx, result); err != nil {
-		e.log.Warn("failed to save analysis result", "role", observedRole, "error", err)
+// mapUsage keys raw by IAM action name, dropping out-of-scope privileges.
+// When several operations map to the same action their calls are added up
+// and the latest observation is kept.
+func (e *Engine) mapUsage(raw map[string]storage.PrivilegeUsage) map[string]storage.PrivilegeUsage {
+	usage := make(map[string]storage.PrivilegeUsage, len(raw))
+	for p, u := range raw {
+		iam := e.mappings.Map(p)
+		if !e.filter.allows(iam) {
+			continue
+		}
+		usage[iam] = mergeUsage(usage[iam], u)
+	}
+	return usage
+}
+
+// mergeUsage adds up the calls of a and b and keeps the later observation.
+func mergeUsage(a, b storage.PrivilegeUsage) storage.PrivilegeUsage {
+	a.CallCount += b.CallCount
+	a.SessionScopedCalls += b.SessionScopedCalls
+	if b.LastSeen.After(a.LastSeen) {
+		a.LastSeen = b.LastSeen
+	}
+	return a
+}
+
+// distinctKeys returns roles without the forms storage matches under the
+// same role key (see rolearn.Role.Key), so each observation is read once.
+func distinctKeys(roles []string) []string {
+	seen := make(map[string]bool, len(roles))
+	var out []string
+	for _, r := range roles {
+		key := rolearn.Parse(r).Key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, r)
+	}
+	return out
+}
+
+// lastSeen returns when each privilege in the role's usage was last used.
+func (e *Engine) lastSeen(ctx context.Context, role string, since time.Time) (map[string]time.Time, error) {
+	usage, err := e.usage(ctx, []string{role}, since)
+	if err != nil {
+		return nil, err
+	}
+	return lastSeenOf(usage), nil
+}
+
+func lastSeenOf(usage map[string]storage.PrivilegeUsage) map[string]time.Time {
+	lastSeen := make(map[string]time.Time, len(usage))
+	for p, u := range usage {
+		lastSeen[p] = u.LastSeen
+	}
+	return lastSeen
+}
+
+// resources returns the deduplicated, sorted resources each in-scope
+// privilege was observed on, by the role under any of the forms in roles,
+// since the given time, keyed by IAM action name.
+func (e *Engine) resources(ctx context.Context, roles []string, since time.Time) (map[string][]string, error) {
+	sets := make(map[string]map[string]bool)
+	for _, role := range distinctKeys(roles) {
+		raw, err := e.db.GetPrivilegeResourcesForRole(ctx, role, since)
+		if err != nil {
+			return nil, fmt.Errorf("getting privilege resources: %w", err)
+		}
+		for p, rs := range raw {
+			iam := e.mappings.Map(p)
+			if !e.filter.allows(iam) {
+				continue
+			}
+			if sets[iam] == nil {
+				sets[iam] = make(map[string]bool)
+			}
+			for _, r := range rs {
+				sets[iam][r] = true
+			}
+		}
+	}
+	if len(sets) == 0 {
+		return nil, nil
+	}
+	resources := make(map[string][]string, len(sets))
+	for p, set := range sets {
+		for r := range set {
+			resources[p] = append(resources[p], r)
+		}
+		sort.Strings(resources[p])
+	}
+	return resources, nil
+}
+
+// excludedRoles returns placeholder assignments, with nothing assigned, for
+// the roles named in excluded that are not in roles.
+func excludedRoles(roles *roleIndex, excluded []string) []scraper.RoleAssignment {
+	out := make([]scraper.RoleAssignment, 0, len(excluded))
+	for _, role := range excluded {
+		if _, err := roles.lookup(role); err == nil {
+			continue
+		}
+		out = append(out, scraper.RoleAssignment{RoleARN: rolearn.Normalize(role), RoleName: rolearn.Parse(role).Name})
+	}
+	return out
+}
+
+// orphanedRole builds the result for a role seen in traces but absent from
+// IAM. Nothing is assigned, so only the observed privileges are reported.
+func (e *Engine) orphanedRole(ctx context.Context, observedRole string, since, now time.Time) (Result, error) {
+	raw, err := e.db.GetPrivilegeUsageForRole(ctx, observedRole, since)
+	if err != nil {
+		return Result{}, fmt.Errorf("getting used privileges: %w", err)
 	}
+	usage := e.mapUsage(raw)
+	used := make([]string, 0, len(usage))
+	for p := range usage {
+		used = append(used, p)
+	}
+	sort.Strings(used)
 
+	result := Result{
+		IAMRole:            rolearn.Normalize(observedRole),
+		Assigned:           []string{},
+		Used:               used,
+		Unused:             []string{},
+		RiskLevel:          string(RiskOrphaned),
+		AnalyzedAt:         now,
+		Usage:              usage,
+		SessionPolicyShare: SessionPolicyShare(usage),
+		Owner:              e.owner(observedRole),
+	}
+	if err := e.saveResult(ctx, result, ""); err != nil {
+		e.log.Warn("failed to save analysis result", "role", observedRole, "error", err)
+	}
 	return result, nil
 }
 
-func (e *Engine) saveResult(ctx context.Context, r Result) error {
-	return e.db.SaveAnalysisResult(ctx, storage.AnalysisResult{
-		AnalysisDate:  r.AnalyzedAt,
-		IAMRole:       r.IAMRole,
-		AssignedPrivs: r.Assigned,
-		UsedPrivs:     r.Used,
-		UnusedPrivs:   r.Unused,
-		RiskLevel:     r.RiskLevel,
-	})
+// isNew reports whether assignment's role was created within the new-role
+// grace period before now.
+func (e *Engine) isNew(assignment scraper.RoleAssignment, now time.Time) bool {
+	return e.graceDays > 0 && !assignment.CreatedAt.IsZero() &&
+		assignment.CreatedAt.After(now.AddDate(0, 0, -e.graceDays))
+}
+
+// markNew reports r as RiskNew, without unused privileges, when its role is
+// still within the new-role grace period, and returns it unchanged
+// otherwise.
+func (e *Engine) markNew(r Result, assignment scraper.RoleAssignment, now time.Time) Result {
+	if !e.isNew(assignment, now) {
+		return r
+	}
+	e.log.Debug("role created within the grace period, not reporting unused privileges",
+		"role", r.IAMRole, "created", assignment.CreatedAt)
+	r.Unused = []string{}
+	r.UnusedRisks = nil
+	r.Suppressed = nil
+	r.RiskLevel = string(RiskNew)
+	return r
+}
+
+// unusedRisks classifies each unused privilege with the engine's
+// classifier, so reports rank them as the analysis did.
+func (e *Engine) unusedRisks(unused []string) map[string]RiskLevel {
+	if len(unused) == 0 {
+		return nil
+	}
+	risks := make(map[string]RiskLevel, len(unused))
+	for _, p := range unused {
+		risks[p] = e.classifier.Classify(p)
+	}
+	return risks
+}
+
+// saveResult stores r with the fingerprint of its inputs; an empty hash
+// means the result is never reused. It stores nothing in a dry run.
+func (e *Engine) saveResult(ctx context.Context, r Result, hash string) error {
+	if e.dryRun {
+		return nil
+	}
+	return e.db.SaveAnalysisResult(ctx, toRecord(r, hash))
+}
+
+// toRecord converts r to its stored form.
+func toRecord(r Result, hash string) storage.AnalysisResult {
+	return storage.AnalysisResult{
+		AnalysisDate:        r.AnalyzedAt,
+		IAMRole:             r.IAMRole,
+		AssignedPrivs:       r.Assigned,
+		UsedPrivs:           r.Used,
+		UnusedPrivs:         r.Unused,
+		UnusedRisks:         risksToStrings(r.UnusedRisks),
+		RiskLevel:           r.RiskLevel,
+		PolicyARNs:          r.PolicyARNs,
+		Resources:           r.Resources,
+		Usage:               r.Usage,
+		Sources:             sourcesToStrings(r.Sources),
+		SuppressedPrivs:     r.Suppressed,
+		ConstrainedPrivs:    r.ResourceConstrained,
+		PolicyOverlaps:      r.PolicyOverlaps,
+		ReadOnly:            r.ReadOnly,
+		ExcessObservedPrivs: r.ExcessObserved,
+		RegressedPrivs:      r.Regressed,
+		ServiceCoverage:     r.ServiceCoverage,
+		Trust:               trustToRecord(r.Trust),
+		Owner:               r.Owner,
+		PrivilegesHash:      hash,
+	}
+}
+
+// suppress splits unused into the privileges to report and those granted
+// only by intentional statements, which are not.
+func suppress(assignment scraper.RoleAssignment, unused []string) (kept, suppressed []string) {
+	intentional := assignment.SuppressedPrivileges()
+	if len(intentional) == 0 {
+		return unused, nil
+	}
+	skip := make(map[string]bool, len(intentional))
+	for _, p := range intentional {
+		skip[p] = true
+	}
+	kept = make([]string, 0, len(unused))
+	for _, p := range unused {
+		if skip[p] {
+			suppressed = append(suppressed, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, suppressed
+}
+
+// usedByWindow returns the privileges in lastSeen observed within the
+// window of their own risk level, the same window unusedByWindow judges
+// them by, so a privilege is never both used and unused.
+func (e *Engine) usedByWindow(lastSeen map[string]time.Time, now time.Time) []string {
+	used := make([]string, 0, len(lastSeen))
+	for p, ts := range lastSeen {
+		cutoff := now.AddDate(0, 0, -e.windowFor(e.classifier.Classify(p)))
+		if !ts.Before(cutoff) {
+			used = append(used, p)
+		}
+	}
+	return used
+}
+
+// unusedByWindow computes the unused privileges when each assigned privilege
+// is judged against the observation window of its own risk tier: a HIGH-risk
+// privilege last used 40 days ago is unused under a 7-day window, while a
+// LOW-risk one is still used under a 90-day window.
+func (e *Engine) unusedByWindow(assigned []string, lastSeen map[string]time.Time, now time.Time) []string {
+	// Group assigned privileges by window so each distinct window builds its
+	// used set once.
+	byWindow := make(map[int][]string)
+	for _, a := range assigned {
+		days := e.windowFor(e.classifier.Classify(a))
+		byWindow[days] = append(byWindow[days], a)
+	}
+
+	unusedSet := make(map[string]bool)
+	for days, privs := range byWindow {
+		cutoff := now.AddDate(0, 0, -days)
+		var used []string
+		for p, ts := range lastSeen {
+			if !ts.Before(cutoff) {
+				used = append(used, p)
+			}
+		}
+		for _, u := range setDifference(privs, used) {
+			unusedSet[u] = true
+		}
+	}
+
+	// Preserve the assigned order.
+	var unused []string
+	for _, a := range assigned {
+		if unusedSet[a] {
+			unused = append(unused, a)
+		}
+	}
+	return unused
 }
 
-// setDifference computes assigned - used, respecting wildcard matching.
-// A privilege from assigned is considered "used" if:
-//   - It exactly matches a used privilege
+// setDifference computes assigned - used, respecting wildcard matching and
+// comparing action names case-insensitively as IAM does. A privilege from
+// assigned is considered "used" if:
+//   - It matches a used privilege ignoring case
 //   - It is a wildcard "svc:*" and any "svc:X" was observed
 //   - It is "*" (global wildcard) and any privilege was observed
 //   - A used privilege is a wildcard that covers it
@@ -178,7 +856,7 @@ func setDifference(assigned, used []string) []string {
 
 	usedSet := make(map[string]struct{}, len(used))
 	for _, u := range used {
-		usedSet[u] = struct{}{}
+		usedSet[strings.ToLower(u)] = struct{}{}
 	}
 
 	var unused []string
@@ -191,10 +869,67 @@ func setDifference(assigned, used []string) []string {
 	return unused
 }
 
-// isPrivilegeUsed checks whether an assigned privilege is covered by the used set.
+// excessObserved returns, sorted, the observed privileges that no assigned
+// privilege grants, allowing for "*", "svc:*" and patterns like "s3:Get*".
+func excessObserved(assigned []string, lastSeen map[string]time.Time) []string {
+	var excess []string
+	for p := range lastSeen {
+		granted := false
+		for _, a := range assigned {
+			if covers(a, p) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			excess = append(excess, p)
+		}
+	}
+	sort.Strings(excess)
+	return excess
+}
+
+// RegressedPrivileges returns, sorted, the privileges last seen at or after
+// since that no assigned privilege grants: ones the role was still using
+// until a policy change removed them. lastSeen maps each observed privilege
+// to its latest observation.
+func RegressedPrivileges(assigned []string, lastSeen map[string]time.Time, since time.Time) []string {
+	var regressed []string
+	for _, p := range excessObserved(assigned, lastSeen) {
+		if !lastSeen[p].Before(since) {
+			regressed = append(regressed, p)
+		}
+	}
+	return regressed
+}
+
+// MatchesAny reports whether privilege matches any of patterns, each a
+// privilege or a wildcard pattern such as "kms:*" or "s3:Get*", compared
+// case-insensitively as IAM does.
+func MatchesAny(patterns []string, privilege string) bool {
+	for _, p := range patterns {
+		if covers(strings.TrimSpace(p), privilege) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether the assigned privilege, possibly a wildcard
+// pattern, grants action. IAM action names are case-insensitive.
+func covers(assigned, action string) bool {
+	if assigned == "*" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(assigned), strings.ToLower(action))
+	return ok
+}
+
+// isPrivilegeUsed checks whether an assigned privilege is covered by the used
+// set, whose keys are the used privileges lowercased.
 func isPrivilegeUsed(assigned string, used []string, usedSet map[string]struct{}) bool {
-	// Direct match.
-	if _, ok := usedSet[assigned]; ok {
+	// Direct match, ignoring case.
+	if _, ok := usedSet[strings.ToLower(assigned)]; ok {
 		return true
 	}
 
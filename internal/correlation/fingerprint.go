@@ -0,0 +1,165 @@
+package correlation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// fingerprintVersion changes when results gain fields derived from inputs
+// already hashed, so results stored without them are recomputed.
+const fingerprintVersion = 6
+
+// fingerprint hashes everything a role's result is computed from:
+//
+//   - the correlation scope;
+//   - its assigned privileges and their risk levels;
+//   - its policies: managed ARNs, privilege sources, suppressed,
+//     resource-constrained and overlapping grants;
+//   - its trusted principals and the expected trust services;
+//   - its owner and whether it is within the new-role grace period;
+//   - the resources its privileges were observed on;
+//   - for each observation window in effect, the privileges observed inside
+//     it, and the privileges in the regression window.
+//
+// A privilege aging out of a window changes the fingerprint even though the
+// role's observed set did not. Call counts are left out, as every call would
+// change them; a reused result takes the current ones.
+func (e *Engine) fingerprint(assignment scraper.RoleAssignment, usage map[string]storage.PrivilegeUsage, resources map[string][]string, now time.Time) string {
+	lastSeen := lastSeenOf(usage)
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\n", fingerprintVersion)
+	writeSorted := func(label string, items []string) {
+		sorted := append([]string(nil), items...)
+		sort.Strings(sorted)
+		fmt.Fprintf(h, "%s\n%s\n", label, strings.Join(sorted, "\n"))
+	}
+	fmt.Fprintf(h, "scope %s\n", e.scope)
+	writeSorted("assigned", assignment.Privileges)
+	// Risk levels depend on the classifier and the action catalog, either of
+	// which may be overridden.
+	var risks []string
+	for _, p := range assignment.Privileges {
+		risks = append(risks, p+" "+string(e.classifier.Classify(p)))
+	}
+	writeSorted("risks", risks)
+	writeSorted("policies", assignment.ManagedPolicyARNs())
+	var sources []string
+	for p, src := range privilegeSources(assignment) {
+		sources = append(sources, p+" "+string(src))
+	}
+	writeSorted("sources", sources)
+	writeSorted("suppressed", assignment.SuppressedPrivileges())
+	writeSorted("constrained", assignment.ConstrainedPrivileges())
+	var overlaps []string
+	for a, policies := range assignment.PolicyOverlaps {
+		overlaps = append(overlaps, a+" "+strings.Join(policies, " "))
+	}
+	writeSorted("overlaps", overlaps)
+	var trusted []string
+	for _, p := range assignment.TrustedPrincipals {
+		trusted = append(trusted, p.String())
+	}
+	// A nil trust policy (never read) differs from an empty one.
+	fmt.Fprintf(h, "trust read %t\n", assignment.TrustedPrincipals != nil)
+	writeSorted("trusted", trusted)
+	writeSorted("expected services", e.services)
+	owner := e.owner(assignment.RoleARN)
+	fmt.Fprintf(h, "owner %q %q %q\n", owner.Team, owner.Email, owner.Slack)
+	fmt.Fprintf(h, "new %t\n", e.isNew(assignment, now))
+	var observedOn []string
+	for p, rs := range resources {
+		for _, r := range rs {
+			observedOn = append(observedOn, p+" "+r)
+		}
+	}
+	writeSorted("resources", observedOn)
+
+	for _, days := range e.distinctWindows() {
+		cutoff := now.AddDate(0, 0, -days)
+		var observed []string
+		for p, ts := range lastSeen {
+			if !ts.Before(cutoff) {
+				observed = append(observed, p)
+			}
+		}
+		writeSorted(fmt.Sprintf("window %d", days), observed)
+	}
+	writeSorted("regressed", RegressedPrivileges(assignment.Privileges, lastSeen, e.regressionSince(now)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// distinctWindows returns the sorted set of observation windows in days.
+func (e *Engine) distinctWindows() []int {
+	seen := map[int]bool{e.windowDays: true}
+	for _, d := range e.windows {
+		if d > 0 {
+			seen[d] = true
+		}
+	}
+	days := make([]int, 0, len(seen))
+	for d := range seen {
+		days = append(days, d)
+	}
+	sort.Ints(days)
+	return days
+}
+
+// priorResults loads the stored results keyed by role so unchanged roles can
+// be reused. Under ScopePolicy a role's result also depends on other roles'
+// usage, so nothing is reused.
+func (e *Engine) priorResults(ctx context.Context) map[string]storage.AnalysisResult {
+	if e.scope == ScopePolicy {
+		return nil
+	}
+	stored, err := e.db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		e.log.Warn("failed to load previous analysis results, re-analyzing every role", "error", err)
+		return nil
+	}
+	prior := make(map[string]storage.AnalysisResult, len(stored))
+	for _, r := range stored {
+		if r.PrivilegesHash != "" {
+			prior[r.IAMRole] = r
+		}
+	}
+	return prior
+}
+
+// reuse returns the stored result for role when its fingerprint matches.
+func reuse(prior map[string]storage.AnalysisResult, role, hash string) (Result, bool) {
+	r, ok := prior[role]
+	if !ok || r.PrivilegesHash != hash {
+		return Result{}, false
+	}
+	return Result{
+		IAMRole:             r.IAMRole,
+		Assigned:            r.AssignedPrivs,
+		Used:                r.UsedPrivs,
+		Unused:              r.UnusedPrivs,
+		UnusedRisks:         RisksFromStrings(r.UnusedRisks),
+		RiskLevel:           r.RiskLevel,
+		AnalyzedAt:          r.AnalysisDate,
+		PolicyARNs:          r.PolicyARNs,
+		Resources:           r.Resources,
+		Usage:               r.Usage,
+		SessionPolicyShare:  SessionPolicyShare(r.Usage),
+		Sources:             SourcesFromStrings(r.Sources),
+		Suppressed:          r.SuppressedPrivs,
+		ResourceConstrained: r.ConstrainedPrivs,
+		PolicyOverlaps:      r.PolicyOverlaps,
+		ReadOnly:            r.ReadOnly,
+		ExcessObserved:      r.ExcessObservedPrivs,
+		Regressed:           r.RegressedPrivs,
+		ServiceCoverage:     r.ServiceCoverage,
+		Trust:               TrustFromRecord(r.Trust),
+		Owner:               r.Owner,
+	}, true
+}
@@ -1,31 +1,65 @@
 package correlation
 
-// sdkToIAMAction maps SDK operation names that differ from their canonical IAM action names.
-// Key: "service:SDKOperation" (lowercase service prefix).
-// Value: correct IAM action "service:IAMAction".
-// Identity mappings (key == value) are omitted — the lookup falls through to
-// returning the input unchanged, which is equivalent.
-var sdkToIAMAction = map[string]string{
-	// Lambda — SDK uses short names; IAM requires the full name.
-	"lambda:Invoke":              "lambda:InvokeFunction",
-	"lambda:InvokeAsync":         "lambda:InvokeFunction",
-	"lambda:InvokeWithQualifier": "lambda:InvokeFunction",
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
 
-	// S3 — HEAD operations map to the corresponding IAM permission.
-	"s3:HeadObject": "s3:GetObject",
-	"s3:HeadBucket": "s3:ListBucket",
+	"github.com/0xKirisame/shinkai-shoujo/internal/privilege"
+)
 
-	// EC2 — SDK uses singular; IAM uses plural.
-	"ec2:StartInstance": "ec2:StartInstances",
-	"ec2:StopInstance":  "ec2:StopInstances",
+// Normalize returns privilege in the canonical form described in package
+// privilege: trimmed, with a lowercase service prefix and SDK operation
+// names replaced by their IAM action. The receiver stores observations in
+// this form, the scraper parses policies into it and the engine compares
+// them in it, so the same action never shows up as both used and unused.
+func Normalize(p string) string {
+	return privilege.Normalize(p)
 }
 
-// MapSDKToIAM converts an SDK-observed privilege to its canonical IAM action name.
-// If no mapping exists, the input is returned unchanged.
-// Input format: "service:Operation" (service is already lowercase).
-func MapSDKToIAM(privilege string) string {
-	if mapped, ok := sdkToIAMAction[privilege]; ok {
+// SDKMappings is the built-in SDK-to-IAM table merged with user-supplied
+// overrides. User keys are matched case-insensitively because viper
+// lower-cases map keys read from config.
+type SDKMappings struct {
+	overrides map[string]string
+}
+
+// NewSDKMappings merges overrides over the built-in table. Overrides that
+// replace a built-in mapping win and are logged at debug level.
+func NewSDKMappings(overrides map[string]string, log *slog.Logger) SDKMappings {
+	m := SDKMappings{overrides: make(map[string]string, len(overrides))}
+	for k, v := range overrides {
+		k = privilege.Format(k)
+		if mapped, ok := privilege.IAMAction(k); ok && mapped != v {
+			log.Debug("user SDK mapping overrides built-in", "operation", k, "builtin", mapped, "override", v)
+		}
+		m.overrides[strings.ToLower(k)] = privilege.Format(v)
+	}
+	return m
+}
+
+// Map converts an SDK-observed privilege to its IAM action name in
+// canonical form, preferring user overrides over the built-in mappings.
+func (m SDKMappings) Map(p string) string {
+	p = privilege.Format(p)
+	if mapped, ok := m.overrides[strings.ToLower(p)]; ok {
 		return mapped
 	}
-	return privilege
+	return Normalize(p)
+}
+
+// LoadSDKMappingsFile reads a JSON object of "service:Op": "service:IamAction"
+// pairs from path.
+func LoadSDKMappingsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SDK mappings file: %w", err)
+	}
+	var mappings map[string]string
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("parsing SDK mappings file %s (expected a JSON object of \"service:Op\": \"service:Action\"): %w", path, err)
+	}
+	return mappings, nil
 }
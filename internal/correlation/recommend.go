@@ -0,0 +1,90 @@
+package correlation
+
+import "github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+
+// PrivilegeSource is where an assigned privilege comes from.
+type PrivilegeSource string
+
+const (
+	// SourceInline marks a privilege granted only by inline policies.
+	SourceInline PrivilegeSource = "inline"
+	// SourceManaged marks a privilege granted by at least one managed policy.
+	SourceManaged PrivilegeSource = "managed"
+	// SourceUnobserved stands in for the provenance when the role made no
+	// observed calls, so its findings may reflect missing instrumentation.
+	SourceUnobserved PrivilegeSource = "unobserved"
+)
+
+// Recommended actions returned by Recommend.
+const (
+	RecommendRemove       = "remove immediately"
+	RecommendRemoveInline = "remove from inline policy"
+	RecommendDetach       = "detach or replace managed policy"
+	RecommendVerify       = "verify instrumentation before acting"
+	RecommendReview       = "review and remove from the role's policies"
+	// RecommendNone is given for service-linked roles, which AWS manages.
+	RecommendNone = "none: service-linked role managed by AWS"
+)
+
+// Recommend returns the action an operator should take for an unused
+// privilege, given its risk (see UnusedByRisk) and where it comes from. A
+// privilege granted by a managed policy cannot be dropped from the role
+// alone, so it is always a detach-or-replace.
+func Recommend(priv string, risk RiskLevel, source PrivilegeSource) string {
+	switch source {
+	case SourceUnobserved:
+		return RecommendVerify
+	case SourceManaged:
+		return RecommendDetach
+	case SourceInline:
+		if risk == RiskHigh {
+			return RecommendRemove
+		}
+		return RecommendRemoveInline
+	default:
+		return RecommendReview
+	}
+}
+
+// SourcesFromStrings converts stored privilege sources back to their type.
+func SourcesFromStrings(m map[string]string) map[string]PrivilegeSource {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]PrivilegeSource, len(m))
+	for p, src := range m {
+		out[p] = PrivilegeSource(src)
+	}
+	return out
+}
+
+// sourcesToStrings is the inverse of SourcesFromStrings, used when saving.
+func sourcesToStrings(m map[string]PrivilegeSource) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for p, src := range m {
+		out[p] = string(src)
+	}
+	return out
+}
+
+// privilegeSources maps each action in the assignment's policies to its
+// source. Managed wins when inline and managed policies both grant an action.
+func privilegeSources(assignment scraper.RoleAssignment) map[string]PrivilegeSource {
+	if len(assignment.Policies) == 0 {
+		return nil
+	}
+	sources := make(map[string]PrivilegeSource)
+	for _, p := range assignment.Policies {
+		for _, a := range p.Actions {
+			if !p.Inline {
+				sources[a] = SourceManaged
+			} else if _, ok := sources[a]; !ok {
+				sources[a] = SourceInline
+			}
+		}
+	}
+	return sources
+}
@@ -0,0 +1,70 @@
+package correlation
+
+import "regexp"
+
+// RedactedAccount replaces account IDs in ARNs redacted by RedactAccounts.
+const RedactedAccount = "XXXXXXXXXXXX"
+
+// arnAccount matches the account field of an ARN: everything up to and
+// including the region, then the 12-digit account ID.
+var arnAccount = regexp.MustCompile(`(arn:[a-z0-9-]+:[a-z0-9-]*:[a-z0-9-]*:)[0-9]{12}(:|$)`)
+
+// RedactAccounts returns copies of results with the account ID of every role,
+// policy, resource and trusted principal ARN replaced by RedactedAccount,
+// for sharing output outside the organization. The rest of each ARN is
+// preserved and results is left unmodified.
+func RedactAccounts(results []Result) []Result {
+	out := make([]Result, len(results))
+	for i, r := range results {
+		r.IAMRole = redactARN(r.IAMRole)
+		r.PolicyARNs = redactARNs(r.PolicyARNs)
+		if r.Resources != nil {
+			resources := make(map[string][]string, len(r.Resources))
+			for p, rs := range r.Resources {
+				resources[p] = redactARNs(rs)
+			}
+			r.Resources = resources
+		}
+		if r.PolicyOverlaps != nil {
+			overlaps := make(map[string][]string, len(r.PolicyOverlaps))
+			for a, policies := range r.PolicyOverlaps {
+				overlaps[a] = redactARNs(policies)
+			}
+			r.PolicyOverlaps = overlaps
+		}
+		r.Trust.Principals = redactPrincipals(r.Trust.Principals)
+		r.Trust.CrossAccount = redactPrincipals(r.Trust.CrossAccount)
+		out[i] = r
+	}
+	return out
+}
+
+func redactARN(s string) string {
+	return arnAccount.ReplaceAllString(s, "${1}"+RedactedAccount+"${2}")
+}
+
+func redactARNs(arns []string) []string {
+	if arns == nil {
+		return nil
+	}
+	out := make([]string, len(arns))
+	for i, a := range arns {
+		out[i] = redactARN(a)
+	}
+	return out
+}
+
+// bareAccountPrincipal matches an AWS principal given as a bare account ID.
+var bareAccountPrincipal = regexp.MustCompile(`^AWS:[0-9]{12}$`)
+
+// redactPrincipals redacts "Type:ID" trusted principals, including AWS
+// principals given as a bare account ID.
+func redactPrincipals(principals []string) []string {
+	out := redactARNs(principals)
+	for i, p := range out {
+		if bareAccountPrincipal.MatchString(p) {
+			out[i] = "AWS:" + RedactedAccount
+		}
+	}
+	return out
+}
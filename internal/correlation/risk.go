@@ -1,6 +1,12 @@
 package correlation
 
-import "strings"
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/catalog"
+)
 
 // RiskLevel represents the risk classification for an IAM privilege.
 type RiskLevel string
@@ -9,20 +15,91 @@ const (
 	RiskHigh   RiskLevel = "HIGH"
 	RiskMedium RiskLevel = "MEDIUM"
 	RiskLow    RiskLevel = "LOW"
+	// RiskOrphaned marks a role observed in traces that no longer exists in
+	// IAM (deleted, or recreated under a different ARN).
+	RiskOrphaned RiskLevel = "ORPHANED"
+	// RiskNew marks a role created within the new-role grace period (see
+	// Options.NewRoleGraceDays), too recently for its unused privileges to
+	// mean anything.
+	RiskNew RiskLevel = "NEW"
 )
 
-// highPrefixes are action prefixes that indicate high-risk operations.
+// highPrefixes are action verbs that indicate high-risk operations.
 var highPrefixes = []string{"Delete", "Terminate"}
 
-// lowPrefixes are action prefixes that indicate low-risk (read-only) operations.
+// lowPrefixes are action verbs that indicate low-risk (read-only) operations.
 var lowPrefixes = []string{"Describe", "List", "Get"}
 
-// mediumPrefixes are action prefixes that indicate medium-risk operations.
+// mediumPrefixes are action verbs that indicate medium-risk operations.
 var mediumPrefixes = []string{"Create", "Put", "Modify", "Update", "Attach", "Detach"}
 
-// ClassifyPrivilege returns the risk level for a single IAM privilege.
-// Format: "service:Action" or "service:*" or "*".
+// accessLevelRisk maps AWS's documented access levels to risk levels.
+var accessLevelRisk = map[string]RiskLevel{
+	catalog.LevelPermissionsManagement: RiskHigh,
+	catalog.LevelWrite:                 RiskMedium,
+	catalog.LevelTagging:               RiskMedium,
+	catalog.LevelRead:                  RiskLow,
+	catalog.LevelList:                  RiskLow,
+}
+
+// leadingVerb returns the first CamelCase word of an action name, e.g. "Put"
+// for "PutBucketDeletePolicy": its first letter and the lowercase letters
+// after it.
+func leadingVerb(action string) string {
+	for i, r := range action {
+		if i > 0 && !unicode.IsLower(r) {
+			return action[:i]
+		}
+	}
+	return action
+}
+
+// Classifier assigns a risk level to a single IAM privilege, given as
+// "service:Action", "service:*" or "*". The Engine classifies with one (see
+// Options.Classifier), so embedders can substitute their own risk model.
+type Classifier interface {
+	Classify(privilege string) RiskLevel
+}
+
+// DefaultClassifier is the built-in risk model behind ClassifyPrivilege.
+type DefaultClassifier struct {
+	// AccessLevelRisks overrides the risk level of catalogued actions of an
+	// access level, keyed by catalog.Level*. Levels it leaves out keep
+	// their built-in risk.
+	AccessLevelRisks map[string]RiskLevel
+}
+
+// ClassifyPrivilege returns the risk level for a single IAM privilege under
+// DefaultClassifier.
 func ClassifyPrivilege(privilege string) RiskLevel {
+	return DefaultClassifier{}.Classify(privilege)
+}
+
+// Classify returns the risk level for privilege.
+//
+// Actions in the catalog are classified by their documented access level, so
+// that e.g. s3:PutObjectAcl (permissions management) is HIGH and
+// dynamodb:Query (read) is LOW. Other actions fall back to their leading verb.
+func (c DefaultClassifier) Classify(privilege string) RiskLevel {
+	byVerb := classifyByVerb(privilege)
+	level, ok := catalog.AccessLevel(privilege)
+	if !ok {
+		return byVerb
+	}
+	// Write covers destroying resources as well as creating them; keep
+	// Delete* and Terminate* at HIGH.
+	if level == catalog.LevelWrite && byVerb == RiskHigh {
+		return RiskHigh
+	}
+	if risk, ok := c.AccessLevelRisks[level]; ok {
+		return risk
+	}
+	return accessLevelRisk[level]
+}
+
+// classifyByVerb is the heuristic DefaultClassifier falls back to for actions
+// outside the catalog.
+func classifyByVerb(privilege string) RiskLevel {
 	parts := strings.SplitN(privilege, ":", 2)
 	var action string
 	if len(parts) == 2 {
@@ -36,18 +113,22 @@ func ClassifyPrivilege(privilege string) RiskLevel {
 		return RiskMedium
 	}
 
+	// Classify on the operation's leading verb only, so a later word such as
+	// the "Delete" in "PutBucketDeletePolicy" or the "Get" in "Getaway" does
+	// not decide the tier.
+	verb := leadingVerb(action)
 	for _, prefix := range highPrefixes {
-		if strings.HasPrefix(action, prefix) {
+		if verb == prefix {
 			return RiskHigh
 		}
 	}
 	for _, prefix := range lowPrefixes {
-		if strings.HasPrefix(action, prefix) {
+		if verb == prefix {
 			return RiskLow
 		}
 	}
 	for _, prefix := range mediumPrefixes {
-		if strings.HasPrefix(action, prefix) {
+		if verb == prefix {
 			return RiskMedium
 		}
 	}
@@ -59,12 +140,17 @@ func ClassifyPrivilege(privilege string) RiskLevel {
 // ClassifySet returns the highest risk level across a set of privileges.
 // If the set is empty, returns LOW.
 func ClassifySet(privileges []string) RiskLevel {
+	return classifySet(DefaultClassifier{}, privileges)
+}
+
+// classifySet is ClassifySet under c.
+func classifySet(c Classifier, privileges []string) RiskLevel {
 	if len(privileges) == 0 {
 		return RiskLow
 	}
 	highest := RiskLow
 	for _, p := range privileges {
-		level := ClassifyPrivilege(p)
+		level := c.Classify(p)
 		if level == RiskHigh {
 			return RiskHigh // short-circuit
 		}
@@ -74,3 +160,68 @@ func ClassifySet(privileges []string) RiskLevel {
 	}
 	return highest
 }
+
+// PrivilegeRisk pairs an unused privilege with its individual risk level.
+type PrivilegeRisk struct {
+	Privilege string
+	Risk      RiskLevel
+}
+
+// riskRank orders risk levels for sorting, highest first.
+var riskRank = map[RiskLevel]int{
+	RiskHigh:   0,
+	RiskMedium: 1,
+	RiskLow:    2,
+}
+
+// UnusedByRisk pairs each unused privilege of r with its risk level and sorts
+// them HIGH → LOW, then alphabetically, so the most dangerous leftovers are
+// listed first.
+func UnusedByRisk(r Result) []PrivilegeRisk {
+	out := make([]PrivilegeRisk, 0, len(r.Unused))
+	for _, p := range r.Unused {
+		out = append(out, PrivilegeRisk{Privilege: p, Risk: r.unusedRisk(p)})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if riskRank[out[i].Risk] != riskRank[out[j].Risk] {
+			return riskRank[out[i].Risk] < riskRank[out[j].Risk]
+		}
+		return out[i].Privilege < out[j].Privilege
+	})
+	return out
+}
+
+// unusedRisk returns the risk level the analysis gave the unused privilege
+// p, classifying it with DefaultClassifier when r does not carry one, as in
+// a result stored before per-privilege risks were.
+func (r Result) unusedRisk(p string) RiskLevel {
+	if risk, ok := r.UnusedRisks[p]; ok {
+		return risk
+	}
+	return ClassifyPrivilege(p)
+}
+
+// RisksFromStrings converts stored per-privilege risk levels back to their
+// type.
+func RisksFromStrings(m map[string]string) map[string]RiskLevel {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]RiskLevel, len(m))
+	for p, risk := range m {
+		out[p] = RiskLevel(risk)
+	}
+	return out
+}
+
+// risksToStrings is the inverse of RisksFromStrings, used when saving.
+func risksToStrings(m map[string]RiskLevel) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for p, risk := range m {
+		out[p] = string(risk)
+	}
+	return out
+}
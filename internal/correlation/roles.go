@@ -0,0 +1,62 @@
+package correlation
+
+import (
+	"errors"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+)
+
+var (
+	errRoleNotFound  = errors.New("role not found in IAM")
+	errAmbiguousRole = errors.New("role name matches roles in multiple accounts")
+)
+
+// roleIndex resolves an observed role identifier to its scraped assignment.
+// Matching is account-aware: an ARN only ever resolves to a role in its own
+// account, so two same-named roles in different accounts never clobber each
+// other. A bare role name (no account) only resolves when it is unique.
+type roleIndex struct {
+	byARN  map[string]scraper.RoleAssignment
+	byKey  map[string]scraper.RoleAssignment
+	byName map[string][]scraper.RoleAssignment
+}
+
+func newRoleIndex(assignments []scraper.RoleAssignment) *roleIndex {
+	idx := &roleIndex{
+		byARN:  make(map[string]scraper.RoleAssignment, len(assignments)),
+		byKey:  make(map[string]scraper.RoleAssignment, len(assignments)),
+		byName: make(map[string][]scraper.RoleAssignment, len(assignments)),
+	}
+	for _, a := range assignments {
+		idx.byARN[a.RoleARN] = a
+		idx.byKey[rolearn.Parse(a.RoleARN).Key()] = a
+		idx.byName[a.RoleName] = append(idx.byName[a.RoleName], a)
+	}
+	return idx
+}
+
+// lookup returns the assignment for an observed role identifier.
+func (idx *roleIndex) lookup(observed string) (scraper.RoleAssignment, error) {
+	if a, ok := idx.byARN[observed]; ok {
+		return a, nil
+	}
+
+	parsed := rolearn.Parse(observed)
+	if parsed.Account != "" {
+		// Same account, possibly a different IAM path in the ARN.
+		if a, ok := idx.byKey[parsed.Key()]; ok {
+			return a, nil
+		}
+		return scraper.RoleAssignment{}, errRoleNotFound
+	}
+
+	switch candidates := idx.byName[parsed.Name]; len(candidates) {
+	case 0:
+		return scraper.RoleAssignment{}, errRoleNotFound
+	case 1:
+		return candidates[0], nil
+	default:
+		return scraper.RoleAssignment{}, errAmbiguousRole
+	}
+}
@@ -0,0 +1,115 @@
+package correlation
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+)
+
+// Scope selects whose observed calls count when deciding whether a privilege
+// is used.
+type Scope string
+
+const (
+	// ScopeRole judges each role only by its own observed calls. This is the
+	// default.
+	ScopeRole Scope = "role"
+	// ScopePolicy treats a privilege granted by a managed policy as used when
+	// any role attached to that same policy used it within the privilege's
+	// observation window. Removing such a privilege would mean editing a
+	// policy other roles rely on, so it is not reported as unused. Inline
+	// policies belong to a single role and are still judged per role.
+	ScopePolicy Scope = "policy"
+)
+
+// policyUsage maps a managed policy ARN to the last time each privilege was
+// used by any role attached to it. A nil policyUsage credits nothing, which
+// is what ScopeRole wants.
+type policyUsage map[string]map[string]time.Time
+
+// sharedPolicyUsage merges the observed usage of every role into the managed
+// policies attached to it.
+func (e *Engine) sharedPolicyUsage(ctx context.Context, roles *roleIndex, observedRoles []string, since time.Time) (policyUsage, error) {
+	shared := make(policyUsage)
+	for _, role := range observedRoles {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		assignment, err := roles.lookup(role)
+		if err != nil {
+			// Ambiguous and orphaned roles are reported by Run.
+			continue
+		}
+		lastSeen, err := e.lastSeen(ctx, role, since)
+		if err != nil {
+			return nil, err
+		}
+		for _, src := range assignment.Policies {
+			if src.Inline || src.ARN == "" {
+				continue
+			}
+			merged, ok := shared[src.ARN]
+			if !ok {
+				merged = make(map[string]time.Time)
+				shared[src.ARN] = merged
+			}
+			for p, ts := range lastSeen {
+				if prev, ok := merged[p]; !ok || ts.After(prev) {
+					merged[p] = ts
+				}
+			}
+		}
+	}
+	return shared, nil
+}
+
+// credit splits a role's unused privileges into those still unused and those
+// used by another role through a managed policy they share. Each privilege is
+// judged against the window of its own risk tier.
+func (u policyUsage) credit(e *Engine, assignment scraper.RoleAssignment, unused []string, now time.Time) (stillUnused, credited []string) {
+	if len(u) == 0 {
+		return unused, nil
+	}
+	for _, p := range unused {
+		if u.usedViaPolicy(e, assignment, p, now) {
+			credited = append(credited, p)
+		} else {
+			stillUnused = append(stillUnused, p)
+		}
+	}
+	return stillUnused, credited
+}
+
+// usedViaPolicy reports whether any managed policy of the role that grants p
+// has usage covering p inside p's window.
+func (u policyUsage) usedViaPolicy(e *Engine, assignment scraper.RoleAssignment, p string, now time.Time) bool {
+	cutoff := now.AddDate(0, 0, -e.windowFor(e.classifier.Classify(p)))
+	for _, src := range assignment.Policies {
+		if src.Inline || !grants(src, p) {
+			continue
+		}
+		var used []string
+		usedSet := make(map[string]struct{})
+		for q, ts := range u[src.ARN] {
+			if !ts.Before(cutoff) {
+				used = append(used, q)
+				usedSet[strings.ToLower(q)] = struct{}{}
+			}
+		}
+		if isPrivilegeUsed(p, used, usedSet) {
+			return true
+		}
+	}
+	return false
+}
+
+func grants(src scraper.PolicySource, p string) bool {
+	for _, a := range src.Actions {
+		if strings.EqualFold(a, p) {
+			return true
+		}
+	}
+	return false
+}
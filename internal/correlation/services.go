@@ -0,0 +1,144 @@
+package correlation
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+)
+
+// serviceFilter restricts analysis to privileges of in-scope services. The
+// zero value allows every privilege.
+type serviceFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// newServiceFilter builds a filter from service prefixes such as "s3". A
+// non-empty include list admits only its services; exclude always wins.
+func newServiceFilter(include, exclude []string) serviceFilter {
+	return serviceFilter{include: serviceSet(include), exclude: serviceSet(exclude)}
+}
+
+func serviceSet(services []string) map[string]bool {
+	if len(services) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(services))
+	for _, s := range services {
+		set[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	return set
+}
+
+// allows reports whether privilege belongs to an in-scope service. A
+// privilege without a service prefix (the "*" wildcard) spans every service
+// and is always in scope.
+func (f serviceFilter) allows(privilege string) bool {
+	service, _, ok := strings.Cut(privilege, ":")
+	if !ok {
+		return true
+	}
+	service = strings.ToLower(service)
+	if f.exclude[service] {
+		return false
+	}
+	return f.include == nil || f.include[service]
+}
+
+// active reports whether the filter excludes anything.
+func (f serviceFilter) active() bool {
+	return f.include != nil || f.exclude != nil
+}
+
+// privileges returns the in-scope subset of privs, preserving order.
+func (f serviceFilter) privileges(privs []string) []string {
+	if privs == nil {
+		return nil
+	}
+	kept := make([]string, 0, len(privs))
+	for _, p := range privs {
+		if f.allows(p) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// assignments restricts each assignment's privileges, and the actions
+// recorded per policy, to in-scope services.
+func (f serviceFilter) assignments(assignments []scraper.RoleAssignment) []scraper.RoleAssignment {
+	if !f.active() {
+		return assignments
+	}
+	filtered := make([]scraper.RoleAssignment, len(assignments))
+	for i, a := range assignments {
+		filtered[i] = f.assignment(a)
+	}
+	return filtered
+}
+
+func (f serviceFilter) assignment(a scraper.RoleAssignment) scraper.RoleAssignment {
+	if !f.active() {
+		return a
+	}
+	a.Privileges = f.privileges(a.Privileges)
+	policies := make([]scraper.PolicySource, len(a.Policies))
+	for i, p := range a.Policies {
+		p.Actions = f.privileges(p.Actions)
+		p.Suppressed = f.privileges(p.Suppressed)
+		p.Constrained = f.privileges(p.Constrained)
+		policies[i] = p
+	}
+	a.Policies = policies
+	if a.PolicyOverlaps != nil {
+		overlaps := make(map[string][]string, len(a.PolicyOverlaps))
+		for action, ids := range a.PolicyOverlaps {
+			if f.allows(action) {
+				overlaps[action] = ids
+			}
+		}
+		if len(overlaps) == 0 {
+			overlaps = nil
+		}
+		a.PolicyOverlaps = overlaps
+	}
+	return a
+}
+
+// ServiceCoverage maps each service of the assigned privileges to whether any
+// of its actions appears in lastSeen, the role's observed privileges. A
+// service with no observation at all is likely not instrumented, so its
+// unused findings say more about tracing than about the role. The "*"
+// wildcard belongs to no service and is left out.
+func ServiceCoverage(assigned []string, lastSeen map[string]time.Time) map[string]bool {
+	observed := make(map[string]bool, len(lastSeen))
+	for p := range lastSeen {
+		if service, _, ok := strings.Cut(p, ":"); ok {
+			observed[strings.ToLower(service)] = true
+		}
+	}
+	coverage := make(map[string]bool)
+	for _, a := range assigned {
+		service, _, ok := strings.Cut(a, ":")
+		if !ok {
+			continue
+		}
+		service = strings.ToLower(service)
+		coverage[service] = observed[service]
+	}
+	return coverage
+}
+
+// UncoveredServices returns, sorted, the services of r with no observations.
+func UncoveredServices(r Result) []string {
+	var uncovered []string
+	for service, covered := range r.ServiceCoverage {
+		if !covered {
+			uncovered = append(uncovered, service)
+		}
+	}
+	sort.Strings(uncovered)
+	return uncovered
+}
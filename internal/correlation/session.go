@@ -0,0 +1,29 @@
+package correlation
+
+import "github.com/0xKirisame/shinkai-shoujo/internal/storage"
+
+// FrequentSessionPolicyShare is the share of a role's observed calls made
+// under session policies from which SessionScoped flags the role.
+const FrequentSessionPolicyShare = 0.5
+
+// SessionPolicyShare returns the share of the calls in usage made in sessions
+// scoped by a session policy, or 0 when usage has no calls.
+func SessionPolicyShare(usage map[string]storage.PrivilegeUsage) float64 {
+	var calls, scoped int
+	for _, u := range usage {
+		calls += u.CallCount
+		scoped += u.SessionScopedCalls
+	}
+	if calls == 0 {
+		return 0
+	}
+	return float64(scoped) / float64(calls)
+}
+
+// SessionScoped reports whether r's role was frequently assumed with a
+// session policy. Session policies narrow what a session may do below the
+// role's own policies, which IAM alone does not reveal, so r's unused
+// findings may overstate what its sessions were actually granted.
+func SessionScoped(r Result) bool {
+	return r.SessionPolicyShare >= FrequentSessionPolicyShare
+}
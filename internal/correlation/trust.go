@@ -0,0 +1,102 @@
+package correlation
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// TrustAnalysis is the second risk axis of a role: who can assume it, as
+// opposed to what it can do once assumed.
+type TrustAnalysis struct {
+	// Principals are the trusted principals as "Type:ID" strings.
+	Principals []string
+	// RiskLevel is HIGH when anyone can assume the role, MEDIUM when another
+	// account or an unexpected service can, LOW otherwise. Empty means the
+	// trust policy was not analyzed.
+	RiskLevel RiskLevel
+	// Wildcard is set when the role trusts "*".
+	Wildcard bool
+	// CrossAccount are the AWS principals outside the role's own account.
+	CrossAccount []string
+	// UnexpectedServices are the service principals not in the configured
+	// list of expected services.
+	UnexpectedServices []string
+}
+
+// AnalyzeTrust classifies the principals trusted by the role roleARN.
+// expectedServices lists the service principals (e.g. "ec2.amazonaws.com")
+// roles may trust; when empty, no service is flagged. A nil principals
+// slice, meaning the trust policy was never read, yields the zero value.
+func AnalyzeTrust(roleARN string, principals []scraper.Principal, expectedServices []string) TrustAnalysis {
+	if principals == nil {
+		return TrustAnalysis{}
+	}
+	expected := make(map[string]bool, len(expectedServices))
+	for _, s := range expectedServices {
+		expected[strings.ToLower(s)] = true
+	}
+	account := rolearn.Parse(roleARN).Account
+
+	t := TrustAnalysis{RiskLevel: RiskLow}
+	for _, p := range principals {
+		t.Principals = append(t.Principals, p.String())
+		switch {
+		case p.ID == "*" && (p.Type == "*" || p.Type == "AWS"):
+			t.Wildcard = true
+		case p.Type == "AWS":
+			if other := principalAccount(p.ID); account != "" && other != "" && other != account {
+				t.CrossAccount = append(t.CrossAccount, p.String())
+			}
+		case p.Type == "Service":
+			if len(expected) > 0 && !expected[strings.ToLower(p.ID)] {
+				t.UnexpectedServices = append(t.UnexpectedServices, p.String())
+			}
+		}
+	}
+	switch {
+	case t.Wildcard:
+		t.RiskLevel = RiskHigh
+	case len(t.CrossAccount) > 0 || len(t.UnexpectedServices) > 0:
+		t.RiskLevel = RiskMedium
+	}
+	return t
+}
+
+// principalAccount returns the account of an AWS principal given as an ARN
+// or a bare account ID, or "" if it has none.
+func principalAccount(id string) string {
+	if a, err := arn.Parse(id); err == nil {
+		return a.AccountID
+	}
+	if len(id) == 12 && strings.Trim(id, "0123456789") == "" {
+		return id
+	}
+	return ""
+}
+
+// TrustFromRecord converts a stored trust analysis back to its type.
+func TrustFromRecord(r storage.TrustRecord) TrustAnalysis {
+	return TrustAnalysis{
+		Principals:         r.Principals,
+		RiskLevel:          RiskLevel(r.RiskLevel),
+		Wildcard:           r.Wildcard,
+		CrossAccount:       r.CrossAccount,
+		UnexpectedServices: r.UnexpectedServices,
+	}
+}
+
+// trustToRecord is the inverse of TrustFromRecord, used when saving.
+func trustToRecord(t TrustAnalysis) storage.TrustRecord {
+	return storage.TrustRecord{
+		Principals:         t.Principals,
+		RiskLevel:          string(t.RiskLevel),
+		Wildcard:           t.Wildcard,
+		CrossAccount:       t.CrossAccount,
+		UnexpectedServices: t.UnexpectedServices,
+	}
+}
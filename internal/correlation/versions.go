@@ -0,0 +1,66 @@
+package correlation
+
+import (
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+)
+
+// VersionDiff compares a managed policy's default version with its previous
+// one, against what the role attached to it was observed using.
+type VersionDiff struct {
+	IAMRole         string
+	PolicyARN       string
+	PreviousVersion string
+	// Removed are actions the previous version granted that the default
+	// version no longer does: already cleaned up.
+	Removed []string
+	// StillUnused are actions both versions grant that the role has not
+	// used: left over from before the last policy change.
+	StillUnused []string
+}
+
+// CompareVersions diffs each managed policy scraped with
+// scraper.Options.CompareVersions against its previous version. Policies
+// with no previous version, and roles without a result, are left out.
+// Actions compare case-insensitively, as IAM does.
+func CompareVersions(assignments []scraper.RoleAssignment, results []Result) []VersionDiff {
+	unusedByRole := make(map[string]map[string]bool, len(results))
+	for _, r := range results {
+		unusedByRole[r.IAMRole] = lowerSet(r.Unused)
+	}
+
+	var diffs []VersionDiff
+	for _, a := range assignments {
+		unused, ok := unusedByRole[a.RoleARN]
+		if !ok {
+			continue
+		}
+		for _, p := range a.Policies {
+			if p.Inline || p.PreviousVersion == "" {
+				continue
+			}
+			current := lowerSet(p.Actions)
+			diff := VersionDiff{IAMRole: a.RoleARN, PolicyARN: p.ARN, PreviousVersion: p.PreviousVersion}
+			for _, action := range p.PreviousActions {
+				key := strings.ToLower(action)
+				switch {
+				case !current[key]:
+					diff.Removed = append(diff.Removed, action)
+				case unused[key]:
+					diff.StillUnused = append(diff.StillUnused, action)
+				}
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+func lowerSet(actions []string) map[string]bool {
+	set := make(map[string]bool, len(actions))
+	for _, a := range actions {
+		set[strings.ToLower(a)] = true
+	}
+	return set
+}
@@ -0,0 +1,133 @@
+// Package forwarder pushes analysis results to an external HTTP endpoint,
+// such as a SIEM ingestion API. The payload is the full JSON report, the
+// same document 'generate json' writes, for machine consumption.
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/generator"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	defaultTimeout     = 10 * time.Second
+)
+
+// Options tune delivery. The zero value is usable.
+type Options struct {
+	// AuthHeader names the header carrying AuthValue. Empty means
+	// "Authorization". Nothing is sent when AuthValue is empty.
+	AuthHeader string
+	AuthValue  string
+	// MaxAttempts is how many times a request is tried before giving up.
+	// Zero means 3.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on each
+	// further retry. Zero means one second.
+	Backoff time.Duration
+	// Timeout bounds each attempt. Zero means ten seconds.
+	Timeout time.Duration
+}
+
+// Forwarder POSTs analysis results to an endpoint.
+type Forwarder struct {
+	endpoint string
+	client   *http.Client
+	log      *slog.Logger
+	opts     Options
+}
+
+// New returns a Forwarder for endpoint.
+func New(endpoint string, log *slog.Logger, opts Options) *Forwarder {
+	if opts.AuthHeader == "" {
+		opts.AuthHeader = "Authorization"
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	return &Forwarder{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: opts.Timeout},
+		log:      log,
+		opts:     opts,
+	}
+}
+
+// Send posts results as a JSON report. Network errors, 429 and 5xx
+// responses are retried with exponential backoff; any other non-2xx status
+// fails immediately, since resending the same payload cannot fix it.
+func (f *Forwarder) Send(ctx context.Context, results []correlation.Result) error {
+	var body bytes.Buffer
+	if err := (&generator.JSONGenerator{}).Generate(results, &body); err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+
+	delay := f.opts.Backoff
+	var lastErr error
+	for attempt := 1; attempt <= f.opts.MaxAttempts; attempt++ {
+		retry, err := f.post(ctx, body.Bytes())
+		if err == nil {
+			f.log.Info("exported analysis results", "endpoint", f.endpoint, "roles", len(results))
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+		if attempt == f.opts.MaxAttempts {
+			break
+		}
+		f.log.Warn("export failed, retrying", "endpoint", f.endpoint, "attempt", attempt, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("exporting results: %w", ctx.Err())
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("exporting results: giving up after %d attempts: %w", f.opts.MaxAttempts, lastErr)
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (f *Forwarder) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("building export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.opts.AuthValue != "" {
+		req.Header.Set(f.opts.AuthHeader, f.opts.AuthValue)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("posting to %s: %w", f.endpoint, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) //nolint:errcheck
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("posting to %s: %s", f.endpoint, resp.Status)
+	default:
+		return false, fmt.Errorf("posting to %s: %s — check export.endpoint and the auth header", f.endpoint, resp.Status)
+	}
+}
@@ -0,0 +1,105 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/generator"
+)
+
+var testResults = []correlation.Result{{
+	IAMRole:   "arn:aws:iam::123456789012:role/App",
+	Assigned:  []string{"s3:GetObject", "s3:DeleteBucket"},
+	Used:      []string{"s3:GetObject"},
+	Unused:    []string{"s3:DeleteBucket"},
+	RiskLevel: "HIGH",
+}}
+
+func testForwarder(url string, opts Options) *Forwarder {
+	opts.Backoff = time.Millisecond
+	return New(url, slog.New(slog.NewTextHandler(io.Discard, nil)), opts)
+}
+
+func TestSendPostsJSONReport(t *testing.T) {
+	var report generator.JSONReport
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("X-Api-Key")
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	f := testForwarder(srv.URL, Options{AuthHeader: "X-Api-Key", AuthValue: "secret"})
+	if err := f.Send(context.Background(), testResults); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if auth != "secret" {
+		t.Errorf("expected auth header to be sent, got %q", auth)
+	}
+	if len(report.Roles) != 1 || report.Roles[0].UnusedCount != 1 {
+		t.Errorf("unexpected report payload: %+v", report)
+	}
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	if err := testForwarder(srv.URL, Options{MaxAttempts: 3}).Send(context.Background(), testResults); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestSendGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := testForwarder(srv.URL, Options{MaxAttempts: 2}).Send(context.Background(), testResults)
+	if err == nil {
+		t.Fatal("expected Send to fail")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+}
+
+func TestSendDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if err := testForwarder(srv.URL, Options{MaxAttempts: 3}).Send(context.Background(), testResults); err == nil {
+		t.Fatal("expected Send to fail on 401")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected a single attempt for a 4xx, got %d", got)
+	}
+}
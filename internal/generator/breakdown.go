@@ -0,0 +1,41 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// WriteRiskBreakdown writes, for each role, its unused privileges labelled
+// with their individual risk level, HIGH first, and noted when granted only
+// on specific resources. It is the text behind 'report --detail'.
+func WriteRiskBreakdown(w io.Writer, results []correlation.Result) error {
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "%s  [%s]  %d unused\n", r.IAMRole, r.RiskLevel, len(r.Unused)); err != nil {
+			return err
+		}
+		if len(r.Unused) == 0 {
+			if _, err := fmt.Fprintf(w, "  (no unused privileges)\n"); err != nil {
+				return err
+			}
+		}
+		constrained := make(map[string]bool, len(r.ResourceConstrained))
+		for _, p := range r.ResourceConstrained {
+			constrained[p] = true
+		}
+		for _, p := range correlation.UnusedByRisk(r) {
+			note := ""
+			if constrained[p.Privilege] {
+				note = "  (resource-scoped)"
+			}
+			if _, err := fmt.Fprintf(w, "  %-8s %s%s\n", p.Risk, p.Privilege, note); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package generator
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// Supported output compressions.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+)
+
+// Compressed wraps g so its output is compressed with the named method.
+// CompressNone (or "") returns g unchanged.
+func Compressed(g Generator, compression string) (Generator, error) {
+	switch compression {
+	case "", CompressNone:
+		return g, nil
+	case CompressGzip:
+		return gzipGenerator{g}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q (supported: none, gzip)", compression)
+	}
+}
+
+// CompressionForPath resolves the compression for an output file. An empty
+// requested value means "auto": gzip when the path ends in ".gz", otherwise
+// none. It also returns the path to write, which gains a ".gz" suffix when
+// gzip was requested for a path without one.
+func CompressionForPath(path, requested string) (compression, outPath string) {
+	gz := strings.HasSuffix(path, ".gz")
+	switch {
+	case requested == "" && gz:
+		return CompressGzip, path
+	case requested == "":
+		return CompressNone, path
+	case requested == CompressGzip && !gz:
+		return requested, path + ".gz"
+	default:
+		return requested, path
+	}
+}
+
+type gzipGenerator struct {
+	g Generator
+}
+
+func (z gzipGenerator) Generate(results []correlation.Result, w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := z.g.Generate(results, zw); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("finishing gzip stream: %w", err)
+	}
+	return nil
+}
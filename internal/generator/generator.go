@@ -12,17 +12,48 @@ type Generator interface {
 	Generate(results []correlation.Result, w io.Writer) error
 }
 
+// Options tune generator output. The zero value reproduces the default output.
+type Options struct {
+	// PreventDestroy adds a lifecycle { prevent_destroy = true } guard to
+	// generated Terraform resources.
+	PreventDestroy bool
+	// VerboseUsage adds, in JSON and YAML reports, the call count and last
+	// observation of each used privilege.
+	VerboseUsage bool
+	// NeverRemove are privileges, or patterns such as "kms:*", that
+	// generated Terraform policies keep even when unused, and remove lists
+	// never list.
+	NeverRemove []string
+	// RemoveStyle is the remove-list output style, one of the RemoveStyle
+	// constants. Empty means RemoveStyleList.
+	RemoveStyle string
+}
+
 // New returns a Generator for the given format string.
-// Supported formats: "terraform", "json", "yaml".
+// Supported formats: "terraform", "tf-json", "json", "yaml", "remove-list".
 func New(format string) (Generator, error) {
+	return NewWithOptions(format, Options{})
+}
+
+// NewWithOptions is like New but applies opts to generators that support them.
+func NewWithOptions(format string, opts Options) (Generator, error) {
 	switch format {
 	case "terraform":
-		return &TerraformGenerator{}, nil
+		return &TerraformGenerator{PreventDestroy: opts.PreventDestroy, NeverRemove: opts.NeverRemove}, nil
+	case "tf-json":
+		return &TerraformJSONGenerator{PreventDestroy: opts.PreventDestroy, NeverRemove: opts.NeverRemove}, nil
 	case "json":
-		return &JSONGenerator{}, nil
+		return &JSONGenerator{VerboseUsage: opts.VerboseUsage}, nil
 	case "yaml":
-		return &YAMLGenerator{}, nil
+		return &YAMLGenerator{VerboseUsage: opts.VerboseUsage}, nil
+	case "remove-list":
+		switch opts.RemoveStyle {
+		case "", RemoveStyleList, RemoveStyleAWSCLI, RemoveStyleJSON:
+		default:
+			return nil, fmt.Errorf("unknown remove-list style %q (supported: %s, %s, %s)", opts.RemoveStyle, RemoveStyleList, RemoveStyleAWSCLI, RemoveStyleJSON)
+		}
+		return &RemoveListGenerator{Style: opts.RemoveStyle, NeverRemove: opts.NeverRemove}, nil
 	default:
-		return nil, fmt.Errorf("unknown output format %q (supported: terraform, json, yaml)", format)
+		return nil, fmt.Errorf("unknown output format %q (supported: terraform, tf-json, json, yaml, remove-list)", format)
 	}
 }
@@ -2,12 +2,21 @@ package generator
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
 
 var testResults = []correlation.Result{
@@ -52,6 +61,115 @@ func TestJSONGenerator(t *testing.T) {
 	}
 }
 
+func TestJSONGenerator_VerboseUsage(t *testing.T) {
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	results := []correlation.Result{{
+		IAMRole:  "arn:aws:iam::123:role/App",
+		Assigned: []string{"s3:GetObject", "s3:PutObject", "sqs:SendMessage"},
+		// sqs:SendMessage is credited through a shared policy, not observed.
+		Used:   []string{"s3:GetObject", "s3:PutObject", "sqs:SendMessage"},
+		Unused: []string{},
+		Usage: map[string]storage.PrivilegeUsage{
+			"s3:GetObject": {CallCount: 42, LastSeen: seen},
+			"s3:PutObject": {CallCount: 3, LastSeen: seen.Add(-time.Hour)},
+		},
+	}}
+
+	var plain bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain.String(), `"used":`) {
+		t.Errorf("usage must only be reported with VerboseUsage:\n%s", plain.String())
+	}
+
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{VerboseUsage: true}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var raw struct {
+		Roles []struct {
+			UsedPrivileges []string         `json:"used_privileges"`
+			Used           []map[string]any `json:"used"`
+		} `json:"roles"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"privilege": "s3:GetObject", "count": float64(42), "last_seen": "2024-05-01T12:00:00Z"},
+		{"privilege": "s3:PutObject", "count": float64(3), "last_seen": "2024-05-01T11:00:00Z"},
+	}
+	if !reflect.DeepEqual(raw.Roles[0].Used, want) {
+		t.Errorf("used = %v, want %v", raw.Roles[0].Used, want)
+	}
+	if len(raw.Roles[0].UsedPrivileges) != 3 {
+		t.Errorf("used_privileges must keep its simple shape, got %v", raw.Roles[0].UsedPrivileges)
+	}
+
+	back, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := back[0].Usage["s3:GetObject"]; u.CallCount != 42 || !u.LastSeen.Equal(seen) {
+		t.Errorf("usage lost through the report: %+v", back[0].Usage)
+	}
+
+	var yml bytes.Buffer
+	if err := (&YAMLGenerator{VerboseUsage: true}).Generate(results, &yml); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(yml.String(), "count: 42") {
+		t.Errorf("expected usage in the YAML report:\n%s", yml.String())
+	}
+}
+
+func TestJSONGenerator_Recommendations(t *testing.T) {
+	results := []correlation.Result{
+		{
+			IAMRole: "arn:aws:iam::123:role/Observed",
+			Used:    []string{"s3:GetObject"},
+			Unused:  []string{"s3:PutObject", "s3:DeleteBucket"},
+			Sources: map[string]correlation.PrivilegeSource{
+				"s3:DeleteBucket": correlation.SourceInline,
+				"s3:PutObject":    correlation.SourceManaged,
+			},
+		},
+		{
+			IAMRole: "arn:aws:iam::123:role/Silent",
+			Unused:  []string{"s3:DeleteBucket"},
+			Sources: map[string]correlation.PrivilegeSource{"s3:DeleteBucket": correlation.SourceInline},
+		},
+	}
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse JSON output: %v", err)
+	}
+
+	got := report.Roles[0].Recommendations
+	want := []JSONRecommendation{
+		{Privilege: "s3:DeleteBucket", RiskLevel: "HIGH", Source: "inline", Recommendation: correlation.RecommendRemove},
+		{Privilege: "s3:PutObject", RiskLevel: "MEDIUM", Source: "managed", Recommendation: correlation.RecommendDetach},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d recommendations, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("recommendation %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	silent := report.Roles[1].Recommendations
+	if len(silent) != 1 || silent[0].Recommendation != correlation.RecommendVerify || silent[0].Source != "inline" {
+		t.Errorf("unobserved role should be told to verify instrumentation, got %+v", silent)
+	}
+}
+
 func TestYAMLGenerator(t *testing.T) {
 	g := &YAMLGenerator{}
 	var buf bytes.Buffer
@@ -84,6 +202,130 @@ func TestTerraformGenerator(t *testing.T) {
 	}
 }
 
+func TestTerraformGenerator_WarnsOfUncoveredServices(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:         "arn:aws:iam::123:role/App",
+		Assigned:        []string{"s3:GetObject", "ec2:DescribeInstances"},
+		Used:            []string{"s3:GetObject"},
+		Unused:          []string{"ec2:DescribeInstances"},
+		RiskLevel:       "LOW",
+		ServiceCoverage: map[string]bool{"s3": true, "ec2": false},
+	}}
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "# WARNING: ec2: no observations — unused findings unreliable.") {
+		t.Errorf("expected a warning for uncovered ec2:\n%s", out)
+	}
+	if strings.Contains(out, "s3: no observations") {
+		t.Errorf("covered s3 must not be warned about:\n%s", out)
+	}
+
+	var report bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &report); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadReport(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back[0].ServiceCoverage, results[0].ServiceCoverage) {
+		t.Errorf("service coverage lost through the JSON report: %v", back[0].ServiceCoverage)
+	}
+}
+
+func TestTerraformGenerator_NeverRemove(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:   "arn:aws:iam::123:role/App",
+		Assigned:  []string{"s3:GetObject", "kms:Decrypt", "s3:DeleteObject"},
+		Used:      []string{"s3:GetObject"},
+		Unused:    []string{"kms:Decrypt", "s3:DeleteObject"},
+		RiskLevel: "MEDIUM",
+	}}
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{NeverRemove: []string{"kms:*"}}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `"kms:Decrypt"`) {
+		t.Errorf("kms:Decrypt matches never_remove and must stay in the Action block:\n%s", out)
+	}
+	if strings.Contains(out, `"s3:DeleteObject"`) {
+		t.Errorf("s3:DeleteObject is unused and not overridden:\n%s", out)
+	}
+	if !strings.Contains(out, "# Kept although unused (manual override, never_remove): kms:Decrypt") {
+		t.Errorf("expected a comment noting the override:\n%s", out)
+	}
+}
+
+func TestTerraformGenerator_WarnsOfSessionScopedRoles(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:            "arn:aws:iam::123:role/Broker",
+		Assigned:           []string{"s3:GetObject", "s3:DeleteObject"},
+		Used:               []string{"s3:GetObject"},
+		Unused:             []string{"s3:DeleteObject"},
+		RiskLevel:          "HIGH",
+		SessionPolicyShare: 0.75,
+	}}
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "# WARNING: 75% of observed calls ran under session policies") {
+		t.Errorf("expected a session policy warning:\n%s", buf.String())
+	}
+
+	var report bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &report); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadReport(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back[0].SessionPolicyShare != 0.75 {
+		t.Errorf("session policy share lost through the JSON report: %v", back[0].SessionPolicyShare)
+	}
+}
+
+func TestTerraformJSONGenerator(t *testing.T) {
+	g := &TerraformJSONGenerator{}
+	var buf bytes.Buffer
+	if err := g.Generate(testResults, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	var cfg map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &cfg); err != nil {
+		t.Fatalf("failed to parse Terraform JSON output: %v", err)
+	}
+	resource, ok := cfg["resource"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected resource key, got %v", cfg)
+	}
+	policies, ok := resource["aws_iam_policy"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected resource.aws_iam_policy key, got %v", resource)
+	}
+	// Only MyRole has unused privileges; ReadOnlyRole gets no resource.
+	if len(policies) != 1 {
+		t.Fatalf("expected 1 policy, got %d", len(policies))
+	}
+	p, ok := policies["arn_aws_iam__123456789012_role_myrole_least_privilege"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing MyRole policy, got %v", policies)
+	}
+	var doc iamPolicyDocument
+	if err := json.Unmarshal([]byte(p["policy"].(string)), &doc); err != nil {
+		t.Fatalf("policy is not a JSON document: %v", err)
+	}
+	if len(doc.Statement) != 1 || len(doc.Statement[0].Action) != 1 || doc.Statement[0].Action[0] != "s3:GetObject" {
+		t.Errorf("expected a single s3:GetObject statement, got %+v", doc.Statement)
+	}
+}
+
 func TestTerraformGenerator_EmptyUsed(t *testing.T) {
 	// Role has assigned privileges but zero OTel observations — used list is empty.
 	// Must NOT generate an empty Action = [] block (invalid HCL).
@@ -113,6 +355,99 @@ func TestTerraformGenerator_EmptyUsed(t *testing.T) {
 	}
 }
 
+func TestGenerators_ServiceLinkedRoleIsInformational(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:    "arn:aws:iam::123:role/aws-service-role/ecs.amazonaws.com/AWSServiceRoleForECS",
+		Assigned:   []string{"ec2:DescribeInstances", "ec2:DeleteNetworkInterface"},
+		Used:       []string{"ec2:DescribeInstances"},
+		Unused:     []string{"ec2:DeleteNetworkInterface"},
+		RiskLevel:  "HIGH",
+		AnalyzedAt: time.Now(),
+		ReadOnly:   true,
+	}}
+
+	var tf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &tf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if strings.Contains(tf.String(), "resource \"aws_iam_policy\"") {
+		t.Error("must not emit a policy resource for a service-linked role")
+	}
+	if !strings.Contains(tf.String(), "Service-linked role") {
+		t.Error("expected an informational comment for the service-linked role")
+	}
+
+	var js bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &js); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var report JSONReport
+	if err := json.Unmarshal(js.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse JSON output: %v", err)
+	}
+	role := report.Roles[0]
+	if !role.ReadOnly {
+		t.Error("expected read_only in JSON output")
+	}
+	if len(role.Recommendations) != 1 || role.Recommendations[0].Recommendation != correlation.RecommendNone {
+		t.Errorf("recommendations = %+v, want none for a service-linked role", role.Recommendations)
+	}
+}
+
+func TestTerraformGenerator_ScopesResources(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:  "arn:aws:iam::123:role/Reader",
+		Assigned: []string{"s3:GetObject", "s3:ListAllMyBuckets", "s3:PutObject"},
+		Used:     []string{"s3:GetObject", "s3:ListAllMyBuckets"},
+		Unused:   []string{"s3:PutObject"},
+		Resources: map[string][]string{
+			"s3:GetObject": {"arn:aws:s3:::reports/*", "arn:aws:s3:::assets/*", " arn:aws:s3:::reports/* "},
+		},
+		RiskLevel: "MEDIUM",
+	}}
+
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	out := buf.String()
+
+	want := "      Action = [\n        \"s3:GetObject\",\n      ]\n" +
+		"      Resource = [\n        \"arn:aws:s3:::assets/*\",\n        \"arn:aws:s3:::reports/*\",\n      ]\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("expected GetObject scoped to the two observed buckets, got:\n%s", out)
+	}
+	if !strings.Contains(out, "        \"s3:ListAllMyBuckets\",\n      ]\n      Resource = \"*\"\n") {
+		t.Errorf("expected privilege without observed resources to fall back to \"*\", got:\n%s", out)
+	}
+}
+
+func TestNormalizeResources(t *testing.T) {
+	got := normalizeResources("s3:GetObject", []string{"logs", "arn:aws:s3:::logs/*", "logs"}, "aws")
+	if len(got) != 1 || got[0] != "arn:aws:s3:::logs/*" {
+		t.Errorf("expected bare bucket to normalize to its object ARN and dedupe, got %v", got)
+	}
+	got = normalizeResources("s3:ListBucket", []string{"logs"}, "aws-cn")
+	if len(got) != 1 || got[0] != "arn:aws-cn:s3:::logs" {
+		t.Errorf("expected bucket ARN in the role's partition, got %v", got)
+	}
+	// The catalog, not the action name, says what an action acts on.
+	got = normalizeResources("s3:ListBucketMultipartUploads", []string{"logs"}, "aws")
+	if len(got) != 1 || got[0] != "arn:aws:s3:::logs" {
+		t.Errorf("expected bucket ARN for a bucket-level multipart action, got %v", got)
+	}
+	got = normalizeResources("s3:ReplicateDelete", []string{"logs"}, "aws")
+	if len(got) != 1 || got[0] != "arn:aws:s3:::logs/*" {
+		t.Errorf("expected object ARN for an object-level action, got %v", got)
+	}
+	if got := normalizeResources("s3:ListAllMyBuckets", []string{"logs"}, "aws"); len(got) != 0 {
+		t.Errorf("expected bare bucket dropped for an action on no bucket or object, got %v", got)
+	}
+	if got := normalizeResources("dynamodb:GetItem", []string{"table"}, "aws"); len(got) != 0 {
+		t.Errorf("expected bare non-S3 name to be dropped, got %v", got)
+	}
+}
+
 func TestTerraformResourceName(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -131,7 +466,7 @@ func TestTerraformResourceName(t *testing.T) {
 }
 
 func TestNew(t *testing.T) {
-	formats := []string{"terraform", "json", "yaml"}
+	formats := []string{"terraform", "tf-json", "json", "yaml"}
 	for _, f := range formats {
 		g, err := New(f)
 		if err != nil {
@@ -147,3 +482,705 @@ func TestNew(t *testing.T) {
 		t.Error("expected error for invalid format")
 	}
 }
+
+func TestWritePerRole(t *testing.T) {
+	dir := t.TempDir()
+
+	written, err := WritePerRole(&TerraformGenerator{}, "terraform", testResults, dir, false)
+	if err != nil {
+		t.Fatalf("WritePerRole() error: %v", err)
+	}
+	want := filepath.Join(dir, "arn_aws_iam__123456789012_role_myrole.tf")
+	if len(written) != 1 || written[0] != want {
+		t.Fatalf("expected only %s, got %v", want, written)
+	}
+
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# Role: arn:aws:iam::123456789012:role/MyRole") {
+		t.Error("expected per-role file to contain only its own role")
+	}
+	if strings.Contains(string(data), "ReadOnlyRole") {
+		t.Error("per-role file must not contain other roles")
+	}
+}
+
+func TestWritePerRole_IncludeClean(t *testing.T) {
+	dir := t.TempDir()
+
+	written, err := WritePerRole(&JSONGenerator{}, "json", testResults, dir, true)
+	if err != nil {
+		t.Fatalf("WritePerRole() error: %v", err)
+	}
+	if len(written) != 2 {
+		t.Fatalf("expected 2 files with includeClean, got %v", written)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "arn_aws_iam__123456789012_role_readonlyrole.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report JSONReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("per-role JSON did not parse: %v", err)
+	}
+	if len(report.Roles) != 1 || report.Roles[0].UnusedCount != 0 {
+		t.Errorf("expected a single clean role, got %+v", report.Roles)
+	}
+}
+
+func TestTerraformGenerator_ImportBlock(t *testing.T) {
+	results := []correlation.Result{
+		{
+			IAMRole:    "arn:aws:iam::123456789012:role/MyRole",
+			Assigned:   []string{"s3:GetObject", "s3:PutObject"},
+			Used:       []string{"s3:GetObject"},
+			Unused:     []string{"s3:PutObject"},
+			RiskLevel:  "MEDIUM",
+			AnalyzedAt: time.Now(),
+			PolicyARNs: []string{
+				"arn:aws:iam::aws:policy/ReadOnlyAccess", // AWS-managed, never imported
+				"arn:aws:iam::123456789012:policy/app/MyAppPolicy",
+			},
+		},
+	}
+
+	g := &TerraformGenerator{PreventDestroy: true}
+	var buf bytes.Buffer
+	if err := g.Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "import {") {
+		t.Error("expected import block when a customer-managed policy ARN is known")
+	}
+	if !strings.Contains(output, `id = "arn:aws:iam::123456789012:policy/app/MyAppPolicy"`) {
+		t.Error("expected import block to reference the customer-managed policy ARN")
+	}
+	if !strings.Contains(output, `name        = "MyAppPolicy"`) {
+		t.Error("expected imported resource to keep the existing policy name")
+	}
+	if !strings.Contains(output, "prevent_destroy = true") {
+		t.Error("expected lifecycle guard when PreventDestroy is set")
+	}
+}
+
+func TestTerraformGenerators_SharedPolicyNotImported(t *testing.T) {
+	shared := "arn:aws:iam::123456789012:policy/SharedPolicy"
+	results := []correlation.Result{
+		{
+			IAMRole:    "arn:aws:iam::123456789012:role/RoleA",
+			Assigned:   []string{"s3:GetObject", "s3:PutObject"},
+			Used:       []string{"s3:GetObject"},
+			Unused:     []string{"s3:PutObject"},
+			RiskLevel:  "MEDIUM",
+			PolicyARNs: []string{shared},
+		},
+		{
+			IAMRole:    "arn:aws:iam::123456789012:role/RoleB",
+			Assigned:   []string{"s3:GetObject", "s3:PutObject"},
+			Used:       []string{"s3:PutObject"},
+			Unused:     []string{"s3:GetObject"},
+			RiskLevel:  "LOW",
+			PolicyARNs: []string{shared},
+		},
+	}
+
+	var hcl bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &hcl); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	output := hcl.String()
+	if strings.Contains(output, "import {") {
+		t.Errorf("a policy shared by two roles must not be imported:\n%s", output)
+	}
+	if strings.Contains(output, `name        = "SharedPolicy"`) {
+		t.Errorf("a policy shared by two roles must not be rewritten in place:\n%s", output)
+	}
+	if !strings.Contains(output, "attached to 2 analyzed roles") {
+		t.Errorf("expected a note on the shared policy:\n%s", output)
+	}
+
+	var tfJSON bytes.Buffer
+	if err := (&TerraformJSONGenerator{}).Generate(results, &tfJSON); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var cfg tfJSONConfig
+	if err := json.Unmarshal(tfJSON.Bytes(), &cfg); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if len(cfg.Import) != 0 {
+		t.Errorf("a policy shared by two roles must not be imported, got %+v", cfg.Import)
+	}
+}
+
+func TestTerraformGenerator_NoImportWithoutARN(t *testing.T) {
+	g := &TerraformGenerator{}
+	var buf bytes.Buffer
+	if err := g.Generate(testResults, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "import {") {
+		t.Error("must not emit import block when no policy ARN is known")
+	}
+	if strings.Contains(output, "lifecycle") {
+		t.Error("must not emit lifecycle guard unless PreventDestroy is set")
+	}
+}
+
+func TestTerraformGenerator_OtherPartitions(t *testing.T) {
+	results := []correlation.Result{
+		{
+			IAMRole:    "arn:aws-us-gov:iam::123456789012:role/GovRole",
+			Assigned:   []string{"s3:GetObject", "s3:PutObject"},
+			Used:       []string{"s3:GetObject"},
+			Unused:     []string{"s3:PutObject"},
+			RiskLevel:  "MEDIUM",
+			PolicyARNs: []string{"arn:aws-us-gov:iam::123456789012:policy/GovPolicy"},
+		},
+		{
+			IAMRole:    "arn:aws-cn:iam::123456789012:role/CnRole",
+			Assigned:   []string{"s3:GetObject", "s3:PutObject"},
+			Used:       []string{"s3:GetObject"},
+			Unused:     []string{"s3:PutObject"},
+			RiskLevel:  "MEDIUM",
+			PolicyARNs: []string{"arn:aws-cn:iam::aws:policy/AmazonS3FullAccess"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	output := buf.String()
+
+	if !strings.Contains(output, `id = "arn:aws-us-gov:iam::123456789012:policy/GovPolicy"`) {
+		t.Error("expected GovCloud policy ARN to be imported unchanged")
+	}
+	if strings.Contains(output, "AmazonS3FullAccess\"") {
+		t.Error("AWS-managed policy in the China partition must not be imported")
+	}
+	if !strings.Contains(output, `resource "aws_iam_policy" "arn_aws_cn_iam__123456789012_role_cnrole_least_privilege"`) {
+		t.Error("expected resource name for China role to be sanitized as usual")
+	}
+}
+
+// validateSchema checks v against the subset of JSON Schema emitted by
+// JSONSchema: type, format, properties, required, items and
+// additionalProperties.
+func validateSchema(t *testing.T, path string, schema map[string]any, v any) {
+	t.Helper()
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			t.Errorf("%s: expected object, got %T", path, v)
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		if req, ok := schema["required"].([]any); ok {
+			for _, name := range req {
+				if _, present := obj[name.(string)]; !present {
+					t.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		for k, val := range obj {
+			sub, ok := props[k].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					t.Errorf("%s: unexpected property %q", path, k)
+				}
+				continue
+			}
+			validateSchema(t, path+"."+k, sub, val)
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			t.Errorf("%s: expected array, got %T", path, v)
+			return
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range arr {
+			validateSchema(t, fmt.Sprintf("%s[%d]", path, i), items, item)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			t.Errorf("%s: expected string, got %T", path, v)
+			return
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				t.Errorf("%s: invalid date-time %q", path, s)
+			}
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			t.Errorf("%s: expected integer, got %v", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s: expected boolean, got %T", path, v)
+		}
+	}
+}
+
+func TestJSONSchemaMatchesGeneratorOutput(t *testing.T) {
+	raw, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema() error: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if schema["$schema"] != SchemaDraft {
+		t.Errorf("expected $schema %q, got %v", SchemaDraft, schema["$schema"])
+	}
+
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(testResults, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var report any
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	validateSchema(t, "$", schema, report)
+}
+
+func TestWriteAll(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bundle")
+
+	written, err := WriteAll(testResults, dir, Options{})
+	if err != nil {
+		t.Fatalf("WriteAll() error: %v", err)
+	}
+	if len(written) != 3 {
+		t.Fatalf("expected 3 files, got %v", written)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jr JSONReport
+	if err := json.Unmarshal(data, &jr); err != nil {
+		t.Fatalf("report.json did not parse: %v", err)
+	}
+	if len(jr.Roles) != len(testResults) {
+		t.Errorf("report.json: expected %d roles, got %d", len(testResults), len(jr.Roles))
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "report.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var yr JSONReport
+	if err := yaml.Unmarshal(data, &yr); err != nil {
+		t.Fatalf("report.yaml did not parse: %v", err)
+	}
+	if len(yr.Roles) != len(testResults) {
+		t.Errorf("report.yaml: expected %d roles, got %d", len(testResults), len(yr.Roles))
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `resource "aws_iam_policy"`) {
+		t.Error("main.tf: expected an aws_iam_policy resource")
+	}
+}
+
+func TestWriteRiskBreakdown(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:   "arn:aws:iam::123456789012:role/WebServer",
+		RiskLevel: "HIGH",
+		Unused:    []string{"s3:GetObject", "s3:DeleteBucket", "s3:PutObject"},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteRiskBreakdown(&buf, results); err != nil {
+		t.Fatalf("WriteRiskBreakdown() error: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{"HIGH     s3:DeleteBucket", "MEDIUM   s3:PutObject", "LOW      s3:GetObject"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in breakdown, got:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "s3:DeleteBucket") > strings.Index(out, "s3:GetObject") {
+		t.Errorf("expected HIGH privileges listed before LOW, got:\n%s", out)
+	}
+}
+
+// fixedGenerator writes the same bytes on every call, so compressed and
+// uncompressed runs can be compared without the generated-at timestamp.
+type fixedGenerator struct{ out []byte }
+
+func (f fixedGenerator) Generate(_ []correlation.Result, w io.Writer) error {
+	_, err := w.Write(f.out)
+	return err
+}
+
+func TestCompressedGzipRoundTrips(t *testing.T) {
+	var plain bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(testResults, &plain); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	g, err := Compressed(fixedGenerator{plain.Bytes()}, CompressGzip)
+	if err != nil {
+		t.Fatalf("Compressed() error: %v", err)
+	}
+	var compressed bytes.Buffer
+	if err := g.Generate(testResults, &compressed); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&compressed)
+	if err != nil {
+		t.Fatalf("output is not gzip: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompressing: %v", err)
+	}
+	if !bytes.Equal(got, plain.Bytes()) {
+		t.Errorf("decompressed output differs from uncompressed generator output")
+	}
+}
+
+func TestCompressionForPath(t *testing.T) {
+	tests := []struct {
+		path, requested string
+		wantComp        string
+		wantPath        string
+	}{
+		{"report.json", "", CompressNone, "report.json"},
+		{"report.json.gz", "", CompressGzip, "report.json.gz"},
+		{"report.json", CompressGzip, CompressGzip, "report.json.gz"},
+		{"report.json.gz", CompressGzip, CompressGzip, "report.json.gz"},
+		{"report.json.gz", CompressNone, CompressNone, "report.json.gz"},
+	}
+	for _, tt := range tests {
+		comp, path := CompressionForPath(tt.path, tt.requested)
+		if comp != tt.wantComp || path != tt.wantPath {
+			t.Errorf("CompressionForPath(%q, %q) = %q, %q; want %q, %q", tt.path, tt.requested, comp, path, tt.wantComp, tt.wantPath)
+		}
+	}
+	if _, err := Compressed(&JSONGenerator{}, "zstd"); err == nil {
+		t.Error("expected error for unknown compression")
+	}
+}
+
+func TestSummaryMatchesRoles(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(testResults, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse JSON output: %v", err)
+	}
+
+	var assigned, used, unused int
+	levels := map[string]int{}
+	for _, r := range report.Roles {
+		assigned += r.AssignedCount
+		used += r.UsedCount
+		unused += r.UnusedCount
+		levels[r.RiskLevel]++
+	}
+	s := report.Summary
+	if s.TotalRoles != len(report.Roles) || s.AssignedCount != assigned || s.UsedCount != used || s.UnusedCount != unused {
+		t.Errorf("summary %+v does not match per-role totals %d/%d/%d over %d roles",
+			s, assigned, used, unused, len(report.Roles))
+	}
+	// 2 of 4 assigned privileges are unused.
+	if s.PercentUnused != 50 {
+		t.Errorf("PercentUnused = %v, want 50", s.PercentUnused)
+	}
+	for level, n := range levels {
+		if s.RiskLevels[level] != n {
+			t.Errorf("RiskLevels[%s] = %d, want %d", level, s.RiskLevels[level], n)
+		}
+	}
+
+	var footer bytes.Buffer
+	if err := WriteSummary(&footer, s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(footer.String(), "50.0% unused") || !strings.Contains(footer.String(), "MEDIUM: 1  LOW: 1") {
+		t.Errorf("unexpected footer:\n%s", footer.String())
+	}
+}
+
+func TestWriteWidelyUnused(t *testing.T) {
+	spreads := []correlation.PrivilegeSpread{
+		{Privilege: "s3:GetObject", Risk: correlation.RiskLow, Roles: 200},
+		{Privilege: "iam:PassRole", Risk: correlation.RiskMedium, Roles: 12},
+		{Privilege: "s3:DeleteObject", Risk: correlation.RiskHigh, Roles: 1},
+	}
+	var buf bytes.Buffer
+	if err := WriteWidelyUnused(&buf, spreads, 2); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "200 roles  s3:GetObject") || !strings.Contains(out, "iam:PassRole") {
+		t.Errorf("missing top privileges:\n%s", out)
+	}
+	if strings.Contains(out, "s3:DeleteObject") {
+		t.Errorf("expected only the top 2 privileges:\n%s", out)
+	}
+}
+
+func TestJSONGenerator_RedactedAccounts(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(correlation.RedactAccounts(testResults), &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if strings.Contains(buf.String(), "123456789012") {
+		t.Errorf("account ID leaked into output:\n%s", buf.String())
+	}
+
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse JSON output: %v", err)
+	}
+	if got := report.Roles[0].IAMRole; got != "arn:aws:iam::XXXXXXXXXXXX:role/MyRole" {
+		t.Errorf("IAMRole = %q, want the ARN with only its account masked", got)
+	}
+}
+
+func TestJSONGenerator_Trust(t *testing.T) {
+	results := []correlation.Result{testResults[0], testResults[1]}
+	results[0].Trust = correlation.TrustAnalysis{
+		Principals: []string{"*"},
+		RiskLevel:  correlation.RiskHigh,
+		Wildcard:   true,
+	}
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	raw, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema, doc map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	validateSchema(t, "$", schema, doc)
+
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if tr := report.Roles[0].Trust; tr == nil || tr.RiskLevel != "HIGH" || !tr.Wildcard {
+		t.Errorf("expected HIGH wildcard trust, got %+v", tr)
+	}
+	if report.Roles[1].Trust != nil {
+		t.Errorf("unanalyzed trust should be omitted, got %+v", report.Roles[1].Trust)
+	}
+}
+
+func TestJSONGenerator_ExcessObserved(t *testing.T) {
+	results := []correlation.Result{testResults[0], testResults[1]}
+	results[0].ExcessObserved = []string{"s3:GetObject"}
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Roles[0].ExcessObserved; len(got) != 1 || got[0] != "s3:GetObject" {
+		t.Errorf("expected s3:GetObject as excess observed, got %v", got)
+	}
+	if strings.Count(buf.String(), `"excess_observed"`) != 1 {
+		t.Errorf("excess_observed should be omitted for roles without any:\n%s", buf.String())
+	}
+}
+
+func TestJSONGenerator_ResourceConstrained(t *testing.T) {
+	results := []correlation.Result{testResults[0]}
+	results[0].ResourceConstrained = []string{"s3:PutObject"}
+	var buf bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &buf); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	var report JSONReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	role := report.Roles[0]
+	if got := role.ResourceConstrainedPrivileges; len(got) != 1 || got[0] != "s3:PutObject" {
+		t.Errorf("resource_constrained_privileges = %v, want [s3:PutObject]", got)
+	}
+	for _, rec := range role.Recommendations {
+		if want := rec.Privilege == "s3:PutObject"; rec.ResourceConstrained != want {
+			t.Errorf("%s resource_constrained = %t, want %t", rec.Privilege, rec.ResourceConstrained, want)
+		}
+	}
+}
+
+func TestTerraformFromReadReport(t *testing.T) {
+	results := []correlation.Result{{
+		IAMRole:    "arn:aws:iam::123456789012:role/MyRole",
+		Assigned:   []string{"s3:GetObject", "s3:DeleteBucket"},
+		Used:       []string{"s3:GetObject"},
+		Unused:     []string{"s3:DeleteBucket"},
+		RiskLevel:  "HIGH",
+		Sources:    map[string]correlation.PrivilegeSource{"s3:DeleteBucket": correlation.SourceInline},
+		PolicyARNs: []string{"arn:aws:iam::123456789012:policy/AppPolicy"},
+		Resources:  map[string][]string{"s3:GetObject": {"arn:aws:s3:::app-bucket/reports/q3.csv"}},
+	}}
+	var report bytes.Buffer
+	if err := (&JSONGenerator{}).Generate(results, &report); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "results.json")
+	if err := os.WriteFile(path, report.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadReportFile(path)
+	if err != nil {
+		t.Fatalf("ReadReportFile() error: %v", err)
+	}
+	if len(read) != 1 || read[0].IAMRole != results[0].IAMRole || read[0].Sources["s3:DeleteBucket"] != correlation.SourceInline {
+		t.Fatalf("unexpected results read back: %+v", read)
+	}
+	if !reflect.DeepEqual(read[0].PolicyARNs, results[0].PolicyARNs) {
+		t.Errorf("PolicyARNs = %v, want %v", read[0].PolicyARNs, results[0].PolicyARNs)
+	}
+	if !reflect.DeepEqual(read[0].Resources, results[0].Resources) {
+		t.Errorf("Resources = %v, want %v", read[0].Resources, results[0].Resources)
+	}
+
+	var buf bytes.Buffer
+	if err := (&TerraformGenerator{}).Generate(read, &buf); err != nil {
+		t.Fatalf("Generate() from report error: %v", err)
+	}
+	output := buf.String()
+	for _, want := range []string{`resource "aws_iam_policy"`, `"s3:GetObject"`, "Assigned: 2 | Used: 1 | Unused: 1", `"arn:aws:s3:::app-bucket/reports/q3.csv"`, `"AppPolicy"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in Terraform output:\n%s", want, output)
+		}
+	}
+}
+
+func TestRemoveListGenerator(t *testing.T) {
+	results := []correlation.Result{
+		{
+			IAMRole: "arn:aws:iam::123456789012:role/App",
+			Used:    []string{"s3:GetObject"},
+			Unused:  []string{"iam:PassRole", "kms:Decrypt", "s3:DeleteObject"},
+			Sources: map[string]correlation.PrivilegeSource{
+				"iam:PassRole":    correlation.SourceManaged,
+				"kms:Decrypt":     correlation.SourceInline,
+				"s3:DeleteObject": correlation.SourceInline,
+			},
+			RiskLevel: "HIGH",
+		},
+		{IAMRole: "arn:aws:iam::123456789012:role/Clean", Used: []string{"s3:GetObject"}, RiskLevel: "LOW"},
+		{
+			IAMRole:   "arn:aws:iam::123456789012:role/Broad",
+			Assigned:  []string{"s3:*", "S3:DeleteBucket", "sqs:Send*"},
+			Used:      []string{"s3:GetObject"},
+			Unused:    []string{"S3:DeleteBucket", "sqs:Send*"},
+			Sources:   map[string]correlation.PrivilegeSource{"S3:DeleteBucket": correlation.SourceInline, "sqs:Send*": correlation.SourceInline},
+			RiskLevel: "HIGH",
+		},
+		{IAMRole: "arn:aws:iam::123456789012:role/Quiet", Unused: []string{"sqs:SendMessage"}, RiskLevel: "MEDIUM"},
+		{IAMRole: "arn:aws:iam::123456789012:role/aws-service-role/x/Linked", Used: []string{"ec2:DescribeInstances"}, Unused: []string{"ec2:RunInstances"}, ReadOnly: true},
+	}
+
+	var list bytes.Buffer
+	g := &RemoveListGenerator{NeverRemove: []string{"kms:*"}}
+	if err := g.Generate(results, &list); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	want := "# WARNING: arn:aws:iam::123456789012:role/Quiet skipped: no observed calls in the observation window; verify instrumentation before removing privileges\n\n" +
+		"# Role: arn:aws:iam::123456789012:role/App\n" +
+		"iam:PassRole\n" +
+		"s3:DeleteObject\n\n" +
+		"# Role: arn:aws:iam::123456789012:role/Broad\n" +
+		"# WARNING: still granted by a wildcard the role keeps: S3:DeleteBucket\n" +
+		"S3:DeleteBucket\n" +
+		"sqs:Send*\n\n"
+	if list.String() != want {
+		t.Errorf("list:\n%s\nwant:\n%s", list.String(), want)
+	}
+
+	var report struct {
+		Roles []struct {
+			Role         string   `json:"role"`
+			Remove       []string `json:"remove"`
+			StillGranted []string `json:"still_granted"`
+		} `json:"roles"`
+		Skipped []struct {
+			Role string `json:"role"`
+		} `json:"skipped"`
+	}
+	var js bytes.Buffer
+	if err := (&RemoveListGenerator{Style: RemoveStyleJSON}).Generate(results, &js); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if err := json.Unmarshal(js.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, js.String())
+	}
+	if len(report.Roles) != 2 || !reflect.DeepEqual(report.Roles[0].Remove, []string{"iam:PassRole", "kms:Decrypt", "s3:DeleteObject"}) {
+		t.Errorf("roles = %+v, want App with exactly its unused privileges", report.Roles)
+	}
+	if len(report.Roles) == 2 && !reflect.DeepEqual(report.Roles[1].StillGranted, []string{"S3:DeleteBucket"}) {
+		t.Errorf("still granted = %v, want the privilege s3:* keeps granting", report.Roles[1].StillGranted)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Role != "arn:aws:iam::123456789012:role/Quiet" {
+		t.Errorf("skipped = %+v, want the unobserved role", report.Skipped)
+	}
+
+	var script bytes.Buffer
+	if err := (&RemoveListGenerator{Style: RemoveStyleAWSCLI}).Generate(results, &script); err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	for _, line := range []string{
+		"strip_inline 'App' 'iam:PassRole' 'kms:Decrypt' 's3:DeleteObject'\n",
+		"# or replace it (see 'generate terraform'): iam:PassRole\n",
+		"# not narrow: S3:DeleteBucket\n",
+	} {
+		if !strings.Contains(script.String(), line) {
+			t.Errorf("script missing %q:\n%s", line, script.String())
+		}
+	}
+
+	if _, err := NewWithOptions("remove-list", Options{RemoveStyle: "patch"}); err == nil {
+		t.Error("expected an unknown remove-list style to be rejected")
+	}
+}
+
+func TestReadReportRejectsOtherJSON(t *testing.T) {
+	if _, err := ReadReport(strings.NewReader(`{"roles": [{"risk_level": "LOW"}]}`)); err == nil {
+		t.Error("expected a role without iam_role to be rejected")
+	}
+	if _, err := ReadReport(strings.NewReader(`[1, 2]`)); err == nil {
+		t.Error("expected a non-report document to be rejected")
+	}
+}
@@ -2,43 +2,166 @@ package generator
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
 	"time"
 
 	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
 
 // JSONReport is the top-level structure for JSON output.
 type JSONReport struct {
-	GeneratedAt time.Time   `json:"generated_at" yaml:"generated_at"`
-	Roles       []JSONRole  `json:"roles"        yaml:"roles"`
+	GeneratedAt time.Time  `json:"generated_at" yaml:"generated_at"`
+	Roles       []JSONRole `json:"roles"        yaml:"roles"`
+	Summary     Summary    `json:"summary"      yaml:"summary"`
 }
 
 // JSONRole holds the analysis for a single IAM role.
 type JSONRole struct {
-	IAMRole           string   `json:"iam_role"            yaml:"iam_role"`
-	RiskLevel         string   `json:"risk_level"          yaml:"risk_level"`
-	AssignedCount     int      `json:"assigned_count"      yaml:"assigned_count"`
-	UsedCount         int      `json:"used_count"          yaml:"used_count"`
-	UnusedCount       int      `json:"unused_count"        yaml:"unused_count"`
+	IAMRole            string   `json:"iam_role"            yaml:"iam_role"`
+	RiskLevel          string   `json:"risk_level"          yaml:"risk_level"`
+	AssignedCount      int      `json:"assigned_count"      yaml:"assigned_count"`
+	UsedCount          int      `json:"used_count"          yaml:"used_count"`
+	UnusedCount        int      `json:"unused_count"        yaml:"unused_count"`
 	AssignedPrivileges []string `json:"assigned_privileges" yaml:"assigned_privileges"`
-	UsedPrivileges    []string `json:"used_privileges"     yaml:"used_privileges"`
-	UnusedPrivileges  []string `json:"unused_privileges"   yaml:"unused_privileges"`
+	UsedPrivileges     []string `json:"used_privileges"     yaml:"used_privileges"`
+	UnusedPrivileges   []string `json:"unused_privileges"   yaml:"unused_privileges"`
+	// Used is UsedPrivileges with how much and how recently each was used.
+	// Present only with verbose usage; privileges credited through a shared
+	// policy rather than observed are left out.
+	Used            []JSONUsage          `json:"used,omitempty" yaml:"used,omitempty"`
+	Recommendations []JSONRecommendation `json:"recommendations" yaml:"recommendations"`
+	// SuppressedPrivileges are unused but granted only by intentionally
+	// annotated statements, so they are not counted as unused.
+	SuppressedPrivileges []string `json:"suppressed_privileges,omitempty" yaml:"suppressed_privileges,omitempty"`
+	// ResourceConstrainedPrivileges are assigned privileges granted only on
+	// specific resources, narrower than their action alone suggests.
+	ResourceConstrainedPrivileges []string `json:"resource_constrained_privileges,omitempty" yaml:"resource_constrained_privileges,omitempty"`
+	// ReadOnly marks a service-linked role; its findings are informational.
+	ReadOnly bool `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+	// ExcessObserved are privileges the role was observed using that it is
+	// not assigned, a sign of mis-attributed spans or a removed policy.
+	ExcessObserved []string `json:"excess_observed,omitempty" yaml:"excess_observed,omitempty"`
+	// Regressed are the ExcessObserved privileges used so recently that a
+	// policy change likely just removed them from a live workload.
+	Regressed []string `json:"regressed,omitempty" yaml:"regressed,omitempty"`
+	// ServiceCoverage maps each service of the assigned privileges to
+	// whether any of its actions was observed. Unused findings in an
+	// uncovered service are unreliable.
+	ServiceCoverage map[string]bool `json:"service_coverage,omitempty" yaml:"service_coverage,omitempty"`
+	// SessionPolicyShare is the share of the role's observed calls made in
+	// sessions scoped by a session policy. From
+	// correlation.FrequentSessionPolicyShare, unused findings may overstate
+	// what the role's sessions were actually granted.
+	SessionPolicyShare float64 `json:"session_policy_share,omitempty" yaml:"session_policy_share,omitempty"`
+	// Trust is who can assume the role, a risk axis separate from
+	// RiskLevel. Absent when the trust policy was not analyzed.
+	Trust *JSONTrust `json:"trust,omitempty" yaml:"trust,omitempty"`
+	// Owner is who owns the role, for routing its findings. Absent when no
+	// owner is known.
+	Owner *ownership.Owner `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// PolicyARNs are the managed policies attached to the role.
+	PolicyARNs []string `json:"policy_arns,omitempty" yaml:"policy_arns,omitempty"`
+	// Resources maps a used privilege to the resources it was observed on.
+	Resources map[string][]string `json:"resources,omitempty" yaml:"resources,omitempty"`
+}
+
+// JSONUsage is the observed use of one privilege.
+type JSONUsage struct {
+	Privilege string    `json:"privilege" yaml:"privilege"`
+	Count     int       `json:"count"     yaml:"count"`
+	LastSeen  time.Time `json:"last_seen" yaml:"last_seen"`
+	// SessionScoped is how many of the calls were made in sessions scoped
+	// by a session policy.
+	SessionScoped int `json:"session_scoped,omitempty" yaml:"session_scoped,omitempty"`
+}
+
+// JSONTrust is the trust-policy analysis of one role.
+type JSONTrust struct {
+	RiskLevel          string   `json:"risk_level"                    yaml:"risk_level"`
+	Principals         []string `json:"principals"                    yaml:"principals"`
+	Wildcard           bool     `json:"wildcard,omitempty"            yaml:"wildcard,omitempty"`
+	CrossAccount       []string `json:"cross_account,omitempty"       yaml:"cross_account,omitempty"`
+	UnexpectedServices []string `json:"unexpected_services,omitempty" yaml:"unexpected_services,omitempty"`
+}
+
+// JSONRecommendation is the suggested action for one unused privilege.
+// Recommendations are ordered HIGH risk first, so they read as a work queue.
+type JSONRecommendation struct {
+	Privilege      string `json:"privilege"          yaml:"privilege"`
+	RiskLevel      string `json:"risk_level"         yaml:"risk_level"`
+	Source         string `json:"source,omitempty"   yaml:"source,omitempty"`
+	Recommendation string `json:"recommendation"     yaml:"recommendation"`
+	// ResourceConstrained marks a privilege granted only on specific
+	// resources: assigned but resource-scoped.
+	ResourceConstrained bool `json:"resource_constrained,omitempty" yaml:"resource_constrained,omitempty"`
 }
 
 // JSONGenerator produces JSON-formatted reports.
-type JSONGenerator struct{}
+type JSONGenerator struct {
+	// VerboseUsage adds the call count and last observation of each used
+	// privilege.
+	VerboseUsage bool
+}
 
 // Generate writes a JSON report to w.
 func (g *JSONGenerator) Generate(results []correlation.Result, w io.Writer) error {
-	report := buildReport(results)
+	report := buildReport(results, g.VerboseUsage)
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	return enc.Encode(report)
 }
 
-// buildReport converts correlation results into a JSONReport.
-func buildReport(results []correlation.Result) JSONReport {
+// recommendations returns the suggested action for each unused privilege of
+// r. A role with no observed usage gets "verify instrumentation" throughout,
+// since its findings may only mean its traces never arrived. Service-linked
+// roles cannot be changed, so nothing is recommended for them.
+func recommendations(r correlation.Result) []JSONRecommendation {
+	unobserved := len(r.Used) == 0
+	constrained := make(map[string]bool, len(r.ResourceConstrained))
+	for _, p := range r.ResourceConstrained {
+		constrained[p] = true
+	}
+	out := make([]JSONRecommendation, 0, len(r.Unused))
+	for _, p := range correlation.UnusedByRisk(r) {
+		source := r.Sources[p.Privilege]
+		basis := source
+		if unobserved {
+			basis = correlation.SourceUnobserved
+		}
+		rec := correlation.Recommend(p.Privilege, p.Risk, basis)
+		if r.ReadOnly {
+			rec = correlation.RecommendNone
+		}
+		out = append(out, JSONRecommendation{
+			Privilege:           p.Privilege,
+			RiskLevel:           string(p.Risk),
+			Source:              string(source),
+			Recommendation:      rec,
+			ResourceConstrained: constrained[p.Privilege],
+		})
+	}
+	return out
+}
+
+// usage returns the observed use of each used privilege of r, in the order
+// of r.Used.
+func usage(r correlation.Result) []JSONUsage {
+	out := make([]JSONUsage, 0, len(r.Usage))
+	for _, p := range r.Used {
+		if u, ok := r.Usage[p]; ok {
+			out = append(out, JSONUsage{Privilege: p, Count: u.CallCount, LastSeen: u.LastSeen, SessionScoped: u.SessionScopedCalls})
+		}
+	}
+	return out
+}
+
+// buildReport converts correlation results into a JSONReport, with the usage
+// of each used privilege when verboseUsage is set.
+func buildReport(results []correlation.Result, verboseUsage bool) JSONReport {
 	roles := make([]JSONRole, 0, len(results))
 	for _, r := range results {
 		role := JSONRole{
@@ -60,10 +183,123 @@ func buildReport(results []correlation.Result) JSONReport {
 		if role.UnusedPrivileges == nil {
 			role.UnusedPrivileges = []string{}
 		}
+		if verboseUsage {
+			role.Used = usage(r)
+		}
+		role.Recommendations = recommendations(r)
+		role.SuppressedPrivileges = r.Suppressed
+		role.ResourceConstrainedPrivileges = r.ResourceConstrained
+		role.ReadOnly = r.ReadOnly
+		role.ExcessObserved = r.ExcessObserved
+		role.Regressed = r.Regressed
+		role.ServiceCoverage = r.ServiceCoverage
+		role.SessionPolicyShare = r.SessionPolicyShare
+		role.PolicyARNs = r.PolicyARNs
+		role.Resources = r.Resources
+		if r.Trust.RiskLevel != "" {
+			role.Trust = &JSONTrust{
+				RiskLevel:          string(r.Trust.RiskLevel),
+				Principals:         r.Trust.Principals,
+				Wildcard:           r.Trust.Wildcard,
+				CrossAccount:       r.Trust.CrossAccount,
+				UnexpectedServices: r.Trust.UnexpectedServices,
+			}
+			if role.Trust.Principals == nil {
+				role.Trust.Principals = []string{}
+			}
+		}
+		if !r.Owner.IsZero() {
+			owner := r.Owner
+			role.Owner = &owner
+		}
 		roles = append(roles, role)
 	}
 	return JSONReport{
 		GeneratedAt: time.Now(),
 		Roles:       roles,
+		Summary:     Summarize(results),
+	}
+}
+
+// ReadReport parses a report written by JSONGenerator back into correlation
+// results, so output can be generated from a report artifact without the
+// database. What the report does not carry, such as where each used
+// privilege was granted, is left empty.
+func ReadReport(r io.Reader) ([]correlation.Result, error) {
+	var report JSONReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	results := make([]correlation.Result, 0, len(report.Roles))
+	for _, role := range report.Roles {
+		if role.IAMRole == "" {
+			return nil, fmt.Errorf("role %d has no iam_role", len(results))
+		}
+		r := correlation.Result{
+			IAMRole:             role.IAMRole,
+			Assigned:            role.AssignedPrivileges,
+			Used:                role.UsedPrivileges,
+			Unused:              role.UnusedPrivileges,
+			RiskLevel:           role.RiskLevel,
+			AnalyzedAt:          report.GeneratedAt,
+			Suppressed:          role.SuppressedPrivileges,
+			ResourceConstrained: role.ResourceConstrainedPrivileges,
+			ReadOnly:            role.ReadOnly,
+			ExcessObserved:      role.ExcessObserved,
+			Regressed:           role.Regressed,
+			ServiceCoverage:     role.ServiceCoverage,
+			SessionPolicyShare:  role.SessionPolicyShare,
+			PolicyARNs:          role.PolicyARNs,
+			Resources:           role.Resources,
+		}
+		for _, u := range role.Used {
+			if r.Usage == nil {
+				r.Usage = map[string]storage.PrivilegeUsage{}
+			}
+			r.Usage[u.Privilege] = storage.PrivilegeUsage{CallCount: u.Count, LastSeen: u.LastSeen, SessionScopedCalls: u.SessionScoped}
+		}
+		for _, rec := range role.Recommendations {
+			if rec.RiskLevel != "" {
+				if r.UnusedRisks == nil {
+					r.UnusedRisks = map[string]correlation.RiskLevel{}
+				}
+				r.UnusedRisks[rec.Privilege] = correlation.RiskLevel(rec.RiskLevel)
+			}
+			if rec.Source == "" {
+				continue
+			}
+			if r.Sources == nil {
+				r.Sources = map[string]correlation.PrivilegeSource{}
+			}
+			r.Sources[rec.Privilege] = correlation.PrivilegeSource(rec.Source)
+		}
+		if role.Trust != nil {
+			r.Trust = correlation.TrustAnalysis{
+				Principals:         role.Trust.Principals,
+				RiskLevel:          correlation.RiskLevel(role.Trust.RiskLevel),
+				Wildcard:           role.Trust.Wildcard,
+				CrossAccount:       role.Trust.CrossAccount,
+				UnexpectedServices: role.Trust.UnexpectedServices,
+			}
+		}
+		if role.Owner != nil {
+			r.Owner = *role.Owner
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// ReadReportFile is ReadReport on the file at path.
+func ReadReportFile(path string) ([]correlation.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening report: %w", err)
+	}
+	defer f.Close()
+	results, err := ReadReport(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing report %s (expected the output of 'generate json'): %w", path, err)
 	}
+	return results, nil
 }
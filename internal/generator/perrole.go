@@ -0,0 +1,83 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// fileExtensions maps an output format to the extension used for its files.
+var fileExtensions = map[string]string{
+	"terraform": ".tf",
+	"tf-json":   ".tf.json",
+	"json":      ".json",
+	"yaml":      ".yaml",
+}
+
+// WritePerRole writes one file per role into dir (creating it if needed),
+// named after terraformResourceName(role) plus the extension for format.
+// Roles with no unused privileges are skipped unless includeClean is set, in
+// which case they get the generator's "nothing to do" output as a stub.
+// It returns the paths of the files written.
+func WritePerRole(g Generator, format string, results []correlation.Result, dir string, includeClean bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	var written []string
+	for _, r := range results {
+		if len(r.Unused) == 0 && !includeClean {
+			continue
+		}
+		path := filepath.Join(dir, terraformResourceName(r.IAMRole)+fileExtensions[format])
+		if err := writeFile(path, g, []correlation.Result{r}); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// bundleFiles names the file each format is written to by WriteAll.
+var bundleFiles = []struct{ format, name string }{
+	{"json", "report.json"},
+	{"yaml", "report.yaml"},
+	{"terraform", "main.tf"},
+}
+
+// WriteAll runs every supported generator over the same results and writes
+// report.json, report.yaml and main.tf into dir (creating it if needed).
+// It returns the paths of the files written.
+func WriteAll(results []correlation.Result, dir string, opts Options) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	var written []string
+	for _, b := range bundleFiles {
+		g, err := NewWithOptions(b.format, opts)
+		if err != nil {
+			return written, err
+		}
+		path := filepath.Join(dir, b.name)
+		if err := writeFile(path, g, results); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+func writeFile(path string, g Generator, results []correlation.Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	if err := g.Generate(results, f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
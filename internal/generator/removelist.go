@@ -0,0 +1,209 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+)
+
+// Remove-list styles, selected by Options.RemoveStyle.
+const (
+	// RemoveStyleList writes each role's privileges to remove, one per line
+	// under a "# Role:" comment.
+	RemoveStyleList = "list"
+	// RemoveStyleAWSCLI writes a shell script stripping the privileges from
+	// each role's inline policies with the aws CLI and jq.
+	RemoveStyleAWSCLI = "aws-cli"
+	// RemoveStyleJSON writes {"roles": [{"role", "remove"}], "skipped": [...]}.
+	RemoveStyleJSON = "json"
+)
+
+// RemoveListGenerator writes only the delta to apply: for each role, the
+// unused privileges to strip, for remediation tooling that edits policies
+// surgically rather than replacing them. Roles with nothing to remove are
+// left out, as are roles with no observed calls, which are only warned
+// about since their findings may reflect missing instrumentation.
+type RemoveListGenerator struct {
+	// Style is one of the RemoveStyle constants; empty means
+	// RemoveStyleList.
+	Style string
+	// NeverRemove are privileges, or patterns such as "kms:*", never listed
+	// for removal.
+	NeverRemove []string
+}
+
+// removal is one role's entry in a remove list.
+type removal struct {
+	Role   string   `json:"role"`
+	Remove []string `json:"remove"`
+	// StillGranted are the privileges in Remove that a wildcard the role
+	// keeps, such as "s3:*", also grants: removing them alone does not take
+	// them away.
+	StillGranted []string `json:"still_granted,omitempty"`
+	// managed are the privileges in Remove also granted by a managed
+	// policy, which stripping inline policies does not take away.
+	managed []string
+}
+
+// skippedRemoval is a role left out of a remove list, and why.
+type skippedRemoval struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
+
+// removals splits results into the roles with privileges to remove and the
+// unobserved roles skipped with a warning.
+func (g *RemoveListGenerator) removals(results []correlation.Result) ([]removal, []skippedRemoval) {
+	var out []removal
+	var skipped []skippedRemoval
+	for _, r := range results {
+		if len(r.Unused) > 0 && len(r.Used) == 0 && !r.ReadOnly {
+			skipped = append(skipped, skippedRemoval{
+				Role:   r.IAMRole,
+				Reason: "no observed calls in the observation window; verify instrumentation before removing privileges",
+			})
+			continue
+		}
+		if !generatesPolicy(r) {
+			continue
+		}
+		rm := removal{Role: r.IAMRole}
+		for _, u := range r.Unused {
+			if correlation.MatchesAny(g.NeverRemove, u) {
+				continue
+			}
+			rm.Remove = append(rm.Remove, u)
+			if r.Sources[u] == correlation.SourceManaged {
+				rm.managed = append(rm.managed, u)
+			}
+		}
+		kept := keptWildcards(r.Assigned, rm.Remove)
+		for _, p := range rm.Remove {
+			if correlation.MatchesAny(kept, p) {
+				rm.StillGranted = append(rm.StillGranted, p)
+			}
+		}
+		if len(rm.Remove) > 0 {
+			out = append(out, rm)
+		}
+	}
+	return out, skipped
+}
+
+// keptWildcards returns the wildcard patterns in assigned that remove does
+// not list, ignoring case.
+func keptWildcards(assigned, remove []string) []string {
+	removed := make(map[string]bool, len(remove))
+	for _, p := range remove {
+		removed[strings.ToLower(p)] = true
+	}
+	var kept []string
+	for _, a := range assigned {
+		if strings.Contains(a, "*") && !removed[strings.ToLower(a)] {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// Generate writes the remove list for results in g's style.
+func (g *RemoveListGenerator) Generate(results []correlation.Result, w io.Writer) error {
+	removals, skipped := g.removals(results)
+	switch g.Style {
+	case "", RemoveStyleList:
+		return writeRemoveList(w, removals, skipped)
+	case RemoveStyleAWSCLI:
+		return writeRemoveScript(w, removals, skipped)
+	case RemoveStyleJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Roles   []removal        `json:"roles"`
+			Skipped []skippedRemoval `json:"skipped,omitempty"`
+		}{append([]removal{}, removals...), skipped})
+	default:
+		return fmt.Errorf("unknown remove-list style %q (supported: %s, %s, %s)", g.Style, RemoveStyleList, RemoveStyleAWSCLI, RemoveStyleJSON)
+	}
+}
+
+func writeRemoveList(w io.Writer, removals []removal, skipped []skippedRemoval) error {
+	for _, s := range skipped {
+		fmt.Fprintf(w, "# WARNING: %s skipped: %s\n", s.Role, s.Reason)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, rm := range removals {
+		fmt.Fprintf(w, "# Role: %s\n", rm.Role)
+		if len(rm.StillGranted) > 0 {
+			fmt.Fprintf(w, "# WARNING: still granted by a wildcard the role keeps: %s\n", strings.Join(rm.StillGranted, ", "))
+		}
+		for _, p := range rm.Remove {
+			fmt.Fprintln(w, p)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// stripInlineFunc is the shell function the aws-cli script strips a role's
+// inline policies with: it drops the given actions from every Allow
+// statement, ignoring case as IAM does, and statements left without
+// actions, then puts each policy back.
+const stripInlineFunc = `strip_inline() {
+  role=$1; shift
+  drop=$(printf '%s\n' "$@" | jq -R 'ascii_downcase' | jq -s .)
+  for policy in $(aws iam list-role-policies --role-name "$role" --query 'PolicyNames[]' --output text); do
+    doc=$(aws iam get-role-policy --role-name "$role" --policy-name "$policy" --query PolicyDocument --output json)
+    new=$(printf '%s' "$doc" | jq -c --argjson drop "$drop" '
+      def arr: if type == "array" then . else [.] end;
+      .Statement |= (arr
+        | map(if .Effect == "Allow" and has("Action")
+            then .Action |= (arr | map(ascii_downcase as $a | select(any($drop[]; . == $a) | not)))
+            else . end)
+        | map(select(.Effect != "Allow" or (has("Action") | not) or (.Action | length) > 0)))')
+    if [ "$new" != "$(printf '%s' "$doc" | jq -c .)" ]; then
+      aws iam put-role-policy --role-name "$role" --policy-name "$policy" --policy-document "$new"
+    fi
+  done
+}
+`
+
+func writeRemoveScript(w io.Writer, removals []removal, skipped []skippedRemoval) error {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Generated by shinkai-shoujo on %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "# Removes unused privileges from each role's inline policies. Needs the\n")
+	fmt.Fprintf(w, "# aws CLI and jq. Review carefully before running — NEVER auto-run.\n")
+	fmt.Fprintf(w, "set -eu\n\n")
+	io.WriteString(w, stripInlineFunc)
+	for _, s := range skipped {
+		fmt.Fprintf(w, "\n# WARNING: %s skipped: %s\n", s.Role, s.Reason)
+	}
+	for _, rm := range removals {
+		fmt.Fprintf(w, "\n# Role: %s\n", rm.Role)
+		if len(rm.managed) > 0 {
+			fmt.Fprintf(w, "# Also granted by a managed policy, which this does not change; detach\n")
+			fmt.Fprintf(w, "# or replace it (see 'generate terraform'): %s\n", strings.Join(rm.managed, ", "))
+		}
+		if len(rm.StillGranted) > 0 {
+			fmt.Fprintf(w, "# WARNING: still granted by a wildcard the role keeps, which this does\n")
+			fmt.Fprintf(w, "# not narrow: %s\n", strings.Join(rm.StillGranted, ", "))
+		}
+		fmt.Fprintf(w, "strip_inline %s", shellQuote(rolearn.Parse(rm.Role).Name))
+		for _, p := range rm.Remove {
+			fmt.Fprintf(w, " %s", shellQuote(p))
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// shellQuote quotes s as one POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
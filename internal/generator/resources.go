@@ -0,0 +1,89 @@
+package generator
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/catalog"
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// policyStatement is one Allow statement of a generated policy. An empty
+// Resources means "*".
+type policyStatement struct {
+	Actions   []string
+	Resources []string
+}
+
+// policyStatements groups a role's used privileges, and the unused ones in
+// extra, by the set of resources they were observed on, so each statement is
+// scoped to exactly those resources. Privileges with no captured resource
+// share a single "*" statement, which comes first; the rest are ordered by
+// resource list.
+func policyStatements(r correlation.Result, extra []string) []policyStatement {
+	partition := "aws"
+	if a, err := arn.Parse(r.IAMRole); err == nil {
+		partition = a.Partition
+	}
+
+	byKey := make(map[string]*policyStatement)
+	var keys []string
+	// Intentional grants are kept even though they were never observed.
+	keep := append(append([]string(nil), r.Used...), r.Suppressed...)
+	keep = append(keep, extra...)
+	for _, p := range keep {
+		resources := normalizeResources(p, r.Resources[p], partition)
+		key := strings.Join(resources, "\n")
+		st, ok := byKey[key]
+		if !ok {
+			st = &policyStatement{Resources: resources}
+			byKey[key] = st
+			keys = append(keys, key)
+		}
+		st.Actions = append(st.Actions, p)
+	}
+	// "" (the wildcard statement) sorts first.
+	sort.Strings(keys)
+
+	statements := make([]policyStatement, 0, len(keys))
+	for _, k := range keys {
+		statements = append(statements, *byKey[k])
+	}
+	return statements
+}
+
+// normalizeResources converts observed resources into sorted, deduplicated
+// ARNs for privilege. Values that are already ARNs are kept as-is. A bare S3
+// bucket name (from the aws.s3.bucket span attribute) becomes the bucket ARN
+// for actions the catalog lists as acting on buckets, or its object ARN
+// "bucket/*" for those acting on objects. Other bare names cannot be turned
+// into ARNs and are dropped; if nothing is left the privilege falls back to
+// "*".
+func normalizeResources(privilege string, observed []string, partition string) []string {
+	service, _, _ := strings.Cut(privilege, ":")
+	resourceType, _ := catalog.ResourceType(privilege)
+	seen := make(map[string]bool, len(observed))
+	var out []string
+	for _, res := range observed {
+		res = strings.TrimSpace(res)
+		switch {
+		case res == "":
+			continue
+		case arn.IsARN(res):
+		case service == "s3" && resourceType == catalog.ResourceBucket:
+			res = "arn:" + partition + ":s3:::" + res
+		case service == "s3" && resourceType == catalog.ResourceObject:
+			res = "arn:" + partition + ":s3:::" + res + "/*"
+		default:
+			continue
+		}
+		if !seen[res] {
+			seen[res] = true
+			out = append(out, res)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// Summary is the account-level roll-up of a set of results: how much of
+// what is assigned goes unused, and how many roles sit at each risk level.
+type Summary struct {
+	TotalRoles    int `json:"total_roles"    yaml:"total_roles"`
+	AssignedCount int `json:"assigned_count" yaml:"assigned_count"`
+	UsedCount     int `json:"used_count"     yaml:"used_count"`
+	UnusedCount   int `json:"unused_count"   yaml:"unused_count"`
+	// PercentUnused is UnusedCount as a percentage of AssignedCount,
+	// rounded to one decimal place.
+	PercentUnused float64 `json:"percent_unused" yaml:"percent_unused"`
+	// RiskLevels counts roles by their risk level.
+	RiskLevels map[string]int `json:"risk_levels" yaml:"risk_levels"`
+}
+
+// summaryRiskOrder is the order WriteSummary lists risk levels in; levels
+// not listed here follow alphabetically.
+var summaryRiskOrder = []string{
+	string(correlation.RiskHigh),
+	string(correlation.RiskMedium),
+	string(correlation.RiskLow),
+	string(correlation.RiskNew),
+	string(correlation.RiskOrphaned),
+}
+
+// Summarize computes the account-level summary of results.
+func Summarize(results []correlation.Result) Summary {
+	s := Summary{
+		TotalRoles: len(results),
+		RiskLevels: make(map[string]int),
+	}
+	for _, r := range results {
+		s.AssignedCount += len(r.Assigned)
+		s.UsedCount += len(r.Used)
+		s.UnusedCount += len(r.Unused)
+		s.RiskLevels[r.RiskLevel]++
+	}
+	if s.AssignedCount > 0 {
+		s.PercentUnused = math.Round(float64(s.UnusedCount)*1000/float64(s.AssignedCount)) / 10
+	}
+	return s
+}
+
+// WriteSummary writes s as the two-line footer shown by 'report'.
+func WriteSummary(w io.Writer, s Summary) error {
+	if _, err := fmt.Fprintf(w, "Account: %d roles, %d assigned, %d used, %d unused (%.1f%% unused)\n",
+		s.TotalRoles, s.AssignedCount, s.UsedCount, s.UnusedCount, s.PercentUnused); err != nil {
+		return err
+	}
+
+	levels := append([]string(nil), summaryRiskOrder...)
+	var others []string
+	for level := range s.RiskLevels {
+		known := false
+		for _, l := range summaryRiskOrder {
+			known = known || l == level
+		}
+		if !known {
+			others = append(others, level)
+		}
+	}
+	sort.Strings(others)
+	levels = append(levels, others...)
+
+	parts := make([]string, 0, len(levels))
+	for _, level := range levels {
+		if n := s.RiskLevels[level]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", level, n))
+		}
+	}
+	_, err := fmt.Fprintf(w, "Roles by risk: %s\n", strings.Join(parts, "  "))
+	return err
+}
+
+// WriteWidelyUnused writes the n privileges left unused by the most roles,
+// the "most widely-unused privileges" section of 'report --top-unused'.
+func WriteWidelyUnused(w io.Writer, spreads []correlation.PrivilegeSpread, n int) error {
+	if n < len(spreads) {
+		spreads = spreads[:n]
+	}
+	if _, err := fmt.Fprintf(w, "Most widely-unused privileges:\n"); err != nil {
+		return err
+	}
+	if len(spreads) == 0 {
+		_, err := fmt.Fprintf(w, "  (no unused privileges)\n")
+		return err
+	}
+	for _, s := range spreads {
+		if _, err := fmt.Fprintf(w, "  %-8s %5d roles  %s\n", s.Risk, s.Roles, s.Privilege); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,32 +7,62 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+
 	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
 )
 
-var nonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+var nonAlnum = regexp.MustCompile(`[^a-z0-9]`)
 
 // TerraformGenerator produces Terraform HCL output for least-privilege policies.
-type TerraformGenerator struct{}
+type TerraformGenerator struct {
+	// PreventDestroy adds a lifecycle guard so `terraform destroy` cannot
+	// delete the managed policies.
+	PreventDestroy bool
+	// NeverRemove are privileges, or patterns such as "kms:*", kept in the
+	// generated policies even when unused.
+	NeverRemove []string
+}
 
 // Generate writes Terraform HCL to w, one resource per IAM role.
 func (g *TerraformGenerator) Generate(results []correlation.Result, w io.Writer) error {
 	fmt.Fprintf(w, "# Generated by shinkai-shoujo on %s\n", time.Now().Format(time.RFC3339))
 	fmt.Fprintf(w, "# Review carefully before applying — NEVER auto-apply.\n\n")
 
+	attachments := policyAttachments(results)
 	for _, r := range results {
-		name := terraformResourceName(r.IAMRole)
 		fmt.Fprintf(w, "# Role: %s\n", r.IAMRole)
+		if !r.Owner.IsZero() {
+			fmt.Fprintf(w, "# Owner: %s\n", r.Owner)
+		}
 		fmt.Fprintf(w, "# Risk level of unused privileges: %s\n", r.RiskLevel)
 		fmt.Fprintf(w, "# Assigned: %d | Used: %d | Unused: %d\n",
 			len(r.Assigned), len(r.Used), len(r.Unused))
 
 		switch {
+		case r.RiskLevel == string(correlation.RiskOrphaned):
+			// Role is still assumed in traces but no longer exists in IAM.
+			fmt.Fprintf(w, "# WARNING: Role was observed using %d privilege(s) but no longer\n", len(r.Used))
+			fmt.Fprintf(w, "# exists in IAM. It may have been deleted or recreated under a\n")
+			fmt.Fprintf(w, "# different ARN. No policy block generated.\n\n")
+			continue
+
+		case r.RiskLevel == string(correlation.RiskNew):
+			fmt.Fprintf(w, "# Role was created too recently to have exercised its privileges\n")
+			fmt.Fprintf(w, "# (correlation.new_role_grace_days). No changes recommended yet.\n\n")
+			continue
+
 		case len(r.Unused) == 0:
 			// All assigned privileges were observed — no changes needed.
 			fmt.Fprintf(w, "# No unused privileges detected for this role.\n\n")
 			continue
 
+		case r.ReadOnly:
+			// Service-linked roles are managed by AWS and cannot be modified.
+			fmt.Fprintf(w, "# INFO: Service-linked role managed by AWS. Reported for information\n")
+			fmt.Fprintf(w, "# only; it cannot be modified. No policy block generated.\n\n")
+			continue
+
 		case len(r.Used) == 0:
 			// Role has assigned privileges but was never observed making any call
 			// within the observation window. A policy with an empty Action list is
@@ -43,23 +73,65 @@ func (g *TerraformGenerator) Generate(results []correlation.Result, w io.Writer)
 			continue
 		}
 
-		fmt.Fprintf(w, `resource "aws_iam_policy" "%s_least_privilege" {`+"\n", name)
-		fmt.Fprintf(w, `  name        = "%s-least-privilege"`+"\n", name)
-		fmt.Fprintf(w, `  description = "Least-privilege policy for %s (shinkai-shoujo generated)"`+"\n", r.IAMRole)
+		for _, service := range correlation.UncoveredServices(r) {
+			fmt.Fprintf(w, "# WARNING: %s: no observations — unused findings unreliable.\n", service)
+		}
+		if correlation.SessionScoped(r) {
+			fmt.Fprintf(w, "# WARNING: %.0f%% of observed calls ran under session policies, which narrow\n", 100*r.SessionPolicyShare)
+			fmt.Fprintf(w, "# the role's grants; unused findings may overstate what sessions were granted.\n")
+		}
+
+		p := newLeastPrivilegePolicy(r, g.NeverRemove, attachments)
+		if len(p.Overridden) > 0 {
+			fmt.Fprintf(w, "# Kept although unused (manual override, never_remove): %s\n", strings.Join(p.Overridden, ", "))
+		}
+		switch {
+		case p.ImportID != "":
+			fmt.Fprintf(w, "import {\n")
+			fmt.Fprintf(w, "  to = aws_iam_policy.%s\n", p.Resource)
+			fmt.Fprintf(w, "  id = %q\n", p.ImportID)
+			fmt.Fprintf(w, "}\n\n")
+		case p.SharedBy > 1:
+			fmt.Fprintf(w, "# Customer-managed policy is attached to %d analyzed roles; no import block\n", p.SharedBy)
+			fmt.Fprintf(w, "# generated, since rewriting it for this role would strip the others' privileges.\n")
+		case p.ManagedPolicies > 1:
+			fmt.Fprintf(w, "# Role has %d customer-managed policies; no import block generated.\n", p.ManagedPolicies)
+			fmt.Fprintf(w, "# Import the one this policy should replace manually.\n")
+		}
+
+		fmt.Fprintf(w, `resource "aws_iam_policy" "%s" {`+"\n", p.Resource)
+		fmt.Fprintf(w, `  name        = "%s"`+"\n", p.Name)
+		fmt.Fprintf(w, `  description = "%s"`+"\n", p.Description)
 		fmt.Fprintf(w, "  policy = jsonencode({\n")
 		fmt.Fprintf(w, "    Version = \"2012-10-17\"\n")
 		fmt.Fprintf(w, "    Statement = [{\n")
-		fmt.Fprintf(w, "      Effect = \"Allow\"\n")
-		fmt.Fprintf(w, "      Action = [\n")
-
-		for _, p := range r.Used {
-			fmt.Fprintf(w, "        %q,\n", p)
+		for i, st := range p.Statements {
+			if i > 0 {
+				fmt.Fprintf(w, "    }, {\n")
+			}
+			fmt.Fprintf(w, "      Effect = \"Allow\"\n")
+			fmt.Fprintf(w, "      Action = [\n")
+			for _, p := range st.Actions {
+				fmt.Fprintf(w, "        %q,\n", p)
+			}
+			fmt.Fprintf(w, "      ]\n")
+			if len(st.Resources) == 0 {
+				fmt.Fprintf(w, "      Resource = \"*\"\n")
+				continue
+			}
+			fmt.Fprintf(w, "      Resource = [\n")
+			for _, res := range st.Resources {
+				fmt.Fprintf(w, "        %q,\n", res)
+			}
+			fmt.Fprintf(w, "      ]\n")
 		}
-
-		fmt.Fprintf(w, "      ]\n")
-		fmt.Fprintf(w, "      Resource = \"*\"\n")
 		fmt.Fprintf(w, "    }]\n")
 		fmt.Fprintf(w, "  })\n")
+		if g.PreventDestroy {
+			fmt.Fprintf(w, "\n  lifecycle {\n")
+			fmt.Fprintf(w, "    prevent_destroy = true\n")
+			fmt.Fprintf(w, "  }\n")
+		}
 		fmt.Fprintf(w, "}\n\n")
 	}
 
@@ -72,6 +144,66 @@ func (g *TerraformGenerator) Generate(results []correlation.Result, w io.Writer)
 	return nil
 }
 
+// leastPrivilegePolicy is the aws_iam_policy resource generated for one
+// role, shared by the HCL and JSON Terraform generators.
+type leastPrivilegePolicy struct {
+	// Resource is the Terraform resource name, without the type.
+	Resource    string
+	Name        string
+	Description string
+	// ImportID is the ARN of the customer-managed policy to import, when the
+	// role has exactly one and no other role shares it. ManagedPolicies
+	// counts them all.
+	ImportID        string
+	ManagedPolicies int
+	// SharedBy is how many analyzed roles the role's only customer-managed
+	// policy is attached to, when more than one; it is then not imported.
+	SharedBy   int
+	Statements []policyStatement
+	// Overridden are the unused privileges kept in Statements because they
+	// match a never-remove pattern.
+	Overridden []string
+}
+
+// generatesPolicy reports whether r gets a policy resource. Orphaned and
+// service-linked roles, roles with nothing unused and roles never observed
+// are reported in comments only.
+func generatesPolicy(r correlation.Result) bool {
+	return r.RiskLevel != string(correlation.RiskOrphaned) &&
+		len(r.Unused) > 0 && !r.ReadOnly && len(r.Used) > 0
+}
+
+// newLeastPrivilegePolicy builds the policy resource for r, keeping the
+// unused privileges that match neverRemove. When the role has exactly one
+// customer-managed policy it is imported, so apply rewrites that policy in
+// place instead of creating a new one, unless attachments shows another
+// role shares it.
+func newLeastPrivilegePolicy(r correlation.Result, neverRemove []string, attachments map[string]int) leastPrivilegePolicy {
+	name := terraformResourceName(r.IAMRole)
+	var overridden []string
+	for _, u := range r.Unused {
+		if correlation.MatchesAny(neverRemove, u) {
+			overridden = append(overridden, u)
+		}
+	}
+	p := leastPrivilegePolicy{
+		Resource:    name + "_least_privilege",
+		Name:        name + "-least-privilege",
+		Description: fmt.Sprintf("Least-privilege policy for %s (shinkai-shoujo generated)", r.IAMRole),
+		Statements:  policyStatements(r, overridden),
+		Overridden:  overridden,
+	}
+	managed := customerManagedPolicies(r.PolicyARNs)
+	p.ManagedPolicies = len(managed)
+	if len(managed) == 1 && attachments[managed[0]] > 1 {
+		p.SharedBy = attachments[managed[0]]
+	} else if len(managed) == 1 {
+		p.ImportID = managed[0]
+		p.Name = policyNameFromARN(managed[0])
+	}
+	return p
+}
+
 // terraformResourceName converts an IAM role ARN or name to a valid Terraform resource name.
 func terraformResourceName(roleARN string) string {
 	lower := strings.ToLower(roleARN)
@@ -82,3 +214,37 @@ func terraformResourceName(roleARN string) string {
 	}
 	return safe
 }
+
+// customerManagedPolicies filters out AWS-managed policies, which live in the
+// "aws" pseudo-account and cannot be modified or imported.
+func customerManagedPolicies(arns []string) []string {
+	var out []string
+	for _, a := range arns {
+		parsed, err := arn.Parse(a)
+		if err != nil || parsed.AccountID == "aws" {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// policyAttachments counts the roles in results each customer-managed policy
+// is attached to.
+func policyAttachments(results []correlation.Result) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range results {
+		for _, a := range customerManagedPolicies(r.PolicyARNs) {
+			counts[a]++
+		}
+	}
+	return counts
+}
+
+// policyNameFromARN returns the policy name (last path segment) of a policy ARN.
+func policyNameFromARN(policyARN string) string {
+	if idx := strings.LastIndex(policyARN, "/"); idx != -1 {
+		return policyARN[idx+1:]
+	}
+	return policyARN
+}
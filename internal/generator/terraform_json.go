@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// TerraformJSONGenerator produces the same aws_iam_policy resources as
+// TerraformGenerator in Terraform's JSON configuration syntax (.tf.json), for
+// tooling that post-processes the output with a JSON library.
+type TerraformJSONGenerator struct {
+	// PreventDestroy adds a lifecycle guard so `terraform destroy` cannot
+	// delete the managed policies.
+	PreventDestroy bool
+	// NeverRemove are privileges, or patterns such as "kms:*", kept in the
+	// generated policies even when unused.
+	NeverRemove []string
+}
+
+// tfJSONConfig is the root of a .tf.json file. Terraform ignores "//" keys,
+// so they carry the comments the HCL output writes inline.
+type tfJSONConfig struct {
+	Comment  string                             `json:"//,omitempty"`
+	Import   []tfJSONImport                     `json:"import,omitempty"`
+	Resource map[string]map[string]tfJSONPolicy `json:"resource,omitempty"`
+}
+
+type tfJSONImport struct {
+	To string `json:"to"`
+	ID string `json:"id"`
+}
+
+type tfJSONPolicy struct {
+	Comment     string           `json:"//,omitempty"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Policy      string           `json:"policy"`
+	Lifecycle   *tfJSONLifecycle `json:"lifecycle,omitempty"`
+}
+
+type tfJSONLifecycle struct {
+	PreventDestroy bool `json:"prevent_destroy"`
+}
+
+// iamPolicyDocument is the policy document embedded in each resource.
+type iamPolicyDocument struct {
+	Version   string               `json:"Version"`
+	Statement []iamPolicyStatement `json:"Statement"`
+}
+
+type iamPolicyStatement struct {
+	Effect string   `json:"Effect"`
+	Action []string `json:"Action"`
+	// Resource is "*" or a list of ARNs.
+	Resource any `json:"Resource"`
+}
+
+// Generate writes Terraform JSON to w, one resource per IAM role that gets a
+// policy. Roles skipped by the HCL output are omitted.
+func (g *TerraformJSONGenerator) Generate(results []correlation.Result, w io.Writer) error {
+	cfg := tfJSONConfig{
+		Comment: fmt.Sprintf("Generated by shinkai-shoujo on %s. Review carefully before applying — NEVER auto-apply.",
+			time.Now().Format(time.RFC3339)),
+	}
+	policies := make(map[string]tfJSONPolicy)
+
+	attachments := policyAttachments(results)
+	for _, r := range results {
+		if !generatesPolicy(r) {
+			continue
+		}
+		p := newLeastPrivilegePolicy(r, g.NeverRemove, attachments)
+		if p.ImportID != "" {
+			cfg.Import = append(cfg.Import, tfJSONImport{
+				To: "aws_iam_policy." + p.Resource,
+				ID: p.ImportID,
+			})
+		}
+
+		doc, err := policyDocumentJSON(p.Statements)
+		if err != nil {
+			return fmt.Errorf("encoding policy for %s: %w", r.IAMRole, err)
+		}
+		res := tfJSONPolicy{
+			Comment: fmt.Sprintf("Role: %s. Risk level of unused privileges: %s. Assigned: %d | Used: %d | Unused: %d",
+				r.IAMRole, r.RiskLevel, len(r.Assigned), len(r.Used), len(r.Unused)),
+			Name:        p.Name,
+			Description: p.Description,
+			Policy:      doc,
+		}
+		if len(p.Overridden) > 0 {
+			res.Comment += ". Kept although unused (manual override, never_remove): " + strings.Join(p.Overridden, ", ")
+		}
+		if p.SharedBy > 1 {
+			res.Comment += fmt.Sprintf(". Customer-managed policy is attached to %d analyzed roles; not imported", p.SharedBy)
+		}
+		if g.PreventDestroy {
+			res.Lifecycle = &tfJSONLifecycle{PreventDestroy: true}
+		}
+		policies[p.Resource] = res
+	}
+	if len(policies) > 0 {
+		cfg.Resource = map[string]map[string]tfJSONPolicy{"aws_iam_policy": policies}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
+
+// policyDocumentJSON renders statements as an IAM policy document string.
+// Terraform evaluates string values in JSON configuration as templates, so
+// "${" and "%{" are escaped to keep IAM policy variables literal.
+func policyDocumentJSON(statements []policyStatement) (string, error) {
+	doc := iamPolicyDocument{Version: "2012-10-17"}
+	for _, st := range statements {
+		var resource any = "*"
+		if len(st.Resources) > 0 {
+			resource = st.Resources
+		}
+		doc.Statement = append(doc.Statement, iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   st.Actions,
+			Resource: resource,
+		})
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	s := strings.ReplaceAll(string(b), "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{"), nil
+}
@@ -9,12 +9,16 @@ import (
 )
 
 // YAMLGenerator produces YAML-formatted reports.
-type YAMLGenerator struct{}
+type YAMLGenerator struct {
+	// VerboseUsage adds the call count and last observation of each used
+	// privilege.
+	VerboseUsage bool
+}
 
 // Generate writes a YAML report to w.
 // Reuses the JSONReport structure (yaml tags are already defined there).
 func (g *YAMLGenerator) Generate(results []correlation.Result, w io.Writer) error {
-	report := buildReport(results)
+	report := buildReport(results, g.VerboseUsage)
 	enc := yaml.NewEncoder(w)
 	enc.SetIndent(2)
 	if err := enc.Encode(report); err != nil {
@@ -0,0 +1,43 @@
+// Package health serves liveness and readiness endpoints for the daemon.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each readiness check so a hung dependency fails the
+// probe instead of hanging it.
+const checkTimeout = 2 * time.Second
+
+// Check is a named readiness condition. Fn returns nil when ready.
+type Check struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Register adds /healthz and /readyz to mux. /healthz answers 200 whenever
+// the process is serving. /readyz answers 200 only when every check passes,
+// and 503 naming the first failing check otherwise.
+func Register(mux *http.ServeMux, checks ...Check) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			err := c.Fn(ctx)
+			cancel()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s not ready: %v", c.Name, err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+}
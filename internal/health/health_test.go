@@ -0,0 +1,58 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(mux *http.ServeMux, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHealthzAlwaysOK(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux, Check{Name: "database", Fn: func(context.Context) error { return errors.New("down") }})
+
+	if rec := serve(mux, "/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200 even when not ready", rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	listening := false
+	var dbErr error
+
+	mux := http.NewServeMux()
+	Register(mux,
+		Check{Name: "receiver", Fn: func(context.Context) error {
+			if !listening {
+				return errors.New("not listening")
+			}
+			return nil
+		}},
+		Check{Name: "database", Fn: func(context.Context) error { return dbErr }},
+	)
+
+	rec := serve(mux, "/readyz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "receiver") {
+		t.Errorf("before listening: got %d %q, want 503 naming receiver", rec.Code, rec.Body.String())
+	}
+
+	listening = true
+	dbErr = errors.New("database is locked")
+	rec = serve(mux, "/readyz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "database") {
+		t.Errorf("db ping failing: got %d %q, want 503 naming database", rec.Code, rec.Body.String())
+	}
+
+	dbErr = nil
+	if rec := serve(mux, "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("all checks passing: got %d, want 200", rec.Code)
+	}
+}
@@ -1,92 +1,296 @@
 package metrics
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"regexp"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+	"github.com/0xKirisame/shinkai-shoujo/internal/version"
 )
 
 // Metrics holds all Prometheus metrics for shinkai-shoujo.
 type Metrics struct {
-	SpansReceived    prometheus.Counter
-	SpansSkipped     prometheus.Counter
-	IAMRolesScraped  prometheus.Gauge
-	AnalysisRuns     prometheus.Counter
-	UnusedPrivileges *prometheus.GaugeVec
-	AnalysisDuration prometheus.Histogram
-	gatherer         prometheus.Gatherer
+	SpansReceived        prometheus.Counter
+	SpansSkipped         prometheus.Counter
+	ReceiverRateLimited  prometheus.Counter
+	IAMRolesScraped      *prometheus.GaugeVec
+	ScrapeSkippedRoles   *prometheus.GaugeVec
+	OrphanedRoles        prometheus.Gauge
+	DBPrivilegeRows      prometheus.Gauge
+	DBAnalysisRows       prometheus.Gauge
+	DBFileBytes          prometheus.Gauge
+	AnalysisRuns         prometheus.Counter
+	UnusedPrivileges     *prometheus.GaugeVec
+	UnusedPrivilegeRoles *prometheus.GaugeVec
+	AnalysisDuration     prometheus.Histogram
+	ScrapeDuration       prometheus.Histogram
+	BuildInfo            *prometheus.GaugeVec
+	gatherer             prometheus.Gatherer
+	labelAccount         bool
+	region               string
+}
+
+// DefaultDurationBuckets are the histogram buckets, in seconds, used for
+// analysis and scrape durations when none are configured. Large accounts take
+// minutes, well past prometheus.DefBuckets' 10s ceiling.
+var DefaultDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// Options tune metric construction. The zero value uses the defaults.
+type Options struct {
+	// AnalysisDurationBuckets are the upper bounds, in seconds, of the
+	// analysis duration histogram. Empty means DefaultDurationBuckets.
+	AnalysisDurationBuckets []float64
+	// ScrapeDurationBuckets are the same for the IAM scrape histogram.
+	ScrapeDurationBuckets []float64
+	// LabelAccount adds an account_id label to the per-role unused
+	// privilege gauge and to the scrape gauges, which also get a region
+	// label set to Region, so one deployment analyzing several accounts can
+	// be broken down by account. Each label multiplies the series a gauge
+	// has by the number of accounts, so it is off by default. The duration
+	// histograms stay unlabeled: every account would add a full set of
+	// bucket series.
+	LabelAccount bool
+	Region       string
+	// Prefix starts every metric name, joined by an underscore, to tell
+	// several deployments sharing a Prometheus apart. Empty means
+	// DefaultPrefix. It must be a valid Prometheus name component; see
+	// ValidPrefix.
+	Prefix string
+}
+
+// DefaultPrefix is the metric name prefix used when Options.Prefix is empty.
+const DefaultPrefix = "shinkai"
+
+var prefixPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidPrefix reports whether prefix can start a Prometheus metric name:
+// letters, digits and underscores, not starting with a digit.
+func ValidPrefix(prefix string) bool {
+	return prefixPattern.MatchString(prefix)
 }
 
 // New creates and registers all metrics with the default Prometheus registry.
-func New() *Metrics {
-	return NewWithRegistry(prometheus.DefaultRegisterer)
+func New(opts Options) *Metrics {
+	return NewWithOptions(prometheus.DefaultRegisterer, opts)
 }
 
 // NewWithRegistry creates metrics registered against the provided Registerer.
 // Use prometheus.NewRegistry() in tests to avoid duplicate registration panics.
 func NewWithRegistry(reg prometheus.Registerer) *Metrics {
+	return NewWithOptions(reg, Options{})
+}
+
+// NewWithOptions is NewWithRegistry with explicit Options.
+func NewWithOptions(reg prometheus.Registerer, opts Options) *Metrics {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if len(opts.AnalysisDurationBuckets) == 0 {
+		opts.AnalysisDurationBuckets = DefaultDurationBuckets
+	}
+	if len(opts.ScrapeDurationBuckets) == 0 {
+		opts.ScrapeDurationBuckets = DefaultDurationBuckets
+	}
+
 	factory := func(c prometheus.Collector) prometheus.Collector {
 		reg.MustRegister(c)
 		return c
 	}
 
 	spansReceived := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "shinkai_spans_received_total",
-		Help: "Total number of OTel spans received.",
+		Namespace: opts.Prefix,
+		Name:      "spans_received_total",
+		Help:      "Total number of OTel spans received.",
 	})
 	factory(spansReceived)
 
 	spansSkipped := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "shinkai_spans_skipped_total",
-		Help: "Total number of OTel spans skipped (missing required attributes).",
+		Namespace: opts.Prefix,
+		Name:      "spans_skipped_total",
+		Help:      "Total number of OTel spans skipped (missing required attributes).",
 	})
 	factory(spansSkipped)
 
-	iamRolesScraped := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "shinkai_iam_roles_scraped",
-		Help: "Number of IAM roles scraped in the last scrape.",
+	receiverRateLimited := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: opts.Prefix,
+		Name:      "receiver_rate_limited_total",
+		Help:      "Total number of OTLP requests rejected by the receiver rate limiter.",
 	})
+	factory(receiverRateLimited)
+
+	var scrapeLabels []string
+	unusedLabels := []string{"iam_role", "risk_level"}
+	if opts.LabelAccount {
+		scrapeLabels = []string{"account_id", "region"}
+		unusedLabels = append(unusedLabels, "account_id")
+	}
+
+	iamRolesScraped := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "iam_roles_scraped",
+		Help:      "Number of IAM roles scraped in the last scrape.",
+	}, scrapeLabels)
 	factory(iamRolesScraped)
 
+	scrapeSkippedRoles := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "scrape_skipped_roles",
+		Help:      "Number of IAM roles that could not be scraped in the last scrape.",
+	}, scrapeLabels)
+	factory(scrapeSkippedRoles)
+	if !opts.LabelAccount {
+		// Without labels there is one series, exported as 0 before the
+		// first scrape like a plain gauge.
+		iamRolesScraped.WithLabelValues()
+		scrapeSkippedRoles.WithLabelValues()
+	}
+
+	orphanedRoles := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "orphaned_roles",
+		Help:      "Number of roles observed in traces but not found in IAM in the last analysis.",
+	})
+	factory(orphanedRoles)
+
+	dbPrivilegeRows := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "db_privilege_usage_rows",
+		Help:      "Number of rows in the privilege_usage table.",
+	})
+	factory(dbPrivilegeRows)
+
+	dbAnalysisRows := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "db_analysis_results_rows",
+		Help:      "Number of rows in the analysis_results table.",
+	})
+	factory(dbAnalysisRows)
+
+	dbFileBytes := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "db_file_bytes",
+		Help:      "Size of the SQLite database file in bytes.",
+	})
+	factory(dbFileBytes)
+
 	analysisRuns := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "shinkai_analysis_runs_total",
-		Help: "Total number of correlation analysis runs.",
+		Namespace: opts.Prefix,
+		Name:      "analysis_runs_total",
+		Help:      "Total number of correlation analysis runs.",
 	})
 	factory(analysisRuns)
 
 	unusedPrivileges := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "shinkai_unused_privileges",
-		Help: "Number of unused privileges per IAM role.",
-	}, []string{"iam_role", "risk_level"})
+		Namespace: opts.Prefix,
+		Name:      "unused_privileges",
+		Help:      "Number of unused privileges per IAM role.",
+	}, unusedLabels)
 	factory(unusedPrivileges)
 
+	unusedPrivilegeRoles := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "unused_privilege_roles",
+		Help:      "Number of IAM roles leaving each privilege unused in the last analysis.",
+	}, []string{"privilege", "risk"})
+	factory(unusedPrivilegeRoles)
+
 	analysisDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "shinkai_analysis_duration_seconds",
-		Help:    "Duration of correlation analysis runs.",
-		Buckets: prometheus.DefBuckets,
+		Namespace: opts.Prefix,
+		Name:      "analysis_duration_seconds",
+		Help:      "Duration of correlation analysis runs.",
+		Buckets:   opts.AnalysisDurationBuckets,
 	})
 	factory(analysisDuration)
 
+	scrapeDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: opts.Prefix,
+		Name:      "scrape_duration_seconds",
+		Help:      "Duration of IAM scrapes.",
+		Buckets:   opts.ScrapeDurationBuckets,
+	})
+	factory(scrapeDuration)
+
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: opts.Prefix,
+		Name:      "build_info",
+		Help:      "Always 1; labeled with the version and commit of the running binary.",
+	}, []string{"version", "commit"})
+	factory(buildInfo)
+	info := version.Get()
+	buildInfo.WithLabelValues(info.Version, info.Commit).Set(1)
+
 	gatherer, ok := reg.(prometheus.Gatherer)
 	if !ok {
 		panic("BUG: registerer does not implement prometheus.Gatherer")
 	}
 
 	return &Metrics{
-		SpansReceived:    spansReceived,
-		SpansSkipped:     spansSkipped,
-		IAMRolesScraped:  iamRolesScraped,
-		AnalysisRuns:     analysisRuns,
-		UnusedPrivileges: unusedPrivileges,
-		AnalysisDuration: analysisDuration,
-		gatherer:         gatherer,
+		SpansReceived:        spansReceived,
+		SpansSkipped:         spansSkipped,
+		ReceiverRateLimited:  receiverRateLimited,
+		IAMRolesScraped:      iamRolesScraped,
+		ScrapeSkippedRoles:   scrapeSkippedRoles,
+		OrphanedRoles:        orphanedRoles,
+		DBPrivilegeRows:      dbPrivilegeRows,
+		DBAnalysisRows:       dbAnalysisRows,
+		DBFileBytes:          dbFileBytes,
+		AnalysisRuns:         analysisRuns,
+		UnusedPrivileges:     unusedPrivileges,
+		UnusedPrivilegeRoles: unusedPrivilegeRoles,
+		AnalysisDuration:     analysisDuration,
+		ScrapeDuration:       scrapeDuration,
+		BuildInfo:            buildInfo,
+		gatherer:             gatherer,
+		labelAccount:         opts.LabelAccount,
+		region:               opts.Region,
 	}
 }
 
+// SetUnusedPrivileges records the number of unused privileges of role,
+// labeled with the role's account when account labels are enabled.
+func (m *Metrics) SetUnusedPrivileges(role, risk string, n int) {
+	labels := []string{role, risk}
+	if m.labelAccount {
+		labels = append(labels, rolearn.Parse(role).Account)
+	}
+	m.UnusedPrivileges.WithLabelValues(labels...).Set(float64(n))
+}
+
+// SetScrape records the roles the last scrape of account fetched and
+// skipped. The account only labels the gauges when account labels are
+// enabled.
+func (m *Metrics) SetScrape(account string, scraped, skipped int) {
+	var labels []string
+	if m.labelAccount {
+		labels = []string{account, m.region}
+	}
+	m.IAMRolesScraped.WithLabelValues(labels...).Set(float64(scraped))
+	m.ScrapeSkippedRoles.WithLabelValues(labels...).Set(float64(skipped))
+}
+
 // Handler returns an HTTP handler for the /metrics endpoint using the registry
 // that was provided to NewWithRegistry. This ensures the handler only exposes
 // metrics registered with this specific Metrics instance.
 func (m *Metrics) Handler() http.Handler {
 	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
 }
+
+// Push sends the metrics to the Prometheus Pushgateway at url, for one-shot
+// runs that have no /metrics endpoint to be scraped. They replace whatever
+// was last pushed under job and, when set, instance.
+func (m *Metrics) Push(ctx context.Context, url, job, instance string) error {
+	p := push.New(url, job).Gatherer(m.gatherer)
+	if instance != "" {
+		p = p.Grouping("instance", instance)
+	}
+	if err := p.PushContext(ctx); err != nil {
+		return fmt.Errorf("pushing metrics to %s: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCustomPrefix(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	NewWithOptions(reg, Options{Prefix: "team_a"})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	if len(families) == 0 {
+		t.Fatal("expected gathered metrics")
+	}
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "team_a_") {
+			t.Errorf("metric %q does not use the custom prefix", mf.GetName())
+		}
+	}
+
+	for prefix, want := range map[string]bool{"shinkai": true, "_x9": true, "team-a": false, "9lives": false, "": false} {
+		if got := ValidPrefix(prefix); got != want {
+			t.Errorf("ValidPrefix(%q) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func TestCustomDurationBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewWithOptions(reg, Options{
+		AnalysisDurationBuckets: []float64{1, 5, 10, 30, 60, 120, 300, 600},
+		ScrapeDurationBuckets:   []float64{10, 100},
+	})
+	m.AnalysisDuration.Observe(45)
+	m.ScrapeDuration.Observe(3)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cumulative counts: each observation lands in the first bucket whose
+	// upper bound it does not exceed, and every bucket above it.
+	want := map[string]map[float64]uint64{
+		"shinkai_analysis_duration_seconds": {1: 0, 5: 0, 10: 0, 30: 0, 60: 1, 120: 1, 300: 1, 600: 1},
+		"shinkai_scrape_duration_seconds":   {10: 1, 100: 1},
+	}
+	for _, mf := range families {
+		buckets, ok := want[mf.GetName()]
+		if !ok {
+			continue
+		}
+		delete(want, mf.GetName())
+		h := mf.GetMetric()[0].GetHistogram()
+		if len(h.GetBucket()) != len(buckets) {
+			t.Errorf("%s: got %d buckets, want %d", mf.GetName(), len(h.GetBucket()), len(buckets))
+		}
+		for _, b := range h.GetBucket() {
+			if got := b.GetCumulativeCount(); got != buckets[b.GetUpperBound()] {
+				t.Errorf("%s: bucket le=%v count = %d, want %d", mf.GetName(), b.GetUpperBound(), got, buckets[b.GetUpperBound()])
+			}
+		}
+	}
+	for name := range want {
+		t.Errorf("histogram %s not gathered", name)
+	}
+}
+
+func TestDefaultDurationBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewWithRegistry(reg)
+	m.AnalysisDuration.Observe(900)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "shinkai_analysis_duration_seconds" {
+			continue
+		}
+		bs := mf.GetMetric()[0].GetHistogram().GetBucket()
+		if got := bs[len(bs)-1].GetUpperBound(); got != DefaultDurationBuckets[len(DefaultDurationBuckets)-1] {
+			t.Errorf("largest default bucket = %v, want %v", got, DefaultDurationBuckets[len(DefaultDurationBuckets)-1])
+		}
+		if got := bs[len(bs)-1].GetCumulativeCount(); got != 1 {
+			t.Errorf("a 15 minute analysis should fall inside the default range, count = %d", got)
+		}
+		return
+	}
+	t.Error("analysis duration histogram not gathered")
+}
+
+func TestPush(t *testing.T) {
+	var (
+		method, path string
+		body         []byte
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	m := NewWithRegistry(prometheus.NewRegistry())
+	m.AnalysisRuns.Inc()
+	if err := m.Push(context.Background(), gateway.URL, "shinkai-shoujo", "cron-host"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/shinkai-shoujo/instance/cron-host" {
+		t.Errorf("push = %s %s, want PUT under the job and instance", method, path)
+	}
+	if !bytes.Contains(body, []byte("shinkai_analysis_runs_total")) {
+		t.Error("expected the analysis run counter in the pushed metrics")
+	}
+}
+
+func TestPushReportsGatewayErrors(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+
+	m := NewWithRegistry(prometheus.NewRegistry())
+	if err := m.Push(context.Background(), gateway.URL, "shinkai-shoujo", ""); err == nil {
+		t.Error("expected an error when the gateway rejects the push")
+	}
+}
+
+func TestAccountLabels(t *testing.T) {
+	m := NewWithOptions(prometheus.NewRegistry(), Options{LabelAccount: true, Region: "us-west-2"})
+	m.SetUnusedPrivileges("arn:aws:iam::123456789012:role/App", "HIGH", 3)
+	m.SetScrape("123456789012", 40, 2)
+
+	if got := testutil.ToFloat64(m.UnusedPrivileges.WithLabelValues("arn:aws:iam::123456789012:role/App", "HIGH", "123456789012")); got != 3 {
+		t.Errorf("unused privileges = %v, want 3 labeled with the role's account", got)
+	}
+	if got := testutil.ToFloat64(m.IAMRolesScraped.WithLabelValues("123456789012", "us-west-2")); got != 40 {
+		t.Errorf("roles scraped = %v, want 40 labeled with account and region", got)
+	}
+	if got := testutil.ToFloat64(m.ScrapeSkippedRoles.WithLabelValues("123456789012", "us-west-2")); got != 2 {
+		t.Errorf("roles skipped = %v, want 2", got)
+	}
+
+	// Off by default: the series carry no account or region, and are
+	// exported before the first scrape.
+	reg := prometheus.NewRegistry()
+	plain := NewWithRegistry(reg)
+	if n, err := testutil.GatherAndCount(reg, "shinkai_iam_roles_scraped", "shinkai_scrape_skipped_roles"); err != nil || n != 2 {
+		t.Errorf("got %d scrape series before a scrape (err %v), want both gauges at 0", n, err)
+	}
+	plain.SetUnusedPrivileges("arn:aws:iam::123456789012:role/App", "HIGH", 3)
+	plain.SetScrape("123456789012", 40, 2)
+	if got := testutil.ToFloat64(plain.UnusedPrivileges.WithLabelValues("arn:aws:iam::123456789012:role/App", "HIGH")); got != 3 {
+		t.Errorf("unlabeled unused privileges = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(plain.IAMRolesScraped.WithLabelValues()); got != 40 {
+		t.Errorf("unlabeled roles scraped = %v, want 40", got)
+	}
+}
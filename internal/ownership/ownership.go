@@ -0,0 +1,126 @@
+// Package ownership maps IAM roles to the teams that own them, so findings
+// can be routed to the people who can act on them.
+package ownership
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+)
+
+// Owner is who to contact about a role. Any field may be empty.
+type Owner struct {
+	Team  string `yaml:"team"  json:"team,omitempty"`
+	Email string `yaml:"email" json:"email,omitempty"`
+	Slack string `yaml:"slack" json:"slack,omitempty"`
+}
+
+// String joins o's non-empty fields, e.g. "payments, #payments".
+func (o Owner) String() string {
+	var parts []string
+	for _, f := range []string{o.Team, o.Email, o.Slack} {
+		if f != "" {
+			parts = append(parts, f)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// IsZero reports whether o names no one.
+func (o Owner) IsZero() bool {
+	return o == Owner{}
+}
+
+// Map assigns owners to roles. A nil *Map owns nothing.
+type Map struct {
+	exact map[string]Owner
+	rules []rule
+}
+
+type rule struct {
+	re    *regexp.Regexp
+	owner Owner
+}
+
+// file is the layout of an ownership file:
+//
+//	owners:
+//	  - role: arn:aws:iam::123456789012:role/billing
+//	    team: payments
+//	    slack: "#payments"
+//	  - role: "app-.*"
+//	    team: platform
+//	    email: platform@example.com
+type file struct {
+	Owners []struct {
+		Role  string `yaml:"role"`
+		Owner `yaml:",inline"`
+	} `yaml:"owners"`
+}
+
+// Parse reads an ownership file. Each entry's role is a role ARN or name,
+// or a regular expression matched against the whole ARN or name.
+func Parse(data []byte) (*Map, error) {
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	m := &Map{exact: make(map[string]Owner, len(f.Owners))}
+	for i, e := range f.Owners {
+		if e.Role == "" {
+			return nil, fmt.Errorf("owners[%d]: missing role", i)
+		}
+		if e.Owner.IsZero() {
+			return nil, fmt.Errorf("owners[%d] (%s): no team, email or slack", i, e.Role)
+		}
+		if _, dup := m.exact[e.Role]; !dup {
+			m.exact[e.Role] = e.Owner
+		}
+		// Role names may contain characters that do not form a valid
+		// expression (e.g. a leading "+"); such entries match exactly only.
+		if re, err := regexp.Compile("^(?:" + e.Role + ")$"); err == nil {
+			m.rules = append(m.rules, rule{re: re, owner: e.Owner})
+		}
+	}
+	return m, nil
+}
+
+// Load reads the ownership file at path.
+func Load(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading ownership file: %w", err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ownership file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Lookup returns the owner of role, given as an ARN or name. An entry
+// naming the role exactly, by ARN or by name, wins; otherwise the first
+// entry whose expression matches the ARN or the name does.
+func (m *Map) Lookup(role string) (Owner, bool) {
+	if m == nil {
+		return Owner{}, false
+	}
+	role = rolearn.Normalize(role)
+	name := rolearn.Parse(role).Name
+	for _, key := range []string{role, name} {
+		if o, ok := m.exact[key]; ok {
+			return o, true
+		}
+	}
+	for _, r := range m.rules {
+		if r.re.MatchString(role) || r.re.MatchString(name) {
+			return r.owner, true
+		}
+	}
+	return Owner{}, false
+}
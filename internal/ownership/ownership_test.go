@@ -0,0 +1,90 @@
+package ownership
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testOwners = `
+owners:
+  - role: "app-.*"
+    team: platform
+    email: platform@example.com
+  - role: arn:aws:iam::123456789012:role/app-billing
+    team: payments
+    slack: "#payments"
+  - role: ci-runner
+    team: build
+  - role: ".*-ci"
+    team: build-fallback
+  - role: "+odd"
+    team: odd
+`
+
+func TestLookup(t *testing.T) {
+	m, err := Parse([]byte(testOwners))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tests := []struct {
+		role string
+		team string
+	}{
+		// An exact entry wins over an earlier expression.
+		{"arn:aws:iam::123456789012:role/app-billing", "payments"},
+		{"arn:aws:sts::123456789012:assumed-role/app-billing/session", "payments"},
+		// Expressions match the whole role name or ARN.
+		{"arn:aws:iam::123456789012:role/app-web", "platform"},
+		{"app-worker", "platform"},
+		{"arn:aws:iam::123456789012:role/my-app-web", ""},
+		{"arn:aws:iam::123456789012:role/service/ci-runner", "build"},
+		{"nightly-ci", "build-fallback"},
+		{"+odd", "odd"},
+		{"unowned", ""},
+	}
+	for _, tt := range tests {
+		o, ok := m.Lookup(tt.role)
+		if o.Team != tt.team || ok != (tt.team != "") {
+			t.Errorf("Lookup(%q) = %+v, %t; want team %q", tt.role, o, ok, tt.team)
+		}
+	}
+	if o, _ := m.Lookup("arn:aws:iam::123456789012:role/app-billing"); o.Slack != "#payments" {
+		t.Errorf("expected the entry's contact details, got %+v", o)
+	}
+}
+
+func TestLookupNilMap(t *testing.T) {
+	var m *Map
+	if _, ok := m.Lookup("app-web"); ok {
+		t.Error("a nil map should own nothing")
+	}
+}
+
+func TestParseRejectsIncompleteEntries(t *testing.T) {
+	for _, data := range []string{
+		"owners:\n  - team: platform\n",
+		"owners:\n  - role: app-web\n",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.yaml")
+	if err := os.WriteFile(path, []byte(testOwners), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if o, ok := m.Lookup("app-web"); !ok || o.Team != "platform" {
+		t.Errorf("Lookup after Load = %+v, %t", o, ok)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
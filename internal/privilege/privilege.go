@@ -0,0 +1,77 @@
+// Package privilege defines the canonical form of an IAM privilege, so that an
+// action is represented identically whether it was read from a policy
+// document, a trace span, a usage line or a seed file. In canonical form:
+//
+//   - surrounding whitespace is trimmed, around the service and the action;
+//   - the service prefix is lowercase ("S3:GetObject" → "s3:GetObject"), as
+//     is anything without one, such as "*";
+//   - the action keeps its casing: IAM compares actions case-insensitively,
+//     and comparisons in this module do too;
+//   - an SDK operation name that differs from its IAM action is replaced by
+//     the action ("lambda:Invoke" → "lambda:InvokeFunction").
+//
+// Observed privileges go through Normalize. Policy actions are IAM actions
+// already and go through Format, the first three rules only.
+//
+// correlation.Normalize is the entry point for most callers; this package
+// exists so the scraper, which correlation depends on, can share it.
+package privilege
+
+import "strings"
+
+// sdkToIAMAction maps SDK operation names that differ from their canonical IAM action names.
+// Key: "service:SDKOperation" (lowercase service prefix).
+// Value: correct IAM action "service:IAMAction".
+// Identity mappings (key == value) are omitted — the lookup falls through to
+// returning the input unchanged, which is equivalent.
+var sdkToIAMAction = map[string]string{
+	// Lambda — SDK uses short names; IAM requires the full name.
+	"lambda:Invoke":              "lambda:InvokeFunction",
+	"lambda:InvokeAsync":         "lambda:InvokeFunction",
+	"lambda:InvokeWithQualifier": "lambda:InvokeFunction",
+
+	// S3 — HEAD operations map to the corresponding IAM permission.
+	"s3:HeadObject": "s3:GetObject",
+	"s3:HeadBucket": "s3:ListBucket",
+
+	// EC2 — SDK uses singular; IAM uses plural.
+	"ec2:StartInstance": "ec2:StartInstances",
+	"ec2:StopInstance":  "ec2:StopInstances",
+}
+
+// byLowerKey is sdkToIAMAction keyed case-insensitively, so an operation
+// reported in another casing still maps.
+var byLowerKey = func() map[string]string {
+	m := make(map[string]string, len(sdkToIAMAction))
+	for k, v := range sdkToIAMAction {
+		m[strings.ToLower(k)] = v
+	}
+	return m
+}()
+
+// Normalize returns p in canonical form.
+func Normalize(p string) string {
+	p = Format(p)
+	if mapped, ok := IAMAction(p); ok {
+		return mapped
+	}
+	return p
+}
+
+// Format is Normalize without the SDK-to-IAM mapping: it only trims p and
+// lowercases its service prefix. Callers applying their own mappings before
+// the built-in ones, such as correlation.SDKMappings, use it.
+func Format(p string) string {
+	service, action, ok := strings.Cut(strings.TrimSpace(p), ":")
+	if !ok {
+		return strings.ToLower(service)
+	}
+	return strings.ToLower(strings.TrimSpace(service)) + ":" + strings.TrimSpace(action)
+}
+
+// IAMAction returns the IAM action the built-in table maps the SDK
+// operation p to, matching case-insensitively, and whether it has one.
+func IAMAction(p string) (string, bool) {
+	mapped, ok := byLowerKey[strings.ToLower(p)]
+	return mapped, ok
+}
@@ -0,0 +1,40 @@
+package privilege
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"S3:GetObject", "s3:GetObject"},
+		{"s3:GetObject", "s3:GetObject"},
+		{"IAM:CreateRole", "iam:CreateRole"},
+		{" s3 : GetObject ", "s3:GetObject"},
+		{"lambda:Invoke", "lambda:Invoke"}, // no SDK mapping
+		{"*", "*"},
+		{"s3:*", "s3:*"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.input); got != tt.expected {
+			t.Errorf("Format(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNormalizeMapsSDKOperations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"lambda:Invoke", "lambda:InvokeFunction"},
+		{"Lambda:invoke", "lambda:InvokeFunction"},
+		{"s3:HeadBucket", "s3:ListBucket"},
+		{"s3:GetObject", "s3:GetObject"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.input); got != tt.expected {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/privilege"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// usageLine is one record of the /v1/usage JSON-lines format, for clients
+// that cannot produce OTLP:
+//
+//	{"role":"arn:aws:iam::123456789012:role/App","service":"s3","operation":"GetObject","ts":1700000000}
+//
+// ts is Unix seconds; when absent the time of receipt is used.
+type usageLine struct {
+	Role      string   `json:"role"`
+	Service   string   `json:"service"`
+	Operation string   `json:"operation"`
+	TS        *float64 `json:"ts"`
+}
+
+// maxUsageClockSkew is how far past the time of receipt a /v1/usage ts may
+// be. Later ones are more likely milliseconds sent as seconds than clock
+// skew, and would keep a privilege "used" for years.
+const maxUsageClockSkew = 5 * time.Minute
+
+// usageResponse reports how many lines of a /v1/usage request were kept.
+type usageResponse struct {
+	Accepted int `json:"accepted"`
+	Skipped  int `json:"skipped"`
+}
+
+// parseUsageLines converts a JSON-lines body into usage records. Lines that
+// are not valid JSON, lack role, service or operation, or carry a ts that is
+// not positive or is more than maxUsageClockSkew past now are skipped and
+// counted; blank lines are ignored.
+func parseUsageLines(body []byte, now time.Time, log *slog.Logger) (records []storage.PrivilegeUsageRecord, skipped int) {
+	for i, raw := range bytes.Split(body, []byte("\n")) {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var line usageLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			log.Debug("skipping usage line: invalid JSON", "line", i+1, "error", err)
+			skipped++
+			continue
+		}
+		role := strings.TrimSpace(line.Role)
+		service := strings.TrimSpace(line.Service)
+		operation := strings.TrimSpace(line.Operation)
+		if role == "" || service == "" || operation == "" {
+			log.Debug("skipping usage line: missing role, service or operation", "line", i+1)
+			skipped++
+			continue
+		}
+		if len(role) > maxRoleLen || len(service) > maxPrivilegeComponentLen || len(operation) > maxPrivilegeComponentLen {
+			log.Debug("skipping usage line: field too long", "line", i+1)
+			skipped++
+			continue
+		}
+		ts := now
+		if line.TS != nil {
+			// Compared as float first: a huge ts would overflow int64.
+			if !(*line.TS > 0) || *line.TS > float64(now.Add(maxUsageClockSkew).Unix()) {
+				log.Debug("skipping usage line: ts out of range", "line", i+1, "ts", *line.TS)
+				skipped++
+				continue
+			}
+			sec, frac := math.Modf(*line.TS)
+			ts = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		records = append(records, storage.PrivilegeUsageRecord{
+			Timestamp: ts,
+			IAMRole:   role,
+			Privilege: privilege.Format(service + ":" + operation),
+			CallCount: 1,
+		})
+	}
+	return records, skipped
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.log.Debug("failed to read request body", "error", err)
+		http.Error(w, "request body too large or unreadable", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	records, skipped := parseUsageLines(body, time.Now(), s.log)
+	if len(records) > 0 && !s.buffer(records) {
+		s.log.Warn("receive buffer full, asking client to retry", "records", len(records))
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "receive buffer full", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.log.Debug("buffered privilege usage from JSON lines", "count", len(records), "skipped", skipped)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usageResponse{Accepted: len(records), Skipped: skipped}) //nolint:errcheck
+}
@@ -10,9 +10,35 @@ import (
 	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
 
 	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/privilege"
 	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
 
+// maxPrivilegeComponentLen bounds the service and operation names taken from
+// span attributes, so a misbehaving client cannot create unbounded DB keys.
+const maxPrivilegeComponentLen = 128
+
+// maxRoleLen bounds the role taken from resource attributes (IAM ARNs are at
+// most 2048 characters).
+const maxRoleLen = 2048
+
+// maxResourceLen bounds the resource taken from span attributes. A longer
+// value is dropped but the privilege is still recorded.
+const maxResourceLen = 2048
+
+// DefaultRoleAttribute is the attribute naming the IAM role a span's calls
+// were made as.
+const DefaultRoleAttribute = "aws.iam.role"
+
+// resourceAttrs are span attributes naming the resource a call acted on,
+// checked in order.
+var resourceAttrs = []string{"aws.s3.bucket"}
+
+// sessionPolicyAttrs are attributes carrying the session policy a call's
+// session was assumed with, or a hash of it. Either marks the call as made
+// under a session policy; the policy itself is not stored.
+var sessionPolicyAttrs = []string{"aws.iam.session_policy", "aws.iam.session_policy_hash"}
+
 // PrivilegeRecord is a parsed privilege observation from an OTel span.
 type PrivilegeRecord struct {
 	Timestamp time.Time
@@ -21,18 +47,27 @@ type PrivilegeRecord struct {
 }
 
 // parseTraces extracts privilege records from an ExportTraceServiceRequest.
+// The role is read from the roleAttr resource attribute (a dotted key path,
+// DefaultRoleAttribute if empty). When the resource lacks it, each span's
+// own attributes and then its links' attributes are checked instead.
+// Privileges are only formatted (see privilege.Format): Server.buffer maps
+// them to IAM actions, so user SDK mappings apply before the built-in ones.
 func parseTraces(
 	resourceSpans []*tracev1.ResourceSpans,
+	roleAttr string,
 	log *slog.Logger,
 	m *metrics.Metrics,
 ) []storage.PrivilegeUsageRecord {
+	if roleAttr == "" {
+		roleAttr = DefaultRoleAttribute
+	}
 	var records []storage.PrivilegeUsageRecord
 
 	for _, rs := range resourceSpans {
-		// Extract aws.iam.role from resource attributes
-		iamRole := attrValue(rs.GetResource().GetAttributes(), "aws.iam.role")
-		if iamRole == "" {
-			log.Debug("skipping ResourceSpans: missing aws.iam.role resource attribute")
+		resourceRole := strings.TrimSpace(attrPath(rs.GetResource().GetAttributes(), roleAttr))
+		resourceScoped := hasSessionPolicy(rs.GetResource().GetAttributes())
+		if len(resourceRole) > maxRoleLen {
+			log.Debug("skipping ResourceSpans: role attribute too long", "attribute", roleAttr, "length", len(resourceRole))
 			continue
 		}
 
@@ -40,8 +75,21 @@ func parseTraces(
 			for _, span := range ss.GetSpans() {
 				m.SpansReceived.Inc()
 
-				service := attrValue(span.GetAttributes(), "aws.service")
-				operation := attrValue(span.GetAttributes(), "aws.operation")
+				iamRole := resourceRole
+				if iamRole == "" {
+					iamRole = spanRole(span, roleAttr)
+				}
+				if iamRole == "" || len(iamRole) > maxRoleLen {
+					log.Debug("skipping span: missing or oversized role attribute",
+						"span_id", fmt.Sprintf("%x", span.GetSpanId()),
+						"attribute", roleAttr,
+					)
+					m.SpansSkipped.Inc()
+					continue
+				}
+
+				service := strings.TrimSpace(attrValue(span.GetAttributes(), "aws.service"))
+				operation := strings.TrimSpace(attrValue(span.GetAttributes(), "aws.operation"))
 
 				if service == "" || operation == "" {
 					log.Debug("skipping span: missing aws.service or aws.operation",
@@ -51,8 +99,16 @@ func parseTraces(
 					m.SpansSkipped.Inc()
 					continue
 				}
+				if len(service) > maxPrivilegeComponentLen || len(operation) > maxPrivilegeComponentLen {
+					log.Debug("skipping span: aws.service or aws.operation too long",
+						"span_id", fmt.Sprintf("%x", span.GetSpanId()),
+						"iam_role", iamRole,
+					)
+					m.SpansSkipped.Inc()
+					continue
+				}
 
-				priv := normalizePrivilege(service, operation)
+				priv := privilege.Format(service + ":" + operation)
 				ts := spanTimestamp(span)
 
 				records = append(records, storage.PrivilegeUsageRecord{
@@ -60,6 +116,10 @@ func parseTraces(
 					IAMRole:   iamRole,
 					Privilege: priv,
 					CallCount: 1,
+					Resource:  spanResource(span),
+					// A session policy is set per session, so the resource
+					// (one SDK client) may carry it for all its spans.
+					SessionPolicy: resourceScoped || hasSessionPolicy(span.GetAttributes()),
 				})
 			}
 		}
@@ -67,10 +127,65 @@ func parseTraces(
 	return records
 }
 
-// normalizePrivilege produces "service:Operation" from span attributes.
-// Service is lowercased; operation preserves original casing.
-func normalizePrivilege(service, operation string) string {
-	return fmt.Sprintf("%s:%s", strings.ToLower(service), operation)
+// spanResource returns the resource a span acted on, or "" if none was
+// captured or it is too long to store.
+func spanResource(span *tracev1.Span) string {
+	for _, key := range resourceAttrs {
+		if v := strings.TrimSpace(attrValue(span.GetAttributes(), key)); v != "" {
+			if len(v) > maxResourceLen {
+				return ""
+			}
+			return v
+		}
+	}
+	return ""
+}
+
+// hasSessionPolicy reports whether attrs name a session policy.
+func hasSessionPolicy(attrs []*commonv1.KeyValue) bool {
+	for _, key := range sessionPolicyAttrs {
+		if strings.TrimSpace(attrValue(attrs, key)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// spanRole returns the role named by a span's own roleAttr attribute or,
+// failing that, by the first of its links that carries one.
+func spanRole(span *tracev1.Span, roleAttr string) string {
+	if v := strings.TrimSpace(attrPath(span.GetAttributes(), roleAttr)); v != "" {
+		return v
+	}
+	for _, link := range span.GetLinks() {
+		if v := strings.TrimSpace(attrPath(link.GetAttributes(), roleAttr)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// attrPath returns the string value at a dotted key path, or "" if not
+// found. An attribute keyed by the whole path is found directly; otherwise
+// the path is followed into nested key-value lists, so
+// "aws.auth.account.principal" also matches {"aws.auth": {"account":
+// {"principal": ...}}}.
+func attrPath(attrs []*commonv1.KeyValue, path string) string {
+	if v := attrValue(attrs, path); v != "" {
+		return v
+	}
+	for _, kv := range attrs {
+		rest, ok := strings.CutPrefix(path, kv.GetKey()+".")
+		if !ok {
+			continue
+		}
+		if nested := kv.GetValue().GetKvlistValue(); nested != nil {
+			if v := attrPath(nested.GetValues(), rest); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
 }
 
 // attrValue returns the string value of a named attribute, or "" if not found.
@@ -0,0 +1,107 @@
+package receiver
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxTrackedAddrs bounds the per-address limiter map; when exceeded the map is
+// reset rather than grown, trading a brief burst allowance for bounded memory.
+const maxTrackedAddrs = 10000
+
+// RateLimit configures the token-bucket limiter in front of the receiver.
+// A zero RequestsPerSecond disables limiting.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+	// PerRemoteAddr keys the limiter by client IP instead of one global bucket.
+	PerRemoteAddr bool
+}
+
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	perAddr bool
+
+	mu     sync.Mutex
+	global *rate.Limiter
+	byAddr map[string]*rate.Limiter
+}
+
+// newRateLimiter returns nil when cfg disables limiting.
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(cfg.RequestsPerSecond))
+	}
+	return &rateLimiter{
+		limit:   rate.Limit(cfg.RequestsPerSecond),
+		burst:   burst,
+		perAddr: cfg.PerRemoteAddr,
+		global:  rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), burst),
+		byAddr:  make(map[string]*rate.Limiter),
+	}
+}
+
+func (l *rateLimiter) limiterFor(r *http.Request) *rate.Limiter {
+	if !l.perAddr {
+		return l.global
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.byAddr[host]
+	if !ok {
+		if len(l.byAddr) >= maxTrackedAddrs {
+			l.byAddr = make(map[string]*rate.Limiter)
+		}
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.byAddr[host] = lim
+	}
+	return lim
+}
+
+// allow reports whether r may proceed and, if not, how long the client
+// should wait before retrying.
+func (l *rateLimiter) allow(r *http.Request) (bool, time.Duration) {
+	res := l.limiterFor(r).Reserve()
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// rateLimited wraps next with the server's limiter, answering 429 with a
+// Retry-After header when the bucket is empty.
+func (s *Server) rateLimited(next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.limiter.allow(r)
+		if !ok {
+			s.metrics.ReceiverRateLimited.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,19 +1,30 @@
 package receiver
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
 	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
 	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/seed"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
 
 func testMetrics() *metrics.Metrics {
@@ -60,7 +71,7 @@ func TestParseTraces_HappyPath(t *testing.T) {
 		},
 	}
 
-	records := parseTraces(resourceSpans, log, m)
+	records := parseTraces(resourceSpans, "", log, m)
 	if len(records) != 1 {
 		t.Fatalf("expected 1 record, got %d", len(records))
 	}
@@ -97,7 +108,7 @@ func TestParseTraces_MissingRole(t *testing.T) {
 		},
 	}
 
-	records := parseTraces(resourceSpans, log, m)
+	records := parseTraces(resourceSpans, "", log, m)
 	if len(records) != 0 {
 		t.Errorf("expected 0 records when role is missing, got %d", len(records))
 	}
@@ -129,13 +140,89 @@ func TestParseTraces_MissingService(t *testing.T) {
 		},
 	}
 
-	records := parseTraces(resourceSpans, log, m)
+	records := parseTraces(resourceSpans, "", log, m)
 	if len(records) != 0 {
 		t.Errorf("expected 0 records when service is missing, got %d", len(records))
 	}
 }
 
-func TestNormalizePrivilege(t *testing.T) {
+func TestParseTraces_NestedRoleAttribute(t *testing.T) {
+	nested := func(key string, values ...*commonv1.KeyValue) *commonv1.KeyValue {
+		return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{
+			Value: &commonv1.AnyValue_KvlistValue{KvlistValue: &commonv1.KeyValueList{Values: values}},
+		}}
+	}
+	resourceSpans := []*tracev1.ResourceSpans{{
+		Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+			nested("aws.auth", nested("account", makeKV("principal", "arn:aws:iam::123:role/Nested"))),
+		}},
+		ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{
+			{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.service", "s3"),
+				makeKV("aws.operation", "GetObject"),
+			}},
+		}}},
+	}}
+
+	records := parseTraces(resourceSpans, "aws.auth.account.principal", testLogger(), testMetrics())
+	if len(records) != 1 || records[0].IAMRole != "arn:aws:iam::123:role/Nested" {
+		t.Fatalf("expected the role from the nested attribute, got %+v", records)
+	}
+	if records := parseTraces(resourceSpans, "", testLogger(), testMetrics()); len(records) != 0 {
+		t.Errorf("expected no records under the default role attribute, got %+v", records)
+	}
+}
+
+func TestParseTraces_RoleFromSpanLink(t *testing.T) {
+	m := testMetrics()
+	resourceSpans := []*tracev1.ResourceSpans{{
+		Resource: &resourcev1.Resource{},
+		ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{
+			{
+				Attributes: []*commonv1.KeyValue{
+					makeKV("aws.service", "s3"),
+					makeKV("aws.operation", "GetObject"),
+				},
+				Links: []*tracev1.Span_Link{
+					{Attributes: []*commonv1.KeyValue{makeKV("other", "x")}},
+					{Attributes: []*commonv1.KeyValue{makeKV("aws.iam.role", "arn:aws:iam::123:role/Linked")}},
+				},
+			},
+			{
+				Attributes: []*commonv1.KeyValue{
+					makeKV("aws.service", "s3"),
+					makeKV("aws.operation", "PutObject"),
+					makeKV("aws.iam.role", "arn:aws:iam::123:role/OnSpan"),
+				},
+			},
+			{
+				// No role anywhere.
+				Attributes: []*commonv1.KeyValue{
+					makeKV("aws.service", "s3"),
+					makeKV("aws.operation", "DeleteObject"),
+				},
+			},
+		}}},
+	}}
+
+	records := parseTraces(resourceSpans, "", testLogger(), m)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	if records[0].IAMRole != "arn:aws:iam::123:role/Linked" || records[1].IAMRole != "arn:aws:iam::123:role/OnSpan" {
+		t.Errorf("unexpected roles: %+v", records)
+	}
+	if got := testutil.ToFloat64(m.SpansSkipped); got != 1 {
+		t.Errorf("expected the role-less span to be skipped, got %v skipped", got)
+	}
+}
+
+func TestPrivilegeFormsAgreeAcrossSources(t *testing.T) {
+	const role = "arn:aws:iam::123:role/MyRole"
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	tests := []struct {
 		service   string
 		operation string
@@ -143,13 +230,499 @@ func TestNormalizePrivilege(t *testing.T) {
 	}{
 		{"S3", "GetObject", "s3:GetObject"},
 		{"s3", "PutObject", "s3:PutObject"},
-		{"Lambda", "Invoke", "lambda:Invoke"},
+		{"Lambda", "Invoke", "lambda:InvokeFunction"},
 		{"EC2", "DescribeInstances", "ec2:DescribeInstances"},
+		{" s3 ", "HeadObject", "s3:GetObject"},
 	}
 	for _, tt := range tests {
-		got := normalizePrivilege(tt.service, tt.operation)
-		if got != tt.expected {
-			t.Errorf("normalizePrivilege(%q, %q) = %q, want %q", tt.service, tt.operation, got, tt.expected)
+		spans := []*tracev1.ResourceSpans{{
+			Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{makeKV("aws.iam.role", role)}},
+			ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{{
+				Attributes: []*commonv1.KeyValue{makeKV("aws.service", tt.service), makeKV("aws.operation", tt.operation)},
+			}}}},
+		}}
+		line := fmt.Sprintf(`{"role":%q,"service":%q,"operation":%q}`, role, tt.service, tt.operation)
+		seedJSON := fmt.Sprintf(`[{"role":%q,"privilege":%q}]`, role, strings.TrimSpace(tt.service)+":"+tt.operation)
+
+		// Both receiver paths map privileges when buffering them.
+		var got []string
+		srv.buffer(parseTraces(spans, "", testLogger(), testMetrics()))
+		lines, _ := parseUsageLines([]byte(line), time.Now(), testLogger())
+		srv.buffer(lines)
+		received, _ := srv.Collect(context.Background())
+		for i, r := range received {
+			got = append(got, []string{"trace ", "jsonl "}[i]+r.Privilege)
+		}
+		seeded, err := seed.Parse(strings.NewReader(seedJSON), time.Now())
+		if err != nil {
+			t.Fatalf("seed.Parse: %v", err)
+		}
+		for _, r := range seeded {
+			got = append(got, "seed "+r.Privilege)
 		}
+
+		want := []string{"trace " + tt.expected, "jsonl " + tt.expected, "seed " + tt.expected}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s/%s: got %v, want %v", tt.service, tt.operation, got, want)
+		}
+	}
+}
+
+func TestUserSDKMappingOverridesBuiltin(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{
+		SDKMappings: map[string]string{"lambda:Invoke": "lambda:InvokeAsync"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _ := parseUsageLines([]byte(`{"role":"MyRole","service":"Lambda","operation":"Invoke"}`), time.Now(), testLogger())
+	srv.buffer(lines)
+	received, _ := srv.Collect(context.Background())
+	if len(received) != 1 || received[0].Privilege != "lambda:InvokeAsync" {
+		t.Errorf("got %+v, want lambda:Invoke mapped to lambda:InvokeAsync by the user mapping", received)
+	}
+}
+
+func TestRateLimit_RejectsBurst(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{
+		RateLimit: RateLimit{RequestsPerSecond: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/traces", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		rec := httptest.NewRecorder()
+		srv.srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 within burst, got %d", i, rec.Code)
+		}
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after burst, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header on 429")
+	}
+}
+
+func TestRateLimit_PerRemoteAddr(t *testing.T) {
+	lim := newRateLimiter(RateLimit{RequestsPerSecond: 1, Burst: 1, PerRemoteAddr: true})
+
+	a := httptest.NewRequest(http.MethodPost, "/v1/traces", nil)
+	a.RemoteAddr = "10.0.0.1:1234"
+	b := httptest.NewRequest(http.MethodPost, "/v1/traces", nil)
+	b.RemoteAddr = "10.0.0.2:1234"
+
+	if ok, _ := lim.allow(a); !ok {
+		t.Fatal("first request from a should pass")
+	}
+	if ok, _ := lim.allow(a); ok {
+		t.Error("second request from a should be limited")
+	}
+	if ok, _ := lim.allow(b); !ok {
+		t.Error("b has its own bucket and should pass")
+	}
+}
+
+func TestRateLimit_DisabledByDefault(t *testing.T) {
+	if newRateLimiter(RateLimit{}) != nil {
+		t.Error("zero config should disable the limiter")
+	}
+}
+
+func TestListeningTracksServerLifecycle(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if srv.Listening() {
+		t.Fatal("server should not report listening before Start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("server never reported listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if srv.Listening() {
+		t.Error("server should not report listening after shutdown")
+	}
+}
+
+func TestCollectDrainsBufferedRecords(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	rec := storage.PrivilegeUsageRecord{IAMRole: "arn:aws:iam::123:role/MyRole", Privilege: "s3:GetObject"}
+	if !srv.buffer([]storage.PrivilegeUsageRecord{rec}) {
+		t.Fatal("buffer rejected a record while empty")
+	}
+
+	got, err := srv.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(got) != 1 || got[0].Privilege != "s3:GetObject" {
+		t.Fatalf("Collect() = %v, want the buffered record", got)
+	}
+	if got, _ := srv.Collect(context.Background()); len(got) != 0 {
+		t.Errorf("second Collect() = %v, want nothing", got)
+	}
+
+	if srv.buffer(make([]storage.PrivilegeUsageRecord, maxPendingRecords+1)) {
+		t.Error("buffer accepted more than maxPendingRecords")
+	}
+}
+
+func TestBufferStoresCanonicalPrivileges(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{
+		SDKMappings: map[string]string{"custom:Op": "custom:Action"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	role := "arn:aws:iam::123:role/MyRole"
+	now := time.Now().UTC()
+	srv.buffer([]storage.PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: role, Privilege: "lambda:Invoke"},
+		{Timestamp: now, IAMRole: role, Privilege: "lambda:InvokeFunction"},
+		{Timestamp: now, IAMRole: role, Privilege: "custom:Op"},
+	})
+
+	records, err := srv.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	defer db.Close()
+	if err := db.BatchRecordPrivilegeUsage(context.Background(), records); err != nil {
+		t.Fatalf("BatchRecordPrivilegeUsage: %v", err)
+	}
+
+	seen, err := db.GetPrivilegeLastSeenForRole(context.Background(), role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetPrivilegeLastSeenForRole: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("stored privileges = %v, want lambda:InvokeFunction and custom:Action only", seen)
+	}
+	for _, p := range []string{"lambda:InvokeFunction", "custom:Action"} {
+		if _, ok := seen[p]; !ok {
+			t.Errorf("stored privileges = %v, missing %s", seen, p)
+		}
+	}
+}
+
+func TestParseTraces_RejectsOversizedComponents(t *testing.T) {
+	m := testMetrics()
+	resourceSpans := []*tracev1.ResourceSpans{{
+		Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+			makeKV("aws.iam.role", "arn:aws:iam::123:role/MyRole"),
+		}},
+		ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{
+			{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.service", "s3"),
+				makeKV("aws.operation", strings.Repeat("A", maxPrivilegeComponentLen+1)),
+			}},
+			{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.service", "s3"),
+				makeKV("aws.operation", "   "),
+			}},
+		}}},
+	}}
+
+	if records := parseTraces(resourceSpans, "", testLogger(), m); len(records) != 0 {
+		t.Errorf("expected oversized and blank operations to be skipped, got %+v", records)
+	}
+	if got := testutil.ToFloat64(m.SpansSkipped); got != 2 {
+		t.Errorf("expected 2 skipped spans, got %v", got)
+	}
+}
+
+func TestParseTraces_CapturesS3Bucket(t *testing.T) {
+	resourceSpans := []*tracev1.ResourceSpans{{
+		Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+			makeKV("aws.iam.role", "arn:aws:iam::123:role/MyRole"),
+		}},
+		ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{
+			{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.service", "s3"),
+				makeKV("aws.operation", "GetObject"),
+				makeKV("aws.s3.bucket", " reports "),
+			}},
+			{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.service", "s3"),
+				makeKV("aws.operation", "GetObject"),
+				makeKV("aws.s3.bucket", strings.Repeat("b", maxResourceLen+1)),
+			}},
+		}}},
+	}}
+
+	records := parseTraces(resourceSpans, "", testLogger(), testMetrics())
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Resource != "reports" {
+		t.Errorf("expected bucket resource %q, got %q", "reports", records[0].Resource)
+	}
+	if records[1].Resource != "" {
+		t.Errorf("expected oversized bucket to be dropped, got %d chars", len(records[1].Resource))
+	}
+}
+
+func TestParseTraces_CapturesSessionPolicy(t *testing.T) {
+	span := func(attrs ...*commonv1.KeyValue) *tracev1.Span {
+		return &tracev1.Span{Attributes: append([]*commonv1.KeyValue{
+			makeKV("aws.service", "s3"),
+			makeKV("aws.operation", "GetObject"),
+		}, attrs...)}
+	}
+	resourceSpans := []*tracev1.ResourceSpans{
+		{
+			Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.iam.role", "arn:aws:iam::123:role/Plain"),
+			}},
+			ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{
+				span(),
+				span(makeKV("aws.iam.session_policy_hash", "sha256:ab12")),
+				span(makeKV("aws.iam.session_policy", "  ")),
+			}}},
+		},
+		{
+			// Set on the resource, the session policy covers every span.
+			Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+				makeKV("aws.iam.role", "arn:aws:iam::123:role/Scoped"),
+				makeKV("aws.iam.session_policy", `{"Version":"2012-10-17","Statement":[]}`),
+			}},
+			ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{span()}}},
+		},
+	}
+
+	records := parseTraces(resourceSpans, "", testLogger(), testMetrics())
+	var got []bool
+	for _, r := range records {
+		got = append(got, r.SessionPolicy)
+	}
+	if want := []bool{false, true, false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SessionPolicy = %v, want %v", got, want)
+	}
+}
+
+func FuzzParseTraces(f *testing.F) {
+	f.Add("arn:aws:iam::123:role/MyRole", "S3", "GetObject", uint64(0))
+	f.Add(" ", "s3", " ", uint64(1))
+	f.Add("role", "ＳＥＲＶＩＣＥ", "操作", uint64(1<<63))
+	f.Add("role", strings.Repeat("s", 200), "Op", uint64(42))
+
+	f.Fuzz(func(t *testing.T, role, service, operation string, ts uint64) {
+		resourceSpans := []*tracev1.ResourceSpans{{
+			Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{makeKV("aws.iam.role", role)}},
+			ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{{
+				StartTimeUnixNano: ts,
+				Attributes: []*commonv1.KeyValue{
+					makeKV("aws.service", service),
+					makeKV("aws.operation", operation),
+				},
+			}}}},
+		}}
+
+		for _, r := range parseTraces(resourceSpans, "", testLogger(), testMetrics()) {
+			if strings.TrimSpace(r.IAMRole) == "" {
+				t.Fatalf("emitted record with empty role: %+v", r)
+			}
+			svc, op, ok := strings.Cut(r.Privilege, ":")
+			if !ok || strings.TrimSpace(svc) == "" || strings.TrimSpace(op) == "" {
+				t.Fatalf("emitted record with empty privilege component: %+v", r)
+			}
+			if len(op) > maxPrivilegeComponentLen {
+				t.Fatalf("emitted operation longer than %d: %d", maxPrivilegeComponentLen, len(op))
+			}
+		}
+	})
+}
+
+func TestUsageEndpoint(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{EnableJSONL: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	body := strings.Join([]string{
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"S3","operation":"GetObject","ts":1700000000}`,
+		``,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"dynamodb","operation":"Query"}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3"`,
+		`not json`,
+		`{"role":"arn:aws:iam::123:role/MyRole","operation":"PutObject"}`,
+		`{"service":"s3","operation":"PutObject"}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"PutObject","ts":0}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"PutObject","ts":-5}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"PutObject","ts":1700000000000}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"PutObject","ts":1e300}`,
+		`{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"PutObject","ts":"yesterday"}`,
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/v1/usage", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"accepted":2,"skipped":9}` {
+		t.Errorf("response = %s, want 2 accepted and 9 skipped", got)
+	}
+
+	records, _ := srv.Collect(context.Background())
+	if len(records) != 2 {
+		t.Fatalf("expected 2 buffered records, got %d", len(records))
+	}
+	if records[0].Privilege != "s3:GetObject" || !records[0].Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("first record = %+v, want s3:GetObject at the given ts", records[0])
+	}
+	if records[1].Privilege != "dynamodb:Query" || records[1].Timestamp.IsZero() {
+		t.Errorf("second record = %+v, want dynamodb:Query stamped on receipt", records[1])
+	}
+}
+
+func TestUsageEndpointDisabledByDefault(t *testing.T) {
+	srv, err := New("127.0.0.1:0", testLogger(), testMetrics(), Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/usage", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when enable_jsonl is off, got %d", rec.Code)
+	}
+}
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "shinkai.sock")
+	srv, err := New("unix://"+sock, testLogger(), testMetrics(), Options{EnableJSONL: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("server never reported listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	body := `{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"GetObject"}`
+	resp, err := client.Post("http://receiver/v1/usage", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST over unix socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if records, _ := srv.Collect(context.Background()); len(records) != 1 || records[0].Privilege != "s3:GetObject" {
+		t.Errorf("Collect() = %v, want the posted record", records)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("expected the socket file to be removed on shutdown, stat error: %v", err)
+	}
+}
+
+func TestShutdownCutsOffStuckRequest(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "shinkai.sock")
+	srv, err := New("unix://"+sock, testLogger(), testMetrics(), Options{EnableJSONL: true, ShutdownTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("server never reported listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	body := `{"role":"arn:aws:iam::123:role/MyRole","service":"s3","operation":"GetObject"}`
+	resp, err := client.Post("http://receiver/v1/usage", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+
+	// A request whose body never finishes arriving stays in flight.
+	stuck, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuck.Close()
+	fmt.Fprintf(stuck, "POST /v1/usage HTTP/1.1\r\nHost: receiver\r\nContent-Length: 1000\r\n\r\n{")
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not complete within its timeout")
+	}
+
+	if records, _ := srv.Collect(context.Background()); len(records) != 1 {
+		t.Errorf("Collect() = %v, want the record buffered before shutdown", records)
+	}
+	if srv.buffer([]storage.PrivilegeUsageRecord{{IAMRole: "arn:aws:iam::123:role/MyRole", Privilege: "s3:GetObject"}}) {
+		t.Error("buffer accepted a record after shutdown cut off in-flight requests")
+	}
+}
+
+func TestNewRejectsEmptySocketPath(t *testing.T) {
+	if _, err := New("unix://", testLogger(), testMetrics(), Options{}); err == nil {
+		t.Error("expected an error for a unix endpoint without a path")
 	}
 }
@@ -2,11 +2,16 @@ package receiver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/protobuf/encoding/protojson"
@@ -14,6 +19,7 @@ import (
 
 	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
 	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
 	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
 )
@@ -21,34 +27,97 @@ import (
 // maxBodyBytes is the maximum accepted size for an OTLP request body (32 MiB).
 const maxBodyBytes = 32 << 20
 
-// Server is the OTLP/HTTP receiver.
+// maxPendingRecords bounds the records buffered between Collect calls. When
+// full the receiver answers 503 so exporters retry instead of losing data.
+const maxPendingRecords = 100000
+
+// DefaultShutdownTimeout is how long Start waits for in-flight requests on
+// shutdown when Options.ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Options configure optional receiver behavior.
+type Options struct {
+	RateLimit RateLimit
+	// EnableJSONL serves the /v1/usage JSON-lines endpoint alongside OTLP.
+	EnableJSONL bool
+	// SDKMappings are extra "service:Op" → "service:IamAction" translations
+	// merged over the built-in table, applied before records are stored.
+	SDKMappings map[string]string
+	// RoleAttribute is the dotted attribute path naming the IAM role.
+	// Empty means DefaultRoleAttribute.
+	RoleAttribute string
+	// ShutdownTimeout bounds how long Start waits for in-flight requests
+	// once its context is cancelled; requests still running are then cut
+	// off. Zero means DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+}
+
+// Server is the OTLP/HTTP receiver. It implements sources.UsageSource:
+// parsed records are buffered and handed out by Collect, and taken back by
+// Requeue when they fail to write.
 type Server struct {
-	db      *storage.DB
 	log     *slog.Logger
 	metrics *metrics.Metrics
+	limiter *rateLimiter
+	// mappings canonicalize SDK operation names to IAM actions at write
+	// time, so "lambda:Invoke" and "lambda:InvokeFunction" share one row.
+	mappings correlation.SDKMappings
+	roleAttr string
+	// network is "tcp", or "unix" with srv.Addr a socket path.
+	network string
 	srv     *http.Server
+	// shutdownTimeout bounds the graceful part of shutdown.
+	shutdownTimeout time.Duration
+	// listening is set while the server socket is bound, for readiness checks.
+	listening atomic.Bool
+
+	mu      sync.Mutex
+	pending []storage.PrivilegeUsageRecord
+	// closed is set when shutdown cuts off in-flight requests; their
+	// records are refused rather than buffered after the final flush.
+	closed bool
 }
 
-// New creates a new receiver Server.
-func New(endpoint string, db *storage.DB, log *slog.Logger, m *metrics.Metrics) (*Server, error) {
-	host, port, err := net.SplitHostPort(endpoint)
-	if err != nil {
-		return nil, fmt.Errorf("invalid OTel endpoint %q: %w", endpoint, err)
+// New creates a new receiver Server. The endpoint is host:port, or
+// unix:///path/to.sock to listen on a unix domain socket instead, for
+// exporters on the same host without exposing a TCP port.
+func New(endpoint string, log *slog.Logger, m *metrics.Metrics, opts Options) (*Server, error) {
+	network, addr := "tcp", ""
+	if path, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("invalid OTel endpoint %q: missing socket path", endpoint)
+		}
+		network, addr = "unix", path
+	} else {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTel endpoint %q: %w", endpoint, err)
+		}
+		addr = net.JoinHostPort(host, port)
 	}
-	addr := net.JoinHostPort(host, port)
 
 	s := &Server{
-		db:      db,
-		log:     log,
-		metrics: m,
+		log:      log,
+		metrics:  m,
+		limiter:  newRateLimiter(opts.RateLimit),
+		mappings: correlation.NewSDKMappings(opts.SDKMappings, log),
+		roleAttr: opts.RoleAttribute,
+		network:  network,
+	}
+	s.shutdownTimeout = opts.ShutdownTimeout
+	if s.shutdownTimeout <= 0 {
+		s.shutdownTimeout = DefaultShutdownTimeout
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/traces", s.handleTraces)
+	if opts.EnableJSONL {
+		mux.HandleFunc("/v1/usage", s.handleUsage)
+	}
 
 	s.srv = &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           s.rateLimited(mux),
 		ReadHeaderTimeout: 10 * time.Second,  // abort if headers arrive slowly
 		ReadTimeout:       30 * time.Second,  // abort if full request takes too long
 		WriteTimeout:      30 * time.Second,  // abort if response takes too long
@@ -57,13 +126,28 @@ func New(endpoint string, db *storage.DB, log *slog.Logger, m *metrics.Metrics)
 	return s, nil
 }
 
-// Start begins listening and serving. It blocks until the context is cancelled.
+// Start begins listening and serving. It blocks until the context is
+// cancelled, then waits up to the shutdown timeout for in-flight requests
+// before closing their connections. Records buffered by then remain for
+// Collect; later ones are refused. A unix socket file is removed when its
+// listener closes on shutdown.
 func (s *Server) Start(ctx context.Context) error {
-	s.log.Info("OTLP receiver listening", "addr", s.srv.Addr)
+	if s.network == "unix" {
+		if err := removeStaleSocket(s.srv.Addr); err != nil {
+			return fmt.Errorf("receiver: %w", err)
+		}
+	}
+	ln, err := net.Listen(s.network, s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("receiver: %w", err)
+	}
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+	s.log.Info("OTLP receiver listening", "addr", ln.Addr().String())
 
 	errCh := make(chan error, 1)
 	go func() {
-		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			errCh <- err
 		}
 	}()
@@ -73,8 +157,84 @@ func (s *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("receiver: %w", err)
 	case <-ctx.Done():
 		s.log.Info("shutting down OTLP receiver")
-		return s.srv.Shutdown(context.Background())
+		return s.shutdown()
+	}
+}
+
+// shutdown stops the server, giving in-flight requests the shutdown timeout
+// to finish before their connections are closed.
+func (s *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	s.log.Warn("OTLP receiver shutdown timed out, closing in-flight requests", "timeout", s.shutdownTimeout)
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.srv.Close()
+}
+
+// Name implements sources.UsageSource.
+func (s *Server) Name() string { return "otlp" }
+
+// Collect implements sources.UsageSource, returning the records received
+// since the previous call.
+func (s *Server) Collect(ctx context.Context) ([]storage.PrivilegeUsageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := s.pending
+	s.pending = nil
+	return records, nil
+}
+
+// Requeue implements sources.Requeuer, putting records that failed to write
+// back ahead of those received since, for the next Collect.
+func (s *Server) Requeue(records []storage.PrivilegeUsageRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(records[:len(records):len(records)], s.pending...)
+}
+
+// buffer maps each record's privilege to its IAM action name and queues the
+// records for the next Collect. It reports false, queueing nothing, when the
+// buffer is full or shutdown has cut off in-flight requests.
+func (s *Server) buffer(records []storage.PrivilegeUsageRecord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.pending)+len(records) > maxPendingRecords {
+		return false
 	}
+	for i := range records {
+		records[i].Privilege = s.mappings.Map(records[i].Privilege)
+	}
+	s.pending = append(s.pending, records...)
+	return true
+}
+
+// removeStaleSocket removes a socket left at path by a receiver that did not
+// shut down cleanly. A socket still accepting connections, and anything that
+// is not a socket, is left for net.Listen to fail on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("removing stale socket: %w", err)
+	}
+	return nil
+}
+
+// Listening reports whether the receiver is currently accepting connections.
+func (s *Server) Listening() bool {
+	return s.listening.Load()
 }
 
 func (s *Server) handleTraces(w http.ResponseWriter, r *http.Request) {
@@ -114,18 +274,19 @@ func (s *Server) handleTraces(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	records := parseTraces(req.GetResourceSpans(), s.log, s.metrics)
+	records := parseTraces(req.GetResourceSpans(), s.roleAttr, s.log, s.metrics)
 	if len(records) == 0 {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := s.db.BatchRecordPrivilegeUsage(r.Context(), records); err != nil {
-		s.log.Error("failed to record privilege usage", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if !s.buffer(records) {
+		s.log.Warn("receive buffer full, asking exporter to retry", "records", len(records))
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "receive buffer full", http.StatusServiceUnavailable)
 		return
 	}
 
-	s.log.Debug("recorded privilege usage from spans", "count", len(records))
+	s.log.Debug("buffered privilege usage from spans", "count", len(records))
 	w.WriteHeader(http.StatusOK)
 }
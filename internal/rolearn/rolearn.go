@@ -0,0 +1,93 @@
+// Package rolearn parses the identifiers an IAM role can appear under in
+// traces and scrapes (IAM role ARNs, STS assumed-role ARNs or bare role names)
+// into a form that can be compared across sources. Parsing is partition-aware,
+// so GovCloud ("aws-us-gov") and China ("aws-cn") ARNs round-trip unchanged.
+package rolearn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Role is a parsed role identifier.
+type Role struct {
+	Partition string
+	// Account is empty when the identifier was a bare role name.
+	Account string
+	// Name is the role name without any IAM path.
+	Name string
+}
+
+// Parse extracts the partition, account and role name from an IAM role ARN
+// ("arn:aws:iam::123456789012:role/path/Name") or an STS assumed-role ARN
+// ("arn:aws:sts::123456789012:assumed-role/Name/session"). Anything that is
+// not an ARN is treated as a bare role name, with any "role/" or path prefix
+// stripped.
+func Parse(s string) Role {
+	if a, err := arn.Parse(s); err == nil {
+		switch {
+		case a.Service == "iam" && strings.HasPrefix(a.Resource, "role/"):
+			return Role{Partition: a.Partition, Account: a.AccountID, Name: lastSegment(a.Resource)}
+		case a.Service == "sts" && strings.HasPrefix(a.Resource, "assumed-role/"):
+			// assumed-role/<name>/<session>: the name is the second segment.
+			parts := strings.SplitN(a.Resource, "/", 3)
+			return Role{Partition: a.Partition, Account: a.AccountID, Name: parts[1]}
+		}
+	}
+	return Role{Name: lastSegment(s)}
+}
+
+// ARN returns the IAM role ARN in the role's own partition, or "" for a bare
+// name with no account. The IAM path is not recoverable from an STS ARN, so
+// the result never includes one.
+func (r Role) ARN() string {
+	if r.Account == "" {
+		return ""
+	}
+	partition := r.Partition
+	if partition == "" {
+		partition = "aws"
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, r.Account, r.Name)
+}
+
+// Normalize converts an STS assumed-role ARN into the IAM role ARN of the
+// same partition and account. Other identifiers are returned unchanged.
+func Normalize(s string) string {
+	if a, err := arn.Parse(s); err == nil && a.Service == "sts" && strings.HasPrefix(a.Resource, "assumed-role/") {
+		return Parse(s).ARN()
+	}
+	return s
+}
+
+// Key returns "account/name", the identity used to match a role across
+// sources. Bare names have no account and return just the name.
+func (r Role) Key() string {
+	if r.Account == "" {
+		return r.Name
+	}
+	return r.Account + "/" + r.Name
+}
+
+func lastSegment(s string) string {
+	if idx := strings.LastIndex(s, "/"); idx != -1 {
+		return s[idx+1:]
+	}
+	return s
+}
+
+// ValidAccount reports whether s is a well-formed AWS account ID: twelve
+// digits.
+func ValidAccount(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package rolearn
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Role
+	}{
+		{"arn:aws:iam::123456789012:role/AppRole", Role{Partition: "aws", Account: "123456789012", Name: "AppRole"}},
+		{"arn:aws:iam::123456789012:role/service/team/AppRole", Role{Partition: "aws", Account: "123456789012", Name: "AppRole"}},
+		{"arn:aws:sts::123456789012:assumed-role/AppRole/session-1", Role{Partition: "aws", Account: "123456789012", Name: "AppRole"}},
+		{"arn:aws-us-gov:iam::123456789012:role/AppRole", Role{Partition: "aws-us-gov", Account: "123456789012", Name: "AppRole"}},
+		{"arn:aws-cn:sts::123456789012:assumed-role/AppRole/i-0abc", Role{Partition: "aws-cn", Account: "123456789012", Name: "AppRole"}},
+		{"AppRole", Role{Name: "AppRole"}},
+		{"role/AppRole", Role{Name: "AppRole"}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.input); got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	if got := Parse("arn:aws:iam::111111111111:role/AppRole").Key(); got != "111111111111/AppRole" {
+		t.Errorf("unexpected key %q", got)
+	}
+	if got := Parse("AppRole").Key(); got != "AppRole" {
+		t.Errorf("unexpected key for bare name %q", got)
+	}
+}
+
+func TestNormalizePreservesPartition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"arn:aws:sts::123456789012:assumed-role/AppRole/s", "arn:aws:iam::123456789012:role/AppRole"},
+		{"arn:aws-us-gov:sts::123456789012:assumed-role/AppRole/s", "arn:aws-us-gov:iam::123456789012:role/AppRole"},
+		{"arn:aws-cn:sts::123456789012:assumed-role/AppRole/s", "arn:aws-cn:iam::123456789012:role/AppRole"},
+		{"arn:aws-cn:iam::123456789012:role/AppRole", "arn:aws-cn:iam::123456789012:role/AppRole"},
+		{"AppRole", "AppRole"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.input); got != tt.expected {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestValidAccount(t *testing.T) {
+	for s, want := range map[string]bool{
+		"123456789012":  true,
+		"12345678901":   false,
+		"1234567890123": false,
+		"12345678901a":  false,
+		"../../../etc":  false,
+		"":              false,
+	} {
+		if got := ValidAccount(s); got != want {
+			t.Errorf("ValidAccount(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// Checkpoint records roles as ScrapeAll scrapes them, so a scrape that is
+// interrupted partway through can be resumed without scraping them again.
+type Checkpoint interface {
+	// Load returns the assignments recorded so far, keyed by role ARN.
+	Load(ctx context.Context) (map[string]RoleAssignment, error)
+	// Save records one scraped role.
+	Save(ctx context.Context, ra RoleAssignment) error
+	// Clear discards every recorded role.
+	Clear(ctx context.Context) error
+}
+
+// DBCheckpoint is a Checkpoint kept in the scrape_checkpoints table under a
+// session name. Roles recorded more than MaxAge ago are not loaded, since
+// their policies may have changed since; zero keeps them indefinitely.
+type DBCheckpoint struct {
+	DB      *storage.DB
+	Session string
+	MaxAge  time.Duration
+}
+
+func (c DBCheckpoint) Load(ctx context.Context) (map[string]RoleAssignment, error) {
+	var since time.Time
+	if c.MaxAge > 0 {
+		since = time.Now().Add(-c.MaxAge)
+	}
+	raw, err := c.DB.GetScrapeCheckpoints(ctx, c.Session, since)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]RoleAssignment, len(raw))
+	for arn, b := range raw {
+		var ra RoleAssignment
+		if err := json.Unmarshal(b, &ra); err != nil {
+			return nil, fmt.Errorf("decoding scrape checkpoint for %s: %w", arn, err)
+		}
+		done[arn] = ra
+	}
+	return done, nil
+}
+
+func (c DBCheckpoint) Save(ctx context.Context, ra RoleAssignment) error {
+	b, err := json.Marshal(ra)
+	if err != nil {
+		return fmt.Errorf("encoding scrape checkpoint for %s: %w", ra.RoleARN, err)
+	}
+	return c.DB.SaveScrapeCheckpoint(ctx, c.Session, ra.RoleARN, b)
+}
+
+func (c DBCheckpoint) Clear(ctx context.Context) error {
+	return c.DB.DeleteScrapeCheckpoints(ctx, c.Session)
+}
@@ -2,10 +2,14 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
@@ -22,11 +26,153 @@ type RoleAssignment struct {
 	// Privileges is the deduplicated set of allowed IAM actions.
 	// Wildcards like "s3:*" or "*" are stored literally.
 	Privileges []string
+	// Policies records which policy granted which actions (provenance).
+	Policies []PolicySource
+	// ReadOnly marks a service-linked role. AWS manages its policies, so it
+	// is reported for information only and never rewritten.
+	ReadOnly bool
+	// TrustedPrincipals are the principals the role's trust policy allows
+	// to assume it. Nil when the trust policy could not be read.
+	TrustedPrincipals []Principal
+	// CreatedAt is when the role was created in IAM; zero when unknown.
+	CreatedAt time.Time
+	// PolicyOverlaps maps each action more than one of Policies grants to
+	// those policies, as found by FindOverlappingPolicies when the role is
+	// scraped. Nil when no two policies share an action.
+	PolicyOverlaps map[string][]string
+}
+
+// PolicySource is a policy attached to a role and the actions it allows.
+type PolicySource struct {
+	// ARN is set for managed policies; inline policies only have a Name.
+	ARN     string
+	Name    string
+	Inline  bool
+	Actions []string
+	// Suppressed are the Actions granted only by intentional statements
+	// (see Options.IgnoreSidPrefix).
+	Suppressed []string
+	// Constrained are the Actions granted only by statements scoped to
+	// specific resources rather than "*".
+	Constrained []string
+	// PreviousVersion and PreviousActions describe the managed policy's most
+	// recent non-default version, with Options.CompareVersions set. They are
+	// empty when the policy has no other version.
+	PreviousVersion string
+	PreviousActions []string
+}
+
+// ManagedPolicyARNs returns the ARNs of the role's attached managed policies.
+func (ra RoleAssignment) ManagedPolicyARNs() []string {
+	var arns []string
+	for _, p := range ra.Policies {
+		if !p.Inline {
+			arns = append(arns, p.ARN)
+		}
+	}
+	return arns
+}
+
+// SuppressedPrivileges returns the privileges that every policy granting
+// them grants only through intentional statements. A privilege also granted
+// by an ordinary statement anywhere on the role is not suppressed.
+func (ra RoleAssignment) SuppressedPrivileges() []string {
+	return ra.grantedOnly(func(p PolicySource) []string { return p.Suppressed })
+}
+
+// ConstrainedPrivileges returns the privileges that every policy granting
+// them scopes to specific resources. A privilege also granted on "*"
+// anywhere on the role is not constrained.
+func (ra RoleAssignment) ConstrainedPrivileges() []string {
+	return ra.grantedOnly(func(p PolicySource) []string { return p.Constrained })
+}
+
+// FindOverlappingPolicies maps each action one of the role's policies lists
+// that more than one of them grants to those policies, managed ones by ARN
+// and inline ones by name, in the role's policy order: candidates for
+// consolidation. Actions are compared as IAM does, ignoring case and with a
+// wildcard granting every action it matches, so a policy granting "s3:*"
+// overlaps one granting "s3:GetObject" on s3:GetObject. Each action is
+// reported as first written. It returns nil when no two policies share an
+// action.
+func FindOverlappingPolicies(ra RoleAssignment) map[string][]string {
+	var actions []string
+	written := make(map[string]bool)
+	for _, p := range ra.Policies {
+		for _, a := range p.Actions {
+			if key := strings.ToLower(a); !written[key] {
+				written[key] = true
+				actions = append(actions, a)
+			}
+		}
+	}
+	var overlaps map[string][]string
+	for _, a := range actions {
+		var ids []string
+		for _, p := range ra.Policies {
+			if !grantsAny(p.Actions, a) {
+				continue
+			}
+			id := p.ARN
+			if p.Inline || id == "" {
+				id = p.Name
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) < 2 {
+			continue
+		}
+		if overlaps == nil {
+			overlaps = make(map[string][]string)
+		}
+		overlaps[a] = ids
+	}
+	return overlaps
+}
+
+// grantsAny reports whether any of granted, each an action or a wildcard
+// pattern, grants action, ignoring case.
+func grantsAny(granted []string, action string) bool {
+	action = strings.ToLower(action)
+	for _, g := range granted {
+		if g == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(g), action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// grantedOnly returns the privileges that every policy granting them lists
+// in subset, a subset of its Actions.
+func (ra RoleAssignment) grantedOnly(subset func(PolicySource) []string) []string {
+	only := make(map[string]bool)
+	for _, p := range ra.Policies {
+		in := make(map[string]bool)
+		for _, a := range subset(p) {
+			in[a] = true
+		}
+		for _, a := range p.Actions {
+			if o, ok := only[a]; !ok || o {
+				only[a] = in[a]
+			}
+		}
+	}
+	var out []string
+	for _, a := range ra.Privileges {
+		if only[a] {
+			out = append(out, a)
+		}
+	}
+	return out
 }
 
 // iamClient is the subset of the AWS IAM client we use (for easy testing).
 type iamClient interface {
 	ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error)
+	GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
 	ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error)
 	GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error)
 	ListPolicyVersions(ctx context.Context, params *iam.ListPolicyVersionsInput, optFns ...func(*iam.Options)) (*iam.ListPolicyVersionsOutput, error)
@@ -34,45 +180,208 @@ type iamClient interface {
 	GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error)
 }
 
+// ScrapeError records a role that could not be scraped and was left out of
+// the returned assignments.
+type ScrapeError struct {
+	RoleName string
+	RoleARN  string
+	Err      error
+}
+
+func (e ScrapeError) Error() string {
+	return fmt.Sprintf("role %s: %v", e.RoleName, e.Err)
+}
+
+func (e ScrapeError) Unwrap() error { return e.Err }
+
+// ErrCredentialsExpired is returned by ScrapeAll when the AWS credentials
+// expire partway through. The scrape is aborted instead of skipping the
+// remaining roles, since a truncated assignment set yields wrong "unused"
+// conclusions.
+var ErrCredentialsExpired = errors.New("AWS credentials expired")
+
+// ErrRoleNotFound is returned by ScrapeSingleRole when IAM has no role with
+// the requested name.
+var ErrRoleNotFound = errors.New("role not found in IAM")
+
+// ErrAccessDenied wraps AWS API errors refusing an IAM call for lack of
+// permission. The underlying API error stays reachable with errors.As.
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrThrottled wraps AWS API errors rejecting an IAM call for exceeding the
+// request rate, which are worth retrying later. The underlying API error
+// stays reachable with errors.As.
+var ErrThrottled = errors.New("request throttled")
+
+// PolicyParseError reports a policy document that could not be parsed.
+// PolicyName is always set; PolicyARN is empty for inline policies.
+type PolicyParseError struct {
+	PolicyARN  string
+	PolicyName string
+	Err        error
+}
+
+func (e *PolicyParseError) Error() string {
+	policy := e.PolicyARN
+	if policy == "" {
+		policy = e.PolicyName
+	}
+	return fmt.Sprintf("policy %s: %v", policy, e.Err)
+}
+
+func (e *PolicyParseError) Unwrap() error { return e.Err }
+
+// classifyAPIError wraps err in ErrAccessDenied or ErrThrottled when it is an
+// AWS API error of that kind, and returns it unchanged otherwise.
+func classifyAPIError(err error) error {
+	var apiErr interface{ ErrorCode() string }
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	}
+	return err
+}
+
+// isExpiredCredentials reports whether err is an AWS API error for an
+// expired session token.
+func isExpiredCredentials(err error) bool {
+	var apiErr interface{ ErrorCode() string }
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ExpiredToken", "ExpiredTokenException":
+		return true
+	}
+	return false
+}
+
+// Options tune how the Scraper interprets policies.
+type Options struct {
+	// StrictDenySplit expands allowed wildcards via the embedded action
+	// catalog when a specific action inside them is denied.
+	StrictDenySplit bool
+	// Timeout bounds a whole ScrapeAll call. Zero means no limit beyond the
+	// caller's context.
+	Timeout time.Duration
+	// IgnoreSidPrefix marks Allow statements whose Sid starts with it as
+	// intentional grants, reported in PolicySource.Suppressed. Empty
+	// disables it.
+	IgnoreSidPrefix string
+	// IncludeServiceLinked scrapes service-linked roles too, marked
+	// ReadOnly, instead of skipping them.
+	IncludeServiceLinked bool
+	// Checkpoint, when set, records each role ScrapeAll scrapes and is
+	// cleared once the scrape completes.
+	Checkpoint Checkpoint
+	// Resume makes ScrapeAll reuse the roles recorded in Checkpoint by an
+	// interrupted scrape instead of discarding them.
+	Resume bool
+	// CompareVersions also fetches the previous version of each managed
+	// policy, reported in PolicySource.PreviousActions.
+	CompareVersions bool
+}
+
 // Scraper fetches IAM role assignments.
 type Scraper struct {
 	client iamClient
 	log    *slog.Logger
+	opts   Options
+	// pageBackoff overrides defaultPageBackoff when positive.
+	pageBackoff time.Duration
+	// outOfScope are the ARNs of the roles the last ScrapeAll listed but
+	// did not scrape.
+	outOfScope []string
 }
 
 // New creates a Scraper with the given AWS config.
-func New(cfg aws.Config, log *slog.Logger) *Scraper {
+func New(cfg aws.Config, log *slog.Logger, opts Options) *Scraper {
 	return &Scraper{
 		client: iam.NewFromConfig(cfg),
 		log:    log,
+		opts:   opts,
+	}
+}
+
+func (s *Scraper) parseOptions() parseOptions {
+	return parseOptions{
+		strictDenySplit: s.opts.StrictDenySplit,
+		ignoreSidPrefix: s.opts.IgnoreSidPrefix,
 	}
 }
 
 // ScrapeAll fetches all customer-managed roles and their privileges concurrently.
 // Service-linked roles (path prefix /aws-service-role/) are skipped — they are
-// managed by AWS and cannot be modified.
+// managed by AWS and cannot be modified — unless Options.IncludeServiceLinked
+// is set, in which case they are returned marked ReadOnly.
 // Both attached managed policies and inline role policies are collected.
-func (s *Scraper) ScrapeAll(ctx context.Context) ([]RoleAssignment, error) {
+// Roles that fail to scrape are skipped and reported in the returned
+// ScrapeError slice so callers can tell a partial scrape from a complete one.
+// A list page that fails transiently is retried before the role, or the
+// whole scrape when listing roles, is given up on.
+//
+// Credentials that expire mid-scrape abort the whole scrape with
+// ErrCredentialsExpired. Refreshable providers from the default credential
+// chain (including assumed roles via stscreds) are wrapped in a
+// CredentialsCache that renews them before expiry, so this only fires for
+// static session tokens that cannot be refreshed.
+//
+// With Options.Checkpoint set, each scraped role is recorded as it completes,
+// and with Options.Resume the roles recorded by an interrupted scrape are
+// returned as recorded instead of being scraped again.
+func (s *Scraper) ScrapeAll(ctx context.Context) ([]RoleAssignment, []ScrapeError, error) {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	// parent distinguishes our own cancellation on expired credentials from
+	// the caller's context or the timeout.
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	allRoles, err := s.listAllRoles(ctx)
+	if isExpiredCredentials(err) {
+		return nil, nil, fmt.Errorf("%w while listing roles — refresh credentials and rerun: %w", ErrCredentialsExpired, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("listing roles: %w", err)
+		if ctx.Err() != nil {
+			return nil, nil, s.interrupted(ctx.Err())
+		}
+		return nil, nil, fmt.Errorf("listing roles: %w", classifyAPIError(err))
 	}
 
 	// Filter out service-linked roles.
 	roles := allRoles[:0]
+	s.outOfScope = nil
 	for _, r := range allRoles {
-		if strings.HasPrefix(aws.ToString(r.Path), "/aws-service-role/") {
+		if isServiceLinked(r) && !s.opts.IncludeServiceLinked {
 			s.log.Debug("skipping service-linked role", "role", aws.ToString(r.RoleName))
+			s.outOfScope = append(s.outOfScope, aws.ToString(r.Arn))
 			continue
 		}
 		roles = append(roles, r)
 	}
 
-	s.log.Info("scraping IAM roles", "total", len(allRoles), "customer_managed", len(roles))
+	s.log.Info("scraping IAM roles", "total", len(allRoles), "in_scope", len(roles))
+
+	done, err := s.loadCheckpoint(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	type scrapeResult struct {
-		ra  RoleAssignment
-		err error
+		role types.Role
+		ra   RoleAssignment
+		err  error
+		// resumed marks a role loaded from the checkpoint.
+		resumed bool
 	}
 
 	resultCh := make(chan scrapeResult, len(roles))
@@ -80,6 +389,10 @@ func (s *Scraper) ScrapeAll(ctx context.Context) ([]RoleAssignment, error) {
 
 	var wg sync.WaitGroup
 	for _, role := range roles {
+		if ra, ok := done[aws.ToString(role.Arn)]; ok {
+			resultCh <- scrapeResult{role: role, ra: ra, resumed: true}
+			continue
+		}
 		role := role // capture loop variable
 		wg.Add(1)
 		go func() {
@@ -88,7 +401,7 @@ func (s *Scraper) ScrapeAll(ctx context.Context) ([]RoleAssignment, error) {
 			defer func() { <-sem }() // release
 
 			ra, err := s.ScrapeRole(ctx, role)
-			resultCh <- scrapeResult{ra, err}
+			resultCh <- scrapeResult{role: role, ra: ra, err: err}
 		}()
 	}
 
@@ -99,14 +412,215 @@ func (s *Scraper) ScrapeAll(ctx context.Context) ([]RoleAssignment, error) {
 	}()
 
 	assignments := make([]RoleAssignment, 0, len(roles))
+	var skipped []ScrapeError
+	var expired error
 	for res := range resultCh {
+		if res.err == nil && !res.resumed {
+			// Checkpoint even while draining or after a timeout, so a
+			// resume need not repeat the role.
+			s.saveCheckpoint(context.WithoutCancel(parent), res.ra)
+		}
+		if expired != nil {
+			continue // drain the remaining goroutines
+		}
+		if isExpiredCredentials(res.err) {
+			expired = res.err
+			cancel()
+			continue
+		}
 		if res.err != nil {
 			s.log.Warn("failed to scrape role, skipping", "error", res.err)
+			skipped = append(skipped, ScrapeError{
+				RoleName: aws.ToString(res.role.RoleName),
+				RoleARN:  aws.ToString(res.role.Arn),
+				Err:      res.err,
+			})
 			continue
 		}
 		assignments = append(assignments, res.ra)
 	}
-	return assignments, nil
+	if expired != nil {
+		return nil, nil, fmt.Errorf("%w after scraping %d of %d roles — refresh credentials and rerun: %w",
+			ErrCredentialsExpired, len(assignments), len(roles), expired)
+	}
+	// A deadline that fires mid-scrape turns every remaining role into a
+	// ScrapeError; report the scrape as failed rather than partial.
+	if parent.Err() != nil {
+		return nil, nil, s.interrupted(parent.Err())
+	}
+	if s.opts.Checkpoint != nil {
+		if err := s.opts.Checkpoint.Clear(parent); err != nil {
+			s.log.Warn("failed to clear scrape checkpoint", "error", err)
+		}
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].RoleName < skipped[j].RoleName })
+	return assignments, skipped, nil
+}
+
+// OutOfScope returns the ARNs of the roles the last ScrapeAll listed but
+// left out as service-linked. Those roles exist in IAM, so usage observed
+// for them is not orphaned (see correlation.Engine.RunExcluding).
+func (s *Scraper) OutOfScope() []string {
+	return s.outOfScope
+}
+
+// loadCheckpoint returns the roles an interrupted scrape already recorded,
+// when resuming, by role ARN. Otherwise it discards them, so this scrape
+// starts from scratch.
+func (s *Scraper) loadCheckpoint(ctx context.Context) (map[string]RoleAssignment, error) {
+	if s.opts.Checkpoint == nil {
+		return nil, nil
+	}
+	if !s.opts.Resume {
+		if err := s.opts.Checkpoint.Clear(ctx); err != nil {
+			return nil, fmt.Errorf("clearing scrape checkpoint: %w", err)
+		}
+		return nil, nil
+	}
+	done, err := s.opts.Checkpoint.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading scrape checkpoint: %w", err)
+	}
+	if len(done) > 0 {
+		s.log.Info("resuming scrape from checkpoint", "roles_done", len(done))
+	}
+	return done, nil
+}
+
+// saveCheckpoint records ra in the checkpoint, if any. A failure only costs
+// rescraping the role on resume, so it is logged rather than returned.
+func (s *Scraper) saveCheckpoint(ctx context.Context, ra RoleAssignment) {
+	if s.opts.Checkpoint == nil {
+		return
+	}
+	if err := s.opts.Checkpoint.Save(ctx, ra); err != nil {
+		s.log.Warn("failed to save scrape checkpoint", "role", ra.RoleName, "error", err)
+	}
+}
+
+// isServiceLinked reports whether role is a service-linked role, which AWS
+// creates and manages on behalf of a service.
+func isServiceLinked(role types.Role) bool {
+	return strings.HasPrefix(aws.ToString(role.Path), "/aws-service-role/")
+}
+
+// interrupted wraps a context error from ScrapeAll with the configured timeout.
+func (s *Scraper) interrupted(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && s.opts.Timeout > 0 {
+		return fmt.Errorf("IAM scrape did not finish within %s: %w", s.opts.Timeout, err)
+	}
+	return fmt.Errorf("IAM scrape interrupted: %w", err)
+}
+
+// ScrapeSingleRole fetches one role by name and its privileges, for
+// iterating on a single role without scraping the whole account. It returns
+// ErrRoleNotFound if the role does not exist.
+func (s *Scraper) ScrapeSingleRole(ctx context.Context, roleName string) (RoleAssignment, error) {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+
+	ra, err := s.scrapeNamedRole(ctx, roleName)
+	if isExpiredCredentials(err) {
+		return RoleAssignment{}, fmt.Errorf("%w — refresh credentials and rerun: %w", ErrCredentialsExpired, err)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return RoleAssignment{}, s.interrupted(ctx.Err())
+		}
+		return RoleAssignment{}, err
+	}
+	return ra, nil
+}
+
+// ScrapeRoles fetches only the named roles, looking each up with GetRole
+// instead of listing the whole account, for targeted audits. Roles that do
+// not exist (wrapping ErrRoleNotFound) or fail to scrape are reported in the
+// returned ScrapeError slice and the rest are still returned. As in
+// ScrapeAll, expired credentials abort the whole scrape.
+func (s *Scraper) ScrapeRoles(ctx context.Context, roleNames []string) ([]RoleAssignment, []ScrapeError, error) {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type scrapeResult struct {
+		name string
+		ra   RoleAssignment
+		err  error
+	}
+
+	resultCh := make(chan scrapeResult, len(roleNames))
+	sem := make(chan struct{}, maxConcurrentRoleScrapes)
+
+	var wg sync.WaitGroup
+	for _, name := range roleNames {
+		name := name // capture loop variable
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire
+			defer func() { <-sem }() // release
+
+			ra, err := s.scrapeNamedRole(ctx, name)
+			resultCh <- scrapeResult{name, ra, err}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	assignments := make([]RoleAssignment, 0, len(roleNames))
+	var skipped []ScrapeError
+	var expired error
+	for res := range resultCh {
+		if expired != nil {
+			continue // drain the remaining goroutines
+		}
+		if isExpiredCredentials(res.err) {
+			expired = res.err
+			cancel()
+			continue
+		}
+		if res.err != nil {
+			s.log.Warn("failed to scrape listed role, skipping", "role", res.name, "error", res.err)
+			skipped = append(skipped, ScrapeError{RoleName: res.name, RoleARN: res.ra.RoleARN, Err: res.err})
+			continue
+		}
+		assignments = append(assignments, res.ra)
+	}
+	if expired != nil {
+		return nil, nil, fmt.Errorf("%w after scraping %d of %d roles — refresh credentials and rerun: %w",
+			ErrCredentialsExpired, len(assignments), len(roleNames), expired)
+	}
+	if parent.Err() != nil {
+		return nil, nil, s.interrupted(parent.Err())
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].RoleName < assignments[j].RoleName })
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].RoleName < skipped[j].RoleName })
+	return assignments, skipped, nil
+}
+
+// scrapeNamedRole looks up a role by name and scrapes it. A missing role
+// yields ErrRoleNotFound.
+func (s *Scraper) scrapeNamedRole(ctx context.Context, roleName string) (RoleAssignment, error) {
+	out, err := s.client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchEntity" {
+		return RoleAssignment{}, fmt.Errorf("%w: %s", ErrRoleNotFound, roleName)
+	}
+	if err != nil {
+		return RoleAssignment{}, fmt.Errorf("getting role %s: %w", roleName, classifyAPIError(err))
+	}
+	return s.ScrapeRole(ctx, *out.Role)
 }
 
 // ScrapeRole fetches the attached policies for a single role and returns its assignment.
@@ -115,23 +629,60 @@ func (s *Scraper) ScrapeRole(ctx context.Context, role types.Role) (RoleAssignme
 	ra := RoleAssignment{
 		RoleName: roleName,
 		RoleARN:  aws.ToString(role.Arn),
+		ReadOnly: isServiceLinked(role),
+	}
+	if role.CreateDate != nil {
+		ra.CreatedAt = *role.CreateDate
+	}
+
+	if doc := aws.ToString(role.AssumeRolePolicyDocument); doc != "" {
+		trusted, err := parseTrustPolicy(doc)
+		if err != nil {
+			s.log.Warn("failed to parse trust policy, skipping trust analysis", "role", roleName, "error", err)
+		} else {
+			ra.TrustedPrincipals = trusted
+		}
 	}
 
 	policies, err := s.listAttachedPolicies(ctx, roleName)
 	if err != nil {
-		return ra, fmt.Errorf("role %s: listing attached policies: %w", roleName, err)
+		return ra, fmt.Errorf("role %s: listing attached policies: %w", roleName, classifyAPIError(err))
 	}
 
 	seen := make(map[string]struct{})
 	for _, policy := range policies {
 		policyARN := aws.ToString(policy.PolicyArn)
-		actions, err := s.getPolicyActions(ctx, policyARN)
+		parsed, previous, err := s.getPolicy(ctx, policyARN)
+		if isExpiredCredentials(err) {
+			return ra, fmt.Errorf("role %s: policy %s: %w", roleName, policyARN, err)
+		}
 		if err != nil {
 			s.log.Warn("failed to get policy actions, skipping policy",
 				"role", roleName, "policy", policyARN, "error", err)
 			continue
 		}
-		for _, action := range actions {
+		src := PolicySource{
+			ARN:         policyARN,
+			Name:        aws.ToString(policy.PolicyName),
+			Actions:     parsed.actions,
+			Suppressed:  parsed.suppressed,
+			Constrained: parsed.constrained,
+		}
+		if s.opts.CompareVersions && previous != "" {
+			prev, err := s.getPolicyActions(ctx, policyARN, previous)
+			if isExpiredCredentials(err) {
+				return ra, fmt.Errorf("role %s: policy %s version %s: %w", roleName, policyARN, previous, err)
+			}
+			if err != nil {
+				s.log.Warn("failed to get previous policy version, skipping comparison",
+					"role", roleName, "policy", policyARN, "version", previous, "error", err)
+			} else {
+				src.PreviousVersion = previous
+				src.PreviousActions = prev.actions
+			}
+		}
+		ra.Policies = append(ra.Policies, src)
+		for _, action := range parsed.actions {
 			if _, ok := seen[action]; !ok {
 				seen[action] = struct{}{}
 				ra.Privileges = append(ra.Privileges, action)
@@ -141,26 +692,39 @@ func (s *Scraper) ScrapeRole(ctx context.Context, role types.Role) (RoleAssignme
 
 	// Collect inline (embedded) role policies using the same seen map to deduplicate.
 	inlineNames, err := s.listInlinePolicies(ctx, roleName)
+	if isExpiredCredentials(err) {
+		return ra, fmt.Errorf("role %s: listing inline policies: %w", roleName, err)
+	}
 	if err != nil {
-		s.log.Warn("failed to list inline policies, skipping", "role", roleName, "error", err)
+		s.log.Warn("failed to list inline policies, skipping", "role", roleName, "error", classifyAPIError(err))
 	} else {
 		for _, policyName := range inlineNames {
 			out, err := s.client.GetRolePolicy(ctx, &iam.GetRolePolicyInput{
 				RoleName:   aws.String(roleName),
 				PolicyName: aws.String(policyName),
 			})
+			if isExpiredCredentials(err) {
+				return ra, fmt.Errorf("role %s: inline policy %s: %w", roleName, policyName, err)
+			}
 			if err != nil {
 				s.log.Warn("failed to get inline policy, skipping",
-					"role", roleName, "policy", policyName, "error", err)
+					"role", roleName, "policy", policyName, "error", classifyAPIError(err))
 				continue
 			}
-			actions, err := parsePolicyDocument(aws.ToString(out.PolicyDocument))
+			parsed, err := parsePolicy(aws.ToString(out.PolicyDocument), s.parseOptions())
 			if err != nil {
 				s.log.Warn("failed to parse inline policy document, skipping",
-					"role", roleName, "policy", policyName, "error", err)
+					"role", roleName, "policy", policyName, "error", &PolicyParseError{PolicyName: policyName, Err: err})
 				continue
 			}
-			for _, action := range actions {
+			ra.Policies = append(ra.Policies, PolicySource{
+				Name:        policyName,
+				Inline:      true,
+				Actions:     parsed.actions,
+				Suppressed:  parsed.suppressed,
+				Constrained: parsed.constrained,
+			})
+			for _, action := range parsed.actions {
 				if _, ok := seen[action]; !ok {
 					seen[action] = struct{}{}
 					ra.Privileges = append(ra.Privileges, action)
@@ -169,6 +733,7 @@ func (s *Scraper) ScrapeRole(ctx context.Context, role types.Role) (RoleAssignme
 		}
 	}
 
+	ra.PolicyOverlaps = FindOverlappingPolicies(ra)
 	return ra, nil
 }
 
@@ -178,25 +743,21 @@ func (s *Scraper) listInlinePolicies(ctx context.Context, roleName string) ([]st
 	paginator := iam.NewListRolePoliciesPaginator(s.client, &iam.ListRolePoliciesInput{
 		RoleName: aws.String(roleName),
 	})
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, err
-		}
+	err := eachPage(ctx, s, "ListRolePolicies", paginator, func(page *iam.ListRolePoliciesOutput) {
 		names = append(names, page.PolicyNames...)
-	}
-	return names, nil
+	})
+	return names, err
 }
 
+// listAllRoles returns every role in the account.
 func (s *Scraper) listAllRoles(ctx context.Context) ([]types.Role, error) {
 	var roles []types.Role
 	paginator := iam.NewListRolesPaginator(s.client, &iam.ListRolesInput{})
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, err
-		}
+	err := eachPage(ctx, s, "ListRoles", paginator, func(page *iam.ListRolesOutput) {
 		roles = append(roles, page.Roles...)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return roles, nil
 }
@@ -206,48 +767,145 @@ func (s *Scraper) listAttachedPolicies(ctx context.Context, roleName string) ([]
 	paginator := iam.NewListAttachedRolePoliciesPaginator(s.client, &iam.ListAttachedRolePoliciesInput{
 		RoleName: aws.String(roleName),
 	})
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, err
-		}
+	err := eachPage(ctx, s, "ListAttachedRolePolicies", paginator, func(page *iam.ListAttachedRolePoliciesOutput) {
 		policies = append(policies, page.AttachedPolicies...)
+	})
+	return policies, err
+}
+
+// pageAttempts is how many times eachPage requests a page before giving up.
+const pageAttempts = 3
+
+// defaultPageBackoff is the delay before a page's first retry; it doubles on
+// each further retry.
+const defaultPageBackoff = 500 * time.Millisecond
+
+// pager is the part of the SDK's IAM paginators eachPage drives.
+type pager[T any] interface {
+	HasMorePages() bool
+	NextPage(ctx context.Context, optFns ...func(*iam.Options)) (T, error)
+}
+
+// eachPage calls add with every page of p. A page that fails transiently is
+// requested again with backoff, up to pageAttempts times: in very large
+// accounts IAM occasionally fails a page mid-listing, and the paginator
+// keeps its position, so the pages already added are not fetched again.
+// Permanent errors (see permanentPageError) are returned at once. The SDK's
+// own retries are off for these requests, so a page is requested at most
+// pageAttempts times rather than that many times the client's attempts.
+func eachPage[T any](ctx context.Context, s *Scraper, call string, p pager[T], add func(T)) error {
+	for p.HasMorePages() {
+		delay := s.pageBackoff
+		if delay <= 0 {
+			delay = defaultPageBackoff
+		}
+		for attempt := 1; ; attempt++ {
+			page, err := p.NextPage(ctx, withoutSDKRetries)
+			if err == nil {
+				add(page)
+				break
+			}
+			if attempt == pageAttempts || permanentPageError(ctx, err) {
+				return err
+			}
+			s.log.Warn("IAM page request failed, retrying", "call", call, "attempt", attempt, "retry_in", delay, "error", err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return err
+			}
+			delay *= 2
+		}
 	}
-	return policies, nil
+	return nil
 }
 
-func (s *Scraper) getPolicyActions(ctx context.Context, policyARN string) ([]string, error) {
-	// Find the default (active) version of the policy.
+// withoutSDKRetries turns off the client's retryer for one request.
+func withoutSDKRetries(o *iam.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// permanentPageError reports whether retrying a failed page request cannot
+// help: the caller gave up, the credentials expired or lack permission, or
+// the role went away.
+func permanentPageError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || isExpiredCredentials(err) || errors.Is(classifyAPIError(err), ErrAccessDenied) {
+		return true
+	}
+	var apiErr interface{ ErrorCode() string }
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchEntity"
+}
+
+// getPolicy returns the parsed default version of a managed policy, along
+// with the ID of its most recent non-default version ("" if it has none).
+func (s *Scraper) getPolicy(ctx context.Context, policyARN string) (parsedPolicy, string, error) {
 	versionsOut, err := s.client.ListPolicyVersions(ctx, &iam.ListPolicyVersionsInput{
 		PolicyArn: aws.String(policyARN),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("listing policy versions: %w", err)
+		return parsedPolicy{}, "", fmt.Errorf("listing policy versions: %w", classifyAPIError(err))
 	}
 
-	var defaultVersionID string
-	for _, v := range versionsOut.Versions {
+	// Find the default (active) version of the policy, and the one it
+	// replaced: the newest created before it. Versions created after the
+	// default, left by rolling the default back, never preceded it.
+	var def *types.PolicyVersion
+	for i, v := range versionsOut.Versions {
 		if v.IsDefaultVersion {
-			defaultVersionID = aws.ToString(v.VersionId)
+			def = &versionsOut.Versions[i]
 			break
 		}
 	}
-	if defaultVersionID == "" {
-		return nil, fmt.Errorf("no default version found for policy %s", policyARN)
+	if def == nil {
+		return parsedPolicy{}, "", fmt.Errorf("no default version found for policy %s", policyARN)
+	}
+	defaultVersionID := aws.ToString(def.VersionId)
+	var previous *types.PolicyVersion
+	for i, v := range versionsOut.Versions {
+		created := aws.ToTime(v.CreateDate)
+		if v.IsDefaultVersion || !created.Before(aws.ToTime(def.CreateDate)) {
+			continue
+		}
+		if previous == nil || created.After(aws.ToTime(previous.CreateDate)) {
+			previous = &versionsOut.Versions[i]
+		}
+	}
+	var previousVersionID string
+	if previous != nil {
+		previousVersionID = aws.ToString(previous.VersionId)
 	}
 
+	parsed, err := s.getPolicyActions(ctx, policyARN, defaultVersionID)
+	if err != nil {
+		return parsedPolicy{}, "", err
+	}
+	return parsed, previousVersionID, nil
+}
+
+// policyNameFromARN returns the name of the managed policy with the given
+// ARN, its last path segment.
+func policyNameFromARN(policyARN string) string {
+	return policyARN[strings.LastIndex(policyARN, "/")+1:]
+}
+
+// getPolicyActions fetches and parses one version of a managed policy.
+func (s *Scraper) getPolicyActions(ctx context.Context, policyARN, versionID string) (parsedPolicy, error) {
 	versionOut, err := s.client.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
 		PolicyArn: aws.String(policyARN),
-		VersionId: aws.String(defaultVersionID),
+		VersionId: aws.String(versionID),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("getting policy version: %w", err)
+		return parsedPolicy{}, fmt.Errorf("getting policy version: %w", classifyAPIError(err))
 	}
 
 	doc := aws.ToString(versionOut.PolicyVersion.Document)
 	if doc == "" {
-		return nil, nil
+		return parsedPolicy{}, nil
 	}
 
-	return parsePolicyDocument(doc)
+	parsed, err := parsePolicy(doc, s.parseOptions())
+	if err != nil {
+		return parsedPolicy{}, &PolicyParseError{PolicyARN: policyARN, PolicyName: policyNameFromARN(policyARN), Err: err}
+	}
+	return parsed, nil
 }
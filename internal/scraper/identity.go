@@ -0,0 +1,41 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// stsClient is the subset of the AWS STS client we use (for easy testing).
+type stsClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// VerifyAccount resolves the account of the current AWS credentials and
+// returns an error if it is not in allowed. An empty allowlist disables the
+// check without calling STS. On success the resolved account ID is returned
+// (empty when the check was skipped).
+func VerifyAccount(ctx context.Context, cfg aws.Config, allowed []string) (string, error) {
+	if len(allowed) == 0 {
+		return "", nil
+	}
+	return verifyAccount(ctx, sts.NewFromConfig(cfg), allowed)
+}
+
+func verifyAccount(ctx context.Context, client stsClient, allowed []string) (string, error) {
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("resolving caller identity for account allowlist: %w", err)
+	}
+	account := aws.ToString(out.Account)
+	for _, a := range allowed {
+		if strings.TrimSpace(a) == account {
+			return account, nil
+		}
+	}
+	return "", fmt.Errorf("AWS credentials resolve to account %s, which is not in aws.allowed_account_ids (%s) — check your AWS profile or update the allowlist",
+		account, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,84 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+// MFA supplies the token code when the role assumed through the shared AWS
+// config (a profile with role_arn) requires MFA.
+type MFA struct {
+	// SerialNumber is the MFA device ARN. Empty keeps the profile's
+	// mfa_serial, if any.
+	SerialNumber string
+	// Token is a code supplied up front, e.g. from --mfa-token. It is used
+	// for the first session only: a code cannot be used twice, so renewing
+	// an expired session needs a new one.
+	Token string
+	// Interactive allows prompting for the code on Prompt and reading it
+	// from In when no Token was supplied.
+	Interactive bool
+	In          io.Reader
+	Prompt      io.Writer
+}
+
+// LoadOption wires m into the assume-role credential provider. The token
+// provider is only called when a serial number is set, by the profile or by
+// m, so it is safe to install unconditionally.
+func (m MFA) LoadOption() awsconfig.LoadOptionsFunc {
+	return awsconfig.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+		if m.SerialNumber != "" {
+			o.SerialNumber = aws.String(m.SerialNumber)
+		}
+		o.TokenProvider = m.tokenProvider(aws.ToString(o.SerialNumber))
+	})
+}
+
+// tokenProvider returns the supplied token for the first session, and
+// prompts for a code when running interactively for that and every later
+// session, as the SDK asks again each time the assumed role's credentials
+// expire. Otherwise it fails with an error naming the fix, rather than the
+// SDK's generic "token provider not set" or STS rejecting a reused code.
+func (m MFA) tokenProvider(serial string) func() (string, error) {
+	var (
+		mu     sync.Mutex
+		used   bool
+		reader *bufio.Reader
+	)
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if m.Token != "" && !used {
+			used = true
+			return m.Token, nil
+		}
+		if !m.Interactive || m.In == nil || m.Prompt == nil {
+			if used {
+				return "", fmt.Errorf("MFA session for %s expired and the --mfa-token code was already used; re-run with a new code", serial)
+			}
+			return "", fmt.Errorf("role requires MFA (%s) but no token was supplied; pass --mfa-token when not running interactively", serial)
+		}
+		if reader == nil {
+			// One reader for every prompt, so input it buffered past a
+			// line is not lost.
+			reader = bufio.NewReader(m.In)
+		}
+		fmt.Fprintf(m.Prompt, "MFA code for %s: ", serial)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading MFA code: %w", err)
+		}
+		code := strings.TrimSpace(line)
+		if code == "" {
+			return "", fmt.Errorf("no MFA code entered for %s", serial)
+		}
+		return code, nil
+	}
+}
@@ -2,22 +2,26 @@ package scraper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
-)
+	"unicode"
+	"unicode/utf8"
 
-// policyDocument represents an IAM policy document.
-type policyDocument struct {
-	Version   string      `json:"Version"`
-	Statement []statement `json:"Statement"`
-}
+	"github.com/0xKirisame/shinkai-shoujo/internal/catalog"
+	"github.com/0xKirisame/shinkai-shoujo/internal/privilege"
+)
 
 // statement represents a single IAM policy statement.
 type statement struct {
-	Effect   string      `json:"Effect"`
-	Action   ActionValue `json:"Action"`
-	Resource interface{} `json:"Resource"`
+	Sid      string        `json:"Sid"`
+	Effect   string        `json:"Effect"`
+	Action   ActionValue   `json:"Action"`
+	Resource ResourceValue `json:"Resource"`
+	// Principal is only present in trust (resource-based) policies.
+	Principal principalValue `json:"Principal"`
 }
 
 // ActionValue handles both string and []string for the Action field.
@@ -39,29 +43,125 @@ func (a *ActionValue) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// parsePolicyDocument decodes an IAM policy document from its URL-encoded JSON form.
+// ResourceValue handles both string and []string for the Resource field,
+// with surrounding whitespace trimmed from each ARN. It is nil when the
+// statement has no Resource (one using NotResource).
+type ResourceValue []string
+
+func (r *ResourceValue) UnmarshalJSON(data []byte) error {
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("Resource must be a string or array of strings: %w", err)
+		}
+		arr = []string{s}
+	}
+	for i := range arr {
+		arr[i] = strings.TrimSpace(arr[i])
+	}
+	*r = arr
+	return nil
+}
+
+// Constrained reports whether r names specific resources rather than "*".
+// An empty or missing Resource is not constrained.
+func (r ResourceValue) Constrained() bool {
+	if len(r) == 0 {
+		return false
+	}
+	for _, arn := range r {
+		if arn == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// principalValue handles both the bare "*" principal and the
+// {"AWS": ..., "Service": ...} form, whose values may be a string or an
+// array. The bare wildcard is stored under the "*" type.
+type principalValue map[string]ActionValue
+
+func (p *principalValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("Principal must be \"*\" or an object, got %q", s)
+		}
+		*p = principalValue{"*": {"*"}}
+		return nil
+	}
+	var m map[string]ActionValue
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("Principal must be \"*\" or an object: %w", err)
+	}
+	*p = m
+	return nil
+}
+
+// parseOptions tune how a policy document is reduced to its allowed actions.
+type parseOptions struct {
+	// strictDenySplit expands an allowed wildcard ("s3:*", "s3:Get*") into the
+	// catalogued actions it covers whenever a specific action of that service
+	// is denied, so the deny actually removes that action.
+	strictDenySplit bool
+	// ignoreSidPrefix marks Allow statements whose Sid starts with it as
+	// intentional grants. Empty disables it.
+	ignoreSidPrefix string
+}
+
+// parsedPolicy is a policy document reduced to what it allows.
+type parsedPolicy struct {
+	actions []string
+	// suppressed are the actions granted only by intentional statements
+	// (see parseOptions.ignoreSidPrefix); they are also in actions.
+	suppressed []string
+	// constrained are the actions granted only by statements scoped to
+	// specific resources rather than "*"; they are also in actions.
+	constrained []string
+}
+
+// parsePolicyDocument decodes an IAM policy document from its URL-encoded
+// JSON form and returns its allowed actions.
+func parsePolicyDocument(encoded string, opts parseOptions) ([]string, error) {
+	p, err := parsePolicy(encoded, opts)
+	return p.actions, err
+}
+
+// parsePolicy decodes an IAM policy document from its URL-encoded JSON form.
 // The policy document returned by GetPolicyVersion is URL-percent-encoded.
-func parsePolicyDocument(encoded string) ([]string, error) {
+func parsePolicy(encoded string, opts parseOptions) (parsedPolicy, error) {
 	// URL-decode the document
 	decoded, err := url.QueryUnescape(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("url-decoding policy: %w", err)
+		return parsedPolicy{}, fmt.Errorf("url-decoding policy: %w", err)
 	}
 
-	var doc policyDocument
-	if err := json.Unmarshal([]byte(decoded), &doc); err != nil {
-		return nil, fmt.Errorf("parsing policy JSON: %w", err)
-	}
+	// The document is streamed one statement at a time rather than
+	// unmarshalled whole, so huge managed policies never hold every statement
+	// in memory at once. That takes two passes over the statements array.
 
-	// First pass: collect all explicitly Denied actions into a set (normalized).
+	// First pass: reject malformed actions up front so nothing downstream
+	// (the deny set, the catalog, the DB) ever sees an empty or
+	// control-character action, and collect all explicitly Denied actions
+	// into a set (normalized).
 	denied := make(map[string]struct{})
-	for _, stmt := range doc.Statement {
-		if !strings.EqualFold(stmt.Effect, "Deny") {
-			continue
-		}
+	err = eachStatement(decoded, func(stmt statement) error {
 		for _, action := range stmt.Action {
-			denied[normalizeAction(action)] = struct{}{}
+			if !validAction(action) {
+				return fmt.Errorf("policy statement has invalid action %q", action)
+			}
+		}
+		if strings.EqualFold(stmt.Effect, "Deny") {
+			for _, action := range stmt.Action {
+				denied[privilege.Format(action)] = struct{}{}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return parsedPolicy{}, err
 	}
 
 	// Second pass: collect Allow actions, skipping those covered by the deny set.
@@ -69,28 +169,213 @@ func parsePolicyDocument(encoded string) ([]string, error) {
 	//   - Exact match:       "s3:GetObject" denied if present in denied set.
 	//   - Global wildcard:   "*" in denied set → everything is denied.
 	//   - Service wildcard:  "s3:*" in denied set → all "s3:X" allowed actions are denied.
-	// Note: denying a specific action does not "split" an allowed wildcard (e.g.
-	// Allow "s3:*" + Deny "s3:DeleteObject" keeps "s3:*" in the result because we
-	// cannot enumerate all S3 actions here). This edge case is intentionally accepted.
-	seen := make(map[string]struct{})
+	// Note: by default, denying a specific action does not "split" an allowed
+	// wildcard (e.g. Allow "s3:*" + Deny "s3:DeleteObject" keeps "s3:*" in the
+	// result because we cannot enumerate all S3 actions here). With
+	// strictDenySplit the wildcard is expanded via the action catalog instead;
+	// services missing from the catalog, and the global "*", still stay whole.
+	// intentionalOnly and constrainedOnly track, per action, whether every
+	// statement granting it so far was an intentional one, and one scoped to
+	// specific resources.
+	intentionalOnly := make(map[string]bool)
+	constrainedOnly := make(map[string]bool)
 	var actions []string
-	for _, stmt := range doc.Statement {
+	err = eachStatement(decoded, func(stmt statement) error {
 		if !strings.EqualFold(stmt.Effect, "Allow") {
-			continue
+			return nil
+		}
+		intentional := opts.ignoreSidPrefix != "" && strings.HasPrefix(stmt.Sid, opts.ignoreSidPrefix)
+		constrained := stmt.Resource.Constrained()
+		add := func(action string) {
+			key := strings.ToLower(action)
+			if _, ok := intentionalOnly[key]; !ok {
+				intentionalOnly[key] = intentional
+				constrainedOnly[key] = constrained
+				actions = append(actions, action)
+				return
+			}
+			intentionalOnly[key] = intentionalOnly[key] && intentional
+			constrainedOnly[key] = constrainedOnly[key] && constrained
 		}
 		for _, action := range stmt.Action {
-			norm := normalizeAction(action)
+			// Policy actions are IAM actions already: the SDK-to-IAM half of
+			// privilege.Normalize would widen e.g. lambda:InvokeAsync into
+			// lambda:InvokeFunction, so only the format is canonicalized.
+			norm := privilege.Format(action)
 			if isDenied(norm, denied) {
 				continue
 			}
-			key := strings.ToLower(norm)
-			if _, ok := seen[key]; !ok {
-				seen[key] = struct{}{}
-				actions = append(actions, norm)
+			if opts.strictDenySplit && splitsWildcard(norm, denied) {
+				if expanded, ok := catalog.Expand(norm); ok {
+					for _, a := range expanded {
+						if !isDenied(a, denied) {
+							add(a)
+						}
+					}
+					continue
+				}
+			}
+			add(norm)
+		}
+		return nil
+	})
+	if err != nil {
+		return parsedPolicy{}, err
+	}
+
+	p := parsedPolicy{actions: actions}
+	for _, a := range actions {
+		if intentionalOnly[strings.ToLower(a)] {
+			p.suppressed = append(p.suppressed, a)
+		}
+		if constrainedOnly[strings.ToLower(a)] {
+			p.constrained = append(p.constrained, a)
+		}
+	}
+	return p, nil
+}
+
+// eachStatement streams the statements of the JSON policy document doc,
+// calling fn for each statement in order and stopping at the first error.
+// Keys are matched case-insensitively, as json.Unmarshal would, and a missing
+// or null Statement yields no statements.
+func eachStatement(doc string, fn func(statement) error) error {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("parsing policy JSON: document must be an object")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("parsing policy JSON: %w", err)
+		}
+		if k, _ := key.(string); !strings.EqualFold(k, "Statement") {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("parsing policy JSON: %w", err)
 			}
+			continue
+		}
+		if err := eachStatementValue(dec, fn); err != nil {
+			return err
+		}
+	}
+	// Consume the closing brace and reject trailing data.
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing policy JSON: unexpected data after document")
+	}
+	return nil
+}
+
+// eachStatementValue decodes the Statement value at the decoder's position:
+// an array of statements, a single statement object (as IAM allows, and AWS
+// returns for simple policies), or null.
+func eachStatementValue(dec *json.Decoder, fn func(statement) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	switch tok {
+	case nil:
+		return nil
+	case json.Delim('{'):
+		stmt, err := decodeStatementObject(dec)
+		if err != nil {
+			return err
 		}
+		return fn(stmt)
+	case json.Delim('['):
+	default:
+		return fmt.Errorf("parsing policy JSON: Statement must be an array or object")
 	}
-	return actions, nil
+	for dec.More() {
+		var stmt statement
+		if err := dec.Decode(&stmt); err != nil {
+			return fmt.Errorf("parsing policy JSON: %w", err)
+		}
+		if err := fn(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	return nil
+}
+
+// decodeStatementObject decodes a statement whose opening brace the decoder
+// has already consumed. Its fields are collected and decoded as one object,
+// so keys match as they would in an array element.
+func decodeStatementObject(dec *json.Decoder) (statement, error) {
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return statement{}, fmt.Errorf("parsing policy JSON: %w", err)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return statement{}, fmt.Errorf("parsing policy JSON: %w", err)
+		}
+		k, _ := key.(string)
+		fields[k] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return statement{}, fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return statement{}, fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	var stmt statement
+	if err := json.Unmarshal(raw, &stmt); err != nil {
+		return statement{}, fmt.Errorf("parsing policy JSON: %w", err)
+	}
+	return stmt, nil
+}
+
+// validAction reports whether action is "*" or "service:Action" with both
+// parts non-empty and no whitespace or control characters.
+func validAction(action string) bool {
+	if action == "*" {
+		return true
+	}
+	service, name, ok := strings.Cut(action, ":")
+	if !ok || service == "" || name == "" {
+		return false
+	}
+	for _, r := range action {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// splitsWildcard reports whether a wildcard allow ("s3:*", "s3:Get*") has a
+// specific deny inside it that would carve an action out of it.
+func splitsWildcard(action string, denied map[string]struct{}) bool {
+	service, name, ok := strings.Cut(action, ":")
+	if !ok || !strings.HasSuffix(name, "*") {
+		return false
+	}
+	prefix := service + ":" + strings.TrimSuffix(name, "*")
+	for d := range denied {
+		if strings.HasPrefix(d, prefix) && !strings.HasSuffix(d, "*") {
+			return true
+		}
+	}
+	return false
 }
 
 // isDenied reports whether the (already-normalized) action is covered by the deny set.
@@ -112,14 +397,3 @@ func isDenied(action string, denied map[string]struct{}) bool {
 	}
 	return false
 }
-
-// normalizeAction lowercases the service prefix (before ':') and preserves action casing.
-// e.g. "S3:GetObject" → "s3:GetObject", "s3:*" → "s3:*"
-func normalizeAction(action string) string {
-	parts := strings.SplitN(action, ":", 2)
-	if len(parts) == 2 {
-		return strings.ToLower(parts[0]) + ":" + parts[1]
-	}
-	// "*" or other bare wildcards
-	return strings.ToLower(action)
-}
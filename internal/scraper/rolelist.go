@@ -0,0 +1,43 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+)
+
+// ReadRoleList reads the roles to scrape for a targeted audit from path: one
+// role name or ARN per line. Blank lines and lines starting with '#' are
+// ignored. It returns the deduplicated role names in file order.
+func ReadRoleList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading role list: %w", err)
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := rolearn.Parse(line).Name
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading role list %s: %w", path, err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("role list %s names no roles", path)
+	}
+	return names, nil
+}
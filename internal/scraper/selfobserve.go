@@ -0,0 +1,95 @@
+package scraper
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// UsageRecorder stores privilege usage records; *storage.DB is one.
+type UsageRecorder interface {
+	BatchRecordPrivilegeUsage(ctx context.Context, records []storage.PrivilegeUsageRecord) error
+}
+
+// SelfObserver records the tool's own AWS calls as privilege usage of the
+// role the tool runs as, so its least-privilege policy can be derived from
+// the same analysis as any other role's. Every call made through a config
+// it is installed in is counted, whether or not it succeeds: a denied call
+// still names a permission the tool needs. Calls are buffered until Flush
+// writes them as one batch. A nil *SelfObserver records nothing.
+type SelfObserver struct {
+	role     string
+	recorder UsageRecorder
+
+	mu    sync.Mutex
+	calls map[string]storage.PrivilegeUsageRecord
+}
+
+// NewSelfObserver returns an observer recording calls as usage by role in
+// recorder.
+func NewSelfObserver(role string, recorder UsageRecorder) *SelfObserver {
+	return &SelfObserver{role: role, recorder: recorder, calls: make(map[string]storage.PrivilegeUsageRecord)}
+}
+
+// Install makes every client created from cfg afterwards record its calls.
+func (o *SelfObserver) Install(cfg *aws.Config) {
+	if o == nil {
+		return
+	}
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		// Right after the operation's metadata, which names the call, is
+		// set.
+		return stack.Initialize.Insert(middleware.InitializeMiddlewareFunc("ShinkaiShoujoSelfObserve",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				o.record(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx))
+				return next.HandleInitialize(ctx, in)
+			}), (&awsmiddleware.RegisterServiceMetadata{}).ID(), middleware.After)
+	})
+}
+
+// record counts one call of operation on the service with the given SDK
+// service ID, whose IAM prefix is the ID lowercased without spaces ("IAM",
+// "STS", "CloudWatch").
+func (o *SelfObserver) record(serviceID, operation string) {
+	if serviceID == "" || operation == "" {
+		return
+	}
+	privilege := strings.ToLower(strings.ReplaceAll(serviceID, " ", "")) + ":" + operation
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	rec := o.calls[privilege]
+	rec.IAMRole = o.role
+	rec.Privilege = privilege
+	rec.Timestamp = time.Now()
+	rec.CallCount++
+	o.calls[privilege] = rec
+}
+
+// Flush writes the calls recorded since the last Flush as one batch. On
+// failure they are kept for the next Flush.
+func (o *SelfObserver) Flush(ctx context.Context) error {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.calls) == 0 {
+		return nil
+	}
+	records := make([]storage.PrivilegeUsageRecord, 0, len(o.calls))
+	for _, rec := range o.calls {
+		records = append(records, rec)
+	}
+	if err := o.recorder.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+		return err
+	}
+	o.calls = make(map[string]storage.PrivilegeUsageRecord)
+	return nil
+}
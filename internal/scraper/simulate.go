@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"golang.org/x/time/rate"
+)
+
+// maxSimulatedActions is how many actions are sent per SimulatePrincipalPolicy
+// request.
+const maxSimulatedActions = 50
+
+// simulatorClient is the subset of the AWS IAM client used by Simulator.
+type simulatorClient interface {
+	SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error)
+}
+
+// Simulator asks IAM's policy simulator whether a role is actually allowed an
+// action. Unlike our own policy parsing, the simulator accounts for
+// permissions boundaries, conditions and (inside an organization) SCPs. It
+// needs iam:SimulatePrincipalPolicy and the API is rate-limited, so calls are
+// paced by a token bucket.
+type Simulator struct {
+	client  simulatorClient
+	limiter *rate.Limiter
+}
+
+// NewSimulator creates a Simulator that sends at most requestsPerSecond
+// SimulatePrincipalPolicy requests per second.
+func NewSimulator(cfg aws.Config, requestsPerSecond float64) *Simulator {
+	return newSimulator(iam.NewFromConfig(cfg), requestsPerSecond)
+}
+
+func newSimulator(client simulatorClient, requestsPerSecond float64) *Simulator {
+	limit := rate.Limit(requestsPerSecond)
+	if requestsPerSecond <= 0 {
+		limit = rate.Inf
+	}
+	return &Simulator{client: client, limiter: rate.NewLimiter(limit, 1)}
+}
+
+// Allowed reports, for each action, whether the simulator allows roleARN to
+// perform it on any resource. Wildcard actions such as "s3:*" cannot be
+// simulated and are left out of the result.
+func (s *Simulator) Allowed(ctx context.Context, roleARN string, actions []string) (map[string]bool, error) {
+	var specific []string
+	for _, a := range actions {
+		if !strings.Contains(a, "*") {
+			specific = append(specific, a)
+		}
+	}
+
+	allowed := make(map[string]bool, len(specific))
+	for start := 0; start < len(specific); start += maxSimulatedActions {
+		end := start + maxSimulatedActions
+		if end > len(specific) {
+			end = len(specific)
+		}
+		input := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(roleARN),
+			ActionNames:     specific[start:end],
+		}
+		for {
+			if err := s.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+			out, err := s.client.SimulatePrincipalPolicy(ctx, input)
+			if err != nil {
+				return nil, fmt.Errorf("simulating policy for %s: %w", roleARN, err)
+			}
+			for _, r := range out.EvaluationResults {
+				allowed[aws.ToString(r.EvalActionName)] = r.EvalDecision == types.PolicyEvaluationDecisionTypeAllowed
+			}
+			if !out.IsTruncated {
+				break
+			}
+			input.Marker = out.Marker
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,66 @@
+package scraper
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Principal is an entity a role's trust policy allows to assume it.
+type Principal struct {
+	// Type is the principal type from the policy: "AWS", "Service",
+	// "Federated" or "CanonicalUser", or "*" for the bare wildcard principal.
+	Type string
+	// ID is the ARN, account ID, service name or provider; "*" for the bare
+	// wildcard.
+	ID string
+}
+
+// String formats p as "Type:ID", or "*" for the bare wildcard.
+func (p Principal) String() string {
+	if p.Type == "*" {
+		return "*"
+	}
+	return p.Type + ":" + p.ID
+}
+
+// parseTrustPolicy decodes a role's URL-encoded AssumeRolePolicyDocument and
+// returns the principals its Allow statements trust, deduplicated and sorted.
+// Conditions are not evaluated, so a principal restricted by one (an
+// ExternalId, an organization ID) is still listed.
+func parseTrustPolicy(encoded string) ([]Principal, error) {
+	decoded, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("url-decoding trust policy: %w", err)
+	}
+
+	seen := make(map[Principal]bool)
+	// Non-nil even when empty: nil means the trust policy was never read.
+	principals := []Principal{}
+	err = eachStatement(decoded, func(stmt statement) error {
+		if !strings.EqualFold(stmt.Effect, "Allow") {
+			return nil
+		}
+		for typ, ids := range stmt.Principal {
+			for _, id := range ids {
+				p := Principal{Type: typ, ID: id}
+				if !seen[p] {
+					seen[p] = true
+					principals = append(principals, p)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(principals, func(i, j int) bool {
+		if principals[i].Type != principals[j].Type {
+			return principals[i].Type < principals[j].Type
+		}
+		return principals[i].ID < principals[j].ID
+	})
+	return principals, nil
+}
@@ -0,0 +1,58 @@
+// Package seed imports a baseline of known-used privileges into the usage
+// store, so a fresh deployment does not report everything as unused while
+// trace history accumulates.
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// Entry is one (role, privilege) pair in a seed file. Timestamp is optional
+// and defaults to the time passed to Parse.
+type Entry struct {
+	Role      string     `json:"role"`
+	Privilege string     `json:"privilege"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// Parse reads a JSON array of Entry values from r and returns them as usage
+// records. Privileges are put in canonical form with correlation.Normalize,
+// so SDK operation names from CloudTrail exports line up with IAM actions.
+// Entries without a timestamp are recorded at defaultTime.
+func Parse(r io.Reader, defaultTime time.Time) ([]storage.PrivilegeUsageRecord, error) {
+	var entries []Entry
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entries); err != nil {
+		return nil, fmt.Errorf(`parsing seed file (expected a JSON array of {"role": ..., "privilege": ...}): %w`, err)
+	}
+
+	records := make([]storage.PrivilegeUsageRecord, 0, len(entries))
+	for i, e := range entries {
+		if strings.TrimSpace(e.Role) == "" {
+			return nil, fmt.Errorf("seed entry %d: role is required", i)
+		}
+		service, action, ok := strings.Cut(e.Privilege, ":")
+		if !ok || service == "" || action == "" {
+			return nil, fmt.Errorf("seed entry %d: privilege %q must be in service:Action form", i, e.Privilege)
+		}
+		ts := defaultTime
+		if e.Timestamp != nil {
+			ts = *e.Timestamp
+		}
+		records = append(records, storage.PrivilegeUsageRecord{
+			Timestamp: ts,
+			IAMRole:   e.Role,
+			Privilege: correlation.Normalize(service + ":" + action),
+			CallCount: 1,
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,87 @@
+package seed
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/metrics"
+	"github.com/0xKirisame/shinkai-shoujo/internal/scraper"
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Now()
+	input := `[
+		{"role": "arn:aws:iam::123456789012:role/App", "privilege": "Lambda:Invoke"},
+		{"role": "App", "privilege": "s3:GetObject", "timestamp": "2024-01-02T03:04:05Z"}
+	]`
+	records, err := Parse(strings.NewReader(input), now)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Privilege != "lambda:InvokeFunction" {
+		t.Errorf("expected SDK name normalized to lambda:InvokeFunction, got %s", records[0].Privilege)
+	}
+	if !records[0].Timestamp.Equal(now) {
+		t.Errorf("expected default timestamp, got %v", records[0].Timestamp)
+	}
+	if records[1].Timestamp.Year() != 2024 {
+		t.Errorf("expected explicit timestamp, got %v", records[1].Timestamp)
+	}
+}
+
+func TestParseRejectsInvalidEntries(t *testing.T) {
+	bad := []string{
+		`{"role": "App"}`,
+		`[{"role": "", "privilege": "s3:GetObject"}]`,
+		`[{"role": "App", "privilege": "GetObject"}]`,
+		`[{"role": "App", "privilege": "s3:GetObject", "extra": true}]`,
+	}
+	for _, in := range bad {
+		if _, err := Parse(strings.NewReader(in), time.Now()); err == nil {
+			t.Errorf("expected error for %s", in)
+		}
+	}
+}
+
+func TestSeededPrivilegesCountAsUsed(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	role := scraper.RoleAssignment{
+		RoleName:   "App",
+		RoleARN:    "arn:aws:iam::123456789012:role/App",
+		Privileges: []string{"s3:GetObject", "s3:PutObject"},
+	}
+	records, err := Parse(strings.NewReader(`[{"role": "arn:aws:iam::123456789012:role/App", "privilege": "s3:GetObject"}]`), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := correlation.NewEngine(db, 30, log, metrics.NewWithRegistry(prometheus.NewRegistry()))
+	results, err := engine.Run(ctx, []scraper.RoleAssignment{role})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Unused) != 1 || results[0].Unused[0] != "s3:PutObject" {
+		t.Errorf("expected only s3:PutObject unused after seeding, got %+v", results)
+	}
+}
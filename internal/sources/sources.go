@@ -0,0 +1,92 @@
+// Package sources defines where privilege usage comes from. Each source
+// (the OTLP receiver today; CloudTrail, S3 access logs or Athena queries in
+// future) only produces records. Run drains every configured source on an
+// interval and writes the records through storage, so adding a source never
+// touches the correlation engine.
+package sources
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// UsageSource produces privilege observations.
+type UsageSource interface {
+	// Name identifies the source in logs.
+	Name() string
+	// Collect returns the records observed since the previous call. Records
+	// returned are owned by the caller; a source must not return them again.
+	Collect(ctx context.Context) ([]storage.PrivilegeUsageRecord, error)
+}
+
+// Requeuer is a UsageSource that can take back records it returned, so a
+// batch that fails to write is collected again on the next cycle rather than
+// lost. The receiver is one: it has already acknowledged the records.
+type Requeuer interface {
+	Requeue(records []storage.PrivilegeUsageRecord)
+}
+
+// Sink stores privilege observations: a *storage.DB, or a
+// *storage.AccountRouter spreading them over one database per account. A
+// sink that writes part of a batch reports the rest in a
+// *storage.PartialWriteError.
+type Sink interface {
+	BatchRecordPrivilegeUsage(ctx context.Context, records []storage.PrivilegeUsageRecord) error
+}
+
+// CollectOnce drains every source once and writes the records. A failing
+// source is logged and does not stop the others. Records that fail to write,
+// and only those when the sink wrote part of the batch, are handed back to a
+// source that is a Requeuer. It returns the number of records written.
+func CollectOnce(ctx context.Context, db Sink, log *slog.Logger, srcs ...UsageSource) int {
+	written := 0
+	for _, src := range srcs {
+		records, err := src.Collect(ctx)
+		if err != nil {
+			log.Warn("failed to collect privilege usage", "source", src.Name(), "error", err)
+			continue
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if err := db.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+			failed := records
+			var partial *storage.PartialWriteError
+			if errors.As(err, &partial) {
+				failed = partial.Unwritten
+			}
+			rq, ok := src.(Requeuer)
+			if ok {
+				rq.Requeue(failed)
+			}
+			log.Error("failed to record privilege usage", "source", src.Name(), "records", len(failed), "requeued", ok, "error", err)
+			written += len(records) - len(failed)
+			continue
+		}
+		log.Debug("recorded privilege usage", "source", src.Name(), "count", len(records))
+		written += len(records)
+	}
+	return written
+}
+
+// Run calls CollectOnce every interval until ctx is done, then collects one
+// final time so records buffered at shutdown are not lost. Cancel ctx only
+// after push-based sources such as the receiver have stopped accepting data.
+func Run(ctx context.Context, db Sink, interval time.Duration, log *slog.Logger, srcs ...UsageSource) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			CollectOnce(ctx, db, log, srcs...)
+		case <-ctx.Done():
+			// The run context is cancelled; flush with a fresh one.
+			CollectOnce(context.Background(), db, log, srcs...)
+			return
+		}
+	}
+}
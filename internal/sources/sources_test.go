@@ -0,0 +1,203 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/storage"
+)
+
+// fakeSource returns its batches one Collect call at a time.
+type fakeSource struct {
+	name    string
+	batches [][]storage.PrivilegeUsageRecord
+	err     error
+}
+
+func (f *fakeSource) Name() string { return f.name }
+
+func (f *fakeSource) Collect(ctx context.Context) ([]storage.PrivilegeUsageRecord, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.batches) == 0 {
+		return nil, nil
+	}
+	b := f.batches[0]
+	f.batches = f.batches[1:]
+	return b, nil
+}
+
+func TestCollectOnceWritesEverySource(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	role := "arn:aws:iam::123456789012:role/App"
+	now := time.Now()
+	good := &fakeSource{name: "fake", batches: [][]storage.PrivilegeUsageRecord{{
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: role, Privilege: "s3:PutObject", CallCount: 1},
+	}}}
+	broken := &fakeSource{name: "broken", err: errors.New("unreachable")}
+
+	if n := CollectOnce(ctx, db, log, broken, good); n != 2 {
+		t.Errorf("expected 2 records written, got %d", n)
+	}
+	privs, err := db.GetUsedPrivilegesForRole(ctx, role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privs) != 2 {
+		t.Errorf("expected 2 privileges stored, got %v", privs)
+	}
+
+	// A drained source has nothing more to write.
+	if n := CollectOnce(ctx, db, log, good); n != 0 {
+		t.Errorf("expected nothing on second collection, got %d", n)
+	}
+}
+
+// requeuingSource is a fakeSource that takes back records that failed to
+// write.
+type requeuingSource struct {
+	fakeSource
+}
+
+func (r *requeuingSource) Requeue(records []storage.PrivilegeUsageRecord) {
+	r.batches = append([][]storage.PrivilegeUsageRecord{records}, r.batches...)
+}
+
+// failingSink fails its next failures writes, then stores in db.
+type failingSink struct {
+	db       *storage.DB
+	failures int
+}
+
+func (f *failingSink) BatchRecordPrivilegeUsage(ctx context.Context, records []storage.PrivilegeUsageRecord) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("database is locked")
+	}
+	return f.db.BatchRecordPrivilegeUsage(ctx, records)
+}
+
+func TestCollectOnceRequeuesFailedWrites(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	role := "arn:aws:iam::123456789012:role/App"
+	now := time.Now()
+	src := &requeuingSource{fakeSource{name: "fake", batches: [][]storage.PrivilegeUsageRecord{{
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1},
+	}}}}
+	sink := &failingSink{db: db, failures: 1}
+
+	if n := CollectOnce(ctx, sink, log, src); n != 0 {
+		t.Errorf("expected nothing written while the sink fails, got %d", n)
+	}
+	if n := CollectOnce(ctx, sink, log, src); n != 1 {
+		t.Errorf("expected the requeued record written on the next cycle, got %d", n)
+	}
+	privs, err := db.GetUsedPrivilegesForRole(ctx, role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privs) != 1 {
+		t.Errorf("expected the requeued record stored, got %v", privs)
+	}
+}
+
+func TestCollectOnceRequeuesOnlyUnwrittenAccounts(t *testing.T) {
+	ctx := context.Background()
+	fallback, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+	healthy, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer healthy.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// 222222222222's database cannot be opened: its directory is a file.
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	router := storage.NewAccountRouter(func(account string) string {
+		return filepath.Join(notDir, account+".db")
+	}, storage.Options{}, fallback, nil)
+	defer router.Close()
+	router.Use("111111111111", healthy)
+
+	role := "arn:aws:iam::111111111111:role/App"
+	now := time.Now()
+	src := &requeuingSource{fakeSource{name: "fake", batches: [][]storage.PrivilegeUsageRecord{{
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::222222222222:role/App", Privilege: "s3:GetObject", CallCount: 1},
+	}}}}
+
+	for i := 0; i < 3; i++ {
+		want := 0
+		if i == 0 {
+			want = 1
+		}
+		if n := CollectOnce(ctx, router, log, src); n != want {
+			t.Errorf("collection %d wrote %d records, want %d", i+1, n, want)
+		}
+	}
+	if len(src.batches) != 1 || len(src.batches[0]) != 1 || src.batches[0][0].IAMRole == role {
+		t.Errorf("expected only the failing account's record requeued, got %v", src.batches)
+	}
+	usage, err := healthy.GetPrivilegeUsageForRole(ctx, role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usage["s3:GetObject"].CallCount; got != 1 {
+		t.Errorf("healthy account CallCount = %d, want 1 (not recounted on retries)", got)
+	}
+}
+
+func TestRunFlushesOnShutdown(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	role := "arn:aws:iam::123456789012:role/App"
+	src := &fakeSource{name: "fake", batches: [][]storage.PrivilegeUsageRecord{{
+		{Timestamp: time.Now(), IAMRole: role, Privilege: "s3:GetObject", CallCount: 1},
+	}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Run(ctx, db, time.Hour, log, src)
+
+	privs, err := db.GetUsedPrivilegesForRole(context.Background(), role, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privs) != 1 {
+		t.Errorf("expected the buffered record to be flushed on shutdown, got %v", privs)
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SaveScrapeCheckpoint records that roleARN was scraped in session, storing
+// the caller's encoding of its assignment. A later save for the same role
+// replaces it.
+func (db *DB) SaveScrapeCheckpoint(ctx context.Context, session, roleARN string, assignment []byte) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT OR REPLACE INTO scrape_checkpoints (session, role_arn, scraped_at, assignment)
+		 VALUES (?, ?, ?, ?)`,
+		session, roleARN, time.Now().Unix(), string(assignment),
+	)
+	if err != nil {
+		return fmt.Errorf("saving scrape checkpoint for %s: %w", roleARN, err)
+	}
+	return nil
+}
+
+// GetScrapeCheckpoints returns the assignments recorded in session at or
+// after since, keyed by role ARN. Older checkpoints are ignored as stale.
+func (db *DB) GetScrapeCheckpoints(ctx context.Context, session string, since time.Time) (map[string][]byte, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT role_arn, assignment FROM scrape_checkpoints
+		 WHERE session = ? AND scraped_at >= ?`,
+		session, since.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying scrape checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string][]byte)
+	for rows.Next() {
+		var (
+			arn        string
+			assignment string
+		)
+		if err := rows.Scan(&arn, &assignment); err != nil {
+			return nil, err
+		}
+		checkpoints[arn] = []byte(assignment)
+	}
+	return checkpoints, rows.Err()
+}
+
+// DeleteScrapeCheckpoints removes every checkpoint recorded in session.
+func (db *DB) DeleteScrapeCheckpoints(ctx context.Context, session string) error {
+	if _, err := db.conn.ExecContext(ctx,
+		`DELETE FROM scrape_checkpoints WHERE session = ?`, session,
+	); err != nil {
+		return fmt.Errorf("deleting scrape checkpoints: %w", err)
+	}
+	return nil
+}
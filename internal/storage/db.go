@@ -1,26 +1,57 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
+// DefaultBusyTimeoutMS is how long a connection waits on a locked database
+// before returning SQLITE_BUSY when no explicit timeout is configured.
+const DefaultBusyTimeoutMS = 5000
+
+// Options tunes per-connection SQLite pragmas and how a database is opened.
+type Options struct {
+	// BusyTimeoutMS is passed to PRAGMA busy_timeout. Zero means fail
+	// immediately on lock contention.
+	BusyTimeoutMS int
+	// CacheSize is passed to PRAGMA cache_size when non-zero. Positive values
+	// are pages, negative values are KiB (see the SQLite docs).
+	CacheSize int
+	// SkipMigrate opens the database without applying pending migrations,
+	// for tools that must never change the schema. A database whose schema
+	// is not exactly this binary's, older or newer, is refused instead.
+	SkipMigrate bool
+}
+
+// DefaultOptions returns the Options used by Open and OpenMemory.
+func DefaultOptions() Options {
+	return Options{BusyTimeoutMS: DefaultBusyTimeoutMS}
+}
+
 // DB wraps a *sql.DB with application-level helpers.
 type DB struct {
 	conn *sql.DB
 }
 
-// Open opens (or creates) the SQLite database at path.
+// Open opens (or creates) the SQLite database at path with DefaultOptions.
 func Open(path string) (*DB, error) {
+	return OpenWithOptions(path, DefaultOptions())
+}
+
+// OpenWithOptions opens (or creates) the SQLite database at path.
+func OpenWithOptions(path string, opts Options) (*DB, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, fmt.Errorf("creating db directory: %w", err)
 	}
 
-	conn, err := sql.Open("sqlite", path)
+	conn, err := sql.Open("sqlite", dsn(path, opts))
 	if err != nil {
 		return nil, fmt.Errorf("opening sqlite: %w", err)
 	}
@@ -30,7 +61,38 @@ func Open(path string) (*DB, error) {
 		conn.Close()
 		return nil, err
 	}
-	if err := db.migrate(); err != nil {
+	if opts.SkipMigrate {
+		err = db.checkSchemaVersion(true)
+	} else {
+		err = db.migrate()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenReadOnly opens an existing database at path for reading only. Unlike
+// OpenWithOptions it does not create the file or its directory, does not
+// change journal settings and does not run migrations, so it neither contends
+// with a running daemon for the write lock nor lets an older binary alter the
+// schema. Every write fails. A database whose schema is newer than this
+// binary's is refused, as its tables may no longer read as expected.
+func OpenReadOnly(path string, opts Options) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("opening database read-only: %w", err)
+	}
+	conn, err := sql.Open("sqlite", readOnlyDSN(path, opts))
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite: %w", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("opening database read-only: %w", err)
+	}
+	db := &DB{conn: conn}
+	if err := db.checkSchemaVersion(false); err != nil {
 		conn.Close()
 		return nil, err
 	}
@@ -39,7 +101,7 @@ func Open(path string) (*DB, error) {
 
 // OpenMemory opens an in-memory SQLite database (for testing).
 func OpenMemory() (*DB, error) {
-	conn, err := sql.Open("sqlite", ":memory:")
+	conn, err := sql.Open("sqlite", dsn(":memory:", DefaultOptions()))
 	if err != nil {
 		return nil, fmt.Errorf("opening in-memory sqlite: %w", err)
 	}
@@ -55,6 +117,30 @@ func OpenMemory() (*DB, error) {
 	return db, nil
 }
 
+// dsn appends the per-connection pragmas to path. busy_timeout and
+// cache_size only affect the connection they run on, so they are passed as
+// _pragma parameters, which the driver applies to every pooled connection,
+// rather than executed once in configure.
+func dsn(path string, opts Options) string {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeoutMS))
+	if opts.CacheSize != 0 {
+		q.Add("_pragma", fmt.Sprintf("cache_size(%d)", opts.CacheSize))
+	}
+	return path + "?" + q.Encode()
+}
+
+// uriPathEscaper escapes the characters that would end the path part of a
+// SQLite "file:" URI.
+var uriPathEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// readOnlyDSN is dsn for a "file:" URI opened with mode=ro, plus
+// query_only so the connection refuses writes even if the mode is ignored.
+func readOnlyDSN(path string, opts Options) string {
+	ro := url.Values{"mode": {"ro"}, "_pragma": {"query_only(1)"}}
+	return dsn("file:"+uriPathEscaper.Replace(path), opts) + "&" + ro.Encode()
+}
+
 func (db *DB) configure() error {
 	pragmas := []string{
 		"PRAGMA journal_mode=WAL",
@@ -69,7 +155,10 @@ func (db *DB) configure() error {
 	return nil
 }
 
-func (db *DB) migrate() error {
+// migrateBaseline creates the schema as it stood before migrations were
+// numbered. Databases created before then have no schema_version, so it is
+// written to bring any of them up to date and must stay idempotent.
+func (db *DB) migrateBaseline() error {
 	schema := `
 -- One row per (iam_role, privilege) pair. The UNIQUE constraint lets the
 -- INSERT upsert update the timestamp and call_count on conflict, keeping
@@ -105,6 +194,44 @@ CREATE INDEX IF NOT EXISTS idx_analysis_results_role
 CREATE INDEX IF NOT EXISTS idx_analysis_results_date
     ON analysis_results (analysis_date);
 
+-- Resources each role-privilege pair was observed acting on, when the span
+-- carried one (e.g. aws.s3.bucket). Bounded like privilege_usage.
+CREATE TABLE IF NOT EXISTS privilege_resources (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp  INTEGER NOT NULL,
+    iam_role   TEXT    NOT NULL,
+    privilege  TEXT    NOT NULL,
+    resource   TEXT    NOT NULL,
+    UNIQUE(iam_role, privilege, resource)
+);
+
+-- IAM action names are case-insensitive, but exporters disagree on casing
+-- ("GetObject" vs "getobject"). Fold case-variant rows into the oldest one,
+-- then key both tables on the privilege ignoring case so later variants
+-- update that row and keep its display form.
+UPDATE privilege_usage SET
+    timestamp  = (SELECT MAX(p.timestamp) FROM privilege_usage p
+                  WHERE p.iam_role = privilege_usage.iam_role
+                    AND p.privilege = privilege_usage.privilege COLLATE NOCASE),
+    call_count = (SELECT SUM(p.call_count) FROM privilege_usage p
+                  WHERE p.iam_role = privilege_usage.iam_role
+                    AND p.privilege = privilege_usage.privilege COLLATE NOCASE)
+WHERE id IN (
+    SELECT MIN(id) FROM privilege_usage
+    GROUP BY iam_role, privilege COLLATE NOCASE HAVING COUNT(*) > 1
+);
+DELETE FROM privilege_usage WHERE id NOT IN (
+    SELECT MIN(id) FROM privilege_usage GROUP BY iam_role, privilege COLLATE NOCASE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_privilege_usage_unique_nocase
+    ON privilege_usage (iam_role, privilege COLLATE NOCASE);
+
+DELETE FROM privilege_resources WHERE id NOT IN (
+    SELECT MAX(id) FROM privilege_resources GROUP BY iam_role, privilege COLLATE NOCASE, resource
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_privilege_resources_unique_nocase
+    ON privilege_resources (iam_role, privilege COLLATE NOCASE, resource);
+
 -- Deduplicate any pre-existing rows (keeps only the latest per role) so that
 -- the UNIQUE index below can be created without conflicts.
 DELETE FROM analysis_results WHERE id NOT IN (
@@ -114,18 +241,198 @@ DELETE FROM analysis_results WHERE id NOT IN (
 -- Enforce at most one result row per role going forward.
 CREATE UNIQUE INDEX IF NOT EXISTS idx_analysis_results_unique_role
     ON analysis_results (iam_role);
+
+-- Every analysis run's results (see history.go), one row per role per run,
+-- for reporting as of a past point in time.
+CREATE TABLE IF NOT EXISTS analysis_history (
+    run_at   INTEGER NOT NULL,
+    iam_role TEXT    NOT NULL,
+    result   TEXT    NOT NULL,
+    PRIMARY KEY (run_at, iam_role)
+);
+
+-- Roles scraped so far by an in-progress scrape (see checkpoint.go), so an
+-- interrupted scrape can resume. Cleared when the scrape completes.
+CREATE TABLE IF NOT EXISTS scrape_checkpoints (
+    session    TEXT    NOT NULL,
+    role_arn   TEXT    NOT NULL,
+    scraped_at INTEGER NOT NULL,
+    assignment TEXT    NOT NULL,
+    PRIMARY KEY (session, role_arn)
+);
+
+-- Advisory locks (see lock.go). A row is a held lock; expires_at lets a
+-- lock left behind by a crashed process be taken over.
+CREATE TABLE IF NOT EXISTS locks (
+    name       TEXT    PRIMARY KEY,
+    holder     TEXT    NOT NULL,
+    expires_at INTEGER NOT NULL
+);
 `
 	if _, err := db.conn.Exec(schema); err != nil {
 		return fmt.Errorf("running migrations: %w", err)
 	}
+
+	// Columns added after the initial schema. CREATE TABLE IF NOT EXISTS does
+	// not alter existing tables, so these are added explicitly.
+	if err := db.addColumn("analysis_results", "policy_arns", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "privileges_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "used_resources", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "privilege_sources", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "suppressed_privileges", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "read_only", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "trust", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "excess_observed", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "owner", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "regressed_privileges", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "constrained_privileges", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "service_coverage", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("analysis_results", "usage_stats", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+
+	if err := db.addColumn("privilege_usage", "session_scoped_calls", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// role_key is the account and name of the role an observation was
+	// stored under (see roleKey), so one role's IAM, STS and bare-name
+	// forms are found with a single indexed lookup.
+	for _, table := range []string{"privilege_usage", "privilege_resources"} {
+		if err := db.addColumn(table, "role_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if err := db.backfillRoleKeys(table); err != nil {
+			return err
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_role_key ON %s (role_key)", table, table)); err != nil {
+			return fmt.Errorf("indexing %s.role_key: %w", table, err)
+		}
+	}
 	return nil
 }
 
+// backfillRoleKeys sets role_key on rows of table written before the column
+// existed. The key is computed in Go, as rolearn parses the ARN forms.
+func (db *DB) backfillRoleKeys(table string) error {
+	rows, err := db.conn.Query(fmt.Sprintf("SELECT DISTINCT iam_role FROM %s WHERE role_key = ''", table))
+	if err != nil {
+		return fmt.Errorf("backfilling %s.role_key: %w", table, err)
+	}
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			rows.Close()
+			return fmt.Errorf("backfilling %s.role_key: %w", table, err)
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("backfilling %s.role_key: %w", table, err)
+	}
+
+	for _, role := range roles {
+		if _, err := db.conn.Exec(
+			fmt.Sprintf("UPDATE %s SET role_key = ? WHERE iam_role = ? AND role_key = ''", table),
+			roleKey(role), role,
+		); err != nil {
+			return fmt.Errorf("backfilling %s.role_key for %s: %w", table, role, err)
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to table unless it already exists, making the
+// migration idempotent across restarts.
+func (db *DB) addColumn(table, column, decl string) error {
+	exists, err := db.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("adding column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// dropColumn drops a column from table if it exists. Indexes on the column
+// must be dropped first.
+func (db *DB) dropColumn(table, column string) error {
+	exists, err := db.hasColumn(table, column)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)); err != nil {
+		return fmt.Errorf("dropping column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// hasColumn reports whether table has the named column.
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("inspecting table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			ctype   string
+			notnull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return false, fmt.Errorf("inspecting table %s: %w", table, err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("inspecting table %s: %w", table, err)
+	}
+	return false, nil
+}
+
 // Close closes the underlying database connection.
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
+// Ping verifies the database is reachable.
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
 // Conn exposes the raw *sql.DB for queries that need it.
 func (db *DB) Conn() *sql.DB {
 	return db.conn
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoAnalysisRun is returned by GetAnalysisResultsAsOf when no analysis
+// run was recorded at or before the requested time.
+var ErrNoAnalysisRun = errors.New("no analysis run recorded")
+
+// AnalysisRun summarizes one run recorded in the analysis history.
+type AnalysisRun struct {
+	RunAt  time.Time
+	Roles  int
+	Labels map[string]string
+}
+
+// SaveAnalysisRun records the results of a whole analysis run in the
+// analysis_history table, keyed by runAt, so later runs can be reported on
+// as of a past point in time. analysis_results keeps only the latest result
+// per role; the history keeps every run. labels, which may be nil, annotate
+// the run for GetAnalysisRuns and GetLabeledAnalysisResults.
+func (db *DB) SaveAnalysisRun(ctx context.Context, runAt time.Time, results []AnalysisResult, labels map[string]string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT OR REPLACE INTO analysis_history (run_at, iam_role, result) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("preparing statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range results {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshaling result for %s: %w", r.IAMRole, err)
+		}
+		if _, err := stmt.ExecContext(ctx, runAt.Unix(), r.IAMRole, string(b)); err != nil {
+			return fmt.Errorf("recording result for %s: %w", r.IAMRole, err)
+		}
+	}
+	for key, value := range labels {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO analysis_run_labels (run_at, key, value) VALUES (?, ?, ?)`,
+			runAt.Unix(), key, value,
+		); err != nil {
+			return fmt.Errorf("recording label %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LastAnalysisRun returns when the newest run in the analysis history ran,
+// or the zero time when none is recorded. Results reused unchanged keep
+// their original analysis date, so this, not the newest result, is when
+// the results were last confirmed current.
+func (db *DB) LastAnalysisRun(ctx context.Context) (time.Time, error) {
+	var runAt sql.NullInt64
+	if err := db.conn.QueryRowContext(ctx, `SELECT MAX(run_at) FROM analysis_history`).Scan(&runAt); err != nil {
+		return time.Time{}, fmt.Errorf("querying analysis history: %w", err)
+	}
+	if !runAt.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(runAt.Int64, 0), nil
+}
+
+// labelFilter returns a condition on analysis_history.run_at matching the
+// runs carrying every one of labels, and its arguments. It is "1" when
+// labels is empty.
+func labelFilter(labels map[string]string) (string, []any) {
+	if len(labels) == 0 {
+		return "1", nil
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	conds := make([]string, 0, len(keys))
+	args := make([]any, 0, 2*len(keys))
+	for _, key := range keys {
+		conds = append(conds, `run_at IN (SELECT run_at FROM analysis_run_labels WHERE key = ? AND value = ?)`)
+		args = append(args, key, labels[key])
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// GetAnalysisRuns returns the recorded analysis runs carrying every one of
+// labels (all runs when it is empty), newest first.
+func (db *DB) GetAnalysisRuns(ctx context.Context, labels map[string]string) ([]AnalysisRun, error) {
+	cond, args := labelFilter(labels)
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT run_at, COUNT(*) FROM analysis_history WHERE `+cond+` GROUP BY run_at ORDER BY run_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying analysis history: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []AnalysisRun
+	for rows.Next() {
+		var runAt int64
+		var roles int
+		if err := rows.Scan(&runAt, &roles); err != nil {
+			return nil, err
+		}
+		runs = append(runs, AnalysisRun{RunAt: time.Unix(runAt, 0), Roles: roles})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	byTime := make(map[int64]*AnalysisRun, len(runs))
+	for i := range runs {
+		byTime[runs[i].RunAt.Unix()] = &runs[i]
+	}
+
+	labelRows, err := db.conn.QueryContext(ctx, `SELECT run_at, key, value FROM analysis_run_labels`)
+	if err != nil {
+		return nil, fmt.Errorf("querying run labels: %w", err)
+	}
+	defer labelRows.Close()
+	for labelRows.Next() {
+		var runAt int64
+		var key, value string
+		if err := labelRows.Scan(&runAt, &key, &value); err != nil {
+			return nil, err
+		}
+		run, ok := byTime[runAt]
+		if !ok {
+			continue
+		}
+		if run.Labels == nil {
+			run.Labels = make(map[string]string)
+		}
+		run.Labels[key] = value
+	}
+	return runs, labelRows.Err()
+}
+
+// GetAnalysisResultsAsOf returns the results of the most recent analysis run
+// at or before t, ordered by role. It returns ErrNoAnalysisRun when there is
+// none.
+func (db *DB) GetAnalysisResultsAsOf(ctx context.Context, t time.Time) ([]AnalysisResult, error) {
+	return db.GetLabeledAnalysisResults(ctx, t, nil)
+}
+
+// GetLabeledAnalysisResults is GetAnalysisResultsAsOf limited to the runs
+// carrying every one of labels.
+func (db *DB) GetLabeledAnalysisResults(ctx context.Context, t time.Time, labels map[string]string) ([]AnalysisResult, error) {
+	cond, args := labelFilter(labels)
+	var runAt sql.NullInt64
+	if err := db.conn.QueryRowContext(ctx,
+		`SELECT MAX(run_at) FROM analysis_history WHERE run_at <= ? AND `+cond, append([]any{t.Unix()}, args...)...,
+	).Scan(&runAt); err != nil {
+		return nil, fmt.Errorf("querying analysis history: %w", err)
+	}
+	if !runAt.Valid {
+		if len(labels) > 0 {
+			return nil, fmt.Errorf("%w with the requested labels at or before %s", ErrNoAnalysisRun, t.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("%w at or before %s", ErrNoAnalysisRun, t.Format(time.RFC3339))
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT result FROM analysis_history WHERE run_at = ? ORDER BY iam_role`, runAt.Int64)
+	if err != nil {
+		return nil, fmt.Errorf("querying analysis history: %w", err)
+	}
+	defer rows.Close()
+
+	var results []AnalysisResult
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var r AnalysisResult
+		if err := json.Unmarshal([]byte(raw), &r); err != nil {
+			return nil, fmt.Errorf("unmarshaling historical result: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AnalysisLock is the advisory lock held while an analysis writes results
+// and purges old records, so overlapping runs (daemon ticks with
+// --skip-if-running=false, or a one-shot analyze next to the daemon) take
+// turns instead of interleaving.
+const AnalysisLock = "analysis"
+
+// lockPollInterval is how often Lock retries a held lock.
+const lockPollInterval = 250 * time.Millisecond
+
+// lockSeq makes holders unique between goroutines of one process.
+var lockSeq atomic.Uint64
+
+// TryLock takes the named lock for lease if it is free or its previous
+// holder's lease has expired. It reports false when another holder has it.
+// While the lock is held its lease is renewed every third of lease, so it
+// expires only once its holder stops, however long it holds the lock. The
+// returned func stops renewing and releases the lock.
+func (db *DB) TryLock(ctx context.Context, name string, lease time.Duration) (unlock func(), ok bool, err error) {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s/%d/%d", host, os.Getpid(), lockSeq.Add(1))
+	now := time.Now()
+
+	res, err := db.conn.ExecContext(ctx,
+		`INSERT INTO locks (name, holder, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET
+		     holder     = excluded.holder,
+		     expires_at = excluded.expires_at
+		 WHERE locks.expires_at <= ?`,
+		name, holder, leaseEnd(now, lease), now.Unix(),
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("taking lock %s: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("taking lock %s: %w", name, err)
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if lease <= 0 {
+			return
+		}
+		db.renewLock(stop, name, holder, lease)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			// Released even if the caller's context is already cancelled.
+			db.conn.ExecContext(context.Background(), //nolint:errcheck
+				`DELETE FROM locks WHERE name = ? AND holder = ?`, name, holder)
+		})
+	}, true, nil
+}
+
+// renewLock extends holder's lease on the named lock every third of lease
+// until stop is closed. It gives up once a renewal fails or finds the lock
+// taken over, leaving the lease to expire.
+func (db *DB) renewLock(stop <-chan struct{}, name, holder string, lease time.Duration) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		res, err := db.conn.ExecContext(context.Background(),
+			`UPDATE locks SET expires_at = ? WHERE name = ? AND holder = ?`,
+			leaseEnd(time.Now(), lease), name, holder,
+		)
+		if err != nil {
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// leaseEnd returns when a lease taken at now ends, in Unix seconds rounded
+// up, so storing whole seconds never shortens it below lease.
+func leaseEnd(now time.Time, lease time.Duration) int64 {
+	end := now.Add(lease)
+	if end.Equal(end.Truncate(time.Second)) {
+		return end.Unix()
+	}
+	return end.Unix() + 1
+}
+
+// Lock waits until it can take the named lock (see TryLock) or ctx is done.
+func (db *DB) Lock(ctx context.Context, name string, lease time.Duration) (unlock func(), err error) {
+	for {
+		unlock, ok, err := db.TryLock(ctx, name, lease)
+		if err != nil || ok {
+			return unlock, err
+		}
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for lock %s: %w", name, ctx.Err())
+		}
+	}
+}
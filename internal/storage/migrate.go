@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaTooNew is returned when opening a database whose schema was
+// migrated by a newer shinkai-shoujo than this one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// ErrSchemaTooOld is returned when opening without migrating a database
+// whose schema has migrations pending, which this binary's queries expect
+// applied.
+var ErrSchemaTooOld = errors.New("database schema is older than this binary requires")
+
+// migration is one numbered schema change. Migrations are applied in order,
+// each once per database, and schema_version records the last one applied.
+// A migration interrupted before its version is recorded runs again on the
+// next open, so each must be safe to rerun.
+type migration struct {
+	version int
+	name    string
+	apply   func(*DB) error
+}
+
+// migrations is every schema change, in order. Append new ones with the
+// next version; never renumber or edit one that has shipped.
+var migrations = []migration{
+	{1, "baseline schema", (*DB).migrateBaseline},
+	{2, "archive_usage", func(db *DB) error {
+		// Monthly rollups of privilege_usage rows purged past the
+		// observation window (see PurgeOldRecords), kept for long-term
+		// trends.
+		_, err := db.conn.Exec(`
+CREATE TABLE IF NOT EXISTS archive_usage (
+    month      TEXT    NOT NULL,
+    role_key   TEXT    NOT NULL,
+    privilege  TEXT    NOT NULL,
+    call_count INTEGER NOT NULL,
+    PRIMARY KEY (month, role_key, privilege)
+);`)
+		return err
+	}},
+	{3, "analysis_run_labels", func(db *DB) error {
+		// Labels annotating an analysis run in analysis_history, e.g.
+		// audit=Q3 (see history.go).
+		_, err := db.conn.Exec(`
+CREATE TABLE IF NOT EXISTS analysis_run_labels (
+    run_at INTEGER NOT NULL,
+    key    TEXT    NOT NULL,
+    value  TEXT    NOT NULL,
+    PRIMARY KEY (run_at, key)
+);`)
+		return err
+	}},
+	{4, "analysis_results.policy_overlaps", func(db *DB) error {
+		return db.addColumn("analysis_results", "policy_overlaps", "TEXT NOT NULL DEFAULT '{}'")
+	}},
+	{5, "analysis_results.unused_risks", func(db *DB) error {
+		return db.addColumn("analysis_results", "unused_risks", "TEXT NOT NULL DEFAULT '{}'")
+	}},
+	{6, "analysis_results keyed by role", func(db *DB) error {
+		// Results were briefly keyed by an account_id column parsed from
+		// iam_role, which the role ARN already makes unique.
+		if _, err := db.conn.Exec(`DROP INDEX IF EXISTS idx_analysis_results_unique_account_role`); err != nil {
+			return err
+		}
+		if err := db.dropColumn("analysis_results", "account_id"); err != nil {
+			return err
+		}
+		_, err := db.conn.Exec(`
+CREATE UNIQUE INDEX IF NOT EXISTS idx_analysis_results_unique_role
+    ON analysis_results (iam_role);`)
+		return err
+	}},
+	{7, "privilege_usage_daily", func(db *DB) error {
+		// Calls per role, privilege and UTC day (Unix seconds / 86400), so
+		// calls can be counted within an observation window; privilege_usage
+		// only keeps each pair's running total. Existing totals are
+		// backfilled on the day they were last seen.
+		_, err := db.conn.Exec(`
+CREATE TABLE IF NOT EXISTS privilege_usage_daily (
+    role_key             TEXT    NOT NULL,
+    privilege            TEXT    NOT NULL COLLATE NOCASE,
+    day                  INTEGER NOT NULL,
+    call_count           INTEGER NOT NULL,
+    session_scoped_calls INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (role_key, privilege, day)
+);
+INSERT INTO privilege_usage_daily (role_key, privilege, day, call_count, session_scoped_calls)
+SELECT role_key, MIN(privilege), timestamp / 86400, SUM(call_count), SUM(session_scoped_calls)
+FROM privilege_usage WHERE true
+GROUP BY role_key, privilege COLLATE NOCASE, timestamp / 86400
+ON CONFLICT DO NOTHING;`)
+		return err
+	}},
+}
+
+// SchemaVersion is the schema version this binary migrates databases to.
+var SchemaVersion = migrations[len(migrations)-1].version
+
+func (db *DB) migrate() error {
+	return db.applyMigrations(migrations)
+}
+
+// applyMigrations applies the migrations in list newer than the database's
+// schema version, in order, recording the version after each. It refuses a
+// database already past the last migration in list.
+func (db *DB) applyMigrations(list []migration) error {
+	if _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("creating schema_version: %w", err)
+	}
+	current, err := db.schemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := 0
+	if len(list) > 0 {
+		latest = list[len(list)-1].version
+	}
+	if current > latest {
+		return schemaTooNew(current, latest)
+	}
+	for _, m := range list {
+		if m.version <= current {
+			continue
+		}
+		if err := m.apply(db); err != nil {
+			return fmt.Errorf("running migration %d (%s): %w", m.version, m.name, err)
+		}
+		if err := db.setSchemaVersion(m.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSchemaVersion refuses a database whose schema is newer than
+// SchemaVersion without changing anything, for opens that skip migrating.
+// With requireCurrent it also refuses one with migrations pending, including
+// a database with no schema yet.
+func (db *DB) checkSchemaVersion(requireCurrent bool) error {
+	var n int
+	if err := db.conn.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`,
+	).Scan(&n); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	current := 0
+	if n > 0 {
+		v, err := db.schemaVersion()
+		if err != nil {
+			return err
+		}
+		current = v
+	}
+	if current > SchemaVersion {
+		return schemaTooNew(current, SchemaVersion)
+	}
+	if requireCurrent && current < SchemaVersion {
+		return fmt.Errorf("%w: database is at schema version %d, this binary requires %d; open it once without storage.skip_migrate to migrate it", ErrSchemaTooOld, current, SchemaVersion)
+	}
+	return nil
+}
+
+// schemaVersion returns the last migration applied, or 0 for a database
+// created before migrations were numbered.
+func (db *DB) schemaVersion() (int, error) {
+	var v int
+	if err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return v, nil
+}
+
+func (db *DB) setSchemaVersion(v int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("recording schema version %d: %w", v, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM schema_version`); err != nil {
+		return fmt.Errorf("recording schema version %d: %w", v, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, v); err != nil {
+		return fmt.Errorf("recording schema version %d: %w", v, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("recording schema version %d: %w", v, err)
+	}
+	return nil
+}
+
+func schemaTooNew(current, supported int) error {
+	return fmt.Errorf("%w: database is at schema version %d, this binary supports up to %d; upgrade shinkai-shoujo", ErrSchemaTooNew, current, supported)
+}
@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/ownership"
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
 )
 
 // PrivilegeUsageRecord represents a single span's privilege observation.
@@ -14,6 +17,12 @@ type PrivilegeUsageRecord struct {
 	IAMRole   string
 	Privilege string
 	CallCount int
+	// Resource is the ARN (or bare name, e.g. an S3 bucket) the call acted
+	// on, when the span captured one.
+	Resource string
+	// SessionPolicy marks calls made in a session that a session policy,
+	// passed to AssumeRole, scoped more narrowly than the role's policies.
+	SessionPolicy bool
 }
 
 // AnalysisResult stores a snapshot of a role's privilege analysis.
@@ -23,7 +32,57 @@ type AnalysisResult struct {
 	AssignedPrivs []string
 	UsedPrivs     []string
 	UnusedPrivs   []string
-	RiskLevel     string
+	// UnusedRisks maps each unused privilege to its risk level, as
+	// classified when the result was computed. Results stored before it was
+	// recorded have none.
+	UnusedRisks map[string]string
+	RiskLevel   string
+	PolicyARNs  []string
+	// Resources maps a used privilege to the resources it was observed on.
+	// Privileges without captured resources are absent.
+	Resources map[string][]string
+	// Usage maps a privilege the role was observed using to its call count
+	// and latest observation.
+	Usage map[string]PrivilegeUsage
+	// Sources maps an assigned privilege to "inline" or "managed".
+	Sources map[string]string
+	// SuppressedPrivs are unused privileges granted only by intentionally
+	// annotated statements, kept out of UnusedPrivs.
+	SuppressedPrivs []string
+	// ConstrainedPrivs are assigned privileges every granting policy scopes
+	// to specific resources rather than "*".
+	ConstrainedPrivs []string
+	// PolicyOverlaps maps each action more than one of the role's policies
+	// grants to those policies.
+	PolicyOverlaps map[string][]string
+	// ReadOnly marks a service-linked role, reported for information only.
+	ReadOnly bool
+	// ExcessObservedPrivs are privileges the role was observed using that
+	// none of its assigned privileges grant.
+	ExcessObservedPrivs []string
+	// RegressedPrivs are the ExcessObservedPrivs used recently enough that
+	// the role likely lost them in a change that will break its workload.
+	RegressedPrivs []string
+	// ServiceCoverage maps each service of the assigned privileges to
+	// whether any of its actions was observed.
+	ServiceCoverage map[string]bool
+	// Trust is the analysis of who can assume the role. The zero value means
+	// its trust policy was not analyzed.
+	Trust TrustRecord
+	// Owner is who owns the role, per the ownership file; zero when unknown.
+	Owner ownership.Owner
+	// PrivilegesHash fingerprints the inputs the result was computed from,
+	// so an unchanged role can reuse it. Empty means "always recompute".
+	PrivilegesHash string
+}
+
+// TrustRecord is the stored analysis of a role's trust policy.
+type TrustRecord struct {
+	Principals         []string `json:"principals,omitempty"`
+	RiskLevel          string   `json:"risk_level,omitempty"`
+	Wildcard           bool     `json:"wildcard,omitempty"`
+	CrossAccount       []string `json:"cross_account,omitempty"`
+	UnexpectedServices []string `json:"unexpected_services,omitempty"`
 }
 
 // BatchRecordPrivilegeUsage inserts multiple records in a single transaction.
@@ -40,33 +99,93 @@ func (db *DB) BatchRecordPrivilegeUsage(ctx context.Context, records []Privilege
 	// ON CONFLICT upsert: advance timestamp to the most recent observation
 	// and accumulate call_count. This keeps one row per (iam_role, privilege)
 	// pair, bounding the table to the set of distinct role-privilege pairs.
+	// Privileges are compared ignoring case, keeping the first-seen casing.
+	// An updated row moves to the next id, as an inserted one would, so ids
+	// order rows by when they were last written (see UsageTail); the
+	// sequence is caught up with the moved ids before committing.
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO privilege_usage (timestamp, iam_role, privilege, call_count)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(iam_role, privilege) DO UPDATE SET
-		    timestamp  = MAX(privilege_usage.timestamp, excluded.timestamp),
-		    call_count = privilege_usage.call_count + excluded.call_count
+		INSERT INTO privilege_usage (timestamp, iam_role, role_key, privilege, call_count, session_scoped_calls)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(iam_role, privilege COLLATE NOCASE) DO UPDATE SET
+		    id                   = MAX(
+		        (SELECT MAX(id) FROM privilege_usage),
+		        COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'privilege_usage'), 0)
+		    ) + 1,
+		    timestamp            = MAX(privilege_usage.timestamp, excluded.timestamp),
+		    call_count           = privilege_usage.call_count + excluded.call_count,
+		    session_scoped_calls = privilege_usage.session_scoped_calls + excluded.session_scoped_calls
 	`)
 	if err != nil {
 		return fmt.Errorf("preparing statement: %w", err)
 	}
 	defer stmt.Close()
 
+	resStmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO privilege_resources (timestamp, iam_role, role_key, privilege, resource)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(iam_role, privilege COLLATE NOCASE, resource) DO UPDATE SET
+		    timestamp = MAX(privilege_resources.timestamp, excluded.timestamp)
+	`)
+	if err != nil {
+		return fmt.Errorf("preparing statement: %w", err)
+	}
+	defer resStmt.Close()
+
+	dailyStmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO privilege_usage_daily (role_key, privilege, day, call_count, session_scoped_calls)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(role_key, privilege, day) DO UPDATE SET
+		    call_count           = privilege_usage_daily.call_count + excluded.call_count,
+		    session_scoped_calls = privilege_usage_daily.session_scoped_calls + excluded.session_scoped_calls
+	`)
+	if err != nil {
+		return fmt.Errorf("preparing statement: %w", err)
+	}
+	defer dailyStmt.Close()
+
 	for _, r := range records {
-		if _, err := stmt.ExecContext(ctx, r.Timestamp.Unix(), r.IAMRole, r.Privilege, r.CallCount); err != nil {
+		key := roleKey(r.IAMRole)
+		scoped := 0
+		if r.SessionPolicy {
+			scoped = r.CallCount
+		}
+		if _, err := stmt.ExecContext(ctx, r.Timestamp.Unix(), r.IAMRole, key, r.Privilege, r.CallCount, scoped); err != nil {
 			return fmt.Errorf("upserting record for role %s: %w", r.IAMRole, err)
 		}
+		if _, err := dailyStmt.ExecContext(ctx, key, r.Privilege, usageDay(r.Timestamp), r.CallCount, scoped); err != nil {
+			return fmt.Errorf("upserting daily calls for role %s: %w", r.IAMRole, err)
+		}
+		if r.Resource == "" {
+			continue
+		}
+		if _, err := resStmt.ExecContext(ctx, r.Timestamp.Unix(), r.IAMRole, key, r.Privilege, r.Resource); err != nil {
+			return fmt.Errorf("upserting resource for role %s: %w", r.IAMRole, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sqlite_sequence SET seq = MAX(seq, (SELECT MAX(id) FROM privilege_usage))
+		WHERE name = 'privilege_usage'`,
+	); err != nil {
+		return fmt.Errorf("advancing privilege_usage sequence: %w", err)
 	}
 	return tx.Commit()
 }
 
+// usageDay returns the privilege_usage_daily day of t: whole UTC days since
+// the Unix epoch.
+func usageDay(t time.Time) int64 {
+	return t.Unix() / 86400
+}
+
 // GetUsedPrivilegesForRole returns distinct privileges observed for a role
-// within the given time window.
+// within the given time window. The role matches every stored form of it
+// (see roleFilter).
 func (db *DB) GetUsedPrivilegesForRole(ctx context.Context, role string, since time.Time) ([]string, error) {
+	filter, args := roleFilter(role)
 	rows, err := db.conn.QueryContext(ctx,
 		`SELECT DISTINCT privilege FROM privilege_usage
-		 WHERE iam_role = ? AND timestamp >= ?`,
-		role, since.Unix(),
+		 WHERE `+filter+` AND timestamp >= ?`,
+		append(args, since.Unix())...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying used privileges: %w", err)
@@ -84,6 +203,115 @@ func (db *DB) GetUsedPrivilegesForRole(ctx context.Context, role string, since t
 	return privs, rows.Err()
 }
 
+// GetPrivilegeLastSeenForRole returns, for each privilege observed for role
+// since the given time, the most recent observation timestamp. The role
+// matches every stored form of it (see roleFilter).
+func (db *DB) GetPrivilegeLastSeenForRole(ctx context.Context, role string, since time.Time) (map[string]time.Time, error) {
+	usage, err := db.GetPrivilegeUsageForRole(ctx, role, since)
+	if err != nil {
+		return nil, err
+	}
+	lastSeen := make(map[string]time.Time, len(usage))
+	for p, u := range usage {
+		lastSeen[p] = u.LastSeen
+	}
+	return lastSeen, nil
+}
+
+// PrivilegeUsage summarizes a role's observations of one privilege.
+type PrivilegeUsage struct {
+	LastSeen time.Time `json:"last_seen"`
+	// CallCount is the number of calls on the UTC days from the queried
+	// time's on. Calls recorded before daily counts were kept count on the
+	// day the privilege was last seen then.
+	CallCount int `json:"count"`
+	// SessionScopedCalls is how many of those calls were made in sessions
+	// scoped by a session policy.
+	SessionScopedCalls int `json:"session_scoped,omitempty"`
+}
+
+// GetPrivilegeUsageForRole is GetPrivilegeLastSeenForRole with the calls to
+// each privilege in the window (see PrivilegeUsage.CallCount). Privileges
+// differing only in case are reported once.
+func (db *DB) GetPrivilegeUsageForRole(ctx context.Context, role string, since time.Time) (map[string]PrivilegeUsage, error) {
+	filter, args := roleFilter(role)
+	day := usageDay(since)
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT MIN(u.privilege), MAX(u.timestamp), COALESCE(d.calls, 0), COALESCE(d.scoped, 0)
+		 FROM privilege_usage u
+		 LEFT JOIN (
+		     SELECT privilege, SUM(call_count) AS calls, SUM(session_scoped_calls) AS scoped
+		     FROM privilege_usage_daily
+		     WHERE `+filter+` AND day >= ?
+		     GROUP BY privilege
+		 ) d ON d.privilege = u.privilege COLLATE NOCASE
+		 WHERE u.`+filter+` AND u.timestamp >= ?
+		 GROUP BY u.privilege COLLATE NOCASE`,
+		append(append(append(append([]any{}, args...), day), args...), since.Unix())...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying privilege usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]PrivilegeUsage)
+	for rows.Next() {
+		var (
+			p      string
+			ts     int64
+			calls  int
+			scoped int
+		)
+		if err := rows.Scan(&p, &ts, &calls, &scoped); err != nil {
+			return nil, err
+		}
+		usage[p] = PrivilegeUsage{LastSeen: time.Unix(ts, 0), CallCount: calls, SessionScopedCalls: scoped}
+	}
+	return usage, rows.Err()
+}
+
+// GetPrivilegeResourcesForRole returns, for each privilege observed on at
+// least one resource for role since the given time, the sorted resources.
+// The role matches every stored form of it (see roleFilter).
+func (db *DB) GetPrivilegeResourcesForRole(ctx context.Context, role string, since time.Time) (map[string][]string, error) {
+	filter, args := roleFilter(role)
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT DISTINCT privilege, resource FROM privilege_resources
+		 WHERE `+filter+` AND timestamp >= ?
+		 ORDER BY privilege, resource`,
+		append(args, since.Unix())...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying privilege resources: %w", err)
+	}
+	defer rows.Close()
+
+	resources := make(map[string][]string)
+	for rows.Next() {
+		var p, res string
+		if err := rows.Scan(&p, &res); err != nil {
+			return nil, err
+		}
+		resources[p] = append(resources[p], res)
+	}
+	return resources, rows.Err()
+}
+
+// roleFilter returns a WHERE fragment (and its arguments) matching every form
+// an exporter may have stored role under: the IAM role ARN with or without a
+// path, STS assumed-role ARNs for any session of the same account and role
+// name, and, for a bare role name, that name. It compares role_key, so both
+// privilege tables are looked up by index.
+func roleFilter(role string) (string, []any) {
+	return "role_key = ?", []any{roleKey(role)}
+}
+
+// roleKey is the role_key stored with an observation of role: its account
+// and name (see rolearn.Role.Key), or the name alone for a bare name.
+func roleKey(role string) string {
+	return rolearn.Parse(role).Key()
+}
+
 // GetObservedRoles returns all distinct IAM roles seen in the observation window.
 func (db *DB) GetObservedRoles(ctx context.Context, since time.Time) ([]string, error) {
 	rows, err := db.conn.QueryContext(ctx,
@@ -106,6 +334,63 @@ func (db *DB) GetObservedRoles(ctx context.Context, since time.Time) ([]string,
 	return roles, rows.Err()
 }
 
+// GetRolesWithoutObservations returns the roles with analysis results that
+// have no privilege observation since since, under any of their forms (see
+// roleFilter), sorted. An observation under a bare role name counts for the
+// role of that name when only one account has one, as correlation matches
+// it. Such roles point at gaps in instrumentation.
+func (db *DB) GetRolesWithoutObservations(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT DISTINCT role_key FROM privilege_usage WHERE timestamp >= ?`,
+		since.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying observed roles: %w", err)
+	}
+	observed := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		observed[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.conn.QueryContext(ctx, `SELECT iam_role FROM analysis_results ORDER BY iam_role`)
+	if err != nil {
+		return nil, fmt.Errorf("querying analysis result roles: %w", err)
+	}
+	defer rows.Close()
+	var all []string
+	names := make(map[string]int)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		all = append(all, role)
+		names[rolearn.Parse(role).Name]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	for _, role := range all {
+		name := rolearn.Parse(role).Name
+		if observed[roleKey(role)] || (observed[name] && names[name] == 1) {
+			continue
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
 // SaveAnalysisResult stores an analysis result snapshot.
 func (db *DB) SaveAnalysisResult(ctx context.Context, r AnalysisResult) error {
 	assigned, err := json.Marshal(r.AssignedPrivs)
@@ -120,27 +405,114 @@ func (db *DB) SaveAnalysisResult(ctx context.Context, r AnalysisResult) error {
 	if err != nil {
 		return fmt.Errorf("marshaling unused privileges: %w", err)
 	}
+	policyARNs, err := json.Marshal(nonNil(r.PolicyARNs))
+	if err != nil {
+		return fmt.Errorf("marshaling policy ARNs: %w", err)
+	}
+	resources := []byte("{}")
+	if len(r.Resources) > 0 {
+		if resources, err = json.Marshal(r.Resources); err != nil {
+			return fmt.Errorf("marshaling resources: %w", err)
+		}
+	}
+	usage := []byte("{}")
+	if len(r.Usage) > 0 {
+		if usage, err = json.Marshal(r.Usage); err != nil {
+			return fmt.Errorf("marshaling usage: %w", err)
+		}
+	}
+	sources := []byte("{}")
+	if len(r.Sources) > 0 {
+		if sources, err = json.Marshal(r.Sources); err != nil {
+			return fmt.Errorf("marshaling privilege sources: %w", err)
+		}
+	}
+	suppressed, err := json.Marshal(nonNil(r.SuppressedPrivs))
+	if err != nil {
+		return fmt.Errorf("marshaling suppressed privileges: %w", err)
+	}
+	trust, err := json.Marshal(r.Trust)
+	if err != nil {
+		return fmt.Errorf("marshaling trust analysis: %w", err)
+	}
+	excess, err := json.Marshal(nonNil(r.ExcessObservedPrivs))
+	if err != nil {
+		return fmt.Errorf("marshaling excess observed privileges: %w", err)
+	}
+	owner, err := json.Marshal(r.Owner)
+	if err != nil {
+		return fmt.Errorf("marshaling owner: %w", err)
+	}
+	regressed, err := json.Marshal(nonNil(r.RegressedPrivs))
+	if err != nil {
+		return fmt.Errorf("marshaling regressed privileges: %w", err)
+	}
+	constrained, err := json.Marshal(nonNil(r.ConstrainedPrivs))
+	if err != nil {
+		return fmt.Errorf("marshaling resource-constrained privileges: %w", err)
+	}
+	overlaps := []byte("{}")
+	if len(r.PolicyOverlaps) > 0 {
+		if overlaps, err = json.Marshal(r.PolicyOverlaps); err != nil {
+			return fmt.Errorf("marshaling policy overlaps: %w", err)
+		}
+	}
+	coverage := []byte("{}")
+	if len(r.ServiceCoverage) > 0 {
+		if coverage, err = json.Marshal(r.ServiceCoverage); err != nil {
+			return fmt.Errorf("marshaling service coverage: %w", err)
+		}
+	}
+	risks := []byte("{}")
+	if len(r.UnusedRisks) > 0 {
+		if risks, err = json.Marshal(r.UnusedRisks); err != nil {
+			return fmt.Errorf("marshaling unused privilege risks: %w", err)
+		}
+	}
 
 	_, err = db.conn.ExecContext(ctx,
 		`INSERT INTO analysis_results
-		 (analysis_date, iam_role, assigned_privileges, used_privileges, unused_privileges, risk_level)
-		 VALUES (?, ?, ?, ?, ?, ?)
+		 (analysis_date, iam_role, assigned_privileges, used_privileges, unused_privileges, risk_level, policy_arns, used_resources, privilege_sources, suppressed_privileges, read_only, trust, excess_observed, owner, regressed_privileges, constrained_privileges, policy_overlaps, service_coverage, usage_stats, unused_risks, privileges_hash)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(iam_role) DO UPDATE SET
-		     analysis_date       = excluded.analysis_date,
-		     assigned_privileges = excluded.assigned_privileges,
-		     used_privileges     = excluded.used_privileges,
-		     unused_privileges   = excluded.unused_privileges,
-		     risk_level          = excluded.risk_level`,
-		r.AnalysisDate.Unix(), r.IAMRole, string(assigned), string(used), string(unused), r.RiskLevel,
+		     analysis_date         = excluded.analysis_date,
+		     assigned_privileges   = excluded.assigned_privileges,
+		     used_privileges       = excluded.used_privileges,
+		     unused_privileges     = excluded.unused_privileges,
+		     risk_level            = excluded.risk_level,
+		     policy_arns           = excluded.policy_arns,
+		     used_resources        = excluded.used_resources,
+		     privilege_sources     = excluded.privilege_sources,
+		     suppressed_privileges = excluded.suppressed_privileges,
+		     read_only             = excluded.read_only,
+		     trust                 = excluded.trust,
+		     excess_observed       = excluded.excess_observed,
+		     owner                 = excluded.owner,
+		     regressed_privileges  = excluded.regressed_privileges,
+		     constrained_privileges = excluded.constrained_privileges,
+		     policy_overlaps       = excluded.policy_overlaps,
+		     service_coverage      = excluded.service_coverage,
+		     usage_stats           = excluded.usage_stats,
+		     unused_risks          = excluded.unused_risks,
+		     privileges_hash       = excluded.privileges_hash`,
+		r.AnalysisDate.Unix(), r.IAMRole, string(assigned), string(used), string(unused), r.RiskLevel, string(policyARNs), string(resources), string(sources), string(suppressed), r.ReadOnly, string(trust), string(excess), string(owner), string(regressed), string(constrained), string(overlaps), string(coverage), string(usage), string(risks), r.PrivilegesHash,
 	)
 	return err
 }
 
+// nonNil returns s, or an empty slice if s is nil, so it marshals as [] not null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
 // GetLatestAnalysisResults returns the analysis result for each role.
 // The unique index on iam_role guarantees at most one row per role.
 func (db *DB) GetLatestAnalysisResults(ctx context.Context) ([]AnalysisResult, error) {
 	rows, err := db.conn.QueryContext(ctx, `
-		SELECT iam_role, analysis_date, assigned_privileges, used_privileges, unused_privileges, risk_level
+		SELECT iam_role, analysis_date, assigned_privileges, used_privileges, unused_privileges, risk_level, policy_arns, used_resources, privilege_sources, suppressed_privileges, read_only, trust, excess_observed, owner, regressed_privileges, constrained_privileges, policy_overlaps, service_coverage, usage_stats, unused_risks, privileges_hash
 		FROM analysis_results
 		ORDER BY iam_role
 	`)
@@ -153,8 +525,8 @@ func (db *DB) GetLatestAnalysisResults(ctx context.Context) ([]AnalysisResult, e
 	for rows.Next() {
 		var r AnalysisResult
 		var ts int64
-		var assigned, used, unused string
-		if err := rows.Scan(&r.IAMRole, &ts, &assigned, &used, &unused, &r.RiskLevel); err != nil {
+		var assigned, used, unused, policyARNs, resources, sources, suppressed, trust, excess, owner, regressed, constrained, overlaps, coverage, usage, risks string
+		if err := rows.Scan(&r.IAMRole, &ts, &assigned, &used, &unused, &r.RiskLevel, &policyARNs, &resources, &sources, &suppressed, &r.ReadOnly, &trust, &excess, &owner, &regressed, &constrained, &overlaps, &coverage, &usage, &risks, &r.PrivilegesHash); err != nil {
 			return nil, err
 		}
 		r.AnalysisDate = time.Unix(ts, 0)
@@ -167,6 +539,45 @@ func (db *DB) GetLatestAnalysisResults(ctx context.Context) ([]AnalysisResult, e
 		if err := json.Unmarshal([]byte(unused), &r.UnusedPrivs); err != nil {
 			return nil, fmt.Errorf("unmarshaling unused: %w", err)
 		}
+		if err := json.Unmarshal([]byte(policyARNs), &r.PolicyARNs); err != nil {
+			return nil, fmt.Errorf("unmarshaling policy ARNs: %w", err)
+		}
+		if err := json.Unmarshal([]byte(resources), &r.Resources); err != nil {
+			return nil, fmt.Errorf("unmarshaling resources: %w", err)
+		}
+		if err := json.Unmarshal([]byte(sources), &r.Sources); err != nil {
+			return nil, fmt.Errorf("unmarshaling privilege sources: %w", err)
+		}
+		if err := json.Unmarshal([]byte(suppressed), &r.SuppressedPrivs); err != nil {
+			return nil, fmt.Errorf("unmarshaling suppressed privileges: %w", err)
+		}
+		if err := json.Unmarshal([]byte(trust), &r.Trust); err != nil {
+			return nil, fmt.Errorf("unmarshaling trust analysis: %w", err)
+		}
+		if err := json.Unmarshal([]byte(excess), &r.ExcessObservedPrivs); err != nil {
+			return nil, fmt.Errorf("unmarshaling excess observed privileges: %w", err)
+		}
+		if err := json.Unmarshal([]byte(owner), &r.Owner); err != nil {
+			return nil, fmt.Errorf("unmarshaling owner: %w", err)
+		}
+		if err := json.Unmarshal([]byte(regressed), &r.RegressedPrivs); err != nil {
+			return nil, fmt.Errorf("unmarshaling regressed privileges: %w", err)
+		}
+		if err := json.Unmarshal([]byte(constrained), &r.ConstrainedPrivs); err != nil {
+			return nil, fmt.Errorf("unmarshaling resource-constrained privileges: %w", err)
+		}
+		if err := json.Unmarshal([]byte(overlaps), &r.PolicyOverlaps); err != nil {
+			return nil, fmt.Errorf("unmarshaling policy overlaps: %w", err)
+		}
+		if err := json.Unmarshal([]byte(coverage), &r.ServiceCoverage); err != nil {
+			return nil, fmt.Errorf("unmarshaling service coverage: %w", err)
+		}
+		if err := json.Unmarshal([]byte(usage), &r.Usage); err != nil {
+			return nil, fmt.Errorf("unmarshaling usage: %w", err)
+		}
+		if err := json.Unmarshal([]byte(risks), &r.UnusedRisks); err != nil {
+			return nil, fmt.Errorf("unmarshaling unused privilege risks: %w", err)
+		}
 		results = append(results, r)
 	}
 	return results, rows.Err()
@@ -186,9 +597,34 @@ func (db *DB) GetOldestObservation(ctx context.Context) (time.Time, bool, error)
 	return time.Unix(ts.Int64, 0), true, nil
 }
 
-// PurgeOldRecords deletes privilege_usage records older than the given cutoff.
-func (db *DB) PurgeOldRecords(ctx context.Context, before time.Time) (int64, error) {
-	res, err := db.conn.ExecContext(ctx,
+// PurgeOldRecords deletes privilege_usage records older than the given cutoff,
+// along with resource observations and daily call counts older than it, and
+// analysis runs (with their labels) recorded before historyBefore; a zero
+// historyBefore keeps the whole analysis history. The count covers
+// privilege_usage rows only.
+//
+// The purged usage is first rolled up into archive_usage, per month and per
+// role and privilege, so GetArchivedUsage can still show long-term trends
+// while privilege_usage stays bounded. A row's calls count towards the month
+// it was last seen in (UTC).
+func (db *DB) PurgeOldRecords(ctx context.Context, before, historyBefore time.Time) (int64, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO archive_usage (month, role_key, privilege, call_count)
+		 SELECT strftime('%Y-%m', timestamp, 'unixepoch'), role_key, privilege, SUM(call_count)
+		 FROM privilege_usage WHERE timestamp < ?
+		 GROUP BY 1, 2, 3
+		 ON CONFLICT (month, role_key, privilege) DO UPDATE SET call_count = call_count + excluded.call_count`,
+		before.Unix(),
+	); err != nil {
+		return 0, fmt.Errorf("archiving old records: %w", err)
+	}
+	res, err := tx.ExecContext(ctx,
 		`DELETE FROM privilege_usage WHERE timestamp < ?`,
 		before.Unix(),
 	)
@@ -196,5 +632,106 @@ func (db *DB) PurgeOldRecords(ctx context.Context, before time.Time) (int64, err
 		return 0, fmt.Errorf("purging old records: %w", err)
 	}
 	n, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM privilege_usage_daily WHERE day < ?`,
+		usageDay(before),
+	); err != nil {
+		return 0, fmt.Errorf("purging old daily calls: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM privilege_resources WHERE timestamp < ?`,
+		before.Unix(),
+	); err != nil {
+		return 0, fmt.Errorf("purging old resource records: %w", err)
+	}
+	if !historyBefore.IsZero() {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM analysis_history WHERE run_at < ?`,
+			historyBefore.Unix(),
+		); err != nil {
+			return 0, fmt.Errorf("purging old analysis runs: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM analysis_run_labels WHERE run_at < ?`,
+			historyBefore.Unix(),
+		); err != nil {
+			return 0, fmt.Errorf("purging old analysis run labels: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing purge: %w", err)
+	}
 	return n, nil
 }
+
+// ArchivedUsage is one month of a role's calls to a privilege, rolled up
+// from purged privilege_usage rows.
+type ArchivedUsage struct {
+	// Month is "YYYY-MM", in UTC.
+	Month string
+	// RoleKey identifies the role by account and name (see rolearn.Role.Key);
+	// the archive merges its IAM, STS and bare-name forms.
+	RoleKey   string
+	Privilege string
+	CallCount int
+}
+
+// GetArchivedUsage returns the archived monthly usage of role, in any of its
+// forms, or of every role when role is empty, from the month of since on,
+// ordered by month, role and privilege.
+func (db *DB) GetArchivedUsage(ctx context.Context, role string, since time.Time) ([]ArchivedUsage, error) {
+	query := `SELECT month, role_key, privilege, call_count FROM archive_usage WHERE month >= ?`
+	args := []any{since.UTC().Format("2006-01")}
+	if role != "" {
+		filter, filterArgs := roleFilter(role)
+		query += " AND " + filter
+		args = append(args, filterArgs...)
+	}
+	rows, err := db.conn.QueryContext(ctx, query+` ORDER BY month, role_key, privilege`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying archived usage: %w", err)
+	}
+	defer rows.Close()
+
+	var out []ArchivedUsage
+	for rows.Next() {
+		var u ArchivedUsage
+		if err := rows.Scan(&u.Month, &u.RoleKey, &u.Privilege, &u.CallCount); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// CountPrivilegeUsage returns the number of rows in privilege_usage.
+func (db *DB) CountPrivilegeUsage(ctx context.Context) (int64, error) {
+	return db.countRows(ctx, "privilege_usage")
+}
+
+// CountAnalysisResults returns the number of rows in analysis_results.
+func (db *DB) CountAnalysisResults(ctx context.Context) (int64, error) {
+	return db.countRows(ctx, "analysis_results")
+}
+
+func (db *DB) countRows(ctx context.Context, table string) (int64, error) {
+	var n int64
+	if err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+		return 0, fmt.Errorf("counting %s rows: %w", table, err)
+	}
+	return n, nil
+}
+
+// PageBytes returns the database size as page_count * page_size. Unlike
+// os.Stat on the file it also works for in-memory databases, but it does
+// not include the WAL file.
+func (db *DB) PageBytes(ctx context.Context) (int64, error) {
+	var pages, size int64
+	if err := db.conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("reading page_count: %w", err)
+	}
+	if err := db.conn.QueryRowContext(ctx, "PRAGMA page_size").Scan(&size); err != nil {
+		return 0, fmt.Errorf("reading page_size: %w", err)
+	}
+	return pages * size, nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/rolearn"
+)
+
+// DefaultMaxOpenAccounts is how many account databases an AccountRouter
+// keeps open at once by default.
+const DefaultMaxOpenAccounts = 16
+
+// AccountRouter records privilege usage in one database per AWS account,
+// each opened on first use. Records whose role carries no usable account,
+// such as a bare role name, a malformed account ID or an account outside
+// the allowlist, go to the fallback database, so a span cannot make the
+// router create a file of its choosing.
+type AccountRouter struct {
+	pathFor  func(account string) string
+	opts     Options
+	fallback *DB
+	allowed  map[string]bool
+	// maxOpen caps the databases the router opened that stay open; the
+	// least recently used is closed to make room for another.
+	maxOpen int
+
+	// mu is held across writes, so a database is never closed while a
+	// batch is being written to it.
+	mu  sync.Mutex
+	dbs map[string]*DB
+	// opened are the accounts whose database the router opened, and so
+	// closes, least recently used first.
+	opened []string
+}
+
+// NewAccountRouter returns a router opening the database of each account at
+// pathFor(account) with opts. When allowed is non-empty only those accounts
+// get a database of their own. The fallback database stays owned by the
+// caller.
+func NewAccountRouter(pathFor func(account string) string, opts Options, fallback *DB, allowed []string) *AccountRouter {
+	r := &AccountRouter{
+		pathFor:  pathFor,
+		opts:     opts,
+		fallback: fallback,
+		maxOpen:  DefaultMaxOpenAccounts,
+		dbs:      make(map[string]*DB),
+	}
+	if len(allowed) > 0 {
+		r.allowed = make(map[string]bool, len(allowed))
+		for _, a := range allowed {
+			r.allowed[strings.TrimSpace(a)] = true
+		}
+	}
+	return r
+}
+
+// Use routes account's records to db, already open at its path, instead of
+// opening the file a second time. The caller keeps ownership.
+func (r *AccountRouter) Use(account string, db *DB) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dbs[account] = db
+}
+
+// PartialWriteError is returned by AccountRouter.BatchRecordPrivilegeUsage
+// when some account databases failed. The other accounts' records were
+// committed, and writing them again would count their calls twice, so only
+// Unwritten should be retried.
+type PartialWriteError struct {
+	Unwritten []PrivilegeUsageRecord
+	Err       error
+}
+
+func (e *PartialWriteError) Error() string { return e.Err.Error() }
+
+func (e *PartialWriteError) Unwrap() error { return e.Err }
+
+// BatchRecordPrivilegeUsage writes each record to its role's account
+// database. A database that fails to open or write does not stop the others;
+// the errors are joined in a *PartialWriteError carrying the records not
+// written.
+func (r *AccountRouter) BatchRecordPrivilegeUsage(ctx context.Context, records []PrivilegeUsageRecord) error {
+	byAccount := make(map[string][]PrivilegeUsageRecord)
+	var order []string
+	for _, rec := range records {
+		account := r.route(rolearn.Parse(rec.IAMRole).Account)
+		if _, ok := byAccount[account]; !ok {
+			order = append(order, account)
+		}
+		byAccount[account] = append(byAccount[account], rec)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	var unwritten []PrivilegeUsageRecord
+	for _, account := range order {
+		db, err := r.db(account)
+		if err == nil {
+			err = db.BatchRecordPrivilegeUsage(ctx, byAccount[account])
+		}
+		if err != nil {
+			unwritten = append(unwritten, byAccount[account]...)
+			if account == "" {
+				account = "(none)"
+			}
+			errs = append(errs, fmt.Errorf("account %s: %w", account, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &PartialWriteError{Unwritten: unwritten, Err: errors.Join(errs...)}
+}
+
+// route returns the account whose database gets a record of account, or ""
+// for the fallback database.
+func (r *AccountRouter) route(account string) string {
+	if !rolearn.ValidAccount(account) {
+		return ""
+	}
+	if r.allowed != nil && !r.allowed[account] {
+		return ""
+	}
+	return account
+}
+
+// db returns the database for account, opening it if needed. r.mu must be
+// held.
+func (r *AccountRouter) db(account string) (*DB, error) {
+	if account == "" {
+		return r.fallback, nil
+	}
+	if db, ok := r.dbs[account]; ok {
+		r.touch(account)
+		return db, nil
+	}
+	if len(r.opened) >= r.maxOpen {
+		lru := r.opened[0]
+		r.opened = r.opened[1:]
+		db := r.dbs[lru]
+		delete(r.dbs, lru)
+		if err := db.Close(); err != nil {
+			return nil, fmt.Errorf("closing database of account %s: %w", lru, err)
+		}
+	}
+	db, err := OpenWithOptions(r.pathFor(account), r.opts)
+	if err != nil {
+		return nil, err
+	}
+	r.dbs[account] = db
+	r.opened = append(r.opened, account)
+	return db, nil
+}
+
+// touch marks account's database, if the router opened it, as the most
+// recently used. r.mu must be held.
+func (r *AccountRouter) touch(account string) {
+	for i, a := range r.opened {
+		if a == account {
+			r.opened = append(append(r.opened[:i:i], r.opened[i+1:]...), account)
+			return
+		}
+	}
+}
+
+// Close closes the databases the router opened.
+func (r *AccountRouter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for _, account := range r.opened {
+		if err := r.dbs[account].Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(r.dbs, account)
+	}
+	r.opened = nil
+	return errors.Join(errs...)
+}
@@ -2,6 +2,16 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -14,6 +24,141 @@ func TestOpenMemory(t *testing.T) {
 	defer db.Close()
 }
 
+func TestOpenRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if err := db.setSchemaVersion(SchemaVersion + 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	skip := DefaultOptions()
+	skip.SkipMigrate = true
+	opens := map[string]func() (*DB, error){
+		"Open":         func() (*DB, error) { return Open(path) },
+		"SkipMigrate":  func() (*DB, error) { return OpenWithOptions(path, skip) },
+		"OpenReadOnly": func() (*DB, error) { return OpenReadOnly(path, DefaultOptions()) },
+	}
+	for name, open := range opens {
+		db, err := open()
+		if err == nil {
+			db.Close()
+			t.Errorf("%s: expected a newer schema to be refused", name)
+			continue
+		}
+		if !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("%s: expected ErrSchemaTooNew, got %v", name, err)
+		}
+	}
+}
+
+func TestMigrationsApplyInOrder(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.schemaVersion(); err != nil || v != SchemaVersion {
+		t.Fatalf("schemaVersion() = %d, %v; want %d", v, err, SchemaVersion)
+	}
+
+	var applied []int
+	step := func(v int) migration {
+		return migration{v, fmt.Sprintf("step %d", v), func(*DB) error {
+			applied = append(applied, v)
+			return nil
+		}}
+	}
+	list := append(append([]migration{}, migrations...), step(SchemaVersion+1), step(SchemaVersion+2))
+	if err := db.applyMigrations(list); err != nil {
+		t.Fatalf("applyMigrations() error: %v", err)
+	}
+	if want := []int{SchemaVersion + 1, SchemaVersion + 2}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied %v, want only the pending migrations %v in order", applied, want)
+	}
+	if v, _ := db.schemaVersion(); v != SchemaVersion+2 {
+		t.Errorf("schemaVersion() = %d after migrating, want %d", v, SchemaVersion+2)
+	}
+
+	applied = nil
+	if err := db.applyMigrations(list); err != nil || len(applied) != 0 {
+		t.Errorf("re-running applied %v (err %v), want nothing", applied, err)
+	}
+
+	// A failed migration stops the run and leaves its version unrecorded.
+	failing := append(list, migration{SchemaVersion + 3, "fails", func(*DB) error {
+		return errors.New("boom")
+	}}, step(SchemaVersion+4))
+	if err := db.applyMigrations(failing); err == nil {
+		t.Error("expected the failing migration's error")
+	}
+	if len(applied) != 0 {
+		t.Errorf("migrations after a failure ran: %v", applied)
+	}
+	if v, _ := db.schemaVersion(); v != SchemaVersion+2 {
+		t.Errorf("schemaVersion() = %d after a failed migration, want %d", v, SchemaVersion+2)
+	}
+}
+
+func TestSkipMigrateLeavesSchemaAlone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	opts := DefaultOptions()
+	opts.SkipMigrate = true
+	if db, err := OpenWithOptions(path, opts); !errors.Is(err, ErrSchemaTooOld) {
+		if err == nil {
+			db.Close()
+		}
+		t.Fatalf("OpenWithOptions() error = %v, want ErrSchemaTooOld for a database with no schema", err)
+	}
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected no tables created with SkipMigrate, found %d", n)
+	}
+}
+
+func TestSkipMigrateRefusesOlderSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if err := db.setSchemaVersion(SchemaVersion - 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.SkipMigrate = true
+	if db, err := OpenWithOptions(path, opts); !errors.Is(err, ErrSchemaTooOld) {
+		if err == nil {
+			db.Close()
+		}
+		t.Fatalf("OpenWithOptions() error = %v, want ErrSchemaTooOld", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	db.Close()
+	if db, err := OpenWithOptions(path, opts); err != nil {
+		t.Errorf("OpenWithOptions() error = %v once migrated", err)
+	} else {
+		db.Close()
+	}
+}
+
 func TestBatchRecordAndQuery(t *testing.T) {
 	ctx := context.Background()
 	db, err := OpenMemory()
@@ -70,6 +215,54 @@ func TestGetObservedRoles(t *testing.T) {
 	}
 }
 
+func TestGetRolesWithoutObservations(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	// App is observed under its STS form, Bare under its bare name, Stale
+	// only before the window. Shared's bare name is ambiguous across two
+	// accounts, so it counts for neither.
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: "arn:aws:sts::123456789012:assumed-role/App/session", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "Bare", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "Shared", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now.AddDate(0, 0, -60), IAMRole: "arn:aws:iam::123456789012:role/Stale", Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{
+		"arn:aws:iam::123456789012:role/App",
+		"arn:aws:iam::123456789012:role/Bare",
+		"arn:aws:iam::123456789012:role/Shared",
+		"arn:aws:iam::210987654321:role/Shared",
+		"arn:aws:iam::123456789012:role/Stale",
+		"arn:aws:iam::123456789012:role/Silent",
+	} {
+		if err := db.SaveAnalysisResult(ctx, AnalysisResult{AnalysisDate: now, IAMRole: role, RiskLevel: "LOW"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roles, err := db.GetRolesWithoutObservations(ctx, now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"arn:aws:iam::123456789012:role/Shared",
+		"arn:aws:iam::123456789012:role/Silent",
+		"arn:aws:iam::123456789012:role/Stale",
+		"arn:aws:iam::210987654321:role/Shared",
+	}
+	if !reflect.DeepEqual(roles, want) {
+		t.Errorf("GetRolesWithoutObservations() = %v, want %v", roles, want)
+	}
+}
+
 func TestSaveAndGetAnalysisResult(t *testing.T) {
 	ctx := context.Background()
 	db, err := OpenMemory()
@@ -79,12 +272,15 @@ func TestSaveAndGetAnalysisResult(t *testing.T) {
 	defer db.Close()
 
 	r := AnalysisResult{
-		AnalysisDate:  time.Now(),
-		IAMRole:       "role/Test",
-		AssignedPrivs: []string{"s3:GetObject", "s3:PutObject", "ec2:DescribeInstances"},
-		UsedPrivs:     []string{"s3:GetObject"},
-		UnusedPrivs:   []string{"s3:PutObject", "ec2:DescribeInstances"},
-		RiskLevel:     "MEDIUM",
+		AnalysisDate:   time.Now(),
+		IAMRole:        "role/Test",
+		AssignedPrivs:  []string{"s3:GetObject", "s3:PutObject", "ec2:DescribeInstances"},
+		UsedPrivs:      []string{"s3:GetObject"},
+		UnusedPrivs:    []string{"s3:PutObject", "ec2:DescribeInstances"},
+		RiskLevel:      "MEDIUM",
+		Sources:        map[string]string{"s3:PutObject": "inline"},
+		ReadOnly:       true,
+		PrivilegesHash: "abc123",
 	}
 
 	if err := db.SaveAnalysisResult(ctx, r); err != nil {
@@ -104,6 +300,15 @@ func TestSaveAndGetAnalysisResult(t *testing.T) {
 	if len(results[0].UnusedPrivs) != 2 {
 		t.Errorf("expected 2 unused, got %d", len(results[0].UnusedPrivs))
 	}
+	if results[0].PrivilegesHash != "abc123" {
+		t.Errorf("expected privileges hash to round-trip, got %q", results[0].PrivilegesHash)
+	}
+	if results[0].Sources["s3:PutObject"] != "inline" {
+		t.Errorf("expected privilege sources to round-trip, got %v", results[0].Sources)
+	}
+	if !results[0].ReadOnly {
+		t.Error("expected read-only flag to round-trip")
+	}
 }
 
 func TestSaveAnalysisResultUpsert(t *testing.T) {
@@ -152,6 +357,267 @@ func TestSaveAnalysisResultUpsert(t *testing.T) {
 	}
 }
 
+func TestAnalysisResultsKeyedByAccount(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	prod := "arn:aws:iam::111111111111:role/App"
+	staging := "arn:aws:iam::222222222222:role/App"
+	for _, r := range []AnalysisResult{
+		{AnalysisDate: time.Now(), IAMRole: prod, UnusedPrivs: []string{"s3:DeleteObject"}, RiskLevel: "HIGH"},
+		{AnalysisDate: time.Now(), IAMRole: staging, UnusedPrivs: []string{}, RiskLevel: "LOW"},
+		{AnalysisDate: time.Now(), IAMRole: "arn:aws:iam::111111111111:role/Other", UnusedPrivs: []string{}, RiskLevel: "LOW"},
+	} {
+		if err := db.SaveAnalysisResult(ctx, r); err != nil {
+			t.Fatalf("SaveAnalysisResult(%s) error: %v", r.IAMRole, err)
+		}
+	}
+
+	results, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	risks := make(map[string]string)
+	for _, r := range results {
+		risks[r.IAMRole] = r.RiskLevel
+	}
+	if risks[prod] != "HIGH" || risks[staging] != "LOW" {
+		t.Errorf("same-named roles in two accounts must persist independently, got %v", risks)
+	}
+}
+
+func TestMigrationDropsResultAccountColumn(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// A database migrated while results were keyed by account.
+	if _, err := db.conn.Exec(`
+DROP INDEX idx_analysis_results_unique_role;
+ALTER TABLE analysis_results ADD COLUMN account_id TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX idx_analysis_results_unique_account_role ON analysis_results (account_id, iam_role);`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.setSchemaVersion(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.migrate(); err != nil {
+		t.Fatalf("migrate() error: %v", err)
+	}
+	if ok, err := db.hasColumn("analysis_results", "account_id"); err != nil || ok {
+		t.Errorf("account_id still present (err %v)", err)
+	}
+	ctx := context.Background()
+	r := AnalysisResult{AnalysisDate: time.Now(), IAMRole: "arn:aws:iam::111111111111:role/App", RiskLevel: "LOW"}
+	for i := 0; i < 2; i++ {
+		if err := db.SaveAnalysisResult(ctx, r); err != nil {
+			t.Fatalf("SaveAnalysisResult() error: %v", err)
+		}
+	}
+	if results, _ := db.GetLatestAnalysisResults(ctx); len(results) != 1 {
+		t.Errorf("got %d results, want the role's one row", len(results))
+	}
+}
+
+func TestMigrationBackfillsDailyUsage(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	seen := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: seen, IAMRole: "arn:aws:iam::111111111111:role/App", Privilege: "s3:GetObject", CallCount: 4},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A database from before daily counts were kept.
+	if _, err := db.conn.Exec(`DROP TABLE privilege_usage_daily`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.setSchemaVersion(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.migrate(); err != nil {
+		t.Fatalf("migrate() error: %v", err)
+	}
+	usage, err := db.GetPrivilegeUsageForRole(ctx, "arn:aws:iam::111111111111:role/App", seen.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usage["s3:GetObject"].CallCount; got != 4 {
+		t.Errorf("CallCount = %d, want the 4 calls counted on the day last seen", got)
+	}
+}
+
+func TestGetPrivilegeUsageForRoleCountsWindow(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	role := "arn:aws:iam::111111111111:role/App"
+	now := time.Now()
+	for _, rec := range []PrivilegeUsageRecord{
+		{Timestamp: now.AddDate(0, 0, -60), IAMRole: role, Privilege: "s3:GetObject", CallCount: 10},
+		{Timestamp: now.AddDate(0, 0, -2), IAMRole: role, Privilege: "s3:GetObject", CallCount: 2, SessionPolicy: true},
+		{Timestamp: now, IAMRole: role, Privilege: "S3:GetObject", CallCount: 1},
+	} {
+		if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{rec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := db.GetPrivilegeUsageForRole(ctx, role, now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 {
+		t.Fatalf("got %v, want one entry for the privilege", usage)
+	}
+	for _, u := range usage {
+		if u.CallCount != 3 || u.SessionScopedCalls != 2 {
+			t.Errorf("got %+v, want the 3 calls (2 session-scoped) in the window", u)
+		}
+	}
+
+	if _, err := db.PurgeOldRecords(ctx, now.AddDate(0, 0, -30), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM privilege_usage_daily`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d daily rows after purging, want the 2 days in the window", n)
+	}
+}
+
+func TestGetAnalysisResultsAsOf(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	firstRun := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	secondRun := firstRun.AddDate(0, 0, 7)
+	if err := db.SaveAnalysisRun(ctx, firstRun, []AnalysisResult{
+		{AnalysisDate: firstRun, IAMRole: "role/App", UnusedPrivs: []string{"s3:DeleteObject", "s3:PutObject"}, RiskLevel: "HIGH"},
+		{AnalysisDate: firstRun, IAMRole: "role/Old", UnusedPrivs: []string{}, RiskLevel: "LOW"},
+	}, nil); err != nil {
+		t.Fatalf("SaveAnalysisRun() error: %v", err)
+	}
+	if err := db.SaveAnalysisRun(ctx, secondRun, []AnalysisResult{
+		{AnalysisDate: secondRun, IAMRole: "role/App", UnusedPrivs: []string{"s3:PutObject"}, RiskLevel: "MEDIUM"},
+	}, nil); err != nil {
+		t.Fatalf("SaveAnalysisRun() error: %v", err)
+	}
+
+	tests := []struct {
+		asOf      time.Time
+		wantRoles int
+		wantRisk  string
+	}{
+		{firstRun, 2, "HIGH"},
+		{secondRun.Add(-time.Second), 2, "HIGH"},
+		{secondRun, 1, "MEDIUM"},
+		{secondRun.AddDate(1, 0, 0), 1, "MEDIUM"},
+	}
+	for _, tt := range tests {
+		results, err := db.GetAnalysisResultsAsOf(ctx, tt.asOf)
+		if err != nil {
+			t.Fatalf("GetAnalysisResultsAsOf(%s) error: %v", tt.asOf, err)
+		}
+		if len(results) != tt.wantRoles || results[0].IAMRole != "role/App" || results[0].RiskLevel != tt.wantRisk {
+			t.Errorf("as of %s: got %+v, want %d roles with App at %s", tt.asOf, results, tt.wantRoles, tt.wantRisk)
+		}
+	}
+
+	if _, err := db.GetAnalysisResultsAsOf(ctx, firstRun.Add(-time.Second)); !errors.Is(err, ErrNoAnalysisRun) {
+		t.Errorf("expected ErrNoAnalysisRun before the first run, got %v", err)
+	}
+}
+
+func TestAnalysisRunsFilteredByLabel(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	first := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	second := first.AddDate(0, 0, 7)
+	third := second.AddDate(0, 0, 7)
+	runs := []struct {
+		at     time.Time
+		risk   string
+		labels map[string]string
+	}{
+		{first, "HIGH", map[string]string{"audit": "Q3", "team": "payments"}},
+		{second, "MEDIUM", map[string]string{"audit": "post-incident-review"}},
+		{third, "LOW", nil},
+	}
+	for _, r := range runs {
+		if err := db.SaveAnalysisRun(ctx, r.at, []AnalysisResult{
+			{AnalysisDate: r.at, IAMRole: "role/App", UnusedPrivs: []string{}, RiskLevel: r.risk},
+		}, r.labels); err != nil {
+			t.Fatalf("SaveAnalysisRun() error: %v", err)
+		}
+	}
+
+	all, err := db.GetAnalysisRuns(ctx, nil)
+	if err != nil {
+		t.Fatalf("GetAnalysisRuns() error: %v", err)
+	}
+	if len(all) != 3 || !all[0].RunAt.Equal(third) || all[0].Labels != nil || all[2].Labels["team"] != "payments" {
+		t.Errorf("all runs = %+v, want the three runs newest first with their labels", all)
+	}
+
+	tests := []struct {
+		labels   map[string]string
+		wantRuns []time.Time
+	}{
+		{map[string]string{"audit": "Q3"}, []time.Time{first}},
+		{map[string]string{"audit": "Q3", "team": "payments"}, []time.Time{first}},
+		{map[string]string{"audit": "Q3", "team": "search"}, nil},
+		{map[string]string{"audit": "post-incident-review"}, []time.Time{second}},
+	}
+	for _, tt := range tests {
+		got, err := db.GetAnalysisRuns(ctx, tt.labels)
+		if err != nil {
+			t.Fatalf("GetAnalysisRuns(%v) error: %v", tt.labels, err)
+		}
+		var times []time.Time
+		for _, r := range got {
+			times = append(times, r.RunAt.UTC())
+		}
+		if !reflect.DeepEqual(times, tt.wantRuns) {
+			t.Errorf("GetAnalysisRuns(%v) = %v, want %v", tt.labels, times, tt.wantRuns)
+		}
+	}
+
+	results, err := db.GetLabeledAnalysisResults(ctx, third.AddDate(1, 0, 0), map[string]string{"audit": "Q3"})
+	if err != nil {
+		t.Fatalf("GetLabeledAnalysisResults() error: %v", err)
+	}
+	if len(results) != 1 || results[0].RiskLevel != "HIGH" {
+		t.Errorf("expected the Q3 run's results, got %+v", results)
+	}
+	if _, err := db.GetLabeledAnalysisResults(ctx, third, map[string]string{"audit": "Q4"}); !errors.Is(err, ErrNoAnalysisRun) {
+		t.Errorf("expected ErrNoAnalysisRun for an unused label, got %v", err)
+	}
+}
+
 func TestPurgeOldRecords(t *testing.T) {
 	ctx := context.Background()
 	db, err := OpenMemory()
@@ -172,7 +638,7 @@ func TestPurgeOldRecords(t *testing.T) {
 	}
 
 	cutoff := time.Now().Add(-24 * time.Hour)
-	n, err := db.PurgeOldRecords(ctx, cutoff)
+	n, err := db.PurgeOldRecords(ctx, cutoff, time.Time{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -188,3 +654,896 @@ func TestPurgeOldRecords(t *testing.T) {
 		t.Errorf("expected 1 role remaining after purge, got %d", len(remaining))
 	}
 }
+
+func TestPurgeOldAnalysisRuns(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	oldRun := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRun := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, runAt := range []time.Time{oldRun, newRun} {
+		if err := db.SaveAnalysisRun(ctx, runAt, []AnalysisResult{
+			{IAMRole: "role/A", AnalysisDate: runAt, RiskLevel: "LOW"},
+		}, map[string]string{"audit": runAt.Format("2006-01")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A zero history cutoff keeps every run.
+	if _, err := db.PurgeOldRecords(ctx, newRun, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.GetAnalysisRuns(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("expected both runs kept, got %v", runs)
+	}
+
+	if _, err := db.PurgeOldRecords(ctx, newRun, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = db.GetAnalysisRuns(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || !runs[0].RunAt.Equal(newRun) {
+		t.Errorf("expected only the newer run kept, got %v", runs)
+	}
+	var labels int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM analysis_run_labels`).Scan(&labels); err != nil {
+		t.Fatal(err)
+	}
+	if labels != 1 {
+		t.Errorf("expected the old run's labels purged, %d left", labels)
+	}
+}
+
+func TestPurgeArchivesUsage(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	role := "arn:aws:iam::123456789012:role/App"
+	session := "arn:aws:sts::123456789012:assumed-role/App/worker"
+	jan := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2026, 2, 3, 12, 0, 0, 0, time.UTC)
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: jan, IAMRole: role, Privilege: "s3:GetObject", CallCount: 4},
+		{Timestamp: jan, IAMRole: session, Privilege: "s3:GetObject", CallCount: 2},
+		{Timestamp: feb, IAMRole: role, Privilege: "sqs:SendMessage", CallCount: 7},
+		{Timestamp: feb, IAMRole: "arn:aws:iam::123456789012:role/Other", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: time.Now(), IAMRole: role, Privilege: "s3:PutObject", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.PurgeOldRecords(ctx, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	if err != nil {
+		t.Fatalf("PurgeOldRecords() error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("purged %d records, want 4", n)
+	}
+	// A later purge of the same month adds to its rollup.
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: jan.Add(time.Hour), IAMRole: role, Privilege: "s3:GetObject", CallCount: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PurgeOldRecords(ctx, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}); err != nil {
+		t.Fatalf("PurgeOldRecords() error: %v", err)
+	}
+
+	want := []ArchivedUsage{
+		{Month: "2026-01", RoleKey: "123456789012/App", Privilege: "s3:GetObject", CallCount: 9},
+		{Month: "2026-02", RoleKey: "123456789012/App", Privilege: "sqs:SendMessage", CallCount: 7},
+	}
+	if got, err := db.GetArchivedUsage(ctx, role, time.Time{}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("archived usage of App = %+v (%v), want %+v", got, err, want)
+	}
+
+	all, err := db.GetArchivedUsage(ctx, "", feb)
+	if err != nil {
+		t.Fatalf("GetArchivedUsage() error: %v", err)
+	}
+	if len(all) != 2 || all[0].Privilege != "sqs:SendMessage" || all[1].RoleKey != "123456789012/Other" {
+		t.Errorf("archived usage since February = %+v, want both roles' February rollups", all)
+	}
+
+	remaining, err := db.GetObservedRoles(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 {
+		t.Errorf("expected the recent record to survive the purge, got roles %v", remaining)
+	}
+}
+
+func TestAnalysisResultPolicyARNsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	arn := "arn:aws:iam::123:policy/AppPolicy"
+	if err := db.SaveAnalysisResult(ctx, AnalysisResult{
+		AnalysisDate: time.Now(),
+		IAMRole:      "role/Test",
+		RiskLevel:    "LOW",
+		PolicyARNs:   []string{arn},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].PolicyARNs) != 1 || results[0].PolicyARNs[0] != arn {
+		t.Errorf("expected policy ARNs to round-trip, got %+v", results)
+	}
+}
+
+func TestConcurrentWritersWithBusyTimeout(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.db")
+	opts := Options{BusyTimeoutMS: 5000, CacheSize: -2000}
+
+	// Two handles on the same file mimic the daemon and an overlapping analyze.
+	var dbs []*DB
+	for i := 0; i < 2; i++ {
+		db, err := OpenWithOptions(path, opts)
+		if err != nil {
+			t.Fatalf("OpenWithOptions() error: %v", err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(dbs))
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db *DB) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rec := PrivilegeUsageRecord{
+					Timestamp: time.Now(),
+					IAMRole:   fmt.Sprintf("arn:aws:iam::123:role/Writer%d", i),
+					Privilege: fmt.Sprintf("s3:Action%d", j),
+					CallCount: 1,
+				}
+				if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{rec}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+
+	roles, err := dbs[0].GetObservedRoles(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 {
+		t.Errorf("expected 2 roles, got %v", roles)
+	}
+}
+
+func TestAnalysisLockSerializesRuns(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.db")
+
+	// Two handles on the same file mimic the daemon and an overlapping analyze.
+	var dbs []*DB
+	for i := 0; i < 2; i++ {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error: %v", err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+
+	var active atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(dbs))
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db *DB) {
+			defer wg.Done()
+			unlock, err := db.Lock(ctx, AnalysisLock, time.Minute)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+			if n := active.Add(1); n > 1 {
+				errs <- fmt.Errorf("run %d: %d analyses held the lock at once", i, n)
+			}
+			defer active.Add(-1)
+
+			// Each run writes every role, then reads them back: with the
+			// lock held nothing from the other run may show up in between.
+			run := fmt.Sprintf("RUN%d", i)
+			for r := 0; r < 5; r++ {
+				if err := db.SaveAnalysisResult(ctx, AnalysisResult{
+					AnalysisDate: time.Now(),
+					IAMRole:      fmt.Sprintf("role/R%d", r),
+					RiskLevel:    run,
+				}); err != nil {
+					errs <- err
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			results, err := db.GetLatestAnalysisResults(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for _, r := range results {
+				if r.RiskLevel != run {
+					errs <- fmt.Errorf("run %d read %s written by %s", i, r.IAMRole, r.RiskLevel)
+				}
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	results, err := dbs[0].GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.RiskLevel != results[0].RiskLevel {
+			t.Errorf("final state mixes runs: %s from %s, %s from %s",
+				r.IAMRole, r.RiskLevel, results[0].IAMRole, results[0].RiskLevel)
+		}
+	}
+}
+
+func TestTryLockExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	unlock, ok, err := db.TryLock(ctx, AnalysisLock, time.Hour)
+	if err != nil || !ok {
+		t.Fatalf("TryLock() on a free lock = %v, %v", ok, err)
+	}
+	if _, ok, _ := db.TryLock(ctx, AnalysisLock, time.Hour); ok {
+		t.Fatal("lock taken twice")
+	}
+	unlock()
+	if _, ok, _ := db.TryLock(ctx, AnalysisLock, -time.Second); !ok {
+		t.Fatal("released lock should be free")
+	}
+	// That holder "crashed" with an already expired lease.
+	if _, ok, _ := db.TryLock(ctx, AnalysisLock, time.Hour); !ok {
+		t.Error("an expired lease should be taken over")
+	}
+}
+
+func TestTryLockRenewsLease(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Leases are stored in whole seconds, so hold the lock well past one.
+	unlock, ok, err := db.TryLock(ctx, AnalysisLock, time.Second)
+	if err != nil || !ok {
+		t.Fatalf("TryLock() on a free lock = %v, %v", ok, err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if _, ok, _ := db.TryLock(ctx, AnalysisLock, time.Second); ok {
+		t.Fatal("a held lock was taken over after its first lease ran out")
+	}
+	unlock()
+	unlock() // idempotent
+	if _, ok, _ := db.TryLock(ctx, AnalysisLock, time.Hour); !ok {
+		t.Error("released lock should be free")
+	}
+}
+
+func TestCountQueries(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if n, err := db.CountPrivilegeUsage(ctx); err != nil || n != 0 {
+		t.Fatalf("CountPrivilegeUsage() on empty db = %d, %v", n, err)
+	}
+
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: "arn:aws:iam::123:role/A", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::123:role/A", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::123:role/A", Privilege: "s3:GetObject", CallCount: 1}, // upsert
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAnalysisResult(ctx, AnalysisResult{AnalysisDate: now, IAMRole: "arn:aws:iam::123:role/A", RiskLevel: "LOW"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := db.CountPrivilegeUsage(ctx); err != nil || n != 2 {
+		t.Errorf("CountPrivilegeUsage() = %d, %v; want 2", n, err)
+	}
+	if n, err := db.CountAnalysisResults(ctx); err != nil || n != 1 {
+		t.Errorf("CountAnalysisResults() = %d, %v; want 1", n, err)
+	}
+	if n, err := db.PageBytes(ctx); err != nil || n <= 0 {
+		t.Errorf("PageBytes() = %d, %v; want > 0", n, err)
+	}
+}
+
+func TestPrivilegeResourcesRecordedAndDeduplicated(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	role := "arn:aws:iam::123:role/MyRole"
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1, Resource: "logs"},
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1, Resource: "assets"},
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1, Resource: "logs"},
+		{Timestamp: now, IAMRole: role, Privilege: "sts:GetCallerIdentity", CallCount: 1},
+	}); err != nil {
+		t.Fatalf("BatchRecordPrivilegeUsage() error: %v", err)
+	}
+
+	resources, err := db.GetPrivilegeResourcesForRole(ctx, role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetPrivilegeResourcesForRole() error: %v", err)
+	}
+	got := resources["s3:GetObject"]
+	if len(got) != 2 || got[0] != "assets" || got[1] != "logs" {
+		t.Errorf("expected [assets logs], got %v", got)
+	}
+	if _, ok := resources["sts:GetCallerIdentity"]; ok {
+		t.Error("privilege without a resource should not appear")
+	}
+
+	if err := db.SaveAnalysisResult(ctx, AnalysisResult{
+		AnalysisDate: now,
+		IAMRole:      role,
+		Resources:    resources,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || len(saved[0].Resources["s3:GetObject"]) != 2 {
+		t.Errorf("expected resources to round-trip, got %+v", saved)
+	}
+}
+
+func TestOpenReadOnlyRejectsWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.db")
+
+	rw, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.SaveAnalysisResult(ctx, AnalysisResult{AnalysisDate: time.Now(), IAMRole: "role/Existing"}); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+
+	ro, err := OpenReadOnly(path, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly() error: %v", err)
+	}
+	defer ro.Close()
+
+	results, err := ro.GetLatestAnalysisResults(ctx)
+	if err != nil {
+		t.Fatalf("GetLatestAnalysisResults() error: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+	}
+	if err := ro.SaveAnalysisResult(ctx, AnalysisResult{AnalysisDate: time.Now(), IAMRole: "role/New"}); err == nil {
+		t.Error("expected write through a read-only handle to fail")
+	}
+}
+
+func TestOpenReadOnlyDoesNotCreateDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "data.db")
+	if _, err := OpenReadOnly(path, DefaultOptions()); err == nil {
+		t.Fatal("expected error opening a missing database")
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Errorf("OpenReadOnly created %s", filepath.Dir(path))
+	}
+}
+
+func TestGetUsedPrivilegesForRoleMatchesEveryARNForm(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: "arn:aws:sts::123456789012:assumed-role/App_Role/session-1", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::123456789012:role/service/App_Role", Privilege: "s3:PutObject", CallCount: 1},
+		// Different account, and a name that only matches if "_" were a wildcard.
+		{Timestamp: now, IAMRole: "arn:aws:sts::999999999999:assumed-role/App_Role/session-1", Privilege: "ec2:RunInstances", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:sts::123456789012:assumed-role/AppXRole/session-1", Privilege: "iam:PassRole", CallCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	since := now.Add(-time.Hour)
+	privs, err := db.GetUsedPrivilegesForRole(ctx, "arn:aws:iam::123456789012:role/App_Role", since)
+	if err != nil {
+		t.Fatalf("GetUsedPrivilegesForRole() error: %v", err)
+	}
+	got := map[string]bool{}
+	for _, p := range privs {
+		got[p] = true
+	}
+	if len(got) != 2 || !got["s3:GetObject"] || !got["s3:PutObject"] {
+		t.Errorf("expected [s3:GetObject s3:PutObject], got %v", privs)
+	}
+
+	// Querying by an assumed-role ARN finds the IAM-form observations too.
+	lastSeen, err := db.GetPrivilegeLastSeenForRole(ctx, "arn:aws:sts::123456789012:assumed-role/App_Role/other-session", since)
+	if err != nil {
+		t.Fatalf("GetPrivilegeLastSeenForRole() error: %v", err)
+	}
+	if len(lastSeen) != 2 {
+		t.Errorf("expected 2 privileges, got %v", lastSeen)
+	}
+}
+
+func TestPrivilegeCasingVariantsCollapse(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	role := "arn:aws:iam::123:role/MyRole"
+	now := time.Now()
+	if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 2},
+		{Timestamp: now, IAMRole: role, Privilege: "s3:getobject", CallCount: 3},
+		{Timestamp: now, IAMRole: role, Privilege: "s3:GetObject", CallCount: 1},
+	}); err != nil {
+		t.Fatalf("BatchRecordPrivilegeUsage() error: %v", err)
+	}
+
+	privs, err := db.GetUsedPrivilegesForRole(ctx, role, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetUsedPrivilegesForRole() error: %v", err)
+	}
+	if len(privs) != 1 || privs[0] != "s3:GetObject" {
+		t.Errorf("expected one privilege in its first-seen casing, got %v", privs)
+	}
+	var calls int
+	if err := db.Conn().QueryRowContext(ctx, `SELECT call_count FROM privilege_usage`).Scan(&calls); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 6 {
+		t.Errorf("expected call counts to accumulate to 6, got %d", calls)
+	}
+}
+
+func TestMigrateFoldsExistingCasingVariants(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Simulate a database written before privileges were compared ignoring case.
+	if _, err := db.Conn().ExecContext(ctx, `DROP INDEX idx_privilege_usage_unique_nocase`); err != nil {
+		t.Fatal(err)
+	}
+	role := "arn:aws:iam::123:role/MyRole"
+	old, recent := time.Now().Add(-2*time.Hour), time.Now()
+	if _, err := db.Conn().ExecContext(ctx,
+		`INSERT INTO privilege_usage (timestamp, iam_role, privilege, call_count) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		old.Unix(), role, "s3:GetObject", 2, recent.Unix(), role, "s3:getobject", 3,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Databases that old predate schema_version, so migrate reruns the baseline.
+	if _, err := db.Conn().ExecContext(ctx, `DROP TABLE schema_version`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.migrate(); err != nil {
+		t.Fatalf("migrate() error: %v", err)
+	}
+
+	last, err := db.GetPrivilegeLastSeenForRole(ctx, role, old.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetPrivilegeLastSeenForRole() error: %v", err)
+	}
+	if len(last) != 1 || last["s3:GetObject"].Unix() != recent.Unix() {
+		t.Errorf("expected one s3:GetObject row last seen at the later time, got %v", last)
+	}
+}
+
+func TestTailPrivilegeUsage(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory() error: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	start := time.Unix(1_700_000_000, 0)
+	app := "arn:aws:iam::123456789012:role/App"
+	other := "arn:aws:iam::123456789012:role/Other"
+	record := func(at time.Time, role, priv string) {
+		t.Helper()
+		if err := db.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{
+			{Timestamp: at, IAMRole: role, Privilege: priv, CallCount: 1},
+		}); err != nil {
+			t.Fatalf("BatchRecordPrivilegeUsage() error: %v", err)
+		}
+	}
+	poll := func(tail *UsageTail) []string {
+		t.Helper()
+		records, err := tail.Poll(ctx)
+		if err != nil {
+			t.Fatalf("Poll() error: %v", err)
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, fmt.Sprintf("%d %s %s", r.Timestamp.Unix()-start.Unix(), r.IAMRole, r.Privilege))
+		}
+		return got
+	}
+
+	record(start.Add(-time.Minute), app, "s3:ListBucket") // before the tail starts
+	tail, err := db.TailPrivilegeUsage(ctx, "")
+	if err != nil {
+		t.Fatalf("TailPrivilegeUsage() error: %v", err)
+	}
+	appTail, err := db.TailPrivilegeUsage(ctx, app)
+	if err != nil {
+		t.Fatalf("TailPrivilegeUsage() error: %v", err)
+	}
+	if got := poll(tail); len(got) != 0 {
+		t.Fatalf("first Poll() = %v, want nothing", got)
+	}
+
+	record(start.Add(time.Second), app, "s3:GetObject")
+	record(start.Add(time.Second), other, "sqs:SendMessage")
+	want := []string{"1 " + app + " s3:GetObject", "1 " + other + " sqs:SendMessage"}
+	if got := poll(tail); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Poll() = %v, want %v", got, want)
+	}
+
+	// A row flushed late with an older timestamp is still returned, once.
+	record(start, app, "s3:PutObject")
+	want = []string{"0 " + app + " s3:PutObject"}
+	if got := poll(tail); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Poll() = %v, want %v", got, want)
+	}
+
+	// A privilege seen again advances its row and is returned again.
+	record(start.Add(2*time.Second), app, "s3:GetObject")
+	want = []string{"2 " + app + " s3:GetObject"}
+	if got := poll(tail); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Poll() = %v, want %v", got, want)
+	}
+	if got := poll(tail); len(got) != 0 {
+		t.Errorf("idle Poll() = %v, want nothing", got)
+	}
+
+	// Purging the newest row does not let a later one reuse its id.
+	if _, err := db.conn.Exec(`DELETE FROM privilege_usage WHERE privilege = 's3:GetObject'`); err != nil {
+		t.Fatal(err)
+	}
+	record(start.Add(3*time.Second), other, "sqs:ReceiveMessage")
+	want = []string{"3 " + other + " sqs:ReceiveMessage"}
+	if got := poll(tail); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Poll() after a purge = %v, want %v", got, want)
+	}
+
+	want = []string{"0 " + app + " s3:PutObject"}
+	if got := poll(appTail); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("role-filtered Poll() = %v, want %v", got, want)
+	}
+}
+
+func TestScrapeCheckpoints(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory() error: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	for _, arn := range []string{"arn:aws:iam::123456789012:role/A", "arn:aws:iam::123456789012:role/B"} {
+		if err := db.SaveScrapeCheckpoint(ctx, "all", arn, []byte(`{"RoleARN":"`+arn+`"}`)); err != nil {
+			t.Fatalf("SaveScrapeCheckpoint() error: %v", err)
+		}
+	}
+	if err := db.SaveScrapeCheckpoint(ctx, "other", "arn:aws:iam::123456789012:role/C", []byte(`{}`)); err != nil {
+		t.Fatalf("SaveScrapeCheckpoint() error: %v", err)
+	}
+	// Age B past the cutoff used below.
+	if _, err := db.conn.Exec(`UPDATE scrape_checkpoints SET scraped_at = ? WHERE role_arn LIKE '%/B'`,
+		time.Now().Add(-48*time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetScrapeCheckpoints(ctx, "all", time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetScrapeCheckpoints() error: %v", err)
+	}
+	if len(got) != 1 || string(got["arn:aws:iam::123456789012:role/A"]) != `{"RoleARN":"arn:aws:iam::123456789012:role/A"}` {
+		t.Errorf("expected only the fresh checkpoint of session all, got %v", got)
+	}
+
+	if err := db.DeleteScrapeCheckpoints(ctx, "all"); err != nil {
+		t.Fatalf("DeleteScrapeCheckpoints() error: %v", err)
+	}
+	if got, _ := db.GetScrapeCheckpoints(ctx, "all", time.Time{}); len(got) != 0 {
+		t.Errorf("expected session all cleared, got %v", got)
+	}
+	if got, _ := db.GetScrapeCheckpoints(ctx, "other", time.Time{}); len(got) != 1 {
+		t.Errorf("expected other sessions untouched, got %v", got)
+	}
+}
+
+func TestAccountRouterRoutesByAccount(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	pathFor := func(account string) string { return filepath.Join(dir, account+".db") }
+
+	fallback, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+	own, err := Open(pathFor("111111111111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer own.Close()
+
+	router := NewAccountRouter(pathFor, Options{}, fallback, nil)
+	router.Use("111111111111", own)
+	now := time.Now()
+	records := []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: "arn:aws:iam::111111111111:role/A", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::222222222222:role/B", Privilege: "s3:PutObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "C", Privilege: "ec2:DescribeInstances", CallCount: 1},
+	}
+	if err := router.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+		t.Fatalf("BatchRecordPrivilegeUsage() error: %v", err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	other, err := Open(pathFor("222222222222"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	since := now.Add(-time.Hour)
+	for _, tc := range []struct {
+		name string
+		db   *DB
+		want string
+	}{
+		{"used", own, "arn:aws:iam::111111111111:role/A"},
+		{"opened", other, "arn:aws:iam::222222222222:role/B"},
+		{"fallback", fallback, "C"},
+	} {
+		roles, err := tc.db.GetObservedRoles(ctx, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roles) != 1 || roles[0] != tc.want {
+			t.Errorf("%s database holds %v, want [%s]", tc.name, roles, tc.want)
+		}
+	}
+}
+
+func TestRoleKeyMatchesEveryARNForm(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	forms := map[string]string{
+		"arn:aws:iam::123456789012:role/service/App":               "s3:GetObject",
+		"arn:aws:sts::123456789012:assumed-role/App/i-0abc":        "s3:PutObject",
+		"arn:aws:iam::123456789012:role/App":                       "sqs:SendMessage",
+		"arn:aws:sts::999999999999:assumed-role/App/other-account": "ec2:RunInstances",
+		"arn:aws:iam::123456789012:role/Application":               "iam:PassRole",
+		"arn:aws:sts::123456789012:assumed-role/App_Backup/i-0def": "kms:Decrypt",
+	}
+	var records []PrivilegeUsageRecord
+	for role, priv := range forms {
+		records = append(records, PrivilegeUsageRecord{Timestamp: now, IAMRole: role, Privilege: priv, CallCount: 1})
+	}
+	if err := db.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.Conn().QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM privilege_usage WHERE role_key = ?`, "123456789012/App",
+	).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 3 {
+		t.Errorf("expected 3 rows under role_key 123456789012/App, got %d", stored)
+	}
+
+	for _, query := range []string{"arn:aws:iam::123456789012:role/App", "arn:aws:sts::123456789012:assumed-role/App/any"} {
+		privs, err := db.GetUsedPrivilegesForRole(ctx, query, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(privs)
+		if got := strings.Join(privs, ","); got != "s3:GetObject,s3:PutObject,sqs:SendMessage" {
+			t.Errorf("GetUsedPrivilegesForRole(%s) = %s", query, got)
+		}
+	}
+}
+
+func TestMigrateBackfillsRoleKey(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Simulate rows written before role_key existed.
+	now := time.Now()
+	if _, err := db.Conn().ExecContext(ctx,
+		`INSERT INTO privilege_usage (timestamp, iam_role, privilege, call_count) VALUES (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?)`,
+		now.Unix(), "arn:aws:iam::123456789012:role/App", "s3:GetObject", 1,
+		now.Unix(), "arn:aws:sts::123456789012:assumed-role/App/session", "s3:PutObject", 1,
+		now.Unix(), "App", "sqs:SendMessage", 1,
+	); err != nil {
+		t.Fatal(err)
+	}
+	// Databases that old predate schema_version, so migrate reruns the baseline.
+	if _, err := db.Conn().ExecContext(ctx, `DROP TABLE schema_version`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.migrate(); err != nil {
+		t.Fatalf("migrate() error: %v", err)
+	}
+
+	privs, err := db.GetUsedPrivilegesForRole(ctx, "arn:aws:iam::123456789012:role/App", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privs) != 2 {
+		t.Errorf("expected the IAM and STS rows after backfill, got %v", privs)
+	}
+	bare, err := db.GetUsedPrivilegesForRole(ctx, "App", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bare) != 1 || bare[0] != "sqs:SendMessage" {
+		t.Errorf("expected the bare-name row by its name, got %v", bare)
+	}
+}
+
+func TestAccountRouterRejectsUnusableAccounts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	pathFor := func(account string) string { return filepath.Join(dir, account+".db") }
+
+	fallback, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+
+	router := NewAccountRouter(pathFor, Options{}, fallback, []string{"111111111111"})
+	now := time.Now()
+	records := []PrivilegeUsageRecord{
+		{Timestamp: now, IAMRole: "arn:aws:iam::222222222222:role/Outside", Privilege: "s3:GetObject", CallCount: 1},
+		{Timestamp: now, IAMRole: "arn:aws:iam::..%2f..%2fetc:role/Bad", Privilege: "s3:GetObject", CallCount: 1},
+	}
+	if err := router.BatchRecordPrivilegeUsage(ctx, records); err != nil {
+		t.Fatalf("BatchRecordPrivilegeUsage() error: %v", err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no account database to be created, found %v", entries)
+	}
+	roles, err := fallback.GetObservedRoles(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 {
+		t.Errorf("expected both records in the fallback database, got %v", roles)
+	}
+}
+
+func TestAccountRouterCapsOpenDatabases(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	pathFor := func(account string) string { return filepath.Join(dir, account+".db") }
+
+	router := NewAccountRouter(pathFor, Options{}, nil, nil)
+	router.maxOpen = 2
+	defer router.Close()
+	now := time.Now()
+	for _, account := range []string{"111111111111", "222222222222", "111111111111", "333333333333"} {
+		rec := PrivilegeUsageRecord{Timestamp: now, IAMRole: "arn:aws:iam::" + account + ":role/A", Privilege: "s3:GetObject", CallCount: 1}
+		if err := router.BatchRecordPrivilegeUsage(ctx, []PrivilegeUsageRecord{rec}); err != nil {
+			t.Fatalf("BatchRecordPrivilegeUsage(%s) error: %v", account, err)
+		}
+	}
+	// 222222222222 was the least recently used when 333333333333 opened.
+	if want := []string{"111111111111", "333333333333"}; !reflect.DeepEqual(router.opened, want) {
+		t.Errorf("open databases = %v, want %v", router.opened, want)
+	}
+	if _, ok := router.dbs["222222222222"]; ok {
+		t.Error("least recently used database was not closed")
+	}
+}
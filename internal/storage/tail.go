@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UsageTail follows privilege_usage, returning observations written since
+// its previous poll. Because each (role, privilege) row is rewritten when it
+// is seen again, an observation shows up again whenever it is recorded
+// later, with its newest timestamp.
+type UsageTail struct {
+	db   *DB
+	role string
+	// cursor is the id of the newest row returned so far. Every write moves
+	// a row to a new, higher id (see BatchRecordPrivilegeUsage), so unlike
+	// a timestamp cursor it also catches rows flushed late with old
+	// timestamps.
+	cursor int64
+}
+
+// TailPrivilegeUsage returns a UsageTail for observations written after the
+// call. A non-empty role limits it to that role, matching every stored form
+// of it (see roleFilter).
+func (db *DB) TailPrivilegeUsage(ctx context.Context, role string) (*UsageTail, error) {
+	var cursor int64
+	if err := db.conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM privilege_usage`).Scan(&cursor); err != nil {
+		return nil, fmt.Errorf("tailing privilege usage: %w", err)
+	}
+	return &UsageTail{db: db, role: role, cursor: cursor}, nil
+}
+
+// Poll returns the observations written since the previous poll, in the
+// order they were written.
+func (t *UsageTail) Poll(ctx context.Context) ([]PrivilegeUsageRecord, error) {
+	query := `SELECT id, timestamp, iam_role, privilege, call_count FROM privilege_usage WHERE id > ?`
+	args := []any{t.cursor}
+	if t.role != "" {
+		filter, roleArgs := roleFilter(t.role)
+		query += ` AND ` + filter
+		args = append(args, roleArgs...)
+	}
+	rows, err := t.db.conn.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("tailing privilege usage: %w", err)
+	}
+	defer rows.Close()
+
+	var records []PrivilegeUsageRecord
+	for rows.Next() {
+		var (
+			id, ts int64
+			r      PrivilegeUsageRecord
+		)
+		if err := rows.Scan(&id, &ts, &r.IAMRole, &r.Privilege, &r.CallCount); err != nil {
+			return nil, err
+		}
+		t.cursor = id
+		r.Timestamp = time.Unix(ts, 0)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
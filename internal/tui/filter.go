@@ -0,0 +1,66 @@
+// Package tui implements an interactive, read-only terminal browser over the
+// latest analysis results. The bubbletea front-end lives behind the "tui"
+// build tag so the default binary does not carry the dependency; the
+// filtering logic here is always compiled so it can be tested on its own.
+package tui
+
+import (
+	"strings"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+// riskCycle is the order the risk filter steps through; "" means "any risk".
+var riskCycle = []string{"", string(correlation.RiskHigh), string(correlation.RiskMedium), string(correlation.RiskLow)}
+
+// Filter narrows a result set by role risk level and a role search string.
+type Filter struct {
+	// Risk matches a role's RiskLevel exactly. Empty matches every level.
+	Risk string
+	// Query is a case-insensitive substring matched against the role ARN/name.
+	Query string
+}
+
+// Match reports whether a single result passes the filter.
+func (f Filter) Match(r correlation.Result) bool {
+	if f.Risk != "" && r.RiskLevel != f.Risk {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(r.IAMRole), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the results that pass the filter, preserving input order.
+func (f Filter) Apply(results []correlation.Result) []correlation.Result {
+	out := make([]correlation.Result, 0, len(results))
+	for _, r := range results {
+		if f.Match(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// NextRisk returns the filter with its risk level advanced to the next step
+// in the cycle any → HIGH → MEDIUM → LOW → any.
+func (f Filter) NextRisk() Filter {
+	for i, level := range riskCycle {
+		if level == f.Risk {
+			f.Risk = riskCycle[(i+1)%len(riskCycle)]
+			return f
+		}
+	}
+	f.Risk = ""
+	return f
+}
+
+// PrivilegeRisk and UnusedByRisk are shared with the report command and live
+// in the correlation package.
+type PrivilegeRisk = correlation.PrivilegeRisk
+
+// UnusedByRisk is correlation.UnusedByRisk.
+func UnusedByRisk(r correlation.Result) []PrivilegeRisk {
+	return correlation.UnusedByRisk(r)
+}
@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+)
+
+var testResults = []correlation.Result{
+	{IAMRole: "arn:aws:iam::123:role/WebServer", RiskLevel: "HIGH", Unused: []string{"s3:GetObject", "s3:DeleteBucket", "s3:PutObject"}},
+	{IAMRole: "arn:aws:iam::123:role/DataPipeline", RiskLevel: "MEDIUM"},
+	{IAMRole: "arn:aws:iam::123:role/ReadOnly", RiskLevel: "LOW"},
+}
+
+func TestFilterByRisk(t *testing.T) {
+	got := Filter{Risk: "HIGH"}.Apply(testResults)
+	if len(got) != 1 || got[0].IAMRole != "arn:aws:iam::123:role/WebServer" {
+		t.Errorf("expected only WebServer, got %v", got)
+	}
+}
+
+func TestFilterByQueryIsCaseInsensitive(t *testing.T) {
+	got := Filter{Query: "pipeline"}.Apply(testResults)
+	if len(got) != 1 || got[0].IAMRole != "arn:aws:iam::123:role/DataPipeline" {
+		t.Errorf("expected only DataPipeline, got %v", got)
+	}
+}
+
+func TestFilterCombined(t *testing.T) {
+	if got := (Filter{Risk: "LOW", Query: "web"}).Apply(testResults); len(got) != 0 {
+		t.Errorf("expected no matches, got %v", got)
+	}
+	if got := (Filter{}).Apply(testResults); len(got) != 3 {
+		t.Errorf("empty filter should match everything, got %d", len(got))
+	}
+}
+
+func TestFilterNextRiskCycles(t *testing.T) {
+	f := Filter{}
+	want := []string{"HIGH", "MEDIUM", "LOW", ""}
+	for _, w := range want {
+		f = f.NextRisk()
+		if f.Risk != w {
+			t.Fatalf("NextRisk() = %q, want %q", f.Risk, w)
+		}
+	}
+}
+
+func TestUnusedByRiskSortsHighFirst(t *testing.T) {
+	got := UnusedByRisk(testResults[0])
+	want := []string{"s3:DeleteBucket", "s3:PutObject", "s3:GetObject"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d privileges, got %v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Privilege != w {
+			t.Errorf("position %d = %s, want %s", i, got[i].Privilege, w)
+		}
+	}
+	if got[0].Risk != correlation.RiskHigh {
+		t.Errorf("expected first privilege to be HIGH, got %s", got[0].Risk)
+	}
+}
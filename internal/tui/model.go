@@ -0,0 +1,181 @@
+//go:build tui
+
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/0xKirisame/shinkai-shoujo/internal/correlation"
+	"github.com/0xKirisame/shinkai-shoujo/internal/generator"
+)
+
+var (
+	riskStyles = map[string]lipgloss.Style{
+		string(correlation.RiskHigh):   lipgloss.NewStyle().Foreground(lipgloss.Color("9")),
+		string(correlation.RiskMedium): lipgloss.NewStyle().Foreground(lipgloss.Color("11")),
+		string(correlation.RiskLow):    lipgloss.NewStyle().Foreground(lipgloss.Color("10")),
+	}
+	cursorStyle = lipgloss.NewStyle().Bold(true)
+	helpStyle   = lipgloss.NewStyle().Faint(true)
+)
+
+// Model is the bubbletea model for the results browser.
+type Model struct {
+	all       []correlation.Result
+	visible   []correlation.Result
+	filter    Filter
+	cursor    int
+	expanded  bool
+	policy    bool
+	searching bool
+}
+
+// New returns a Model browsing the given results.
+func New(results []correlation.Result) Model {
+	m := Model{all: results}
+	m.refilter()
+	return m
+}
+
+// Run starts the browser in the alternate screen and blocks until the user quits.
+func Run(results []correlation.Result) error {
+	_, err := tea.NewProgram(New(results), tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m *Model) refilter() {
+	m.visible = m.filter.Apply(m.all)
+	if m.cursor >= len(m.visible) {
+		m.cursor = len(m.visible) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// Init implements tea.Model.
+func (m Model) Init() tea.Cmd { return nil }
+
+// Update implements tea.Model.
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	// While searching, keystrokes edit the query instead of navigating.
+	if m.searching {
+		switch key.Type {
+		case tea.KeyEnter, tea.KeyEsc:
+			m.searching = false
+		case tea.KeyBackspace:
+			if q := []rune(m.filter.Query); len(q) > 0 {
+				m.filter.Query = string(q[:len(q)-1])
+			}
+		case tea.KeyRunes:
+			m.filter.Query += string(key.Runes)
+		}
+		m.refilter()
+		return m, nil
+	}
+
+	switch key.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(m.visible)-1 {
+			m.cursor++
+		}
+	case "enter", " ":
+		m.expanded = !m.expanded
+		m.policy = false
+	case "p":
+		m.policy = !m.policy
+		m.expanded = false
+	case "r":
+		m.filter = m.filter.NextRisk()
+		m.refilter()
+	case "/":
+		m.searching = true
+	case "esc":
+		m.filter = Filter{}
+		m.refilter()
+	}
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m Model) View() string {
+	var b strings.Builder
+
+	risk := m.filter.Risk
+	if risk == "" {
+		risk = "any"
+	}
+	fmt.Fprintf(&b, "Shinkai Shoujo — %d/%d roles  [risk: %s]  [search: %s]\n\n",
+		len(m.visible), len(m.all), risk, m.filter.Query)
+
+	if len(m.visible) == 0 {
+		b.WriteString("No roles match the current filter.\n")
+	}
+	for i, r := range m.visible {
+		line := fmt.Sprintf("%-8s %-60s %d unused", riskStyle(r.RiskLevel).Render(fmt.Sprintf("%-8s", r.RiskLevel)), r.IAMRole, len(r.Unused))
+		if i == m.cursor {
+			b.WriteString(cursorStyle.Render("> " + line))
+		} else {
+			b.WriteString("  " + line)
+		}
+		b.WriteString("\n")
+
+		if i == m.cursor && m.expanded {
+			for _, p := range UnusedByRisk(r) {
+				fmt.Fprintf(&b, "      %s %s\n", riskStyle(string(p.Risk)).Render(fmt.Sprintf("%-8s", p.Risk)), p.Privilege)
+			}
+		}
+		if i == m.cursor && m.policy {
+			b.WriteString(indent(policyFor(r), "      "))
+		}
+	}
+
+	b.WriteString("\n")
+	if m.searching {
+		b.WriteString(helpStyle.Render("typing search — enter/esc to finish"))
+	} else {
+		b.WriteString(helpStyle.Render("↑/↓ move • enter expand • p policy • r risk • / search • esc clear • q quit"))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func riskStyle(level string) lipgloss.Style {
+	if s, ok := riskStyles[level]; ok {
+		return s
+	}
+	return lipgloss.NewStyle()
+}
+
+// policyFor renders the least-privilege Terraform for a single role.
+func policyFor(r correlation.Result) string {
+	var buf bytes.Buffer
+	if err := (&generator.TerraformGenerator{}).Generate([]correlation.Result{r}, &buf); err != nil {
+		return fmt.Sprintf("error generating policy: %v\n", err)
+	}
+	return buf.String()
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = prefix + l
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,65 @@
+// Package version reports the build metadata of the running binary.
+//
+// Release builds inject the values with -ldflags, e.g.
+//
+//	-X github.com/0xKirisame/shinkai-shoujo/internal/version.Version=v1.2.3
+//
+// (see the Makefile). When they are not injected, Get falls back to the
+// module version and VCS settings recorded by the Go toolchain.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via -ldflags -X at build time.
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+}
+
+// Get returns the build metadata, preferring injected values and filling
+// gaps from debug.ReadBuildInfo. Unknown fields are "unknown".
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String formats the metadata for the version command.
+func (i Info) String() string {
+	return fmt.Sprintf("shinkai-shoujo %s\n  commit:     %s\n  built:      %s\n  go version: %s\n",
+		i.Version, i.Commit, i.Date, i.GoVersion)
+}
@@ -0,0 +1,33 @@
+package version
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGetPrefersInjectedValues(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "v1.2.3", "abc1234", "2024-05-01T00:00:00Z"
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc1234" || info.Date != "2024-05-01T00:00:00Z" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	out := info.String()
+	for _, want := range []string{"v1.2.3", "abc1234", "2024-05-01T00:00:00Z", runtime.Version()} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGetFillsUnknowns(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "", "", ""
+
+	info := Get()
+	if info.Version == "" || info.Commit == "" || info.Date == "" {
+		t.Errorf("expected every field to be filled, got %+v", info)
+	}
+}